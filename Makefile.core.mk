@@ -161,9 +161,13 @@ test.integration.kind:
 build: ## Build manager binary.
 	CGO_ENABLED=0 go build -o bin/manager -ldflags '${LD_FLAGS}' main.go
 
+.PHONY: build-migrate-smcp
+build-migrate-smcp: ## Build the tool that migrates Maistra 2.x ServiceMeshControlPlanes to Istio resources.
+	CGO_ENABLED=0 go build -o bin/migrate-smcp -ldflags '${LD_FLAGS}' ./cmd/migrate-smcp
+
 .PHONY: run
 run: gen ## Run a controller from your host.
-	POD_NAMESPACE=${NAMESPACE} go run ./main.go --config-file=./hack/config.properties --resource-directory=./resources
+	POD_NAMESPACE=${NAMESPACE} go run ./main.go --config-file=./hack/config.properties --resource-directory=./resources --enable-webhooks=false
 
 # docker build -t ${IMAGE} --build-arg GIT_TAG=${GIT_TAG} --build-arg GIT_REVISION=${GIT_REVISION} --build-arg GIT_STATUS=${GIT_STATUS} .
 .PHONY: docker-build
@@ -219,10 +223,11 @@ deploy: kustomize ## Deploy controller to the K8s cluster specified in ~/.kube/c
 	$(MAKE) -s deploy-yaml | kubectl apply -f -
 
 .PHONY: deploy-yaml
-deploy-yaml: kustomize ## Outputs YAML manifests needed to deploy the controller
+deploy-yaml: kustomize ## Outputs YAML manifests needed to deploy the controller (requires cert-manager for the webhook certificate)
 	cd config/manager && $(KUSTOMIZE) edit set image controller=${IMAGE}
 	cd config/default && $(KUSTOMIZE) edit set namespace ${NAMESPACE}
-	$(KUSTOMIZE) build config/default
+	cd config/deploy && $(KUSTOMIZE) edit set namespace ${NAMESPACE}
+	$(KUSTOMIZE) build config/deploy
 
 .PHONY: deploy-olm
 deploy-olm: bundle bundle-build bundle-push ## Builds and pushes the operator OLM bundle and then deploys the operator using OLM
@@ -256,6 +261,10 @@ deploy-example-kubernetes: ## Deploy an example Istio resource on Kubernetes
 gen-manifests: controller-gen ## Generate WebhookConfiguration, ClusterRole and CustomResourceDefinition objects.
 	$(CONTROLLER_GEN) rbac:roleName=manager-role crd webhook paths="./..." output:crd:artifacts:config=config/crd/bases
 
+.PHONY: gen-rbac
+gen-rbac: ## Generate the RBAC markers for the objects in the charts.
+	go run ./cmd/gen-rbac
+
 .PHONY: gen-code
 gen-code: controller-gen ## Generate code containing DeepCopy, DeepCopyInto, and DeepCopyObject method implementations.
 	$(CONTROLLER_GEN) object:headerFile="hack/boilerplate.go.txt" paths="./..."
@@ -265,7 +274,7 @@ gen-charts: ## Pull charts from maistra/istio repository
 	hack/download-charts.sh v${MINOR_VERSION} https://github.com/${ISTIO_REPOSITORY} ${ISTIO_COMMIT_30}
 
 .PHONY: gen ## Generate everything
-gen: controller-gen gen-manifests gen-code gen-charts bundle
+gen: controller-gen gen-charts gen-rbac gen-manifests gen-code bundle
 
 .PHONY: gen-check
 gen-check: gen restore-manifest-dates check-clean-repo ## Verifies that changes in generated resources have been checked in
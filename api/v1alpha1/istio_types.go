@@ -20,6 +20,7 @@ import (
 	"encoding/json"
 	"time"
 
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -28,7 +29,10 @@ const IstioKind = "Istio"
 // IstioSpec defines the desired state of Istio
 type IstioSpec struct {
 	// Version defines the version of Istio to install. If not specified, the
-	// latest version supported by the operator is installed.
+	// latest version supported by the operator is installed. The version can only
+	// be upgraded one minor version at a time and can't be downgraded to a lower
+	// minor version, unless the resource is annotated with
+	// operator.istio.io/force-version-change=true.
 	// +operator-sdk:csv:customresourcedefinitions:type=spec,order=1,displayName="Istio Version",xDescriptors={"urn:alm:descriptor:com.tectonic.ui:fieldGroup:General","urn:alm:descriptor:com.tectonic.ui:select:v3.0"}
 	Version string `json:"version,omitempty"`
 
@@ -42,8 +46,456 @@ type IstioSpec struct {
 	// +kubebuilder:validation:Schemaless
 	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Helm Values"
 	Values json.RawMessage `json:"values,omitempty"`
+
+	// MeshConfig defines the mesh-wide configuration of the control plane, as in
+	// Istio's MeshConfig API (e.g. accessLogFile, defaultConfig, extensionProviders).
+	// Its fields are validated against the MeshConfig API and take precedence over
+	// values.meshConfig. Istiod reloads the mesh configuration from the istio
+	// ConfigMap, so that most changes are applied without restarting istiod.
+	// The field has no OpenAPI schema, because the MeshConfig API is defined in
+	// protobuf and its JSON form (e.g. durations as strings, oneofs, free-form
+	// structs) can't be expressed as a structural schema. It is validated by the
+	// operator's validating webhook instead, and again when it is reconciled.
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +kubebuilder:validation:Schemaless
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Mesh Config"
+	MeshConfig json.RawMessage `json:"meshConfig,omitempty"`
+
+	// Members lists the namespaces that are part of this mesh. When set, the
+	// control plane only discovers its own namespace and the member namespaces,
+	// and sidecar injection is enabled in the member namespaces (unless they are
+	// labeled with operator.istio.io/ignore-namespace). When empty, the control
+	// plane discovers all namespaces in the cluster.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Member Namespaces"
+	Members []string `json:"members,omitempty"`
+
+	// DeletionPolicy defines whether the deletion of the Istio resource is blocked
+	// while pods injected by this control plane still exist. With BlockIfInUse (the
+	// default), the components are only uninstalled once all injected pods are gone.
+	// The policy can be overridden by annotating the resource with
+	// operator.istio.io/force-deletion=true.
+	// +kubebuilder:validation:Enum=BlockIfInUse;Always
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Deletion Policy"
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// RolloutPolicy enables the automatic restart of workloads whose proxies don't
+	// run the proxy image of the control plane (e.g. after an upgrade). When
+	// enabled, the Deployments and StatefulSets in the member namespaces are
+	// restarted in batches once the control plane is ready.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Rollout Policy"
+	RolloutPolicy *RolloutPolicy `json:"rolloutPolicy,omitempty"`
+
+	// RemoteClusters lists the clusters whose workloads are managed by this control
+	// plane (primary-remote multi-cluster installation). The operator installs the
+	// istiod-remote chart in each of these clusters and creates the remote secrets
+	// that allow istiod to discover their endpoints.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Remote Clusters"
+	RemoteClusters []RemoteCluster `json:"remoteClusters,omitempty"`
+
+	// ConfigCluster enables the external control plane topology: istiod runs in this
+	// cluster, but manages the configuration and workloads of the config cluster. The
+	// operator installs the webhooks and CRDs in the config cluster and only installs
+	// istiod in this cluster.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Config Cluster"
+	ConfigCluster *RemoteCluster `json:"configCluster,omitempty"`
+
+	// MultiNetwork configures the control plane for a mesh that spans multiple
+	// networks. The operator labels the istio namespace and the member namespaces
+	// with the network and deploys an east-west gateway through which the
+	// workloads in other networks reach the services of this network.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Multi-Network"
+	MultiNetwork *MultiNetworkConfig `json:"multiNetwork,omitempty"`
+
+	// Security configures the security features of the control plane.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Security"
+	Security *SecurityConfig `json:"security,omitempty"`
+
+	// Registry configures registry mirrors and the pull secret for the images of
+	// the control plane. It takes precedence over the registry configuration of the operator.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Registry"
+	Registry *RegistryConfig `json:"registry,omitempty"`
+
+	// Overlays patch objects rendered by the charts, e.g. to set fields that the
+	// charts don't expose as values. Each overlay must refer to a component that is
+	// installed and match at least one of its objects, otherwise the control plane
+	// isn't reconciled.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Overlays"
+	Overlays []Overlay `json:"overlays,omitempty"`
+
+	// Monitoring configures the Prometheus monitors that the operator creates for the
+	// control plane, the gateways and the proxies, if prometheus-operator is installed.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Monitoring"
+	Monitoring *MonitoringConfig `json:"monitoring,omitempty"`
+}
+
+// MonitoringConfig defines the ServiceMonitors and PodMonitors of the mesh
+type MonitoringConfig struct {
+	// Enabled creates a ServiceMonitor for istiod, a PodMonitor for the gateways in the
+	// istio namespace and a PodMonitor for the sidecar proxies in the member namespaces
+	// (or all namespaces if the mesh has no members), if the monitoring.coreos.com CRDs
+	// are installed. The monitors are deleted when monitoring is disabled.
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Interval at which Prometheus scrapes the metrics, e.g. 30s. Defaults to the
+	// scrape interval of Prometheus.
+	// +kubebuilder:validation:Pattern="^(0|(([0-9]+)h)?(([0-9]+)m)?(([0-9]+)s)?(([0-9]+)ms)?)$"
+	Interval string `json:"interval,omitempty"`
+
+	// Labels are added to the monitors, e.g. to match the serviceMonitorSelector and
+	// podMonitorSelector of the Prometheus resource.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// OverlayPatchType is the type of the patch of an overlay
+// +kubebuilder:validation:Enum=StrategicMerge;JSON
+type OverlayPatchType string
+
+const (
+	// OverlayPatchTypeStrategicMerge patches the object with a strategic merge patch.
+	// Objects whose kind has no strategic merge information, such as custom
+	// resources, are patched with a JSON merge patch.
+	OverlayPatchTypeStrategicMerge OverlayPatchType = "StrategicMerge"
+	// OverlayPatchTypeJSON patches the object with a JSON patch (RFC 6902).
+	OverlayPatchTypeJSON OverlayPatchType = "JSON"
+)
+
+// Overlay patches the objects of a component that match its target
+type Overlay struct {
+	// Component whose objects are patched: cni, base, istiod, ingress-gateway,
+	// egress-gateway or eastwest-gateway.
+	// +kubebuilder:validation:Enum=cni;base;istiod;ingress-gateway;egress-gateway;eastwest-gateway
+	Component string `json:"component"`
+
+	// Target selects the objects to patch.
+	Target OverlayTarget `json:"target"`
+
+	// Type of the patch. Defaults to StrategicMerge.
+	// +kubebuilder:default=StrategicMerge
+	Type OverlayPatchType `json:"type,omitempty"`
+
+	// Patch in YAML or JSON. A JSON patch is a list of operations.
+	// +kubebuilder:validation:MinLength=1
+	Patch string `json:"patch"`
+}
+
+// OverlayTarget selects objects by their kind and name
+type OverlayTarget struct {
+	// Group of the objects. If empty, objects of all groups match.
+	Group string `json:"group,omitempty"`
+
+	// Kind of the objects.
+	// +kubebuilder:validation:MinLength=1
+	Kind string `json:"kind"`
+
+	// Name of the objects. If empty, all objects of the kind match.
+	Name string `json:"name,omitempty"`
+}
+
+// RegistryConfig defines where the images of the control plane are pulled from
+type RegistryConfig struct {
+	// Mirrors rewrite the images and hubs in the values that start with the source
+	// of a mirror to the mirror.
+	Mirrors []RegistryMirror `json:"mirrors,omitempty"`
+
+	// PullSecret is the Secret containing the credentials for pulling the images.
+	// The operator copies it to the istio and operator namespaces and adds it to
+	// `global.imagePullSecrets`.
+	PullSecret *PullSecretReference `json:"pullSecret,omitempty"`
+}
+
+// RegistryMirror maps a registry or repository to a mirror
+type RegistryMirror struct {
+	// Source is the registry or repository prefix that is mirrored, e.g. quay.io/maistra.
+	// +kubebuilder:validation:MinLength=1
+	Source string `json:"source"`
+
+	// Mirror replaces the source in the images, e.g. registry.example.com/maistra.
+	// +kubebuilder:validation:MinLength=1
+	Mirror string `json:"mirror"`
+}
+
+// PullSecretReference references an image pull Secret
+type PullSecretReference struct {
+	// Name of the Secret.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Namespace of the Secret. Defaults to the namespace of the Istio resource,
+	// which is the only namespace that the Secret may be in. The Secret must be of
+	// type kubernetes.io/dockerconfigjson or kubernetes.io/dockercfg.
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// SecurityConfig defines the security features of the control plane
+type SecurityConfig struct {
+	// CA configures the certificate authority that istiod signs the workload
+	// certificates with. The operator stores it in the cacerts Secret in the istio
+	// namespace and restarts istiod when it changes. Without it, istiod uses a
+	// self-signed root certificate.
+	CA *CAConfig `json:"ca,omitempty"`
+}
+
+// CAType defines where the certificate authority of istiod comes from
+type CAType string
+
+const (
+	// CATypeProvided uses a CA certificate and key provided by the user
+	CATypeProvided CAType = "Provided"
+
+	// CATypeGenerated uses an intermediate CA that the operator generates from a root CA
+	CATypeGenerated CAType = "Generated"
+
+	// CATypeCertManager uses an intermediate CA issued by cert-manager
+	CATypeCertManager CAType = "CertManager"
+)
+
+// CAConfig defines the certificate authority of istiod. Only the section that
+// matches the type is used.
+type CAConfig struct {
+	// Type of the certificate authority.
+	// +kubebuilder:validation:Enum=Provided;Generated;CertManager
+	Type CAType `json:"type"`
+
+	// Provided configures a CA provided by the user.
+	Provided *ProvidedCAConfig `json:"provided,omitempty"`
+
+	// Generated configures a CA generated by the operator.
+	Generated *GeneratedCAConfig `json:"generated,omitempty"`
+
+	// CertManager configures a CA issued by cert-manager.
+	CertManager *CertManagerCAConfig `json:"certManager,omitempty"`
+}
+
+// ProvidedCAConfig references a CA provided by the user
+type ProvidedCAConfig struct {
+	// SecretName is the name of the Secret in the istio namespace that contains the
+	// ca-cert.pem, ca-key.pem, cert-chain.pem and root-cert.pem keys. The operator
+	// copies them to the cacerts Secret. Rotating the CA is up to the user.
+	// +kubebuilder:validation:MinLength=1
+	SecretName string `json:"secretName"`
+}
+
+// GeneratedCAConfig defines the intermediate CA that the operator generates
+type GeneratedCAConfig struct {
+	// RootSecretName is the name of the Secret in the istio namespace that contains
+	// the root certificate and key (root-cert.pem and root-key.pem) that the
+	// operator signs the intermediate CA with.
+	// +kubebuilder:validation:MinLength=1
+	RootSecretName string `json:"rootSecretName"`
+
+	// Validity of the intermediate CA certificate. Defaults to 8760h (one year).
+	Validity *metav1.Duration `json:"validity,omitempty"`
+
+	// RenewBefore is how long before its expiry the intermediate CA is renewed.
+	// Defaults to 720h (30 days).
+	RenewBefore *metav1.Duration `json:"renewBefore,omitempty"`
+}
+
+// GetValidity returns the validity of the intermediate CA, or its default if not set
+func (c *GeneratedCAConfig) GetValidity() time.Duration {
+	if c.Validity == nil {
+		return 365 * 24 * time.Hour
+	}
+	return c.Validity.Duration
+}
+
+// GetRenewBefore returns how long before its expiry the intermediate CA is renewed, or its default if not set
+func (c *GeneratedCAConfig) GetRenewBefore() time.Duration {
+	if c.RenewBefore == nil {
+		return 30 * 24 * time.Hour
+	}
+	return c.RenewBefore.Duration
+}
+
+// CertManagerCAConfig defines the cert-manager Certificate of the intermediate CA
+type CertManagerCAConfig struct {
+	// IssuerRef references the cert-manager Issuer or ClusterIssuer that issues the
+	// intermediate CA. The issuer must be a root CA, or its certificate chain must
+	// include the self-signed root certificate.
+	IssuerRef CertManagerIssuerReference `json:"issuerRef"`
+
+	// Duration of the intermediate CA certificate. Defaults to cert-manager's default.
+	Duration *metav1.Duration `json:"duration,omitempty"`
+
+	// RenewBefore is how long before its expiry cert-manager renews the intermediate
+	// CA. Defaults to cert-manager's default.
+	RenewBefore *metav1.Duration `json:"renewBefore,omitempty"`
+}
+
+// CertManagerIssuerReference references a cert-manager issuer
+type CertManagerIssuerReference struct {
+	// Name of the issuer.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Kind of the issuer, e.g. Issuer or ClusterIssuer. Defaults to Issuer.
+	Kind string `json:"kind,omitempty"`
+
+	// Group of the issuer. Defaults to cert-manager.io.
+	Group string `json:"group,omitempty"`
+}
+
+// MultiNetworkConfig defines the network of the cluster and how it's reached from other networks
+type MultiNetworkConfig struct {
+	// Network is the name of the network that the workloads in this cluster belong to.
+	// +kubebuilder:validation:MinLength=1
+	Network string `json:"network"`
+
+	// EastWestGateway configures the gateway that connects this network to the other
+	// networks of the mesh.
+	EastWestGateway EastWestGatewayConfig `json:"eastWestGateway,omitempty"`
+
+	// MeshNetworks lists the networks whose gateways can't be discovered by istiod,
+	// e.g. because their clusters aren't remote clusters of this control plane. They
+	// are added to the meshNetworks configuration.
+	MeshNetworks []MeshNetwork `json:"meshNetworks,omitempty"`
+}
+
+// EastWestGatewayConfig defines the east-west gateway of a network
+type EastWestGatewayConfig struct {
+	// Enabled deploys the east-west gateway. Defaults to true.
+	Enabled *bool `json:"enabled,omitempty"`
+
+	// ServiceType is the type of the gateway's Service. Defaults to LoadBalancer.
+	// +kubebuilder:validation:Enum=LoadBalancer;NodePort;ClusterIP
+	ServiceType corev1.ServiceType `json:"serviceType,omitempty"`
+
+	// ExposeServices exposes the services of this network to the other networks
+	// through the gateway. Defaults to true.
+	ExposeServices *bool `json:"exposeServices,omitempty"`
+
+	// ExposeIstiod exposes istiod through the gateway, so that remote clusters in
+	// other networks can reach it.
+	ExposeIstiod bool `json:"exposeIstiod,omitempty"`
+}
+
+// IsEnabled returns whether the east-west gateway is deployed
+func (c *EastWestGatewayConfig) IsEnabled() bool {
+	return c.Enabled == nil || *c.Enabled
+}
+
+// GetServiceType returns the type of the gateway's Service, or its default if not set
+func (c *EastWestGatewayConfig) GetServiceType() corev1.ServiceType {
+	if c.ServiceType == "" {
+		return corev1.ServiceTypeLoadBalancer
+	}
+	return c.ServiceType
+}
+
+// ShouldExposeServices returns whether the services are exposed through the gateway
+func (c *EastWestGatewayConfig) ShouldExposeServices() bool {
+	return c.ExposeServices == nil || *c.ExposeServices
+}
+
+// MeshNetwork defines a network of the mesh and the gateway through which it's reached
+type MeshNetwork struct {
+	// Name of the network.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Clusters lists the clusters whose endpoints belong to the network.
+	Clusters []string `json:"clusters,omitempty"`
+
+	// GatewayAddress is the address of the network's east-west gateway.
+	// +kubebuilder:validation:MinLength=1
+	GatewayAddress string `json:"gatewayAddress"`
+
+	// GatewayPort is the port of the network's east-west gateway. Defaults to 15443.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	GatewayPort int32 `json:"gatewayPort,omitempty"`
+}
+
+// GetGatewayPort returns the port of the network's gateway, or its default if not set
+func (n MeshNetwork) GetGatewayPort() int32 {
+	if n.GatewayPort == 0 {
+		return 15443
+	}
+	return n.GatewayPort
+}
+
+// RemoteCluster defines a cluster that is managed by the control plane, but doesn't run it
+type RemoteCluster struct {
+	// Name identifies the cluster in the mesh. It's used as the clusterName of the
+	// cluster and must be unique within the mesh.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// KubeconfigSecret references the Secret in the namespace of the Istio resource
+	// that contains the kubeconfig for accessing the cluster.
+	KubeconfigSecret KubeconfigSecretReference `json:"kubeconfigSecret"`
+
+	// DiscoveryAddress is the address at which the proxies and the injection webhook
+	// in the remote cluster reach istiod, e.g. the address of an east-west gateway.
+	// +kubebuilder:validation:MinLength=1
+	DiscoveryAddress string `json:"discoveryAddress"`
+}
+
+// KubeconfigSecretReference references a kubeconfig stored in a Secret
+type KubeconfigSecretReference struct {
+	// Name of the Secret.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Key of the kubeconfig in the Secret. Defaults to "kubeconfig".
+	Key string `json:"key,omitempty"`
+}
+
+// GetKey returns the key of the kubeconfig, or its default if not set
+func (r KubeconfigSecretReference) GetKey() string {
+	if r.Key == "" {
+		return "kubeconfig"
+	}
+	return r.Key
 }
 
+// RolloutPolicy defines how workloads are restarted to pick up a new proxy version
+type RolloutPolicy struct {
+	// Enabled turns on the automatic restart of workloads with outdated proxies.
+	Enabled bool `json:"enabled,omitempty"`
+
+	// BatchSize is the number of workloads that are restarted at once. The next
+	// batch is only restarted once all workloads of the previous batch are
+	// available. Defaults to 1.
+	// +kubebuilder:validation:Minimum=1
+	BatchSize int32 `json:"batchSize,omitempty"`
+
+	// PauseOnFailure pauses the rollout when a restarted workload fails to become
+	// available. The rollout resumes once the workload is available. Defaults to true.
+	PauseOnFailure *bool `json:"pauseOnFailure,omitempty"`
+}
+
+// GetBatchSize returns the batch size, or its default if not set
+func (p *RolloutPolicy) GetBatchSize() int32 {
+	if p.BatchSize < 1 {
+		return 1
+	}
+	return p.BatchSize
+}
+
+// ShouldPauseOnFailure returns whether the rollout should pause when a workload fails to become available
+func (p *RolloutPolicy) ShouldPauseOnFailure() bool {
+	return p.PauseOnFailure == nil || *p.PauseOnFailure
+}
+
+// DeletionPolicy defines what happens when an Istio resource is deleted while it's in use
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyBlockIfInUse blocks the deletion while pods injected by the control plane exist
+	DeletionPolicyBlockIfInUse DeletionPolicy = "BlockIfInUse"
+
+	// DeletionPolicyAlways uninstalls the control plane regardless of its data plane
+	DeletionPolicyAlways DeletionPolicy = "Always"
+)
+
+// AnnotationForceDeletion allows an Istio resource to be deleted regardless of its DeletionPolicy
+const AnnotationForceDeletion = "operator.istio.io/force-deletion"
+
+// AnnotationForceVersionChange allows spec.version to be changed in ways that the upgrade
+// path rules forbid, e.g. to downgrade the control plane or to skip a minor version
+const AnnotationForceVersionChange = "operator.istio.io/force-version-change"
+
 func (s *IstioSpec) GetValues() map[string]interface{} {
 	var vals map[string]interface{}
 	err := json.Unmarshal(s.Values, &vals)
@@ -53,6 +505,15 @@ func (s *IstioSpec) GetValues() map[string]interface{} {
 	return vals
 }
 
+func (s *IstioSpec) GetMeshConfig() map[string]interface{} {
+	var meshConfig map[string]interface{}
+	err := json.Unmarshal(s.MeshConfig, &meshConfig)
+	if err != nil {
+		return nil
+	}
+	return meshConfig
+}
+
 func (s *IstioSpec) SetValues(values map[string]interface{}) error {
 	jsonVals, err := json.Marshal(values)
 	if err != nil {
@@ -69,6 +530,52 @@ type IstioStatus struct {
 	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Applied Helm Values"
 	AppliedValues json.RawMessage `json:"appliedValues,omitempty"`
 
+	// AppliedHash is a hash of the values, charts and operator version that were last
+	// installed successfully. The operator skips the helm upgrade while the hash remains
+	// unchanged and the installed resources haven't been modified.
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Applied Hash"
+	AppliedHash string `json:"appliedHash,omitempty"`
+
+	// AppliedMeshConfig is the mesh configuration that was last installed successfully,
+	// i.e. values.meshConfig merged with spec.meshConfig.
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +kubebuilder:validation:Schemaless
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Applied Mesh Config"
+	AppliedMeshConfig json.RawMessage `json:"appliedMeshConfig,omitempty"`
+
+	// Version is the version of the control plane that was last installed successfully.
+	// Changes of spec.version are validated against this version.
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Installed Version"
+	Version string `json:"version,omitempty"`
+
+	// DataPlane reports the sidecar proxies of the pods injected by this control plane.
+	// It's refreshed periodically.
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Data Plane"
+	DataPlane *DataPlaneStatus `json:"dataPlane,omitempty"`
+
+	// Rollout reports the progress of the automatic restart of workloads, if enabled
+	// in the RolloutPolicy.
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Rollout"
+	Rollout *RolloutStatus `json:"rollout,omitempty"`
+
+	// RemoteClusters reports the state of the installation in each remote cluster.
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Remote Clusters"
+	RemoteClusters []RemoteClusterStatus `json:"remoteClusters,omitempty"`
+
+	// ConfigCluster reports the state of the installation in the config cluster of an
+	// external control plane.
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Config Cluster"
+	ConfigCluster *RemoteClusterStatus `json:"configCluster,omitempty"`
+
+	// CA reports the certificate authority of istiod, if configured in spec.security.ca.
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Certificate Authority"
+	CA *CAStatus `json:"ca,omitempty"`
+
+	// Images lists the images that the operator installs, pinned to their digests
+	// if digest pinning is enabled in the operator configuration.
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Images"
+	Images []ImageStatus `json:"images,omitempty"`
+
 	// ObservedGeneration is the most recent generation observed for this
 	// Istio object. It corresponds to the object's generation, which is
 	// updated on mutation by the API Server. The information in the status
@@ -82,6 +589,142 @@ type IstioStatus struct {
 	State IstioConditionReason `json:"state,omitempty"`
 }
 
+// ImageStatus reports an image that the operator installs
+type ImageStatus struct {
+	// Name of the image: istiod, proxy or cni.
+	Name string `json:"name"`
+
+	// Reference is the image as configured for the version.
+	Reference string `json:"reference"`
+
+	// Image is the reference that is installed. If the image is pinned, it
+	// contains the digest of the image.
+	Image string `json:"image"`
+
+	// Verified is true if the cosign signature of the image was verified.
+	Verified bool `json:"verified,omitempty"`
+
+	// VerificationKey is the SHA-256 fingerprint of the public key that the
+	// signature was verified with. The image is verified again when the key changes.
+	VerificationKey string `json:"verificationKey,omitempty"`
+}
+
+// DataPlaneStatus reports how many of the proxies injected by a control plane run its
+// version. Proxies whose version can't be determined are counted as outdated.
+type DataPlaneStatus struct {
+	// Proxies is the total number of pods injected by the control plane's revision.
+	Proxies int32 `json:"proxies"`
+
+	// UpToDate is the number of proxies that run the proxy image of the control plane.
+	UpToDate int32 `json:"upToDate"`
+
+	// Outdated is the number of proxies that run a different image that's within the
+	// supported version skew. These proxies are updated when their pods are restarted.
+	Outdated int32 `json:"outdated"`
+
+	// UnsupportedSkew is the number of proxies whose version exceeds the supported
+	// version skew of the control plane.
+	UnsupportedSkew int32 `json:"unsupportedSkew"`
+
+	// UnsupportedWorkloads lists (some of) the pods whose proxies exceed the supported
+	// version skew, in the form namespace/name.
+	UnsupportedWorkloads []string `json:"unsupportedWorkloads,omitempty"`
+}
+
+// RolloutState is the state of the automatic restart of workloads
+type RolloutState string
+
+const (
+	// RolloutStateWaiting indicates that the rollout waits for the control plane to become ready
+	RolloutStateWaiting RolloutState = "Waiting"
+
+	// RolloutStateInProgress indicates that workloads are being restarted
+	RolloutStateInProgress RolloutState = "InProgress"
+
+	// RolloutStatePaused indicates that a restarted workload failed to become available
+	RolloutStatePaused RolloutState = "Paused"
+
+	// RolloutStateComplete indicates that all workloads with outdated proxies were restarted
+	RolloutStateComplete RolloutState = "Complete"
+)
+
+// RolloutStatus reports the progress of the automatic restart of workloads
+type RolloutStatus struct {
+	// Target identifies the control plane installation that the workloads are
+	// being restarted for. It's the AppliedHash at the start of the rollout.
+	Target string `json:"target,omitempty"`
+
+	// State is the state of the rollout.
+	State RolloutState `json:"state,omitempty"`
+
+	// Restarted is the number of workloads that have been restarted in this rollout.
+	Restarted int32 `json:"restarted"`
+
+	// Pending is the number of workloads that still have outdated proxies and haven't
+	// been restarted yet.
+	Pending int32 `json:"pending"`
+
+	// Message describes what the rollout is waiting for or why it's paused.
+	Message string `json:"message,omitempty"`
+}
+
+// CAStatus reports the certificate authority that istiod uses
+type CAStatus struct {
+	// Type of the certificate authority.
+	Type CAType `json:"type,omitempty"`
+
+	// NotAfter is the expiry of the CA certificate.
+	NotAfter *metav1.Time `json:"notAfter,omitempty"`
+
+	// RootNotAfter is the expiry of the root certificate.
+	RootNotAfter *metav1.Time `json:"rootNotAfter,omitempty"`
+
+	// RenewalTime is when the operator or cert-manager renews the CA certificate.
+	// It's not set for CAs provided by the user.
+	RenewalTime *metav1.Time `json:"renewalTime,omitempty"`
+
+	// Hash of the contents of the cacerts Secret. Istiod is restarted when it changes.
+	Hash string `json:"hash,omitempty"`
+
+	// Message describes what the operator is waiting for.
+	Message string `json:"message,omitempty"`
+}
+
+// RemoteClusterState is the state of the installation in a remote cluster
+type RemoteClusterState string
+
+const (
+	// RemoteClusterStateInstalled indicates that istiod-remote is installed in the cluster and
+	// that istiod can discover its endpoints
+	RemoteClusterStateInstalled RemoteClusterState = "Installed"
+
+	// RemoteClusterStatePending indicates that the installation waits for the cluster, e.g. for the
+	// token of the reader service account to be issued
+	RemoteClusterStatePending RemoteClusterState = "Pending"
+
+	// RemoteClusterStateError indicates that the installation in the cluster failed
+	RemoteClusterStateError RemoteClusterState = "Error"
+)
+
+// RemoteClusterStatus reports the state of the installation in a remote cluster
+type RemoteClusterStatus struct {
+	// Name of the remote cluster.
+	Name string `json:"name"`
+
+	// State of the installation in the cluster.
+	State RemoteClusterState `json:"state,omitempty"`
+
+	// Message describes the state.
+	Message string `json:"message,omitempty"`
+
+	// AppliedHash is a hash of the values and chart that were last installed in the cluster.
+	AppliedHash string `json:"appliedHash,omitempty"`
+
+	// KubeconfigSecret references the kubeconfig that the cluster was reconciled with.
+	// It's used to uninstall istiod-remote once the cluster is removed from the spec.
+	KubeconfigSecret *KubeconfigSecretReference `json:"kubeconfigSecret,omitempty"`
+}
+
 func (s *IstioStatus) GetAppliedValues() map[string]interface{} {
 	var vals map[string]interface{}
 	err := json.Unmarshal(s.AppliedValues, &vals)
@@ -171,6 +814,26 @@ const (
 
 	// ConditionReasonReconcileError indicates that the reconciliation of the resource has failed, but will be retried.
 	ConditionReasonReconcileError IstioConditionReason = "ReconcileError"
+
+	// ConditionReasonConflict indicates that the resources of the control plane can't be reconciled, because
+	// they conflict with the resources of another Istio resource. The reconciliation will be retried.
+	ConditionReasonConflict IstioConditionReason = "Conflict"
+
+	// ConditionReasonImageVerificationFailed indicates that the control plane isn't installed,
+	// because the signature of one of its images couldn't be verified.
+	ConditionReasonImageVerificationFailed IstioConditionReason = "ImageVerificationFailed"
+
+	// ConditionReasonVersionNotSupported indicates that the control plane isn't reconciled,
+	// because the operator doesn't support its version (anymore).
+	ConditionReasonVersionNotSupported IstioConditionReason = "VersionNotSupported"
+
+	// ConditionReasonVersionChangeNotAllowed indicates that the control plane isn't reconciled,
+	// because the change of its version violates the upgrade path rules.
+	ConditionReasonVersionChangeNotAllowed IstioConditionReason = "VersionChangeNotAllowed"
+
+	// ConditionReasonInvalidMeshConfig indicates that the control plane isn't reconciled,
+	// because spec.meshConfig isn't a valid mesh configuration.
+	ConditionReasonInvalidMeshConfig IstioConditionReason = "InvalidMeshConfig"
 )
 
 const (
@@ -183,6 +846,24 @@ const (
 
 	// ConditionReasonCNINotReady indicates that the control plane is fully reconciled, but istio-cni-node is not ready.
 	ConditionReasonCNINotReady IstioConditionReason = "CNINotReady"
+
+	// ConditionReasonConfigClusterNotReady indicates that istiod is ready, but the installation in the
+	// config cluster of an external control plane isn't complete.
+	ConditionReasonConfigClusterNotReady IstioConditionReason = "ConfigClusterNotReady"
+)
+
+const (
+	// ConditionTypeTerminating signifies that the Istio resource is being deleted and
+	// that the operator is uninstalling its components.
+	ConditionTypeTerminating IstioConditionType = "Terminating"
+
+	// ConditionReasonUninstalling indicates that the components are being uninstalled and that the
+	// operator waits for their workloads to terminate.
+	ConditionReasonUninstalling IstioConditionReason = "Uninstalling"
+
+	// ConditionReasonInUse indicates that the deletion is blocked by the DeletionPolicy, because pods
+	// injected by the control plane still exist.
+	ConditionReasonInUse IstioConditionReason = "InUse"
 )
 
 const (
@@ -0,0 +1,84 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const IstioVersionKind = "IstioVersion"
+
+// IstioVersionStatus describes a version that the operator supports
+type IstioVersionStatus struct {
+	// Latest is true for the latest version that the operator supports.
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Latest"
+	Latest bool `json:"latest,omitempty"`
+
+	// Profiles lists the profiles that can be set in spec.profile.
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Profiles"
+	Profiles []string `json:"profiles,omitempty"`
+
+	// Charts lists the charts of the version.
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Charts"
+	Charts []IstioVersionChart `json:"charts,omitempty"`
+}
+
+// IstioVersionChart describes a chart of a version
+type IstioVersionChart struct {
+	// Name of the chart.
+	Name string `json:"name"`
+
+	// Path of the chart within the charts of the version.
+	Path string `json:"path,omitempty"`
+
+	// Version of the chart.
+	Version string `json:"version,omitempty"`
+
+	// AppVersion is the version of the application that the chart installs.
+	AppVersion string `json:"appVersion,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +operator-sdk:csv:customresourcedefinitions:displayName="Istio Version"
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Latest",type="boolean",JSONPath=".status.latest",description="Whether this is the latest version supported by the operator."
+// +kubebuilder:printcolumn:name="Profiles",type="string",JSONPath=".status.profiles",description="The profiles of the version."
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description="The age of the object"
+
+// IstioVersion is a version of Istio that the operator supports. The operator
+// publishes one IstioVersion for every version that can be set in spec.version of
+// an Istio resource; the resources are read-only.
+type IstioVersion struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status IstioVersionStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// IstioVersionList contains a list of IstioVersion
+type IstioVersionList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IstioVersion `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IstioVersion{}, &IstioVersionList{})
+}
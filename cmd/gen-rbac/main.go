@@ -0,0 +1,63 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// gen-rbac generates the kubebuilder RBAC markers that allow the operator to
+// install the objects of the bundled charts. controller-gen turns the markers into
+// the operator's ClusterRole and Role.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+
+	"maistra.io/istio-operator/controllers"
+	"maistra.io/istio-operator/pkg/rbac"
+)
+
+const header = `// Code generated by gen-rbac. DO NOT EDIT.
+
+package controllers
+
+// Permissions needed to install the charts in %s
+`
+
+func main() {
+	var resourceDir, output, namespace string
+	flag.StringVar(&resourceDir, "resource-dir", "resources", "Directory containing the charts and profiles of all versions")
+	flag.StringVar(&output, "output", "controllers/zz_generated.rbac.go", "File to write the markers to")
+	flag.StringVar(&namespace, "namespace", "system", "Namespace of the Role for the operator namespace")
+	flag.Parse()
+
+	if err := generate(resourceDir, output, namespace); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func generate(resourceDir, output, namespace string) error {
+	permissions, err := rbac.ChartPermissions(resourceDir, controllers.ComponentCharts())
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, header, resourceDir)
+	for _, marker := range rbac.Markers(permissions, namespace) {
+		b.WriteString(marker + "\n")
+	}
+	return os.WriteFile(output, b.Bytes(), 0o644)
+}
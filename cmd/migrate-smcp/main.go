@@ -0,0 +1,129 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// migrate-smcp converts Maistra 2.x ServiceMeshControlPlane and ServiceMeshMemberRoll
+// resources into Istio resources. It either reads the resources from files or from
+// the cluster in the current kubeconfig context and writes the resulting Istio
+// resources to stdout. Fields that can't be migrated are reported on stderr.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"maistra.io/istio-operator/pkg/common"
+	"maistra.io/istio-operator/pkg/migration"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+func main() {
+	var smcpFile, smmrFile, namespace string
+	flag.StringVar(&smcpFile, "smcp-file", "", "File containing the ServiceMeshControlPlane to migrate. If not set, the control planes are read from the cluster.")
+	flag.StringVar(&smmrFile, "smmr-file", "", "File containing the ServiceMeshMemberRoll belonging to the control plane in --smcp-file")
+	flag.StringVar(&namespace, "namespace", "", "Only migrate control planes in this namespace when reading from the cluster (default: all namespaces)")
+	flag.Parse()
+
+	var err error
+	if smcpFile != "" {
+		err = migrateFiles(os.Stdout, os.Stderr, smcpFile, smmrFile)
+	} else {
+		err = migrateCluster(context.Background(), os.Stdout, os.Stderr, namespace)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func migrateFiles(out, warnings io.Writer, smcpFile, smmrFile string) error {
+	smcp, err := readFile(smcpFile)
+	if err != nil {
+		return err
+	}
+	var smmr *unstructured.Unstructured
+	if smmrFile != "" {
+		if smmr, err = readFile(smmrFile); err != nil {
+			return err
+		}
+	}
+	return migrate(out, warnings, smcp, smmr)
+}
+
+func migrateCluster(ctx context.Context, out, warnings io.Writer, namespace string) error {
+	cl, err := client.New(ctrl.GetConfigOrDie(), client.Options{})
+	if err != nil {
+		return err
+	}
+
+	smcps := &unstructured.UnstructuredList{}
+	smcps.SetGroupVersionKind(migration.SMCPGroupVersionKind.GroupVersion().WithKind(migration.SMCPGroupVersionKind.Kind + "List"))
+	if err := cl.List(ctx, smcps, client.InNamespace(namespace)); err != nil {
+		return fmt.Errorf("failed to list ServiceMeshControlPlanes: %v", err)
+	}
+
+	for i := range smcps.Items {
+		smcp := &smcps.Items[i]
+		smmr := &unstructured.Unstructured{}
+		smmr.SetGroupVersionKind(migration.SMMRGroupVersionKind)
+		err := cl.Get(ctx, client.ObjectKey{Namespace: smcp.GetNamespace(), Name: common.MemberRollName}, smmr)
+		if errors.IsNotFound(err) {
+			smmr = nil
+		} else if err != nil {
+			return fmt.Errorf("failed to get ServiceMeshMemberRoll in namespace %s: %v", smcp.GetNamespace(), err)
+		}
+
+		if err := migrate(out, warnings, smcp, smmr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func migrate(out, warnings io.Writer, smcp, smmr *unstructured.Unstructured) error {
+	result, err := migration.ConvertSMCP(smcp, smmr)
+	if err != nil {
+		return fmt.Errorf("failed to migrate ServiceMeshControlPlane %s/%s: %v", smcp.GetNamespace(), smcp.GetName(), err)
+	}
+	for _, field := range result.Unsupported {
+		fmt.Fprintf(warnings, "WARNING: %s/%s: field %s is not supported and was not migrated\n", smcp.GetNamespace(), smcp.GetName(), field)
+	}
+
+	manifest, err := yaml.Marshal(result.Istio)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "---\n%s", manifest)
+	return err
+}
+
+func readFile(file string) (*unstructured.Unstructured, error) {
+	contents, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", file, err)
+	}
+	obj := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal(contents, &obj.Object); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", file, err)
+	}
+	return obj, nil
+}
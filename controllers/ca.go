@@ -0,0 +1,469 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"maistra.io/istio-operator/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// caCertsSecret is the Secret that istiod loads its CA from
+	caCertsSecret = "cacerts"
+
+	caCertKey    = "ca-cert.pem"
+	caKeyKey     = "ca-key.pem"
+	certChainKey = "cert-chain.pem"
+	rootCertKey  = "root-cert.pem"
+	rootKeyKey   = "root-key.pem"
+
+	// certManagerCertificateName is the cert-manager Certificate of the intermediate CA
+	certManagerCertificateName = "istio-ca"
+	// certManagerSecretName is the Secret that cert-manager stores the intermediate CA in
+	certManagerSecretName = "istio-ca-cert-manager"
+
+	// AnnotationCACertsHash is set on the istiod pods. It contains the hash of the
+	// cacerts Secret, so that istiod is restarted when its CA changes.
+	AnnotationCACertsHash = "operator.istio.io/cacerts-hash"
+
+	intermediateCAKeySize = 2048
+
+	// caRenewalPollInterval is how often the CA is checked once it is due for renewal
+	caRenewalPollInterval = time.Minute
+)
+
+var certificateGVK = schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"}
+
+// reconcileCA stores the CA configured in spec.security.ca in the cacerts Secret,
+// records it in istio.Status.CA and sets the hash of the Secret in the istiod pod
+// annotations. It returns an error while the CA isn't available, so that istiod
+// isn't installed with a self-signed CA in the meantime.
+func (r *IstioReconciler) reconcileCA(ctx context.Context, istio *v1alpha1.Istio) error {
+	var config *v1alpha1.CAConfig
+	if istio.Spec.Security != nil {
+		config = istio.Spec.Security.CA
+	}
+	cacerts := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: caCertsSecret, Namespace: istio.Namespace}}
+	if config == nil || config.Type != v1alpha1.CATypeCertManager {
+		if err := r.deleteOwnedObject(ctx, istio, newCertManagerCertificate(istio)); err != nil {
+			return err
+		}
+	}
+	if config == nil {
+		istio.Status.CA = nil
+		return r.deleteOwnedObject(ctx, istio, cacerts)
+	}
+
+	status := &v1alpha1.CAStatus{Type: config.Type}
+	istio.Status.CA = status
+
+	var data map[string][]byte
+	var err error
+	switch config.Type {
+	case v1alpha1.CATypeProvided:
+		if config.Provided == nil {
+			return fmt.Errorf("spec.security.ca.provided must be set for CA type %s", config.Type)
+		}
+		data, err = r.providedCACerts(ctx, istio, config.Provided)
+	case v1alpha1.CATypeGenerated:
+		if config.Generated == nil {
+			return fmt.Errorf("spec.security.ca.generated must be set for CA type %s", config.Type)
+		}
+		data, err = r.generatedCACerts(ctx, istio, config.Generated, time.Now())
+		if err == nil {
+			status.RenewalTime = renewalTime(data, config.Generated.GetRenewBefore())
+		}
+	case v1alpha1.CATypeCertManager:
+		if config.CertManager == nil {
+			return fmt.Errorf("spec.security.ca.certManager must be set for CA type %s", config.Type)
+		}
+		data, status.RenewalTime, err = r.certManagerCACerts(ctx, istio, config.CertManager)
+	default:
+		return fmt.Errorf("unsupported CA type %s", config.Type)
+	}
+	if err != nil {
+		status.Message = err.Error()
+		return err
+	}
+
+	if config.Type != v1alpha1.CATypeProvided || config.Provided.SecretName != caCertsSecret {
+		if err := r.createOrUpdateOwnedObject(ctx, istio, cacerts, func() {
+			cacerts.Data = data
+		}); err != nil {
+			return err
+		}
+	}
+
+	if caCert, err := parseFirstCertificate(data[caCertKey]); err == nil {
+		status.NotAfter = &metav1.Time{Time: caCert.NotAfter}
+	}
+	if rootCert, err := parseFirstCertificate(data[rootCertKey]); err == nil {
+		status.RootNotAfter = &metav1.Time{Time: rootCert.NotAfter}
+	}
+	status.Hash = hashSecretData(data)
+
+	overrides := map[string]interface{}{
+		"pilot": map[string]interface{}{
+			"podAnnotations": map[string]interface{}{
+				AnnotationCACertsHash: status.Hash,
+			},
+		},
+	}
+	return istio.Spec.SetValues(mergeValues(overrides, istio.Spec.GetValues()))
+}
+
+// providedCACerts returns the CA in the Secret provided by the user
+func (r *IstioReconciler) providedCACerts(ctx context.Context, istio *v1alpha1.Istio, config *v1alpha1.ProvidedCAConfig) (map[string][]byte, error) {
+	secret, err := r.getSecret(ctx, istio.Namespace, config.SecretName)
+	if err != nil {
+		return nil, err
+	}
+	data := make(map[string][]byte, 4)
+	for _, key := range []string{caCertKey, caKeyKey, certChainKey, rootCertKey} {
+		if len(secret.Data[key]) == 0 {
+			return nil, fmt.Errorf("secret %s doesn't contain %s", config.SecretName, key)
+		}
+		data[key] = secret.Data[key]
+	}
+	if _, err := parseFirstCertificate(data[caCertKey]); err != nil {
+		return nil, fmt.Errorf("invalid %s in secret %s: %v", caCertKey, config.SecretName, err)
+	}
+	return data, nil
+}
+
+// generatedCACerts returns the intermediate CA in the cacerts Secret if it was signed
+// by the configured root and isn't due for renewal, or a new intermediate CA
+// otherwise
+func (r *IstioReconciler) generatedCACerts(ctx context.Context, istio *v1alpha1.Istio, config *v1alpha1.GeneratedCAConfig,
+	now time.Time,
+) (map[string][]byte, error) {
+	root, err := r.getSecret(ctx, istio.Namespace, config.RootSecretName)
+	if err != nil {
+		return nil, err
+	}
+	rootCertPEM, rootKeyPEM := root.Data[rootCertKey], root.Data[rootKeyKey]
+	if len(rootCertPEM) == 0 || len(rootKeyPEM) == 0 {
+		return nil, fmt.Errorf("secret %s must contain %s and %s", config.RootSecretName, rootCertKey, rootKeyKey)
+	}
+
+	existing := &corev1.Secret{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: istio.Namespace, Name: caCertsSecret}, existing); err == nil {
+		if isValidIntermediateCA(existing.Data, rootCertPEM, now.Add(config.GetRenewBefore())) {
+			return existing.Data, nil
+		}
+	} else if !errors.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to get secret %s: %v", caCertsSecret, err)
+	}
+
+	log.FromContext(ctx).Info("Generating intermediate CA", "root", config.RootSecretName)
+	return generateIntermediateCA(rootCertPEM, rootKeyPEM, fmt.Sprintf("Istio intermediate CA %s/%s", istio.Namespace, istio.Name),
+		config.GetValidity(), now)
+}
+
+// isValidIntermediateCA returns whether the CA in data was signed by the root and is
+// still valid at the given time
+func isValidIntermediateCA(data map[string][]byte, rootCertPEM []byte, validAt time.Time) bool {
+	if !bytes.Equal(data[rootCertKey], rootCertPEM) || len(data[caKeyKey]) == 0 {
+		return false
+	}
+	caCert, err := parseFirstCertificate(data[caCertKey])
+	if err != nil || validAt.After(caCert.NotAfter) {
+		return false
+	}
+	rootCert, err := parseFirstCertificate(rootCertPEM)
+	if err != nil {
+		return false
+	}
+	return caCert.CheckSignatureFrom(rootCert) == nil
+}
+
+// generateIntermediateCA generates a CA certificate and key signed by the root CA.
+// The certificate doesn't outlive the root.
+func generateIntermediateCA(rootCertPEM, rootKeyPEM []byte, commonName string, validity time.Duration, now time.Time) (map[string][]byte, error) {
+	rootCert, err := parseFirstCertificate(rootCertPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid root certificate: %v", err)
+	}
+	rootKey, err := parsePrivateKey(rootKeyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid root key: %v", err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, intermediateCAKeySize)
+	if err != nil {
+		return nil, err
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	notAfter := now.Add(validity)
+	if notAfter.After(rootCert.NotAfter) {
+		notAfter = rootCert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			Organization: rootCert.Subject.Organization,
+			CommonName:   commonName,
+		},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, rootCert, &key.PublicKey, rootKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign intermediate CA: %v", err)
+	}
+
+	caCertPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return map[string][]byte{
+		caCertKey:    caCertPEM,
+		caKeyKey:     pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+		certChainKey: append(append([]byte{}, caCertPEM...), rootCertPEM...),
+		rootCertKey:  rootCertPEM,
+	}, nil
+}
+
+// certManagerCACerts creates the cert-manager Certificate of the intermediate CA and
+// converts the Secret issued by cert-manager to the keys that istiod expects. It
+// also returns the time at which cert-manager renews the certificate.
+func (r *IstioReconciler) certManagerCACerts(ctx context.Context, istio *v1alpha1.Istio, config *v1alpha1.CertManagerCAConfig,
+) (map[string][]byte, *metav1.Time, error) {
+	certificate := newCertManagerCertificate(istio)
+	issuerRef := map[string]interface{}{"name": config.IssuerRef.Name}
+	if config.IssuerRef.Kind != "" {
+		issuerRef["kind"] = config.IssuerRef.Kind
+	}
+	if config.IssuerRef.Group != "" {
+		issuerRef["group"] = config.IssuerRef.Group
+	}
+	spec := map[string]interface{}{
+		"isCA":       true,
+		"commonName": fmt.Sprintf("Istio intermediate CA %s/%s", istio.Namespace, istio.Name),
+		"secretName": certManagerSecretName,
+		"issuerRef":  issuerRef,
+		"usages":     []interface{}{"digital signature", "key encipherment", "cert sign"},
+	}
+	if config.Duration != nil {
+		spec["duration"] = config.Duration.Duration.String()
+	}
+	if config.RenewBefore != nil {
+		spec["renewBefore"] = config.RenewBefore.Duration.String()
+	}
+	if err := r.createOrUpdateOwnedObject(ctx, istio, certificate, func() {
+		certificate.Object["spec"] = spec
+	}); err != nil {
+		if meta.IsNoMatchError(err) {
+			return nil, nil, fmt.Errorf("cert-manager is not installed: %v", err)
+		}
+		return nil, nil, err
+	}
+
+	var renewal *metav1.Time
+	if value, _, _ := unstructured.NestedString(certificate.Object, "status", "renewalTime"); value != "" {
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			renewal = &metav1.Time{Time: t}
+		}
+	}
+
+	secret, err := r.getSecret(ctx, istio.Namespace, certManagerSecretName)
+	if errors.IsNotFound(err) {
+		return nil, renewal, fmt.Errorf("waiting for cert-manager to issue the CA certificate %s", certManagerCertificateName)
+	} else if err != nil {
+		return nil, renewal, err
+	}
+	tlsCert, tlsKey, issuerCert := secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey], secret.Data["ca.crt"]
+	if len(tlsCert) == 0 || len(tlsKey) == 0 || len(issuerCert) == 0 {
+		return nil, renewal, fmt.Errorf("waiting for cert-manager to issue the CA certificate %s", certManagerCertificateName)
+	}
+
+	chain, root, err := chainToRoot(tlsCert, issuerCert)
+	if err != nil {
+		return nil, renewal, fmt.Errorf("invalid CA certificate issued by cert-manager: %v", err)
+	}
+	return map[string][]byte{
+		caCertKey:    tlsCert,
+		caKeyKey:     tlsKey,
+		certChainKey: chain,
+		rootCertKey:  root,
+	}, renewal, nil
+}
+
+// chainToRoot returns the chain from the first certificate in certPEM up to a
+// self-signed root certificate, and the root certificate. The chain is built from
+// the certificates in certPEM and issuerPEM. cert-manager stores the certificate of
+// the issuer in ca.crt, which is only the root if the issuer is a root CA, so the
+// root must either be the issuer or be included in one of them.
+func chainToRoot(certPEM, issuerPEM []byte) (chain, root []byte, err error) {
+	certs, err := parseCertificates(append(append([]byte{}, certPEM...), issuerPEM...))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(certs) == 0 {
+		return nil, nil, fmt.Errorf("no PEM-encoded certificate found")
+	}
+	cert := certs[0]
+	for i := 0; i < len(certs); i++ {
+		chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+		if bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil {
+			return chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), nil
+		}
+		var issuer *x509.Certificate
+		for _, candidate := range certs {
+			if bytes.Equal(candidate.RawSubject, cert.RawIssuer) && cert.CheckSignatureFrom(candidate) == nil {
+				issuer = candidate
+				break
+			}
+		}
+		if issuer == nil {
+			break
+		}
+		cert = issuer
+	}
+	return nil, nil, fmt.Errorf("the chain of %q doesn't end in a self-signed root certificate; "+
+		"the issuer must be a root CA or include its root certificate in the chain", certs[0].Subject.CommonName)
+}
+
+func newCertManagerCertificate(istio *v1alpha1.Istio) *unstructured.Unstructured {
+	certificate := &unstructured.Unstructured{}
+	certificate.SetGroupVersionKind(certificateGVK)
+	certificate.SetName(certManagerCertificateName)
+	certificate.SetNamespace(istio.Namespace)
+	return certificate
+}
+
+func (r *IstioReconciler) getSecret(ctx context.Context, namespace, name string) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, secret); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to get secret %s: %v", name, err)
+	}
+	return secret, nil
+}
+
+// caRenewalRequeueAfter returns the duration after which the CA must be reconciled to
+// pick up its renewal, or zero if it isn't renewed by the operator or cert-manager
+func caRenewalRequeueAfter(status *v1alpha1.CAStatus, now time.Time) time.Duration {
+	if status == nil || status.RenewalTime == nil {
+		return 0
+	}
+	if after := status.RenewalTime.Sub(now); after > 0 {
+		return after
+	}
+	return caRenewalPollInterval
+}
+
+// renewalTime returns when the CA certificate in data is renewed
+func renewalTime(data map[string][]byte, renewBefore time.Duration) *metav1.Time {
+	caCert, err := parseFirstCertificate(data[caCertKey])
+	if err != nil {
+		return nil
+	}
+	return &metav1.Time{Time: caCert.NotAfter.Add(-renewBefore)}
+}
+
+// hashSecretData returns a hash of the keys and values of a Secret
+func hashSecretData(data map[string][]byte) string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(h, "%s\x00%d\x00", key, len(data[key]))
+		h.Write(data[key])
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// parseCertificates parses the PEM-encoded certificates in data
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+func parseFirstCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no PEM-encoded certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM-encoded key found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case *ecdsa.PrivateKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", key)
+}
@@ -0,0 +1,291 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	v1 "maistra.io/istio-operator/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newTestRootCA returns a self-signed root certificate and its key
+func newTestRootCA(t *testing.T, notAfter time.Time) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Must(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"cluster.local"}, CommonName: "Root CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Must(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	Must(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestGenerateIntermediateCA(t *testing.T) {
+	now := time.Now()
+	rootNotAfter := now.Add(90 * 24 * time.Hour).Truncate(time.Second)
+	rootCertPEM, rootKeyPEM := newTestRootCA(t, rootNotAfter)
+
+	data, err := generateIntermediateCA(rootCertPEM, rootKeyPEM, "test", 365*24*time.Hour, now)
+	Must(t, err)
+
+	caCert, err := parseFirstCertificate(data[caCertKey])
+	Must(t, err)
+	rootCert, err := parseFirstCertificate(rootCertPEM)
+	Must(t, err)
+	if err := caCert.CheckSignatureFrom(rootCert); err != nil {
+		t.Errorf("expected CA certificate to be signed by the root: %v", err)
+	}
+	if !caCert.IsCA {
+		t.Error("expected a CA certificate")
+	}
+	if !caCert.NotAfter.Equal(rootNotAfter) {
+		t.Errorf("expected CA certificate to expire with the root at %v, got %v", rootNotAfter, caCert.NotAfter)
+	}
+	if _, err := parsePrivateKey(data[caKeyKey]); err != nil {
+		t.Errorf("invalid CA key: %v", err)
+	}
+	if string(data[rootCertKey]) != string(rootCertPEM) {
+		t.Error("expected root-cert.pem to contain the root certificate")
+	}
+
+	if !isValidIntermediateCA(data, rootCertPEM, now) {
+		t.Error("expected generated CA to be valid")
+	}
+	if isValidIntermediateCA(data, rootCertPEM, rootNotAfter.Add(time.Second)) {
+		t.Error("expected CA to be invalid after its expiry")
+	}
+	otherRootCertPEM, _ := newTestRootCA(t, rootNotAfter)
+	if isValidIntermediateCA(data, otherRootCertPEM, now) {
+		t.Error("expected CA to be invalid for another root")
+	}
+}
+
+func TestChainToRoot(t *testing.T) {
+	now := time.Now()
+	rootCertPEM, rootKeyPEM := newTestRootCA(t, now.Add(365*24*time.Hour))
+	issuer, err := generateIntermediateCA(rootCertPEM, rootKeyPEM, "issuer", 24*time.Hour, now)
+	Must(t, err)
+	ca, err := generateIntermediateCA(issuer[caCertKey], issuer[caKeyKey], "istio", 24*time.Hour, now)
+	Must(t, err)
+	concat := func(pems ...[]byte) []byte {
+		return bytes.Join(pems, nil)
+	}
+	fullChain := concat(ca[caCertKey], issuer[caCertKey], rootCertPEM)
+
+	testCases := []struct {
+		name          string
+		certPEM       []byte
+		issuerPEM     []byte
+		expectedChain []byte
+	}{
+		{name: "root issuer", certPEM: issuer[caCertKey], issuerPEM: rootCertPEM, expectedChain: concat(issuer[caCertKey], rootCertPEM)},
+		{name: "intermediate issuer with root", certPEM: ca[caCertKey], issuerPEM: concat(issuer[caCertKey], rootCertPEM), expectedChain: fullChain},
+		{name: "chain in certificate", certPEM: concat(ca[caCertKey], issuer[caCertKey]), issuerPEM: rootCertPEM, expectedChain: fullChain},
+		{name: "intermediate issuer without root", certPEM: ca[caCertKey], issuerPEM: issuer[caCertKey]},
+		{name: "unrelated issuer", certPEM: ca[caCertKey], issuerPEM: rootCertPEM},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			chain, root, err := chainToRoot(tc.certPEM, tc.issuerPEM)
+			if tc.expectedChain == nil {
+				if err == nil {
+					t.Error("expected error")
+				}
+				return
+			}
+			Must(t, err)
+			if !bytes.Equal(root, rootCertPEM) {
+				t.Errorf("expected the self-signed root, got %s", root)
+			}
+			if !bytes.Equal(chain, tc.expectedChain) {
+				t.Errorf("expected chain up to the root, got %s", chain)
+			}
+		})
+	}
+}
+
+func TestReconcileCA(t *testing.T) {
+	ctx := context.Background()
+	rootCertPEM, rootKeyPEM := newTestRootCA(t, time.Now().Add(10*365*24*time.Hour))
+	providedCA, err := generateIntermediateCA(rootCertPEM, rootKeyPEM, "provided", 24*time.Hour, time.Now())
+	Must(t, err)
+
+	s := runtime.NewScheme()
+	Must(t, clientgoscheme.AddToScheme(s))
+	s.AddKnownTypeWithName(certificateGVK, &unstructured.Unstructured{})
+	s.AddKnownTypeWithName(certificateGVK.GroupVersion().WithKind("CertificateList"), &unstructured.UnstructuredList{})
+
+	newSecret := func(name string, data map[string][]byte) *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "istio-system"}, Data: data}
+	}
+	newIstio := func(ca *v1.CAConfig) *v1.Istio {
+		istio := &v1.Istio{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "istio-system", UID: "istio-uid"}}
+		if ca != nil {
+			istio.Spec.Security = &v1.SecurityConfig{CA: ca}
+		}
+		return istio
+	}
+	getCACerts := func(t *testing.T, cl client.Client) map[string][]byte {
+		secret := &corev1.Secret{}
+		Must(t, cl.Get(ctx, client.ObjectKey{Namespace: "istio-system", Name: caCertsSecret}, secret))
+		return secret.Data
+	}
+	expectHashAnnotation := func(t *testing.T, istio *v1.Istio) {
+		t.Helper()
+		hash, _, _ := unstructured.NestedString(istio.Spec.GetValues(), "pilot", "podAnnotations", AnnotationCACertsHash)
+		if hash == "" || hash != istio.Status.CA.Hash {
+			t.Errorf("expected istiod pod annotation with hash %q, got %q", istio.Status.CA.Hash, hash)
+		}
+	}
+
+	t.Run("provided", func(t *testing.T) {
+		cl := fake.NewClientBuilder().WithScheme(s).WithObjects(newSecret("my-ca", providedCA)).Build()
+		r := &IstioReconciler{Client: cl}
+		istio := newIstio(&v1.CAConfig{Type: v1.CATypeProvided, Provided: &v1.ProvidedCAConfig{SecretName: "my-ca"}})
+
+		Must(t, r.reconcileCA(ctx, istio))
+		if string(getCACerts(t, cl)[caCertKey]) != string(providedCA[caCertKey]) {
+			t.Error("expected provided CA to be copied to cacerts")
+		}
+		if istio.Status.CA.NotAfter == nil || istio.Status.CA.RootNotAfter == nil {
+			t.Errorf("expected expiry in status, got %+v", istio.Status.CA)
+		}
+		expectHashAnnotation(t, istio)
+
+		// removing the CA deletes cacerts
+		istio.Spec.Security = nil
+		Must(t, r.reconcileCA(ctx, istio))
+		if err := cl.Get(ctx, client.ObjectKey{Namespace: "istio-system", Name: caCertsSecret}, &corev1.Secret{}); !errors.IsNotFound(err) {
+			t.Errorf("expected cacerts to be deleted, got %v", err)
+		}
+		if istio.Status.CA != nil {
+			t.Errorf("expected no CA status, got %+v", istio.Status.CA)
+		}
+	})
+
+	t.Run("provided secret missing a key", func(t *testing.T) {
+		cl := fake.NewClientBuilder().WithScheme(s).WithObjects(newSecret("my-ca", map[string][]byte{caCertKey: providedCA[caCertKey]})).Build()
+		r := &IstioReconciler{Client: cl}
+		istio := newIstio(&v1.CAConfig{Type: v1.CATypeProvided, Provided: &v1.ProvidedCAConfig{SecretName: "my-ca"}})
+		if err := r.reconcileCA(ctx, istio); err == nil {
+			t.Error("expected error for incomplete secret")
+		}
+	})
+
+	t.Run("generated", func(t *testing.T) {
+		cl := fake.NewClientBuilder().WithScheme(s).
+			WithObjects(newSecret("root-ca", map[string][]byte{rootCertKey: rootCertPEM, rootKeyKey: rootKeyPEM})).
+			Build()
+		r := &IstioReconciler{Client: cl}
+		config := &v1.GeneratedCAConfig{RootSecretName: "root-ca"}
+		istio := newIstio(&v1.CAConfig{Type: v1.CATypeGenerated, Generated: config})
+
+		Must(t, r.reconcileCA(ctx, istio))
+		first := getCACerts(t, cl)
+		if !isValidIntermediateCA(first, rootCertPEM, time.Now()) {
+			t.Error("expected cacerts to contain an intermediate CA signed by the root")
+		}
+		if istio.Status.CA.RenewalTime == nil || !istio.Status.CA.RenewalTime.Time.Equal(istio.Status.CA.NotAfter.Add(-30*24*time.Hour)) {
+			t.Errorf("unexpected renewal time in status %+v", istio.Status.CA)
+		}
+		expectHashAnnotation(t, istio)
+		firstHash := istio.Status.CA.Hash
+
+		// the CA is reused until it's due for renewal
+		istio = newIstio(&v1.CAConfig{Type: v1.CATypeGenerated, Generated: config})
+		Must(t, r.reconcileCA(ctx, istio))
+		if istio.Status.CA.Hash != firstHash {
+			t.Error("expected the intermediate CA to be reused")
+		}
+
+		config.RenewBefore = &metav1.Duration{Duration: 366 * 24 * time.Hour}
+		istio = newIstio(&v1.CAConfig{Type: v1.CATypeGenerated, Generated: config})
+		Must(t, r.reconcileCA(ctx, istio))
+		if istio.Status.CA.Hash == firstHash {
+			t.Error("expected the intermediate CA to be renewed")
+		}
+	})
+
+	t.Run("cert-manager", func(t *testing.T) {
+		cl := fake.NewClientBuilder().WithScheme(s).Build()
+		r := &IstioReconciler{Client: cl}
+		istio := newIstio(&v1.CAConfig{
+			Type: v1.CATypeCertManager,
+			CertManager: &v1.CertManagerCAConfig{
+				IssuerRef: v1.CertManagerIssuerReference{Name: "root", Kind: "ClusterIssuer"},
+				Duration:  &metav1.Duration{Duration: 720 * time.Hour},
+			},
+		})
+
+		if err := r.reconcileCA(ctx, istio); err == nil {
+			t.Error("expected error while cert-manager hasn't issued the certificate")
+		}
+		certificate := newCertManagerCertificate(istio)
+		Must(t, cl.Get(ctx, client.ObjectKeyFromObject(certificate), certificate))
+		if kind, _, _ := unstructured.NestedString(certificate.Object, "spec", "issuerRef", "kind"); kind != "ClusterIssuer" {
+			t.Errorf("expected issuer kind ClusterIssuer, got %q", kind)
+		}
+		if duration, _, _ := unstructured.NestedString(certificate.Object, "spec", "duration"); duration != "720h0m0s" {
+			t.Errorf("expected duration 720h0m0s, got %q", duration)
+		}
+
+		Must(t, cl.Create(ctx, newSecret(certManagerSecretName, map[string][]byte{
+			"tls.crt": providedCA[caCertKey],
+			"tls.key": providedCA[caKeyKey],
+			"ca.crt":  rootCertPEM,
+		})))
+		Must(t, r.reconcileCA(ctx, istio))
+		cacerts := getCACerts(t, cl)
+		if string(cacerts[rootCertKey]) != string(rootCertPEM) || string(cacerts[certChainKey]) != string(providedCA[certChainKey]) {
+			t.Error("expected cert-manager secret to be converted to cacerts")
+		}
+		expectHashAnnotation(t, istio)
+
+		// switching to another CA type deletes the Certificate
+		istio.Spec.Security.CA = &v1.CAConfig{Type: v1.CATypeProvided, Provided: &v1.ProvidedCAConfig{SecretName: certManagerSecretName}}
+		_ = r.reconcileCA(ctx, istio)
+		if err := cl.Get(ctx, client.ObjectKeyFromObject(certificate), newCertManagerCertificate(istio)); !errors.IsNotFound(err) {
+			t.Errorf("expected Certificate to be deleted, got %v", err)
+		}
+	})
+}
+
+func TestCARenewalRequeueAfter(t *testing.T) {
+	now := time.Now()
+	renewal := metav1.NewTime(now.Add(time.Hour))
+	if after := caRenewalRequeueAfter(&v1.CAStatus{RenewalTime: &renewal}, now); after != time.Hour {
+		t.Errorf("expected requeue at the renewal time, got %v", after)
+	}
+	if after := caRenewalRequeueAfter(&v1.CAStatus{RenewalTime: &renewal}, now.Add(2*time.Hour)); after != caRenewalPollInterval {
+		t.Errorf("expected requeue after %v once the CA is due for renewal, got %v", caRenewalPollInterval, after)
+	}
+	if after := caRenewalRequeueAfter(&v1.CAStatus{Type: v1.CATypeProvided}, now); after != 0 {
+		t.Errorf("expected no requeue without renewal time, got %v", after)
+	}
+}
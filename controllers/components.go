@@ -0,0 +1,288 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"maistra.io/istio-operator/api/v1alpha1"
+	"maistra.io/istio-operator/pkg/kube"
+	"maistra.io/istio-operator/pkg/rbac"
+
+	"istio.io/api/label"
+)
+
+// component is a chart that the operator installs for an Istio resource
+type component struct {
+	// name of the component; also the suffix of its helm release name
+	name string
+	// chart is the path of the chart, relative to the charts directory of the version
+	chart string
+	// chartName is the name of the chart in its Chart.yaml
+	chartName string
+	// system components are installed in the operator namespace instead of the istio namespace
+	system bool
+	// shared components contain resources that are shared by all control planes in the
+	// cluster (or namespace). A control plane with a revision reuses them when they are
+	// installed by another control plane.
+	shared bool
+	// dependsOn lists the components that must be installed before this one
+	dependsOn []string
+	// enabledPath is the path of the value that enables the component. If empty, the
+	// component is always installed.
+	enabledPath []string
+	// enabledFor returns whether the component is enabled by the spec of the Istio
+	// resource. It's checked in addition to enabledPath.
+	enabledFor func(istio *v1alpha1.Istio) bool
+	// chartValues returns the values that the chart is installed with. If nil, the
+	// chart is installed with the values of the control plane.
+	chartValues func(istio *v1alpha1.Istio, values map[string]interface{}) map[string]interface{}
+	// podLabels select the pods of the component
+	podLabels map[string]string
+	// revisioned components label their pods with the revision of the control plane
+	revisioned bool
+	// controlPlane components are installed when the control plane manages an external
+	// config cluster. All other components belong to the config cluster.
+	controlPlane bool
+}
+
+// components is the graph of all components. Components whose dependencies are
+// installed are installed concurrently.
+var components = []component{
+	{
+		name:      "cni",
+		chart:     "istio-cni",
+		chartName: "cni",
+		system:    true,
+		shared:    true,
+		podLabels: map[string]string{"k8s-app": "istio-cni-node"},
+	},
+	{
+		name:      "base",
+		chart:     "base",
+		chartName: "base",
+		shared:    true,
+	},
+	{
+		name:         "istiod",
+		chart:        "istio-control/istio-discovery",
+		chartName:    "istiod",
+		dependsOn:    []string{"base"},
+		podLabels:    map[string]string{"operator.istio.io/component": "Pilot"},
+		revisioned:   true,
+		controlPlane: true,
+	},
+	{
+		name:        "ingress-gateway",
+		chart:       "gateways/istio-ingress",
+		chartName:   "istio-ingress",
+		dependsOn:   []string{"istiod"},
+		enabledPath: []string{"gateways", "istio-ingressgateway", "enabled"},
+		podLabels:   map[string]string{"operator.istio.io/component": "IngressGateways"},
+		revisioned:  true,
+	},
+	{
+		name:        "egress-gateway",
+		chart:       "gateways/istio-egress",
+		chartName:   "istio-egress",
+		dependsOn:   []string{"istiod"},
+		enabledPath: []string{"gateways", "istio-egressgateway", "enabled"},
+		podLabels:   map[string]string{"operator.istio.io/component": "EgressGateways"},
+		revisioned:  true,
+	},
+	{
+		name:        "eastwest-gateway",
+		chart:       "gateway",
+		chartName:   "gateway",
+		dependsOn:   []string{"istiod"},
+		enabledFor:  hasEastWestGateway,
+		chartValues: eastWestGatewayValues,
+		podLabels:   map[string]string{"istio": eastWestGatewayLabel},
+	},
+}
+
+func (c component) releaseSuffix() string {
+	return "-" + c.name
+}
+
+func (c component) releaseName(istio *v1alpha1.Istio) string {
+	return istio.Name + c.releaseSuffix()
+}
+
+func (c component) namespace(istio *v1alpha1.Istio) string {
+	if c.system {
+		return kube.GetOperatorNamespace()
+	}
+	return istio.Namespace
+}
+
+func (c component) isEnabled(values map[string]interface{}) bool {
+	if len(c.enabledPath) == 0 {
+		return true
+	}
+	enabled, _, _ := unstructured.NestedBool(values, c.enabledPath...)
+	return enabled
+}
+
+// isInstalled returns whether the component is installed for the Istio resource
+func (c component) isInstalled(istio *v1alpha1.Istio, values map[string]interface{}) bool {
+	if istio.Spec.ConfigCluster != nil && !c.controlPlane {
+		return false
+	}
+	if c.enabledFor != nil && !c.enabledFor(istio) {
+		return false
+	}
+	return c.isEnabled(values)
+}
+
+// valuesFor returns the values that the component's chart is installed with
+func (c component) valuesFor(istio *v1alpha1.Istio, values map[string]interface{}) map[string]interface{} {
+	if c.chartValues == nil {
+		return values
+	}
+	return c.chartValues(istio, values)
+}
+
+// ComponentCharts returns the charts of all components, with sample values for the
+// charts that aren't installed with the values of the Istio resource. It is used
+// to generate the operator's RBAC rules.
+func ComponentCharts() []rbac.Chart {
+	sample := &v1alpha1.Istio{Spec: v1alpha1.IstioSpec{MultiNetwork: &v1alpha1.MultiNetworkConfig{Network: "network"}}}
+	charts := make([]rbac.Chart, 0, len(components))
+	for _, c := range components {
+		chart := rbac.Chart{Path: c.chart, System: c.system}
+		if c.chartValues != nil {
+			chart.Values = c.chartValues(sample, nil)
+		}
+		charts = append(charts, chart)
+	}
+	return charts
+}
+
+// podSelector returns the labels of the component's pods in the given revision
+func (c component) podSelector(revision string) map[string]string {
+	selector := make(map[string]string, len(c.podLabels)+1)
+	for k, v := range c.podLabels {
+		selector[k] = v
+	}
+	if c.revisioned {
+		selector[label.IoIstioRev.Name] = revision
+	}
+	return selector
+}
+
+// sortComponents returns the components in dependency order, i.e. each component
+// comes after all of its dependencies
+func sortComponents(comps []component) ([]component, error) {
+	byName := make(map[string]component, len(comps))
+	for _, c := range comps {
+		byName[c.name] = c
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int, len(comps))
+	sorted := make([]component, 0, len(comps))
+	var visit func(c component) error
+	visit = func(c component) error {
+		switch state[c.name] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("dependency cycle at component %s", c.name)
+		}
+		state[c.name] = visiting
+		for _, dep := range c.dependsOn {
+			depComponent, found := byName[dep]
+			if !found {
+				return fmt.Errorf("component %s depends on unknown component %s", c.name, dep)
+			}
+			if err := visit(depComponent); err != nil {
+				return err
+			}
+		}
+		state[c.name] = visited
+		sorted = append(sorted, c)
+		return nil
+	}
+
+	for _, c := range comps {
+		if err := visit(c); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
+
+// processComponents calls fn for all components concurrently, but only after fn
+// has returned successfully for all of the component's dependencies. Components
+// whose dependencies failed are skipped.
+func processComponents(ctx context.Context, comps []component, fn func(ctx context.Context, c component) error) error {
+	sorted, err := sortComponents(comps)
+	if err != nil {
+		return err
+	}
+
+	done := make(map[string]chan struct{}, len(sorted))
+	for _, c := range sorted {
+		done[c.name] = make(chan struct{})
+	}
+
+	var mu sync.Mutex
+	errs := make(map[string]error, len(sorted))
+	var wg sync.WaitGroup
+	for _, c := range sorted {
+		wg.Add(1)
+		go func(c component) {
+			defer wg.Done()
+			defer close(done[c.name])
+
+			for _, dep := range c.dependsOn {
+				<-done[dep]
+				mu.Lock()
+				depErr := errs[dep]
+				mu.Unlock()
+				if depErr != nil {
+					mu.Lock()
+					errs[c.name] = fmt.Errorf("component %s was skipped, because component %s failed", c.name, dep)
+					mu.Unlock()
+					return
+				}
+			}
+
+			err := fn(ctx, c)
+			mu.Lock()
+			errs[c.name] = err
+			mu.Unlock()
+		}(c)
+	}
+	wg.Wait()
+
+	var result []error
+	for _, c := range sorted {
+		if errs[c.name] != nil {
+			result = append(result, errs[c.name])
+		}
+	}
+	return utilerrors.NewAggregate(result)
+}
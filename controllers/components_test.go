@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSortComponents(t *testing.T) {
+	testCases := []struct {
+		name        string
+		components  []component
+		expectOrder []string
+		expectErr   bool
+	}{
+		{
+			name:        "default components",
+			components:  components,
+			expectOrder: []string{"cni", "base", "istiod", "ingress-gateway", "egress-gateway", "eastwest-gateway"},
+		},
+		{
+			name: "dependencies listed last",
+			components: []component{
+				{name: "c", dependsOn: []string{"b"}},
+				{name: "b", dependsOn: []string{"a"}},
+				{name: "a"},
+			},
+			expectOrder: []string{"a", "b", "c"},
+		},
+		{
+			name: "cycle",
+			components: []component{
+				{name: "a", dependsOn: []string{"b"}},
+				{name: "b", dependsOn: []string{"a"}},
+			},
+			expectErr: true,
+		},
+		{
+			name: "unknown dependency",
+			components: []component{
+				{name: "a", dependsOn: []string{"missing"}},
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sorted, err := sortComponents(tc.components)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("sortComponents() error = %v, expectErr %v", err, tc.expectErr)
+			}
+			var order []string
+			for _, c := range sorted {
+				order = append(order, c.name)
+			}
+			if diff := cmp.Diff(tc.expectOrder, order); diff != "" {
+				t.Errorf("unexpected order; diff (-expected, +actual):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestProcessComponents(t *testing.T) {
+	comps := []component{
+		{name: "cni"},
+		{name: "base"},
+		{name: "istiod", dependsOn: []string{"base"}},
+		{name: "gateway", dependsOn: []string{"istiod"}},
+	}
+
+	t.Run("dependency order", func(t *testing.T) {
+		var mu sync.Mutex
+		var finished []string
+		started := map[string]chan struct{}{"cni": make(chan struct{}), "base": make(chan struct{})}
+
+		err := processComponents(context.Background(), comps, func(_ context.Context, c component) error {
+			// cni and base are independent, so each of them must be able to wait for the other one to start
+			if ch, found := started[c.name]; found {
+				close(ch)
+				for _, other := range started {
+					select {
+					case <-other:
+					case <-time.After(10 * time.Second):
+						return fmt.Errorf("independent components weren't processed concurrently")
+					}
+				}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for _, dep := range c.dependsOn {
+				if !contains(finished, dep) {
+					return fmt.Errorf("component %s processed before its dependency %s", c.name, dep)
+				}
+			}
+			finished = append(finished, c.name)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(finished) != len(comps) {
+			t.Errorf("expected all components to be processed, got %v", finished)
+		}
+	})
+
+	t.Run("failed dependency", func(t *testing.T) {
+		var mu sync.Mutex
+		var processed []string
+		err := processComponents(context.Background(), comps, func(_ context.Context, c component) error {
+			mu.Lock()
+			processed = append(processed, c.name)
+			mu.Unlock()
+			if c.name == "base" {
+				return fmt.Errorf("failed")
+			}
+			return nil
+		})
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if contains(processed, "istiod") || contains(processed, "gateway") {
+			t.Errorf("expected components depending on the failed component to be skipped, got %v", processed)
+		}
+		if !contains(processed, "cni") {
+			t.Errorf("expected independent component to be processed, got %v", processed)
+		}
+	})
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
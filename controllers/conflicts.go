@@ -0,0 +1,91 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"maistra.io/istio-operator/api/v1alpha1"
+	"maistra.io/istio-operator/pkg/helm"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// conflictError indicates that an Istio resource can't be reconciled, because
+// it overlaps with another Istio resource
+type conflictError struct {
+	message string
+}
+
+func newConflictError(format string, args ...interface{}) error {
+	return &conflictError{message: fmt.Sprintf(format, args...)}
+}
+
+func (e *conflictError) Error() string {
+	return e.message
+}
+
+// isConflict returns true if the error (or any of the aggregated errors) is
+// caused by a conflict with another Istio resource
+func isConflict(err error) bool {
+	var agg utilerrors.Aggregate
+	if errors.As(err, &agg) {
+		for _, e := range agg.Errors() {
+			if isConflict(e) {
+				return true
+			}
+		}
+		return false
+	}
+	var conflictErr *conflictError
+	return errors.As(err, &conflictErr) || helm.IsConflict(err)
+}
+
+// checkConflicts returns an error if another Istio resource is in the same namespace
+// or uses the same revision. The state of a mesh, e.g. the cacerts Secret and the
+// labels of its member namespaces, is kept per istio namespace, so each namespace
+// can only hold one Istio resource. Each control plane must use its own revision,
+// because the revision determines which control plane injects the sidecars into a
+// namespace's pods. The Istio resource that was created first wins.
+func (r *IstioReconciler) checkConflicts(ctx context.Context, istio *v1alpha1.Istio, revision string) error {
+	istios := &v1alpha1.IstioList{}
+	if err := r.Client.List(ctx, istios); err != nil {
+		return err
+	}
+	for i := range istios.Items {
+		other := &istios.Items[i]
+		if other.UID == istio.UID || other.DeletionTimestamp != nil || !createdBefore(other, istio) {
+			continue
+		}
+		if other.Namespace == istio.Namespace {
+			return newConflictError("namespace %s already contains Istio %s", istio.Namespace, other.Name)
+		}
+		if getRevision(other.Spec.GetValues()) == revision {
+			return newConflictError("revision %s is already used by Istio %s", revision, client.ObjectKeyFromObject(other))
+		}
+	}
+	return nil
+}
+
+func createdBefore(a, b *v1alpha1.Istio) bool {
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+	return client.ObjectKeyFromObject(a).String() < client.ObjectKeyFromObject(b).String()
+}
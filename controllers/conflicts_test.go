@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	v1 "maistra.io/istio-operator/api/v1alpha1"
+	"maistra.io/istio-operator/pkg/helm"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestIsConflict(t *testing.T) {
+	helmConflict := &helm.ConflictError{Kind: "DaemonSet", Name: "istio-cni-node", Owner: types.NamespacedName{Namespace: "a", Name: "b"}}
+	testCases := []struct {
+		name   string
+		err    error
+		expect bool
+	}{
+		{name: "nil", err: nil, expect: false},
+		{name: "other error", err: fmt.Errorf("boom"), expect: false},
+		{name: "conflict", err: newConflictError("conflict"), expect: true},
+		{name: "wrapped helm conflict", err: fmt.Errorf("failed to install helm chart: %w", helmConflict), expect: true},
+		{name: "aggregate", err: utilerrors.NewAggregate([]error{fmt.Errorf("boom"), newConflictError("conflict")}), expect: true},
+		{name: "aggregate without conflict", err: utilerrors.NewAggregate([]error{fmt.Errorf("boom")}), expect: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := isConflict(tc.err); actual != tc.expect {
+				t.Errorf("isConflict() = %v, expected %v", actual, tc.expect)
+			}
+		})
+	}
+}
+
+func TestCheckConflicts(t *testing.T) {
+	ctx := context.Background()
+	s := runtime.NewScheme()
+	Must(t, v1.AddToScheme(s))
+
+	now := time.Now()
+	newIstio := func(namespace, uid string, created time.Time, values string) *v1.Istio {
+		return &v1.Istio{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "istio",
+				Namespace:         namespace,
+				UID:               types.UID(uid),
+				CreationTimestamp: metav1.NewTime(created),
+			},
+			Spec: v1.IstioSpec{Version: "v3.0", Values: []byte(values)},
+		}
+	}
+
+	first := newIstio("first", "1", now.Add(-time.Hour), "")
+	second := newIstio("second", "2", now, "")
+	canary := newIstio("canary", "3", now, `{"revision":"canary"}`)
+
+	cl := fake.NewClientBuilder().WithScheme(s).WithObjects(first, second, canary).Build()
+	r := &IstioReconciler{Client: cl}
+
+	if err := r.checkConflicts(ctx, first, "default"); err != nil {
+		t.Errorf("expected no conflict for the first control plane, got %v", err)
+	}
+	if err := r.checkConflicts(ctx, second, "default"); !isConflict(err) {
+		t.Errorf("expected conflict for the second control plane, got %v", err)
+	}
+	if err := r.checkConflicts(ctx, canary, "canary"); err != nil {
+		t.Errorf("expected no conflict for the control plane with its own revision, got %v", err)
+	}
+
+	// a namespace can only hold one Istio resource, even with different revisions
+	older := newIstio("shared", "4", now.Add(-time.Hour), `{"revision":"blue"}`)
+	newer := newIstio("shared", "5", now, `{"revision":"green"}`)
+	newer.Name = "istio-green"
+	deleted := newIstio("shared", "6", now.Add(-2*time.Hour), `{"revision":"red"}`)
+	deleted.Name = "istio-red"
+	deleted.DeletionTimestamp = &metav1.Time{Time: now}
+	deleted.Finalizers = []string{"test"}
+	cl = fake.NewClientBuilder().WithScheme(s).WithObjects(older, newer, deleted).Build()
+	r = &IstioReconciler{Client: cl}
+	if err := r.checkConflicts(ctx, older, "blue"); err != nil {
+		t.Errorf("expected no conflict for the older Istio in the namespace, got %v", err)
+	}
+	err := r.checkConflicts(ctx, newer, "green")
+	if !isConflict(err) {
+		t.Fatalf("expected conflict for the second Istio in the namespace, got %v", err)
+	}
+	if condition := determineReconciledCondition(err); condition.Reason != v1.ConditionReasonConflict {
+		t.Errorf("expected reason %s, got %s", v1.ConditionReasonConflict, condition.Reason)
+	}
+}
+
+func TestDetermineReconciledCondition(t *testing.T) {
+	if reason := determineReconciledCondition(newConflictError("conflict")).Reason; reason != v1.ConditionReasonConflict {
+		t.Errorf("expected reason %s, got %s", v1.ConditionReasonConflict, reason)
+	}
+	if reason := determineReconciledCondition(fmt.Errorf("boom")).Reason; reason != v1.ConditionReasonReconcileError {
+		t.Errorf("expected reason %s, got %s", v1.ConditionReasonReconcileError, reason)
+	}
+}
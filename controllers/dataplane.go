@@ -0,0 +1,292 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"maistra.io/istio-operator/api/v1alpha1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"istio.io/api/annotation"
+	"istio.io/api/label"
+)
+
+const (
+	// maxProxyMinorSkew is the number of minor versions a proxy may lag behind its control plane
+	maxProxyMinorSkew = 2
+
+	// maxUnsupportedWorkloads limits the number of pods listed in the status
+	maxUnsupportedWorkloads = 10
+
+	proxyContainerName = "istio-proxy"
+)
+
+// versionPattern extracts the major and minor version from versions like v3.0 and
+// image tags like 1.20.1 or 3.0-latest
+var versionPattern = regexp.MustCompile(`^v?(\d+)\.(\d+)`)
+
+// setupDataPlaneStatusController sets up the controller that refreshes the data plane
+// status of the Istio resources when the pods injected by their control planes change.
+// It only takes an inventory of the pods, so the installation isn't reconciled.
+func (r *IstioReconciler) setupDataPlaneStatusController(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("istio-dataplane").
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(r.mapPodToReconcileRequest)).
+		Complete(reconcile.Func(r.reconcileDataPlaneStatus))
+}
+
+// reconcileDataPlaneStatus updates the data plane status of the Istio resource. The
+// revision and proxy image are taken from the values that were last applied.
+func (r *IstioReconciler) reconcileDataPlaneStatus(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	istio := &v1alpha1.Istio{}
+	if err := r.Client.Get(ctx, req.NamespacedName, istio); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	values := istio.Status.GetAppliedValues()
+	if istio.DeletionTimestamp != nil || values == nil {
+		return ctrl.Result{}, nil
+	}
+
+	dataPlane, err := r.inventoryDataPlane(ctx, istio, values)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if reflect.DeepEqual(dataPlane, istio.Status.DataPlane) {
+		return ctrl.Result{}, nil
+	}
+	log.FromContext(ctx).V(2).Info("Updating data plane status", "proxies", dataPlane.Proxies, "upToDate", dataPlane.UpToDate)
+	patch := client.MergeFrom(istio.DeepCopy())
+	istio.Status.DataPlane = dataPlane
+	return ctrl.Result{}, r.Client.Status().Patch(ctx, istio, patch)
+}
+
+// mapPodToReconcileRequest returns the Istio resources whose revision injected the pod
+func (r *IstioReconciler) mapPodToReconcileRequest(ctx context.Context, obj client.Object) []reconcile.Request {
+	revision, found := obj.GetLabels()[label.IoIstioRev.Name]
+	if _, injected := obj.GetAnnotations()[annotation.SidecarStatus.Name]; !found || !injected {
+		return nil
+	}
+
+	istios := &v1alpha1.IstioList{}
+	if err := r.Client.List(ctx, istios); err != nil {
+		log.FromContext(ctx).Error(err, "failed to list Istio resources")
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, istio := range istios.Items {
+		if values := istio.Status.GetAppliedValues(); values != nil && getRevision(values) == revision {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&istio)})
+		}
+	}
+	return requests
+}
+
+// ignoreDataPlaneStatusChanges filters out the updates of an Istio resource that only
+// change its data plane status, so that refreshing the status doesn't reconcile the
+// installation
+type ignoreDataPlaneStatusChanges struct {
+	predicate.Funcs
+}
+
+func (ignoreDataPlaneStatusChanges) Update(e event.UpdateEvent) bool {
+	oldIstio, ok := e.ObjectOld.(*v1alpha1.Istio)
+	if !ok {
+		return true
+	}
+	newIstio, ok := e.ObjectNew.(*v1alpha1.Istio)
+	if !ok {
+		return true
+	}
+	oldIstio, newIstio = oldIstio.DeepCopy(), newIstio.DeepCopy()
+	for _, istio := range []*v1alpha1.Istio{oldIstio, newIstio} {
+		istio.ResourceVersion = ""
+		istio.ManagedFields = nil
+		istio.Status.DataPlane = nil
+	}
+	return !reflect.DeepEqual(oldIstio, newIstio)
+}
+
+// listInjectedPods returns the pods in all namespaces that have a sidecar injected by
+// the given revision. The pods of the Istio resource's own components, e.g. the
+// injected east-west gateway, aren't part of the data plane and are excluded.
+func (r *IstioReconciler) listInjectedPods(ctx context.Context, istio *v1alpha1.Istio, revision string) ([]corev1.Pod, error) {
+	pods := &corev1.PodList{}
+	if err := r.Client.List(ctx, pods, client.MatchingLabels{label.IoIstioRev.Name: revision}); err != nil {
+		return nil, fmt.Errorf("failed to list injected pods: %v", err)
+	}
+
+	var injected []corev1.Pod
+	for _, pod := range pods.Items {
+		status, found := pod.Annotations[annotation.SidecarStatus.Name]
+		if !found {
+			continue
+		}
+		// the label can be changed by the user, but the status annotation records the actual injector
+		var sidecarStatus struct {
+			Revision string `json:"revision"`
+		}
+		if err := json.Unmarshal([]byte(status), &sidecarStatus); err == nil && sidecarStatus.Revision != "" &&
+			sidecarStatus.Revision != revision {
+			continue
+		}
+		if isComponentPod(istio, &pod, revision) {
+			continue
+		}
+		injected = append(injected, pod)
+	}
+	return injected, nil
+}
+
+// isComponentPod returns whether the pod belongs to one of the components of the Istio resource
+func isComponentPod(istio *v1alpha1.Istio, pod *corev1.Pod, revision string) bool {
+	for _, c := range components {
+		if len(c.podLabels) == 0 || pod.Namespace != c.namespace(istio) {
+			continue
+		}
+		if labels.SelectorFromSet(c.podSelector(revision)).Matches(labels.Set(pod.Labels)) {
+			return true
+		}
+	}
+	return false
+}
+
+// inventoryDataPlane counts the proxies injected by the control plane by their
+// version relative to the control plane
+func (r *IstioReconciler) inventoryDataPlane(ctx context.Context, istio *v1alpha1.Istio, values map[string]interface{}) (*v1alpha1.DataPlaneStatus, error) {
+	pods, err := r.listInjectedPods(ctx, istio, getRevision(values))
+	if err != nil {
+		return nil, err
+	}
+
+	expectedImage := expectedProxyImage(values)
+	version := controlPlaneVersion(istio, expectedImage)
+	status := &v1alpha1.DataPlaneStatus{}
+	var unsupported []string
+	for i := range pods {
+		image := proxyImage(&pods[i])
+		if image == "" {
+			continue
+		}
+		status.Proxies++
+		switch {
+		case image == expectedImage:
+			status.UpToDate++
+		case exceedsSupportedSkew(version, imageTag(image)):
+			status.UnsupportedSkew++
+			unsupported = append(unsupported, pods[i].Namespace+"/"+pods[i].Name)
+		default:
+			status.Outdated++
+		}
+	}
+
+	sort.Strings(unsupported)
+	if len(unsupported) > maxUnsupportedWorkloads {
+		unsupported = unsupported[:maxUnsupportedWorkloads]
+	}
+	status.UnsupportedWorkloads = unsupported
+	return status, nil
+}
+
+// expectedProxyImage returns the image that the injector uses for the proxy container
+func expectedProxyImage(values map[string]interface{}) string {
+	image, _, _ := unstructured.NestedString(values, "global", "proxy", "image")
+	if image == "" || strings.Contains(image, "/") {
+		return image
+	}
+	hub, _, _ := unstructured.NestedString(values, "global", "hub")
+	tag, found, _ := unstructured.NestedFieldNoCopy(values, "global", "tag")
+	if !found || tag == "" {
+		return hub + "/" + image
+	}
+	return fmt.Sprintf("%s/%s:%v", hub, image, tag)
+}
+
+// controlPlaneVersion returns the version that the proxies are compared against. This
+// is the tag of the proxy image applied by the control plane, because spec.version
+// (e.g. v3.0) doesn't follow the versioning of the proxy images (e.g. 1.20.1). The
+// spec.version is only used if the image tag carries no version.
+func controlPlaneVersion(istio *v1alpha1.Istio, expectedImage string) string {
+	if tag := imageTag(expectedImage); versionPattern.MatchString(tag) {
+		return tag
+	}
+	return istio.Spec.Version
+}
+
+// proxyImage returns the image of the pod's proxy container, which is either a
+// regular container or a native sidecar (init) container
+func proxyImage(pod *corev1.Pod) string {
+	for _, containers := range [][]corev1.Container{pod.Spec.Containers, pod.Spec.InitContainers} {
+		for _, c := range containers {
+			if c.Name == proxyContainerName {
+				return c.Image
+			}
+		}
+	}
+	return ""
+}
+
+// imageTag returns the tag of the image, or an empty string if the image is only referenced by digest
+func imageTag(image string) string {
+	// images pinned by the operator keep their tag in front of the digest
+	image, _, _ = strings.Cut(image, "@")
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[i+1:]
+	}
+	return ""
+}
+
+// exceedsSupportedSkew returns whether a proxy of the given version is not supported by
+// a control plane of the given version. Proxies whose version is unknown are assumed
+// to be supported.
+func exceedsSupportedSkew(controlPlaneVersion, proxyVersion string) bool {
+	cpMajor, cpMinor, ok := parseMinorVersion(controlPlaneVersion)
+	if !ok {
+		return false
+	}
+	major, minor, ok := parseMinorVersion(proxyVersion)
+	if !ok {
+		return false
+	}
+	return major != cpMajor || minor > cpMinor || cpMinor-minor > maxProxyMinorSkew
+}
+
+func parseMinorVersion(version string) (major, minor int, ok bool) {
+	match := versionPattern.FindStringSubmatch(version)
+	if match == nil {
+		return 0, 0, false
+	}
+	major, _ = strconv.Atoi(match[1])
+	minor, _ = strconv.Atoi(match[2])
+	return major, minor, true
+}
@@ -0,0 +1,277 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	v1 "maistra.io/istio-operator/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestInventoryDataPlane(t *testing.T) {
+	newPod := func(namespace, name, revision, image string, injected bool) client.Object {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Labels:    map[string]string{"istio.io/rev": revision},
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{
+					{Name: "app", Image: "app:1.0"},
+					{Name: "istio-proxy", Image: image},
+				},
+			},
+		}
+		if injected {
+			pod.Annotations = map[string]string{"sidecar.istio.io/status": `{"revision":"` + revision + `"}`}
+		}
+		return pod
+	}
+
+	istio := &v1.Istio{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "istio-system"},
+		Spec:       v1.IstioSpec{Version: "v3.0"},
+	}
+	values := map[string]interface{}{
+		"global": map[string]interface{}{
+			"proxy": map[string]interface{}{"image": "quay.io/maistra-dev/proxyv2:3.0-latest"},
+		},
+	}
+
+	// the pods of the control plane's own components aren't part of the data plane
+	eastWestGatewayPod := newPod("istio-system", "istio-eastwestgateway", "default", "docker.io/istio/proxyv2:1.20.1", true)
+	eastWestGatewayPod.SetLabels(map[string]string{"istio.io/rev": "default", "istio": eastWestGatewayLabel})
+
+	cl := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(
+		newPod("ns1", "up-to-date", "default", "quay.io/maistra-dev/proxyv2:3.0-latest", true),
+		newPod("ns1", "digest", "default", "quay.io/maistra-dev/proxyv2@sha256:abc", true),
+		newPod("ns2", "older-major", "default", "docker.io/istio/proxyv2:1.20.1", true),
+		newPod("ns2", "newer-minor", "default", "quay.io/maistra-dev/proxyv2:3.1", true),
+		newPod("ns3", "other-revision", "canary", "docker.io/istio/proxyv2:1.20.1", true),
+		newPod("ns3", "not-injected", "default", "docker.io/istio/proxyv2:1.20.1", false),
+		eastWestGatewayPod,
+	).Build()
+	r := &IstioReconciler{Client: cl}
+
+	actual, err := r.inventoryDataPlane(context.Background(), istio, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &v1.DataPlaneStatus{
+		Proxies:              4,
+		UpToDate:             1,
+		Outdated:             1,
+		UnsupportedSkew:      2,
+		UnsupportedWorkloads: []string{"ns2/newer-minor", "ns2/older-major"},
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("unexpected data plane status; diff (-expected, +actual):\n%v", diff)
+	}
+}
+
+func TestReconcileDataPlaneStatus(t *testing.T) {
+	ctx := context.Background()
+	s := runtime.NewScheme()
+	Must(t, scheme.AddToScheme(s))
+	Must(t, v1.AddToScheme(s))
+
+	newPod := func(name, revision string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "bookinfo",
+				Labels:      map[string]string{"istio.io/rev": revision},
+				Annotations: map[string]string{"sidecar.istio.io/status": `{"revision":"` + revision + `"}`},
+			},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "istio-proxy", Image: "quay.io/maistra/proxyv2:3.0"}}},
+		}
+	}
+	istio := &v1.Istio{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "istio-system", Generation: 1},
+		Spec:       v1.IstioSpec{Version: "v3.0"},
+		Status: v1.IstioStatus{
+			ObservedGeneration: 1,
+			AppliedValues:      []byte(`{"revision":"canary","global":{"proxy":{"image":"quay.io/maistra/proxyv2:3.0"}}}`),
+		},
+	}
+	notInstalled := &v1.Istio{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "istio-system"}}
+	pod := newPod("productpage", "canary")
+	cl := fake.NewClientBuilder().WithScheme(s).WithStatusSubresource(&v1.Istio{}).
+		WithObjects(istio, notInstalled, pod, newPod("reviews", "default")).Build()
+	r := &IstioReconciler{Client: cl}
+
+	expectedRequests := []reconcile.Request{{NamespacedName: client.ObjectKeyFromObject(istio)}}
+	if diff := cmp.Diff(expectedRequests, r.mapPodToReconcileRequest(ctx, pod)); diff != "" {
+		t.Errorf("unexpected requests (-expected +actual):\n%s", diff)
+	}
+	if requests := r.mapPodToReconcileRequest(ctx, newPod("details", "default")); len(requests) != 0 {
+		t.Errorf("expected no requests for a pod of another revision, got %v", requests)
+	}
+
+	for _, obj := range []*v1.Istio{istio, notInstalled} {
+		_, err := r.reconcileDataPlaneStatus(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(obj)})
+		Must(t, err)
+	}
+	updated := &v1.Istio{}
+	Must(t, cl.Get(ctx, client.ObjectKeyFromObject(istio), updated))
+	if diff := cmp.Diff(&v1.DataPlaneStatus{Proxies: 1, UpToDate: 1}, updated.Status.DataPlane); diff != "" {
+		t.Errorf("unexpected data plane status (-expected +actual):\n%s", diff)
+	}
+	if updated.Status.ObservedGeneration != 1 || !cmp.Equal(updated.Status.GetAppliedValues(), istio.Status.GetAppliedValues()) {
+		t.Errorf("expected the rest of the status to be kept, got %+v", updated.Status)
+	}
+	Must(t, cl.Get(ctx, client.ObjectKeyFromObject(notInstalled), updated))
+	if updated.Status.DataPlane != nil {
+		t.Errorf("expected no data plane status before the installation, got %+v", updated.Status.DataPlane)
+	}
+
+	// the update of the data plane status doesn't trigger a reconciliation of the installation
+	changed := istio.DeepCopy()
+	changed.ResourceVersion = "2"
+	changed.Status.DataPlane = &v1.DataPlaneStatus{Proxies: 1}
+	if (ignoreDataPlaneStatusChanges{}).Update(event.UpdateEvent{ObjectOld: istio, ObjectNew: changed}) {
+		t.Error("expected a change of the data plane status to be ignored")
+	}
+	changed.Spec.Members = []string{"bookinfo"}
+	if !(ignoreDataPlaneStatusChanges{}).Update(event.UpdateEvent{ObjectOld: istio, ObjectNew: changed}) {
+		t.Error("expected a change of the spec to be reconciled")
+	}
+}
+
+func TestInventoryDataPlaneWithUpstreamTag(t *testing.T) {
+	newPod := func(name, image string) client.Object {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "ns1",
+				Labels:      map[string]string{"istio.io/rev": "default"},
+				Annotations: map[string]string{"sidecar.istio.io/status": `{"revision":"default"}`},
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "istio-proxy", Image: image}},
+			},
+		}
+	}
+
+	// the proxies are compared against the tag of the applied image, not spec.version
+	istio := &v1.Istio{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "istio-system"},
+		Spec:       v1.IstioSpec{Version: "v3.0"},
+	}
+	values := map[string]interface{}{
+		"global": map[string]interface{}{
+			"proxy": map[string]interface{}{"image": "docker.io/istio/proxyv2:1.20.1"},
+		},
+	}
+
+	cl := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(
+		newPod("up-to-date", "docker.io/istio/proxyv2:1.20.1"),
+		newPod("older-patch", "docker.io/istio/proxyv2:1.20.0"),
+		newPod("older-minor", "docker.io/istio/proxyv2:1.19.3"),
+		newPod("too-old", "docker.io/istio/proxyv2:1.17.8"),
+	).Build()
+	r := &IstioReconciler{Client: cl}
+
+	actual, err := r.inventoryDataPlane(context.Background(), istio, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &v1.DataPlaneStatus{
+		Proxies:              4,
+		UpToDate:             1,
+		Outdated:             2,
+		UnsupportedSkew:      1,
+		UnsupportedWorkloads: []string{"ns1/too-old"},
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("unexpected data plane status; diff (-expected, +actual):\n%v", diff)
+	}
+}
+
+func TestControlPlaneVersion(t *testing.T) {
+	istio := &v1.Istio{Spec: v1.IstioSpec{Version: "v3.0"}}
+	testCases := []struct {
+		image  string
+		expect string
+	}{
+		{image: "docker.io/istio/proxyv2:1.20.1", expect: "1.20.1"},
+		{image: "quay.io/maistra-dev/proxyv2:3.0-latest", expect: "3.0-latest"},
+		{image: "quay.io/maistra-dev/proxyv2:latest", expect: "v3.0"},
+		{image: "quay.io/maistra-dev/proxyv2@sha256:abc", expect: "v3.0"},
+		{image: "", expect: "v3.0"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.image, func(t *testing.T) {
+			if actual := controlPlaneVersion(istio, tc.image); actual != tc.expect {
+				t.Errorf("controlPlaneVersion(%q) = %q, expected %q", tc.image, actual, tc.expect)
+			}
+		})
+	}
+}
+
+func TestExceedsSupportedSkew(t *testing.T) {
+	testCases := []struct {
+		controlPlane string
+		proxy        string
+		expect       bool
+	}{
+		{controlPlane: "v3.0", proxy: "3.0-latest", expect: false},
+		{controlPlane: "v3.2", proxy: "3.0.1", expect: false},
+		{controlPlane: "v3.3", proxy: "3.0.1", expect: true},
+		{controlPlane: "v3.0", proxy: "3.1", expect: true},
+		{controlPlane: "v3.0", proxy: "2.9", expect: true},
+		{controlPlane: "v3.0", proxy: "latest", expect: false},
+		{controlPlane: "latest", proxy: "1.0", expect: false},
+		{controlPlane: "1.20.1", proxy: "1.18.0", expect: false},
+		{controlPlane: "1.20.1", proxy: "1.17.8", expect: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.controlPlane+"/"+tc.proxy, func(t *testing.T) {
+			if actual := exceedsSupportedSkew(tc.controlPlane, tc.proxy); actual != tc.expect {
+				t.Errorf("exceedsSupportedSkew(%q, %q) = %v, expected %v", tc.controlPlane, tc.proxy, actual, tc.expect)
+			}
+		})
+	}
+}
+
+func TestExpectedProxyImage(t *testing.T) {
+	testCases := []struct {
+		name   string
+		values map[string]interface{}
+		expect string
+	}{
+		{
+			name:   "full image",
+			values: map[string]interface{}{"global": map[string]interface{}{"proxy": map[string]interface{}{"image": "quay.io/proxy:1.0"}}},
+			expect: "quay.io/proxy:1.0",
+		},
+		{
+			name: "hub and tag",
+			values: map[string]interface{}{"global": map[string]interface{}{
+				"hub": "docker.io/istio", "tag": "1.20.0", "proxy": map[string]interface{}{"image": "proxyv2"},
+			}},
+			expect: "docker.io/istio/proxyv2:1.20.0",
+		},
+		{
+			name:   "no image",
+			values: map[string]interface{}{},
+			expect: "",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := expectedProxyImage(tc.values); actual != tc.expect {
+				t.Errorf("expected %q, got %q", tc.expect, actual)
+			}
+		})
+	}
+}
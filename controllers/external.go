@@ -0,0 +1,193 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"maistra.io/istio-operator/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// istioKubeconfigSecret contains the kubeconfig that an external istiod uses to
+	// access its config cluster. The istiod chart mounts it if it exists.
+	istioKubeconfigSecret = "istio-kubeconfig"
+	istioKubeconfigKey    = "config"
+	// istioKubeconfigOfLabel records the Istio resource that the kubeconfig secret was
+	// created for. It's separate from remoteSecretOfLabel, so that the secret isn't
+	// mistaken for the remote secret of a removed cluster.
+	istioKubeconfigOfLabel = "operator.istio.io/istio-kubeconfig-of"
+)
+
+// applyExternalControlPlane configures istiod to run outside of its config cluster:
+// it reads its configuration and manages the webhooks in the config cluster, so
+// neither the webhooks nor validation are installed in this cluster
+func applyExternalControlPlane(istio *v1alpha1.Istio) error {
+	if istio.Spec.ConfigCluster == nil {
+		return nil
+	}
+
+	overrides := map[string]interface{}{
+		"global": map[string]interface{}{
+			"externalIstiod":         true,
+			"operatorManageWebhooks": true,
+			"configValidation":       false,
+			"multiCluster": map[string]interface{}{
+				"clusterName": istio.Spec.ConfigCluster.Name,
+			},
+		},
+		"pilot": map[string]interface{}{
+			"env": map[string]interface{}{
+				"EXTERNAL_ISTIOD":                "true",
+				"LOCAL_CLUSTER_SECRET_WATCHER":   "true",
+				"INJECTION_WEBHOOK_CONFIG_NAME":  "",
+				"VALIDATION_WEBHOOK_CONFIG_NAME": "",
+			},
+		},
+	}
+	return istio.Spec.SetValues(mergeValues(overrides, istio.Spec.GetValues()))
+}
+
+// configClusterValues returns the values that make the istiod-remote chart install the
+// resources of a config cluster
+func configClusterValues() map[string]interface{} {
+	return map[string]interface{}{
+		"global": map[string]interface{}{
+			"configCluster":          true,
+			"configValidation":       true,
+			"operatorManageWebhooks": false,
+		},
+		"pilot": map[string]interface{}{
+			"configMap": true,
+		},
+		"telemetry": map[string]interface{}{
+			"enabled": true,
+		},
+	}
+}
+
+// reconcileConfigCluster installs the webhooks and CRDs in the config cluster of an
+// external control plane and records its state in the status. It returns the
+// duration after which a pending installation should be checked again.
+func (r *IstioReconciler) reconcileConfigCluster(ctx context.Context, istio *v1alpha1.Istio, values map[string]interface{}) (time.Duration, error) {
+	cluster := istio.Spec.ConfigCluster
+	if cluster == nil {
+		// uninstall istiod-remote from the cluster that was the config cluster
+		if previous := istio.Status.ConfigCluster; previous != nil && previous.KubeconfigSecret != nil {
+			removed := v1alpha1.RemoteCluster{Name: previous.Name, KubeconfigSecret: *previous.KubeconfigSecret}
+			if err := r.uninstallRemoteCluster(ctx, istio, removed); err != nil {
+				previous.State = v1alpha1.RemoteClusterStateError
+				previous.Message = err.Error()
+				return 0, err
+			}
+		}
+		istio.Status.ConfigCluster = nil
+		return 0, r.deleteIstioKubeconfig(ctx, istio)
+	}
+
+	var previous v1alpha1.RemoteClusterStatus
+	if istio.Status.ConfigCluster != nil && istio.Status.ConfigCluster.Name == cluster.Name {
+		previous = *istio.Status.ConfigCluster
+	}
+	status, err := r.reconcileRemoteCluster(ctx, istio, values, *cluster, previous, true)
+	istio.Status.ConfigCluster = &status
+	if err != nil {
+		status.State = v1alpha1.RemoteClusterStateError
+		status.Message = err.Error()
+		return 0, fmt.Errorf("failed to reconcile config cluster %s: %w", cluster.Name, err)
+	} else if status.State == v1alpha1.RemoteClusterStatePending {
+		return remoteClusterPollInterval, nil
+	}
+	return 0, nil
+}
+
+// reconcileIstioKubeconfig creates the secret with the kubeconfig that istiod uses to
+// access the config cluster. The kubeconfig uses the token of istiod's service account
+// in the config cluster. It returns a message describing what it's waiting for, or an
+// empty string when the secret is up to date.
+func (r *IstioReconciler) reconcileIstioKubeconfig(ctx context.Context, istio *v1alpha1.Istio, clusterName string,
+	remote client.Client, host string,
+) (string, error) {
+	kubeconfig, pending, err := serviceAccountKubeconfig(ctx, remote, istio.Namespace, istiodServiceAccount(istio), clusterName, host)
+	if err != nil || pending != "" {
+		return pending, err
+	}
+
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: istioKubeconfigSecret, Namespace: istio.Namespace}}
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		if owner := istioKubeconfigOwner(secret); owner != "" && owner != istio.Name {
+			return newConflictError("secret %s is already managed by Istio %s", secret.Name, owner)
+		}
+		if secret.Labels == nil {
+			secret.Labels = map[string]string{}
+		}
+		delete(secret.Labels, remoteSecretOfLabel)
+		secret.Labels[istioKubeconfigOfLabel] = istio.Name
+		secret.OwnerReferences = []metav1.OwnerReference{newOwnerReference(istio)}
+		secret.Data = map[string][]byte{istioKubeconfigKey: kubeconfig}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to reconcile secret %s: %w", istioKubeconfigSecret, err)
+	}
+	return "", nil
+}
+
+// deleteIstioKubeconfig deletes the kubeconfig of the config cluster once the Istio
+// resource no longer has one
+func (r *IstioReconciler) deleteIstioKubeconfig(ctx context.Context, istio *v1alpha1.Istio) error {
+	secret := &corev1.Secret{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: istio.Namespace, Name: istioKubeconfigSecret}, secret); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get secret %s: %v", istioKubeconfigSecret, err)
+	}
+	if istioKubeconfigOwner(secret) != istio.Name {
+		return nil
+	}
+	if err := r.Client.Delete(ctx, secret); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete secret %s: %v", istioKubeconfigSecret, err)
+	}
+	return nil
+}
+
+// istioKubeconfigOwner returns the name of the Istio resource that the kubeconfig
+// secret was created for. Older versions of the operator labeled it as a remote secret.
+func istioKubeconfigOwner(secret *corev1.Secret) string {
+	if owner, found := secret.Labels[istioKubeconfigOfLabel]; found {
+		return owner
+	}
+	return secret.Labels[remoteSecretOfLabel]
+}
+
+// istiodServiceAccount returns the name of istiod's service account
+func istiodServiceAccount(istio *v1alpha1.Istio) string {
+	name := "istiod"
+	if revision, _, _ := unstructured.NestedString(istio.Spec.GetValues(), "revision"); revision != "" {
+		name += "-" + revision
+	}
+	return name
+}
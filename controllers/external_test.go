@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"context"
+	"path"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes/scheme"
+	v1 "maistra.io/istio-operator/api/v1alpha1"
+	"maistra.io/istio-operator/pkg/common"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestApplyExternalControlPlane(t *testing.T) {
+	istio := &v1.Istio{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "istio-system"},
+		Spec: v1.IstioSpec{
+			Version:       "v3.0",
+			Values:        []byte(`{"global":{"configValidation":true,"meshID":"mesh1"}}`),
+			ConfigCluster: &v1.RemoteCluster{Name: "config", DiscoveryAddress: "10.0.0.1"},
+		},
+	}
+	Must(t, applyExternalControlPlane(istio))
+	values := istio.Spec.GetValues()
+
+	for _, tc := range []struct {
+		path   []string
+		expect interface{}
+	}{
+		{path: []string{"global", "meshID"}, expect: "mesh1"},
+		{path: []string{"global", "configValidation"}, expect: false},
+		{path: []string{"global", "operatorManageWebhooks"}, expect: true},
+		{path: []string{"global", "multiCluster", "clusterName"}, expect: "config"},
+		{path: []string{"pilot", "env", "EXTERNAL_ISTIOD"}, expect: "true"},
+	} {
+		value, _, _ := unstructured.NestedFieldNoCopy(values, tc.path...)
+		if value != tc.expect {
+			t.Errorf("expected %v to be %v, got %v", tc.path, tc.expect, value)
+		}
+	}
+
+	var installed []string
+	for _, c := range components {
+		if c.isInstalled(istio, values) {
+			installed = append(installed, c.name)
+		}
+	}
+	if len(installed) != 1 || installed[0] != "istiod" {
+		t.Errorf("expected only istiod to be installed in the control plane cluster, got %v", installed)
+	}
+
+	// the config cluster gets the CRDs and validation
+	configValues, err := remoteClusterValues(path.Join(common.RepositoryRoot, "resources"), istio, values, *istio.Spec.ConfigCluster, true)
+	Must(t, err)
+	for _, tc := range []struct {
+		path   []string
+		expect interface{}
+	}{
+		{path: []string{"global", "configCluster"}, expect: true},
+		{path: []string{"global", "configValidation"}, expect: true},
+		{path: []string{"global", "operatorManageWebhooks"}, expect: false},
+		{path: []string{"global", "remotePilotAddress"}, expect: "10.0.0.1"},
+		{path: []string{"pilot", "configMap"}, expect: true},
+	} {
+		value, _, _ := unstructured.NestedFieldNoCopy(configValues, tc.path...)
+		if value != tc.expect {
+			t.Errorf("expected %v to be %v in config cluster, got %v", tc.path, tc.expect, value)
+		}
+	}
+}
+
+func TestReconcileIstioKubeconfig(t *testing.T) {
+	ctx := context.Background()
+	istio := &v1.Istio{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "istio-system", UID: "uid"},
+		Spec:       v1.IstioSpec{Values: []byte(`{"revision":"canary"}`)},
+	}
+
+	primary := fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+	remote := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: tokenSecretName("istiod-canary"), Namespace: "istio-system"},
+		Data:       map[string][]byte{corev1.ServiceAccountTokenKey: []byte("token")},
+	}).Build()
+	r := &IstioReconciler{Client: primary}
+
+	pending, err := r.reconcileIstioKubeconfig(ctx, istio, "config", remote, "https://config:6443")
+	Must(t, err)
+	if pending != "" {
+		t.Fatalf("expected the kubeconfig secret to be created, got %q", pending)
+	}
+	secret := &corev1.Secret{}
+	Must(t, primary.Get(ctx, client.ObjectKey{Namespace: "istio-system", Name: istioKubeconfigSecret}, secret))
+	if len(secret.Data[istioKubeconfigKey]) == 0 {
+		t.Errorf("expected kubeconfig in secret")
+	}
+
+	// removing the config cluster deletes the secret
+	istio.Spec.ConfigCluster = nil
+	_, err = r.reconcileConfigCluster(ctx, istio, nil)
+	Must(t, err)
+	if err := primary.Get(ctx, client.ObjectKeyFromObject(secret), secret); err == nil {
+		t.Errorf("expected secret %s to be deleted", istioKubeconfigSecret)
+	}
+}
+
+func TestReconcileIstioKubeconfigWithRemoteClusters(t *testing.T) {
+	ctx := context.Background()
+	istio := &v1.Istio{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "istio-system", UID: "uid"},
+		Spec: v1.IstioSpec{
+			ConfigCluster:  &v1.RemoteCluster{Name: "config"},
+			RemoteClusters: []v1.RemoteCluster{{Name: "cluster2"}},
+		},
+	}
+
+	primary := fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+	remote := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: tokenSecretName("istiod"), Namespace: "istio-system"},
+			Data:       map[string][]byte{corev1.ServiceAccountTokenKey: []byte("token")},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: tokenSecretName(readerServiceAccount), Namespace: "istio-system"},
+			Data:       map[string][]byte{corev1.ServiceAccountTokenKey: []byte("token")},
+		},
+	).Build()
+	r := &IstioReconciler{Client: primary}
+
+	_, err := r.reconcileIstioKubeconfig(ctx, istio, "config", remote, "https://config:6443")
+	Must(t, err)
+	for _, cluster := range []string{"cluster2", "cluster3"} {
+		_, err := r.reconcileRemoteSecret(ctx, istio, cluster, remote, "https://"+cluster+":6443")
+		Must(t, err)
+	}
+
+	// cluster3 was removed from the spec; the remote clusters have no kubeconfig, so
+	// their reconciliation fails, but the stale remote secrets are still deleted
+	_, err = r.reconcileRemoteClusters(ctx, istio, nil)
+	if err == nil {
+		t.Fatal("expected error for remote cluster without kubeconfig")
+	}
+
+	secret := &corev1.Secret{}
+	Must(t, primary.Get(ctx, client.ObjectKey{Namespace: "istio-system", Name: istioKubeconfigSecret}, secret))
+	Must(t, primary.Get(ctx, client.ObjectKey{Namespace: "istio-system", Name: "istio-remote-secret-cluster2"}, secret))
+	if err := primary.Get(ctx, client.ObjectKey{Namespace: "istio-system", Name: "istio-remote-secret-cluster3"}, secret); err == nil {
+		t.Errorf("expected remote secret of removed cluster to be deleted")
+	}
+
+	// the kubeconfig secret created by older versions is labeled as a remote secret
+	Must(t, primary.Get(ctx, client.ObjectKey{Namespace: "istio-system", Name: istioKubeconfigSecret}, secret))
+	secret.Labels = map[string]string{remoteSecretOfLabel: istio.Name}
+	Must(t, primary.Update(ctx, secret))
+	_, _ = r.reconcileRemoteClusters(ctx, istio, nil)
+	Must(t, primary.Get(ctx, client.ObjectKeyFromObject(secret), secret))
+}
+
+func TestDetermineReadyConditionExternal(t *testing.T) {
+	istio := &v1.Istio{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "istio-system"},
+		Spec:       v1.IstioSpec{ConfigCluster: &v1.RemoteCluster{Name: "config"}},
+	}
+	// no CNI DaemonSet, since it isn't installed for an external control plane
+	cl := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "istiod", Namespace: "istio-system"},
+		Status:     appsv1.DeploymentStatus{Replicas: 1, ReadyReplicas: 1},
+	}).Build()
+	r := &IstioReconciler{Client: cl}
+
+	for _, tc := range []struct {
+		status *v1.RemoteClusterStatus
+		expect metav1.ConditionStatus
+	}{
+		{status: nil, expect: metav1.ConditionFalse},
+		{status: &v1.RemoteClusterStatus{Name: "config", State: v1.RemoteClusterStatePending}, expect: metav1.ConditionFalse},
+		{status: &v1.RemoteClusterStatus{Name: "config", State: v1.RemoteClusterStateInstalled}, expect: metav1.ConditionTrue},
+	} {
+		istio.Status.ConfigCluster = tc.status
+		if actual := r.determineReadyCondition(context.Background(), istio); actual.Status != tc.expect {
+			t.Errorf("expected Ready=%s for config cluster status %+v, got %+v", tc.expect, tc.status, actual)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"maistra.io/istio-operator/api/v1alpha1"
+	"maistra.io/istio-operator/pkg/common"
+	"maistra.io/istio-operator/pkg/images"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// imageVerificationError is returned when the signature of an image can't be verified
+type imageVerificationError struct {
+	image string
+	err   error
+}
+
+func (e *imageVerificationError) Error() string {
+	return fmt.Sprintf("failed to verify the signature of image %s: %v", e.image, e.err)
+}
+
+// isImageVerificationError returns true if the error is caused by an image whose
+// signature can't be verified
+func isImageVerificationError(err error) bool {
+	var agg utilerrors.Aggregate
+	if errors.As(err, &agg) {
+		for _, e := range agg.Errors() {
+			if isImageVerificationError(e) {
+				return true
+			}
+		}
+		return false
+	}
+	var verificationErr *imageVerificationError
+	return errors.As(err, &verificationErr)
+}
+
+// operatorImage is an image that the operator sets in the values
+type operatorImage struct {
+	name  string
+	image string
+	// paths of the values that the image is set in
+	paths [][]string
+}
+
+// operatorImages returns the images of the version of the Istio resource, taking
+// the overrides in the operator configuration into account
+func operatorImages(resourceDir, version string) ([]operatorImage, error) {
+	manifest, err := images.LoadManifest(resourceDir, version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load images of version %s: %v", version, err)
+	}
+	if version == "v3.0" {
+		override := common.Config.Images3_0
+		for _, o := range []struct {
+			image *string
+			value string
+		}{
+			{&manifest.Istiod, override.Istiod},
+			{&manifest.Proxy, override.Proxy},
+			{&manifest.CNI, override.CNI},
+		} {
+			if o.value != "" {
+				*o.image = o.value
+			}
+		}
+	}
+	return []operatorImage{
+		{name: "istiod", image: manifest.Istiod, paths: [][]string{{"pilot", "image"}}},
+		{name: "proxy", image: manifest.Proxy, paths: [][]string{{"global", "proxy", "image"}, {"global", "proxy_init", "image"}}},
+		{name: "cni", image: manifest.CNI, paths: [][]string{{"cni", "image"}}},
+	}, nil
+}
+
+// reconcileImages sets the images of the version in the values, unless they are
+// already set. The images are rewritten to their registry mirrors. If enabled in the
+// operator configuration, the images are pinned to the digests that their tags refer
+// to, and their cosign signatures are verified. Since verifying a tag wouldn't
+// prevent it from being moved to an unverified image, verification implies pinning.
+// When a verification key is configured, the images that are already set in the
+// values are pinned and verified as well, so they must be full references. The
+// pinned digests are kept in the status and only resolved again when the image or
+// the verification key changes.
+func (r *IstioReconciler) reconcileImages(ctx context.Context, istio *v1alpha1.Istio) error {
+	operatorImages, err := operatorImages(r.ResourceDirectory, istio.Spec.Version)
+	if err != nil {
+		return err
+	}
+
+	var publicKey crypto.PublicKey
+	var keyFingerprint string
+	if keyFile := common.Config.Images.VerificationKey; keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read image verification key: %v", err)
+		}
+		if publicKey, err = images.ParsePublicKey(data); err != nil {
+			return fmt.Errorf("invalid image verification key %s: %v", keyFile, err)
+		}
+		if keyFingerprint, err = images.KeyFingerprint(publicKey); err != nil {
+			return fmt.Errorf("invalid image verification key %s: %v", keyFile, err)
+		}
+	}
+	pin := common.Config.Images.PinDigests || publicKey != nil
+	mirrors, err := registryMirrors(istio)
+	if err != nil {
+		return err
+	}
+
+	previous := map[string]v1alpha1.ImageStatus{}
+	for _, status := range istio.Status.Images {
+		previous[status.Reference] = status
+	}
+
+	values := istio.Spec.GetValues()
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	var statuses []v1alpha1.ImageStatus
+	reconciled := map[string]*v1alpha1.ImageStatus{}
+	var errs []error
+	for _, img := range operatorImages {
+		if publicKey == nil && isSetInValues(values, img.paths[0]) {
+			continue
+		}
+		for _, path := range img.paths {
+			image, _, _ := unstructured.NestedString(values, path...)
+			if image == "" {
+				if img.image == "" {
+					continue
+				}
+				image = mirrorImage(img.image, mirrors)
+			} else if publicKey == nil {
+				continue
+			} else if !strings.Contains(image, "/") {
+				// the charts would prefix the image with global.hub, which isn't verified
+				errs = append(errs, &imageVerificationError{
+					image: image,
+					err:   fmt.Errorf("%s must be a full image reference", strings.Join(path, ".")),
+				})
+				continue
+			}
+
+			status := reconciled[image]
+			if status == nil {
+				status = &v1alpha1.ImageStatus{Name: img.name, Reference: image, Image: image}
+				if pin {
+					if prev, found := previous[image]; found && strings.Contains(prev.Image, "@") &&
+						(publicKey == nil || prev.Verified && prev.VerificationKey == keyFingerprint) {
+						*status = prev
+					} else if *status, err = r.pinImage(ctx, img.name, image, publicKey, keyFingerprint); err != nil {
+						errs = append(errs, err)
+						continue
+					}
+				}
+				reconciled[image] = status
+				statuses = append(statuses, *status)
+			}
+			if err := unstructured.SetNestedField(values, status.Image, path...); err != nil {
+				return err
+			}
+		}
+	}
+	istio.Status.Images = statuses
+	if len(errs) > 0 {
+		return utilerrors.NewAggregate(errs)
+	}
+	return istio.Spec.SetValues(values)
+}
+
+// pinImage resolves the digest of the image and verifies its signature if a
+// public key is given
+func (r *IstioReconciler) pinImage(ctx context.Context, name, image string, publicKey crypto.PublicKey, keyFingerprint string) (v1alpha1.ImageStatus, error) {
+	status := v1alpha1.ImageStatus{Name: name, Reference: image}
+	ref, err := images.ParseReference(image)
+	if err != nil {
+		return status, err
+	}
+	registry := r.registryClient()
+	digest, err := registry.Resolve(ctx, ref)
+	if err != nil {
+		return status, fmt.Errorf("failed to resolve the digest of image %s: %v", image, err)
+	}
+	status.Image = ref.WithDigest(digest).String()
+	if publicKey != nil {
+		if err := registry.VerifySignature(ctx, ref, digest, publicKey); err != nil {
+			return status, &imageVerificationError{image: status.Image, err: err}
+		}
+		status.Verified = true
+		status.VerificationKey = keyFingerprint
+	}
+	log.FromContext(ctx).Info("Pinned image", "name", name, "image", status.Image, "verified", status.Verified)
+	return status, nil
+}
+
+func (r *IstioReconciler) registryClient() *images.Client {
+	if r.Registry != nil {
+		return r.Registry
+	}
+	return &images.Client{InsecureRegistries: common.Config.Images.InsecureRegistries}
+}
+
+func isSetInValues(values map[string]interface{}, path []string) bool {
+	value, found, _ := unstructured.NestedString(values, path...)
+	return found && value != ""
+}
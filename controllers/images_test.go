@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	v1 "maistra.io/istio-operator/api/v1alpha1"
+	"maistra.io/istio-operator/pkg/common"
+	"maistra.io/istio-operator/pkg/images/imagestest"
+)
+
+func TestReconcileImages(t *testing.T) {
+	ctx := context.Background()
+	registry := imagestest.NewRegistry(t, false)
+	pilotDigest := registry.PushImage("maistra/pilot", "3.0")
+	proxyDigest := registry.PushImage("maistra/proxyv2", "3.0")
+	cniDigest := registry.PushImage("maistra/install-cni", "3.0")
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Must(t, err)
+	registry.Sign("maistra/pilot", pilotDigest, key)
+	registry.Sign("maistra/proxyv2", proxyDigest, key)
+	publicKeyDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	Must(t, err)
+	keyFile := path.Join(t.TempDir(), "cosign.pub")
+	Must(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyDER}), 0o600))
+
+	resourceDir := t.TempDir()
+	Must(t, os.MkdirAll(path.Join(resourceDir, "v3.0"), 0o755))
+	Must(t, os.WriteFile(path.Join(resourceDir, "v3.0", "images.yaml"), []byte(fmt.Sprintf(
+		"istiod: %[1]s/maistra/pilot:3.0\nproxy: %[1]s/maistra/proxyv2:3.0\ncni: %[1]s/maistra/install-cni:3.0\n", registry.Host)), 0o644))
+
+	originalConfig := common.Config
+	t.Cleanup(func() { common.Config = originalConfig })
+	r := &IstioReconciler{ResourceDirectory: resourceDir, Registry: registry.Client()}
+	newIstio := func(values string) *v1.Istio {
+		return &v1.Istio{Spec: v1.IstioSpec{Version: "v3.0", Values: []byte(values)}}
+	}
+	getImage := func(istio *v1.Istio, path ...string) string {
+		image, _, _ := unstructured.NestedString(istio.Spec.GetValues(), path...)
+		return image
+	}
+
+	t.Run("tags", func(t *testing.T) {
+		common.Config = common.OperatorConfig{Images3_0: common.ImageConfig3_0{CNI: "quay.io/custom/install-cni:latest"}}
+		istio := newIstio(`{"pilot": {"image": "my-pilot"}}`)
+		Must(t, r.reconcileImages(ctx, istio))
+
+		if image := getImage(istio, "pilot", "image"); image != "my-pilot" {
+			t.Errorf("expected image in values to be kept, got %s", image)
+		}
+		if image := getImage(istio, "global", "proxy_init", "image"); image != registry.Host+"/maistra/proxyv2:3.0" {
+			t.Errorf("expected proxy image from manifest, got %s", image)
+		}
+		if image := getImage(istio, "cni", "image"); image != "quay.io/custom/install-cni:latest" {
+			t.Errorf("expected cni image from operator configuration, got %s", image)
+		}
+		if len(istio.Status.Images) != 2 {
+			t.Errorf("expected status for proxy and cni images, got %+v", istio.Status.Images)
+		}
+	})
+
+	t.Run("pinned", func(t *testing.T) {
+		common.Config = common.OperatorConfig{Images: common.ImagesConfig{PinDigests: true}}
+		istio := newIstio(`{}`)
+		Must(t, r.reconcileImages(ctx, istio))
+		expected := registry.Host + "/maistra/pilot:3.0@" + pilotDigest
+		if image := getImage(istio, "pilot", "image"); image != expected {
+			t.Errorf("expected pinned image %s, got %s", expected, image)
+		}
+		if image := getImage(istio, "cni", "image"); image != registry.Host+"/maistra/install-cni:3.0@"+cniDigest {
+			t.Errorf("expected pinned cni image, got %s", image)
+		}
+
+		// the pinned digest is kept when the tag moves
+		registry.Sign("maistra/pilot", registry.PushImage("maistra/pilot", "3.0"), key)
+		status := istio.Status
+		istio = newIstio(`{}`)
+		istio.Status = status
+		Must(t, r.reconcileImages(ctx, istio))
+		if image := getImage(istio, "pilot", "image"); image != expected {
+			t.Errorf("expected image to stay pinned to %s, got %s", expected, image)
+		}
+	})
+
+	t.Run("verified", func(t *testing.T) {
+		common.Config = common.OperatorConfig{Images: common.ImagesConfig{VerificationKey: keyFile}}
+		customCNI := registry.Host + "/custom/install-cni:3.0"
+		registry.Sign("custom/install-cni", registry.PushImage("custom/install-cni", "3.0"), key)
+		istio := newIstio(fmt.Sprintf(`{"cni": {"image": %q}}`, customCNI))
+		Must(t, r.reconcileImages(ctx, istio))
+		if len(istio.Status.Images) != 3 {
+			t.Errorf("expected status for istiod, proxy and cni images, got %+v", istio.Status.Images)
+		}
+		for _, status := range istio.Status.Images {
+			if !status.Verified || !strings.Contains(status.Image, "@sha256:") || status.VerificationKey == "" {
+				t.Errorf("expected verified and pinned image, got %+v", status)
+			}
+		}
+		if image := getImage(istio, "cni", "image"); !strings.HasPrefix(image, customCNI+"@sha256:") {
+			t.Errorf("expected image in values to be pinned, got %s", image)
+		}
+
+		// images in the values are verified as well
+		registry.PushImage("custom/pilot", "3.0")
+		for _, values := range []string{
+			fmt.Sprintf(`{"pilot": {"image": "%s/custom/pilot:3.0"}, "cni": {"image": %q}}`, registry.Host, customCNI),
+			fmt.Sprintf(`{"pilot": {"image": "my-pilot"}, "cni": {"image": %q}}`, customCNI),
+		} {
+			err := r.reconcileImages(ctx, newIstio(values))
+			if !isImageVerificationError(err) {
+				t.Errorf("expected image verification error for values %s, got %v", values, err)
+			}
+		}
+
+		// the images are verified again when the key changes
+		otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Must(t, err)
+		otherKeyDER, err := x509.MarshalPKIXPublicKey(&otherKey.PublicKey)
+		Must(t, err)
+		otherKeyFile := path.Join(t.TempDir(), "cosign.pub")
+		Must(t, os.WriteFile(otherKeyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: otherKeyDER}), 0o600))
+		common.Config.Images.VerificationKey = otherKeyFile
+		status := istio.Status
+		istio = newIstio(fmt.Sprintf(`{"cni": {"image": %q}}`, customCNI))
+		istio.Status = status
+		if err := r.reconcileImages(ctx, istio); !isImageVerificationError(err) {
+			t.Errorf("expected image verification error after the key changed, got %v", err)
+		}
+		common.Config.Images.VerificationKey = keyFile
+
+		// the unsigned cni image is refused
+		istio = newIstio(`{}`)
+		err = r.reconcileImages(ctx, istio)
+		if !isImageVerificationError(err) {
+			t.Fatalf("expected image verification error, got %v", err)
+		}
+		if condition := determineReconciledCondition(err); condition.Reason != v1.ConditionReasonImageVerificationFailed {
+			t.Errorf("expected reason %s, got %s", v1.ConditionReasonImageVerificationFailed, condition.Reason)
+		}
+		if image := getImage(istio, "cni", "image"); image != "" {
+			t.Errorf("expected no images in values, got cni image %s", image)
+		}
+	})
+}
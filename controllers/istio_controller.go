@@ -18,12 +18,15 @@ package controllers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path"
 	"reflect"
 	"regexp"
+	"time"
 
 	"github.com/go-logr/logr"
 	"gopkg.in/yaml.v3"
@@ -37,12 +40,17 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/rest"
 	"maistra.io/istio-operator/api/v1alpha1"
+	"maistra.io/istio-operator/pkg/common"
 	"maistra.io/istio-operator/pkg/helm"
+	"maistra.io/istio-operator/pkg/images"
 	"maistra.io/istio-operator/pkg/kube"
+	"maistra.io/istio-operator/pkg/redact"
 	"maistra.io/istio-operator/pkg/strategy"
+	"maistra.io/istio-operator/pkg/version"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -62,6 +70,15 @@ type IstioReconciler struct {
 	RestClientGetter  genericclioptions.RESTClientGetter
 	client.Client
 	Scheme *runtime.Scheme
+
+	// Registry resolves and verifies images. If nil, a client for the registries in
+	// the operator configuration is used.
+	Registry *images.Client
+
+	// ReleaseMigration, if set, is waited for before reconciling
+	ReleaseMigration *ReleaseMigration
+
+	remoteClients remoteClusterClients
 }
 
 func NewIstioReconciler(client client.Client, scheme *runtime.Scheme, restConfig *rest.Config, resourceDir string) *IstioReconciler {
@@ -73,31 +90,15 @@ func NewIstioReconciler(client client.Client, scheme *runtime.Scheme, restConfig
 	}
 }
 
-// charts to deploy in the operator namespace (and their suffixes)
-var systemCharts = map[string]string{
-	"istio-cni": "-cni",
-}
-
-// charts to deploy in the istio namespace (and their suffixes)
-var userCharts = map[string]string{
-	"base":                          "-base",
-	"istio-control/istio-discovery": "-istiod",
-}
-
 // +kubebuilder:rbac:groups=operator.istio.io,resources=istios,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=operator.istio.io,resources=istios/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=operator.istio.io,resources=istios/finalizers,verbs=update
-// +kubebuilder:rbac:groups="",resources="*",verbs="*"
-// +kubebuilder:rbac:groups="networking.k8s.io",resources="networkpolicies",verbs="*"
-// +kubebuilder:rbac:groups="policy",resources="poddisruptionbudgets",verbs="*"
-// +kubebuilder:rbac:groups="rbac.authorization.k8s.io",resources=clusterroles;clusterrolebindings;roles;rolebindings,verbs="*"
-// +kubebuilder:rbac:groups="apps",resources=deployments;daemonsets,verbs="*"
-// +kubebuilder:rbac:groups="admissionregistration.k8s.io",resources=validatingwebhookconfigurations;mutatingwebhookconfigurations,verbs="*"
-// +kubebuilder:rbac:groups="autoscaling",resources=horizontalpodautoscalers,verbs="*"
-// +kubebuilder:rbac:groups="apiextensions.k8s.io",resources=customresourcedefinitions,verbs=get;list;watch
-// +kubebuilder:rbac:groups="k8s.cni.cncf.io",resources=network-attachment-definitions,verbs="*"
-// +kubebuilder:rbac:groups="security.openshift.io",resources=securitycontextconstraints,resourceNames=privileged,verbs=use
-// +kubebuilder:rbac:groups="networking.istio.io",resources=envoyfilters,verbs="*"
+// The permissions needed to install the charts are generated in zz_generated.rbac.go
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups="apps",resources=statefulsets,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups="networking.istio.io",resources=gateways;virtualservices,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="cert-manager.io",resources=certificates,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="monitoring.coreos.com",resources=servicemonitors;podmonitors,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -106,6 +107,12 @@ var userCharts = map[string]string{
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.14.1/pkg/reconcile
 func (r *IstioReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	logger := log.FromContext(ctx).WithName("reconciler")
+	if r.ReleaseMigration != nil {
+		if err := r.ReleaseMigration.Wait(ctx); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
 	var istio v1alpha1.Istio
 	if err := r.Client.Get(ctx, req.NamespacedName, &istio); err != nil {
 		if errors.IsNotFound(err) {
@@ -116,7 +123,32 @@ func (r *IstioReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 	}
 
 	if istio.DeletionTimestamp != nil {
-		if err := r.uninstallHelmCharts(&istio); err != nil {
+		if !kube.HasFinalizer(&istio) {
+			return ctrl.Result{}, nil
+		}
+
+		blocker, err := r.deletionBlocker(ctx, &istio)
+		if err != nil || blocker != "" {
+			logger.Info("Deletion blocked", "reason", blocker)
+			if err := r.updateTerminatingStatus(ctx, &istio, v1alpha1.ConditionReasonInUse, blocker, err); err != nil {
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{RequeueAfter: uninstallPollInterval}, nil
+		}
+
+		pending, err := r.uninstall(ctx, &istio)
+		if err != nil || pending != "" {
+			logger.Info("Uninstalling components", "pending", pending)
+			if err := r.updateTerminatingStatus(ctx, &istio, v1alpha1.ConditionReasonUninstalling, pending, err); err != nil {
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{RequeueAfter: uninstallPollInterval}, nil
+		}
+
+		if err := r.reconcileNetworkLabels(ctx, &istio, "", getNetwork(istio.Status.GetAppliedValues())); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.reconcileMembers(ctx, &istio, nil, getRevision(istio.Status.GetAppliedValues())); err != nil {
 			return ctrl.Result{}, err
 		}
 
@@ -139,6 +171,16 @@ func (r *IstioReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 		}
 	}
 
+	if err := checkVersionSupported(r.ResourceDirectory, istio.Spec.Version); err != nil {
+		err = r.updateStatus(ctx, logger, &istio, istio.Spec.GetValues(), err)
+		return ctrl.Result{}, err
+	}
+
+	if err := validateVersionChange(istio.Status.Version, &istio); err != nil {
+		err = r.updateStatus(ctx, logger, &istio, istio.Spec.GetValues(), err)
+		return ctrl.Result{}, err
+	}
+
 	s := strategy.Maistra30Strategy{}
 	err := s.ApplyDefaults(&istio)
 	if err != nil {
@@ -146,61 +188,261 @@ func (r *IstioReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 		return ctrl.Result{Requeue: true}, nil
 	}
 
+	if err := r.reconcileImages(ctx, &istio); err != nil {
+		err = r.updateStatus(ctx, logger, &istio, istio.Spec.GetValues(), err)
+		return ctrl.Result{}, err
+	}
+
 	if err := applyProfile(&istio, r.ResourceDirectory); err != nil {
 		err = r.updateStatus(ctx, logger, &istio, istio.Spec.GetValues(), err)
 		return ctrl.Result{}, err
 	}
 
+	if err := applyRegistry(&istio); err != nil {
+		err = r.updateStatus(ctx, logger, &istio, istio.Spec.GetValues(), err)
+		return ctrl.Result{}, err
+	}
+
+	if err := validateMeshConfig(&istio); err != nil {
+		err = r.updateStatus(ctx, logger, &istio, istio.Spec.GetValues(), err)
+		return ctrl.Result{}, err
+	}
+
+	if err := applyMeshConfig(&istio); err != nil {
+		err = r.updateStatus(ctx, logger, &istio, istio.Spec.GetValues(), err)
+		return ctrl.Result{}, err
+	}
+
+	if err := applyDiscoverySelectors(&istio); err != nil {
+		err = r.updateStatus(ctx, logger, &istio, istio.Spec.GetValues(), err)
+		return ctrl.Result{}, err
+	}
+
+	if err := applyExternalControlPlane(&istio); err != nil {
+		err = r.updateStatus(ctx, logger, &istio, istio.Spec.GetValues(), err)
+		return ctrl.Result{}, err
+	}
+
+	if err := applyMultiNetwork(&istio); err != nil {
+		err = r.updateStatus(ctx, logger, &istio, istio.Spec.GetValues(), err)
+		return ctrl.Result{}, err
+	}
+
+	if err := validateOverlays(&istio); err != nil {
+		err = r.updateStatus(ctx, logger, &istio, istio.Spec.GetValues(), err)
+		return ctrl.Result{}, err
+	}
+
+	if err := r.reconcileCA(ctx, &istio); err != nil {
+		err = r.updateStatus(ctx, logger, &istio, istio.Spec.GetValues(), err)
+		return ctrl.Result{}, err
+	}
+
+	if err := r.reconcilePullSecret(ctx, &istio); err != nil {
+		err = r.updateStatus(ctx, logger, &istio, istio.Spec.GetValues(), err)
+		return ctrl.Result{}, err
+	}
+
 	values := istio.Spec.GetValues()
+	revision := getRevision(values)
 
-	logger.Info("Installing components", "values", values)
-	err = r.installHelmCharts(ctx, istio, values)
+	if err := r.checkConflicts(ctx, &istio, revision); err != nil {
+		err = r.updateStatus(ctx, logger, &istio, values, err)
+		return ctrl.Result{}, err
+	}
+
+	membersErr := r.reconcileMembers(ctx, &istio, istio.Spec.Members, revision)
+	networkErr := r.reconcileNetworkLabels(ctx, &istio, getNetwork(values), getNetwork(istio.Status.GetAppliedValues()))
+
+	// the config cluster must have the CRDs before istiod starts
+	configClusterAfter, configClusterErr := r.reconcileConfigCluster(ctx, &istio, values)
+
+	installErr := r.installHelmChartsIfChanged(ctx, &istio, values)
+	err = utilerrors.NewAggregate([]error{membersErr, networkErr, configClusterErr, installErr})
+
+	// requeue to pick up the renewal of the CA; the data plane status is refreshed
+	// by the data plane controller
+	requeueAfter := minRequeueAfter(caRenewalRequeueAfter(istio.Status.CA, time.Now()), configClusterAfter)
+	if err == nil {
+		err = r.reconcileNetworkGateways(ctx, &istio)
+	}
+	if err == nil {
+		err = r.reconcileMonitors(ctx, &istio, values)
+	}
+	if err == nil {
+		var remoteAfter time.Duration
+		remoteAfter, err = r.reconcileRemoteClusters(ctx, &istio, values)
+		requeueAfter = minRequeueAfter(requeueAfter, remoteAfter)
+	}
+	if err == nil {
+		var rolloutAfter time.Duration
+		rolloutAfter, err = r.reconcileRollout(ctx, &istio, values)
+		requeueAfter = minRequeueAfter(requeueAfter, rolloutAfter)
+	}
 
 	logger.Info("Reconciliation done. Updating status.")
 	err = r.updateStatus(ctx, logger, &istio, values, err)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
 
-	return ctrl.Result{}, err
+// minRequeueAfter returns the shorter of the durations after which a reconciliation
+// is requested, ignoring zero durations
+func minRequeueAfter(a, b time.Duration) time.Duration {
+	if a == 0 || b > 0 && b < a {
+		return b
+	}
+	return a
 }
 
-func (r *IstioReconciler) installHelmCharts(ctx context.Context, istio v1alpha1.Istio, values map[string]interface{}) error {
-	ownerReference := metav1.OwnerReference{
-		APIVersion:         v1alpha1.GroupVersion.String(),
-		Kind:               v1alpha1.IstioKind,
-		Name:               istio.Name,
-		UID:                istio.UID,
-		Controller:         ptr.Of(true),
-		BlockOwnerDeletion: ptr.Of(true),
+// installHelmChartsIfChanged installs the charts unless the values, charts and operator
+// version are the same as in the last successful installation and the installed
+// resources haven't drifted from the release manifests. It records the hash of the
+// installation in the status.
+func (r *IstioReconciler) installHelmChartsIfChanged(ctx context.Context, istio *v1alpha1.Istio, values map[string]interface{}) error {
+	logger := log.FromContext(ctx)
+	hash, err := computeAppliedHash(ctx, istio, values)
+	if err != nil {
+		return err
+	}
+
+	if hash == istio.Status.AppliedHash {
+		drifted, err := r.hasDrifted(istio, values)
+		if err != nil {
+			return err
+		}
+		if !drifted {
+			logger.Info("Values and charts unchanged. Skipping installation of components")
+			istio.Status.Version = istio.Spec.Version
+			return setAppliedMeshConfig(istio, values)
+		}
 	}
 
-	if err := helm.UpgradeOrInstallCharts(ctx, r.RestClientGetter, systemCharts, values,
-		istio.Spec.Version, istio.Name, kube.GetOperatorNamespace(), ownerReference, istio.Namespace); err != nil {
+	logger.Info("Installing components", "values", redactValues(values))
+	if err := r.installHelmCharts(ctx, *istio, values); err != nil {
+		istio.Status.AppliedHash = ""
 		return err
 	}
+	istio.Status.AppliedHash = hash
+	istio.Status.Version = istio.Spec.Version
+	return setAppliedMeshConfig(istio, values)
+}
 
-	if err := helm.UpgradeOrInstallCharts(ctx, r.RestClientGetter, userCharts, values,
-		istio.Spec.Version, istio.Name, istio.Namespace, ownerReference, istio.Namespace); err != nil {
+// setAppliedMeshConfig records the installed mesh configuration in the status.
+// Sensitive values are redacted like in the applied values.
+func setAppliedMeshConfig(istio *v1alpha1.Istio, values map[string]interface{}) error {
+	meshConfig, found := redactValues(values)["meshConfig"]
+	if !found {
+		istio.Status.AppliedMeshConfig = nil
+		return nil
+	}
+	meshConfigJSON, err := json.Marshal(meshConfig)
+	if err != nil {
 		return err
 	}
+	istio.Status.AppliedMeshConfig = meshConfigJSON
 	return nil
 }
 
-func (r *IstioReconciler) uninstallHelmCharts(istio *v1alpha1.Istio) error {
-	if err := helm.UninstallCharts(r.RestClientGetter, systemCharts, istio.Name, kube.GetOperatorNamespace()); err != nil {
-		return err
+// computeAppliedHash returns a hash of everything that determines the resources
+// installed for the Istio resource
+func computeAppliedHash(ctx context.Context, istio *v1alpha1.Istio, values map[string]interface{}) (string, error) {
+	h := sha256.New()
+	valuesJSON, err := json.Marshal(values)
+	if err != nil {
+		return "", err
 	}
+	h.Write(valuesJSON)
+	fmt.Fprintf(h, "\x00%s\x00%s\x00%s\x00%s\x00%s", istio.Spec.Version, istio.Namespace,
+		kube.GetOperatorNamespace(), version.Info.Version, version.Info.GitRevision)
 
-	if err := helm.UninstallCharts(r.RestClientGetter, userCharts, istio.Name, istio.Namespace); err != nil {
-		return err
+	for _, c := range components {
+		digest, err := helm.ChartDigest(ctx, istio.Spec.Version, c.chart)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "\x00%s:%s", c.name, digest)
+		if c.isInstalled(istio, values) && c.chartValues != nil {
+			chartValuesJSON, err := json.Marshal(c.chartValues(istio, values))
+			if err != nil {
+				return "", err
+			}
+			h.Write(chartValuesJSON)
+		}
+	}
+
+	overlaysJSON, err := json.Marshal(istio.Spec.Overlays)
+	if err != nil {
+		return "", err
+	}
+	h.Write(overlaysJSON)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hasDrifted returns whether any of the installed resources no longer match their
+// release manifests
+func (r *IstioReconciler) hasDrifted(istio *v1alpha1.Istio, values map[string]interface{}) (bool, error) {
+	revision, _, _ := unstructured.NestedString(values, "revision")
+	for _, c := range components {
+		// shared components may be managed by another control plane
+		if !c.isInstalled(istio, values) || (revision != "" && c.shared) {
+			continue
+		}
+		if drifted, err := helm.HasDrifted(r.RestClientGetter, c.namespace(istio), c.releaseName(istio)); drifted || err != nil {
+			return drifted, err
+		}
+	}
+	return false, nil
+}
+
+// installHelmCharts installs the charts of all enabled components and uninstalls
+// the charts of disabled components. Independent components are installed
+// concurrently.
+func (r *IstioReconciler) installHelmCharts(ctx context.Context, istio v1alpha1.Istio, values map[string]interface{}) error {
+	ownerReference := newOwnerReference(&istio)
+
+	revision, _, _ := unstructured.NestedString(values, "revision")
+	return processComponents(ctx, components, func(ctx context.Context, c component) error {
+		logger := log.FromContext(ctx).WithValues("component", c.name)
+		if !c.isInstalled(&istio, values) {
+			return helm.UninstallChart(r.RestClientGetter, c.releaseName(&istio), c.namespace(&istio))
+		}
+
+		start := time.Now()
+		err := helm.UpgradeOrInstallChart(ctx, r.RestClientGetter, c.chart, c.valuesFor(&istio, values),
+			istio.Spec.Version, c.releaseName(&istio), c.namespace(&istio), ownerReference, istio.Namespace,
+			helm.NewOverlayPostRenderer(overlaysFor(&istio, c.name)))
+		if err != nil && revision != "" && c.shared && helm.IsConflict(err) {
+			logger.Info("Shared component is managed by another control plane; skipping", "reason", err.Error())
+			return nil
+		} else if err != nil {
+			return err
+		}
+		logger.V(2).Info("Component installed", "duration", time.Since(start))
+		return nil
+	})
+}
+
+// newOwnerReference returns the OwnerReference that marks objects as controlled by the Istio resource
+func newOwnerReference(istio *v1alpha1.Istio) metav1.OwnerReference {
+	return metav1.OwnerReference{
+		APIVersion:         v1alpha1.GroupVersion.String(),
+		Kind:               v1alpha1.IstioKind,
+		Name:               istio.Name,
+		UID:                istio.UID,
+		Controller:         ptr.Of(true),
+		BlockOwnerDeletion: ptr.Of(true),
 	}
-	return nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *IstioReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	clusterScopedResourceHandler := handler.EnqueueRequestsFromMapFunc(mapOwnerAnnotationsToReconcileRequest)
 
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&v1alpha1.Istio{}).
+	err := ctrl.NewControllerManagedBy(mgr).
+		For(&v1alpha1.Istio{}, builder.WithPredicates(ignoreDataPlaneStatusChanges{})).
 
 		// namespaced resources
 		Owns(&corev1.ConfigMap{}).
@@ -216,6 +458,14 @@ func (r *IstioReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		Owns(&policyv1.PodDisruptionBudget{}).
 		Owns(&autoscalingv2.HorizontalPodAutoscaler{}).
 		Owns(&networkingv1alpha3.EnvoyFilter{}).
+		Owns(&networkingv1alpha3.Gateway{}).
+		Owns(&networkingv1alpha3.VirtualService{}).
+
+		// Secrets referenced by the Istio resource, e.g. the CA or the kubeconfigs of the remote clusters
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.mapSecretToReconcileRequest)).
+
+		// member namespaces
+		Watches(&corev1.Namespace{}, handler.EnqueueRequestsFromMapFunc(r.mapNamespaceToReconcileRequest)).
 
 		// TODO: only register NetAttachDef if the CRD is installed (may also need to watch for CRD creation)
 		// Owns(&multusv1.NetworkAttachmentDefinition{}).
@@ -230,6 +480,10 @@ func (r *IstioReconciler) SetupWithManager(mgr ctrl.Manager) error {
 
 		// +lint-watches:ignore: CustomResourceDefinition (prevents `make lint-watches` from bugging us about CRDs)
 		Complete(r)
+	if err != nil {
+		return err
+	}
+	return r.setupDataPlaneStatusController(mgr)
 }
 
 func (r *IstioReconciler) updateStatus(ctx context.Context, log logr.Logger, istio *v1alpha1.Istio, values map[string]interface{}, err error) error {
@@ -242,7 +496,13 @@ func (r *IstioReconciler) updateStatus(ctx context.Context, log logr.Logger, ist
 	status.SetCondition(readyCondition)
 	status.State = deriveState(reconciledCondition, readyCondition)
 
-	appliedValues, err2 := json.Marshal(values)
+	if dataPlane, err := r.inventoryDataPlane(ctx, istio, values); err != nil {
+		log.Error(err, "failed to inventory the data plane")
+	} else {
+		status.DataPlane = dataPlane
+	}
+
+	appliedValues, err2 := json.Marshal(redactValues(values))
 	if err2 != nil {
 		log.Error(err2, "failed to marshal status")
 		if err == nil {
@@ -265,6 +525,12 @@ func (r *IstioReconciler) updateStatus(ctx context.Context, log logr.Logger, ist
 	return err
 }
 
+// redactValues returns a copy of the values without the sensitive values, which
+// must not be logged or published in the status
+func redactValues(values map[string]interface{}) map[string]interface{} {
+	return redact.Values(values, append(redact.DefaultPaths, common.Config.Redaction.Paths...))
+}
+
 func deriveState(reconciledCondition, readyCondition v1alpha1.IstioCondition) v1alpha1.IstioConditionReason {
 	if reconciledCondition.Status == metav1.ConditionFalse {
 		return reconciledCondition.Reason
@@ -283,10 +549,23 @@ func determineReconciledCondition(err error) v1alpha1.IstioCondition {
 		}
 	}
 
+	reason := v1alpha1.ConditionReasonReconcileError
+	if isConflict(err) {
+		reason = v1alpha1.ConditionReasonConflict
+	} else if isImageVerificationError(err) {
+		reason = v1alpha1.ConditionReasonImageVerificationFailed
+	} else if isVersionNotSupported(err) {
+		reason = v1alpha1.ConditionReasonVersionNotSupported
+	} else if isVersionChangeNotAllowed(err) {
+		reason = v1alpha1.ConditionReasonVersionChangeNotAllowed
+	} else if isInvalidMeshConfig(err) {
+		reason = v1alpha1.ConditionReasonInvalidMeshConfig
+	}
+
 	return v1alpha1.IstioCondition{
 		Type:    v1alpha1.ConditionTypeReconciled,
 		Status:  metav1.ConditionFalse,
-		Reason:  v1alpha1.ConditionReasonReconcileError,
+		Reason:  reason,
 		Message: fmt.Sprintf("error reconciling resource: %v", err),
 	}
 }
@@ -315,6 +594,17 @@ func (r *IstioReconciler) determineReadyCondition(ctx context.Context, istio *v1
 		return notReady(v1alpha1.ConditionReasonIstiodNotReady, "not all istiod pods are ready")
 	}
 
+	if istio.Spec.ConfigCluster != nil {
+		// an external control plane only consists of istiod in this cluster
+		if status := istio.Status.ConfigCluster; status == nil || status.State != v1alpha1.RemoteClusterStateInstalled {
+			return notReady(v1alpha1.ConditionReasonConfigClusterNotReady, "the installation in the config cluster isn't complete")
+		}
+		return v1alpha1.IstioCondition{
+			Type:   v1alpha1.ConditionTypeReady,
+			Status: metav1.ConditionTrue,
+		}
+	}
+
 	cni := appsv1.DaemonSet{}
 	if err := r.Client.Get(ctx, cniDaemonSetKey(), &cni); err != nil {
 		if errors.IsNotFound(err) {
@@ -341,34 +631,39 @@ func applyProfile(istio *v1alpha1.Istio, resourceDir string) error {
 		profileName = "default"
 	}
 
-	profilesDir := path.Join(resourceDir, istio.Spec.Version, "profiles")
+	profileValues, err := loadProfileValues(resourceDir, istio.Spec.Version, profileName)
+	if err != nil {
+		return err
+	}
+	err = istio.Spec.SetValues(mergeValues(istio.Spec.GetValues(), profileValues))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// loadProfileValues returns the values of the profile with the given name
+func loadProfileValues(resourceDir, version, profileName string) (map[string]interface{}, error) {
+	profilesDir := path.Join(resourceDir, version, "profiles")
 	file := path.Join(profilesDir, profileName+".yaml")
 
 	// prevent path traversal attacks
 	if path.Dir(file) != path.Join(profilesDir) {
-		return fmt.Errorf("invalid profile name %s", profileName)
+		return nil, fmt.Errorf("invalid profile name %s", profileName)
 	}
 
 	fileContents, err := os.ReadFile(file)
 	if err != nil {
-		return fmt.Errorf("failed to read profile file %v: %v", file, err)
+		return nil, fmt.Errorf("failed to read profile file %v: %v", file, err)
 	}
 
 	var profile map[string]interface{}
 	err = yaml.Unmarshal(fileContents, &profile)
 	if err != nil {
-		return fmt.Errorf("failed to unmarshal profile YAML %s: %v", file, err)
+		return nil, fmt.Errorf("failed to unmarshal profile YAML %s: %v", file, err)
 	}
 
-	profileValues, err := getValues(profile)
-	if err != nil {
-		return err
-	}
-	err = istio.Spec.SetValues(mergeValues(istio.Spec.GetValues(), profileValues))
-	if err != nil {
-		return err
-	}
-	return nil
+	return getValues(profile)
 }
 
 func getValues(profile map[string]interface{}) (map[string]interface{}, error) {
@@ -416,9 +711,13 @@ func cniDaemonSetKey() client.ObjectKey {
 }
 
 func istiodDeploymentKey(istio *v1alpha1.Istio) client.ObjectKey {
+	name := "istiod"
+	if revision, _, _ := unstructured.NestedString(istio.Spec.GetValues(), "revision"); revision != "" {
+		name += "-" + revision
+	}
 	return client.ObjectKey{
 		Namespace: istio.Namespace,
-		Name:      "istiod",
+		Name:      name,
 	}
 }
 
@@ -435,6 +734,50 @@ func mapOwnerAnnotationsToReconcileRequest(ctx context.Context, obj client.Objec
 	return nil
 }
 
+// mapSecretToReconcileRequest returns the Istio resources that reference the Secret.
+// Secrets created by the operator are owned by the Istio resource and watched as such.
+func (r *IstioReconciler) mapSecretToReconcileRequest(ctx context.Context, obj client.Object) []reconcile.Request {
+	istios := &v1alpha1.IstioList{}
+	if err := r.Client.List(ctx, istios, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "failed to list Istio resources")
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, istio := range istios.Items {
+		for _, name := range referencedSecrets(&istio) {
+			if name == obj.GetName() {
+				requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&istio)})
+				break
+			}
+		}
+	}
+	return requests
+}
+
+// referencedSecrets returns the names of the Secrets in the istio namespace that the
+// Istio resource reads
+func referencedSecrets(istio *v1alpha1.Istio) []string {
+	var names []string
+	if istio.Spec.Security != nil && istio.Spec.Security.CA != nil {
+		ca := istio.Spec.Security.CA
+		switch {
+		case ca.Type == v1alpha1.CATypeProvided && ca.Provided != nil:
+			names = append(names, ca.Provided.SecretName)
+		case ca.Type == v1alpha1.CATypeGenerated && ca.Generated != nil:
+			names = append(names, ca.Generated.RootSecretName)
+		case ca.Type == v1alpha1.CATypeCertManager:
+			names = append(names, certManagerSecretName)
+		}
+	}
+	for _, cluster := range istio.Spec.RemoteClusters {
+		names = append(names, cluster.KubeconfigSecret.Name)
+	}
+	if istio.Spec.ConfigCluster != nil {
+		names = append(names, istio.Spec.ConfigCluster.KubeconfigSecret.Name)
+	}
+	return names
+}
+
 type validatingWebhookConfigPredicate struct {
 	predicate.Funcs
 }
@@ -16,9 +16,13 @@ import (
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	v1 "maistra.io/istio-operator/api/v1alpha1"
 	"maistra.io/istio-operator/pkg/common"
+	"maistra.io/istio-operator/pkg/helm"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	"istio.io/istio/pkg/ptr"
 )
@@ -485,3 +489,84 @@ func Must(t *testing.T, err error) {
 		t.Fatal(err)
 	}
 }
+
+func TestComputeAppliedHash(t *testing.T) {
+	helm.ResourceDirectory = path.Join(common.RepositoryRoot, "resources")
+	istio := &v1.Istio{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "istio-system"},
+		Spec:       v1.IstioSpec{Version: "v3.0"},
+	}
+	values := map[string]interface{}{"pilot": map[string]interface{}{"replicaCount": 1}}
+
+	hash, err := computeAppliedHash(context.Background(), istio, values)
+	Must(t, err)
+	if hash2, err := computeAppliedHash(context.Background(), istio, map[string]interface{}{"pilot": map[string]interface{}{"replicaCount": 1}}); err != nil || hash2 != hash {
+		t.Errorf("expected the same hash for the same values, got %s and %s (err: %v)", hash, hash2, err)
+	}
+	if hash2, err := computeAppliedHash(context.Background(), istio, map[string]interface{}{"pilot": map[string]interface{}{"replicaCount": 2}}); err != nil || hash2 == hash {
+		t.Errorf("expected a different hash for different values (err: %v)", err)
+	}
+
+	otherNamespace := istio.DeepCopy()
+	otherNamespace.Namespace = "other"
+	if hash2, err := computeAppliedHash(context.Background(), otherNamespace, values); err != nil || hash2 == hash {
+		t.Errorf("expected a different hash for a different namespace (err: %v)", err)
+	}
+
+	invalidVersion := istio.DeepCopy()
+	invalidVersion.Spec.Version = "v0.0"
+	if _, err := computeAppliedHash(context.Background(), invalidVersion, values); err == nil {
+		t.Error("expected error for a version without charts")
+	}
+}
+
+func TestMapSecretToReconcileRequest(t *testing.T) {
+	ctx := context.Background()
+	s := runtime.NewScheme()
+	Must(t, v1.AddToScheme(s))
+	newIstio := func(name string, spec v1.IstioSpec) *v1.Istio {
+		return &v1.Istio{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "istio-system"}, Spec: spec}
+	}
+	generated := newIstio("generated", v1.IstioSpec{Security: &v1.SecurityConfig{CA: &v1.CAConfig{
+		Type:      v1.CATypeGenerated,
+		Generated: &v1.GeneratedCAConfig{RootSecretName: "root-ca"},
+	}}})
+	remote := newIstio("remote", v1.IstioSpec{RemoteClusters: []v1.RemoteCluster{
+		{Name: "cluster2", KubeconfigSecret: v1.KubeconfigSecretReference{Name: "cluster2-kubeconfig"}},
+	}})
+	cl := fake.NewClientBuilder().WithScheme(s).WithObjects(generated, remote, newIstio("plain", v1.IstioSpec{})).Build()
+	r := &IstioReconciler{Client: cl}
+
+	testCases := []struct {
+		namespace, name string
+		expect          []reconcile.Request
+	}{
+		{namespace: "istio-system", name: "root-ca", expect: []reconcile.Request{{NamespacedName: client.ObjectKeyFromObject(generated)}}},
+		{namespace: "istio-system", name: "cluster2-kubeconfig", expect: []reconcile.Request{{NamespacedName: client.ObjectKeyFromObject(remote)}}},
+		{namespace: "other", name: "root-ca"},
+		{namespace: "istio-system", name: "unrelated"},
+	}
+	for _, tc := range testCases {
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: tc.namespace, Name: tc.name}}
+		if diff := cmp.Diff(tc.expect, r.mapSecretToReconcileRequest(ctx, secret)); diff != "" {
+			t.Errorf("unexpected requests for secret %s/%s (-expected +actual):\n%s", tc.namespace, tc.name, diff)
+		}
+	}
+}
+
+func TestMinRequeueAfter(t *testing.T) {
+	testCases := []struct {
+		a, b, expect time.Duration
+	}{
+		{a: 0, b: 0, expect: 0},
+		{a: time.Minute, b: 0, expect: time.Minute},
+		{a: 0, b: time.Second, expect: time.Second},
+		{a: time.Minute, b: time.Second, expect: time.Second},
+		{a: time.Second, b: time.Minute, expect: time.Second},
+	}
+	for _, tc := range testCases {
+		if actual := minRequeueAfter(tc.a, tc.b); actual != tc.expect {
+			t.Errorf("minRequeueAfter(%v, %v) = %v, expected %v", tc.a, tc.b, actual, tc.expect)
+		}
+	}
+}
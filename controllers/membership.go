@@ -0,0 +1,208 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"maistra.io/istio-operator/api/v1alpha1"
+	"maistra.io/istio-operator/pkg/common"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"istio.io/api/label"
+	"istio.io/istio/pkg/util/sets"
+)
+
+const (
+	// injectionLabel is the legacy label that enables/disables sidecar injection for the default revision.
+	// When a namespace has it, the operator leaves the namespace's injection configuration alone.
+	injectionLabel = "istio-injection"
+
+	namespaceNameLabel = "kubernetes.io/metadata.name"
+)
+
+// applyDiscoverySelectors restricts the control plane to its own namespace and the
+// namespaces that are members of the mesh. Selectors specified by the user in
+// values.meshConfig.discoverySelectors are preserved.
+func applyDiscoverySelectors(istio *v1alpha1.Istio) error {
+	if len(istio.Spec.Members) == 0 {
+		return nil
+	}
+
+	values := istio.Spec.GetValues()
+	if values == nil {
+		values = make(map[string]interface{})
+	}
+	selectors, _, err := unstructured.NestedSlice(values, "meshConfig", "discoverySelectors")
+	if err != nil {
+		return fmt.Errorf("invalid meshConfig.discoverySelectors: %v", err)
+	}
+	selectors = append(selectors,
+		map[string]interface{}{
+			"matchLabels": map[string]interface{}{namespaceNameLabel: istio.Namespace},
+		},
+		map[string]interface{}{
+			"matchLabels": map[string]interface{}{common.MemberOfKey: istio.Namespace},
+		},
+	)
+	if err := unstructured.SetNestedSlice(values, selectors, "meshConfig", "discoverySelectors"); err != nil {
+		return err
+	}
+	return istio.Spec.SetValues(values)
+}
+
+// reconcileMembers labels the given member namespaces so that the control plane
+// discovers them and injects sidecars into their pods, and removes the labels
+// (including the network label) from namespaces that are no longer members. The
+// name of the Istio resource is recorded in an annotation of each member namespace,
+// so that only the namespaces of this Istio resource are removed.
+func (r *IstioReconciler) reconcileMembers(ctx context.Context, istio *v1alpha1.Istio, members []string, revision string) error {
+	logger := log.FromContext(ctx)
+	memberSet := sets.New(members...)
+	memberSet.Delete(istio.Namespace)
+
+	var errs []error
+	for _, name := range sets.SortedList(memberSet) {
+		ns := &corev1.Namespace{}
+		if err := r.Client.Get(ctx, client.ObjectKey{Name: name}, ns); err != nil {
+			if errors.IsNotFound(err) {
+				// the namespace will be labeled when it's created
+				logger.V(2).Info("Member namespace not found", "namespace", name)
+				continue
+			}
+			return err
+		}
+
+		if mesh, found := ns.Labels[common.MemberOfKey]; found && !isMemberOf(ns, istio) {
+			if owner := ns.Annotations[common.MemberOfNameKey]; owner != "" {
+				mesh = mesh + "/" + owner
+			}
+			errs = append(errs, newConflictError("namespace %s is already a member of the mesh %s", name, mesh))
+			continue
+		}
+
+		if err := r.patchNamespaceLabels(ctx, ns, func(labels map[string]string) {
+			setMemberOfName(ns, istio.Name)
+			labels[common.MemberOfKey] = istio.Namespace
+			if _, ignored := labels[common.IgnoreNamespaceKey]; ignored {
+				if labels[label.IoIstioRev.Name] == revision {
+					delete(labels, label.IoIstioRev.Name)
+				}
+			} else if _, found := labels[injectionLabel]; !found {
+				labels[label.IoIstioRev.Name] = revision
+			}
+		}); err != nil {
+			return err
+		}
+	}
+
+	namespaces := &corev1.NamespaceList{}
+	if err := r.Client.List(ctx, namespaces, client.MatchingLabels{common.MemberOfKey: istio.Namespace}); err != nil {
+		return err
+	}
+	network := getNetwork(istio.Status.GetAppliedValues())
+	for i := range namespaces.Items {
+		ns := &namespaces.Items[i]
+		if memberSet.Contains(ns.Name) || !isMemberOf(ns, istio) {
+			continue
+		}
+		logger.Info("Removing namespace from mesh", "namespace", ns.Name)
+		if err := r.patchNamespaceLabels(ctx, ns, func(labels map[string]string) {
+			setMemberOfName(ns, "")
+			delete(labels, common.MemberOfKey)
+			if labels[label.IoIstioRev.Name] == revision {
+				delete(labels, label.IoIstioRev.Name)
+			}
+			if network != "" && labels[label.TopologyNetwork.Name] == network {
+				delete(labels, label.TopologyNetwork.Name)
+			}
+		}); err != nil {
+			return err
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// isMemberOf returns whether the namespace is a member of the mesh of the Istio
+// resource. Namespaces labeled before the name of the Istio resource was recorded
+// belong to the Istio resource in the mesh's namespace.
+func isMemberOf(ns *corev1.Namespace, istio *v1alpha1.Istio) bool {
+	if ns.Labels[common.MemberOfKey] != istio.Namespace {
+		return false
+	}
+	name, found := ns.Annotations[common.MemberOfNameKey]
+	return !found || name == istio.Name
+}
+
+// setMemberOfName records the name of the Istio resource whose mesh the namespace is
+// a member of, or removes it if name is empty
+func setMemberOfName(ns *corev1.Namespace, name string) {
+	if name == "" {
+		delete(ns.Annotations, common.MemberOfNameKey)
+		return
+	}
+	if ns.Annotations == nil {
+		ns.Annotations = make(map[string]string)
+	}
+	ns.Annotations[common.MemberOfNameKey] = name
+}
+
+func (r *IstioReconciler) patchNamespaceLabels(ctx context.Context, ns *corev1.Namespace, mutate func(labels map[string]string)) error {
+	patch := client.MergeFrom(ns.DeepCopy())
+	if ns.Labels == nil {
+		ns.Labels = make(map[string]string)
+	}
+	mutate(ns.Labels)
+	if err := r.Client.Patch(ctx, ns, patch); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to update labels of namespace %s: %v", ns.Name, err)
+	}
+	return nil
+}
+
+// mapNamespaceToReconcileRequest returns requests for all Istio resources that
+// list the namespace as a member or that the namespace is labeled as a member of
+func (r *IstioReconciler) mapNamespaceToReconcileRequest(ctx context.Context, obj client.Object) []reconcile.Request {
+	istios := &v1alpha1.IstioList{}
+	if err := r.Client.List(ctx, istios); err != nil {
+		log.FromContext(ctx).Error(err, "failed to list Istio resources")
+		return nil
+	}
+
+	mesh := obj.GetLabels()[common.MemberOfKey]
+	var requests []reconcile.Request
+	for _, istio := range istios.Items {
+		if istio.Namespace == mesh || sets.New(istio.Spec.Members...).Contains(obj.GetName()) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&istio)})
+		}
+	}
+	return requests
+}
+
+func getRevision(values map[string]interface{}) string {
+	revision, _, _ := unstructured.NestedString(values, "revision")
+	if revision == "" {
+		return "default"
+	}
+	return revision
+}
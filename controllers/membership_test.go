@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	v1 "maistra.io/istio-operator/api/v1alpha1"
+	"maistra.io/istio-operator/pkg/common"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestApplyDiscoverySelectors(t *testing.T) {
+	testCases := []struct {
+		name         string
+		spec         v1.IstioSpec
+		expectValues map[string]interface{}
+	}{
+		{
+			name:         "no members",
+			spec:         v1.IstioSpec{},
+			expectValues: nil,
+		},
+		{
+			name: "members",
+			spec: v1.IstioSpec{Members: []string{"bookinfo"}},
+			expectValues: map[string]interface{}{
+				"meshConfig": map[string]interface{}{
+					"discoverySelectors": []interface{}{
+						map[string]interface{}{"matchLabels": map[string]interface{}{"kubernetes.io/metadata.name": "istio-system"}},
+						map[string]interface{}{"matchLabels": map[string]interface{}{common.MemberOfKey: "istio-system"}},
+					},
+				},
+			},
+		},
+		{
+			name: "members with user selectors",
+			spec: v1.IstioSpec{
+				Members: []string{"bookinfo"},
+				Values:  []byte(`{"meshConfig":{"discoverySelectors":[{"matchLabels":{"mesh":"basic"}}]}}`),
+			},
+			expectValues: map[string]interface{}{
+				"meshConfig": map[string]interface{}{
+					"discoverySelectors": []interface{}{
+						map[string]interface{}{"matchLabels": map[string]interface{}{"mesh": "basic"}},
+						map[string]interface{}{"matchLabels": map[string]interface{}{"kubernetes.io/metadata.name": "istio-system"}},
+						map[string]interface{}{"matchLabels": map[string]interface{}{common.MemberOfKey: "istio-system"}},
+					},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			istio := &v1.Istio{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "istio-system"},
+				Spec:       tc.spec,
+			}
+			Must(t, applyDiscoverySelectors(istio))
+			if diff := cmp.Diff(tc.expectValues, istio.Spec.GetValues()); diff != "" {
+				t.Errorf("unexpected values; diff (-expected, +actual):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestReconcileMembers(t *testing.T) {
+	ctx := context.Background()
+	newNamespace := func(name string, labels map[string]string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+
+	cl := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(
+		newNamespace("istio-system", nil),
+		newNamespace("bookinfo", nil),
+		newNamespace("legacy", map[string]string{injectionLabel: "enabled"}),
+		newNamespace("ignored", map[string]string{common.IgnoreNamespaceKey: "true"}),
+		newNamespace("other-mesh", map[string]string{common.MemberOfKey: "other-system"}),
+		newNamespace("former", map[string]string{common.MemberOfKey: "istio-system", "istio.io/rev": "default"}),
+	).Build()
+	r := &IstioReconciler{Client: cl}
+
+	istio := &v1.Istio{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "istio-system"}}
+	err := r.reconcileMembers(ctx, istio, []string{"bookinfo", "legacy", "ignored", "other-mesh", "missing"}, "default")
+	if err == nil {
+		t.Error("expected error for namespace that belongs to another mesh")
+	}
+
+	expectLabels := map[string]map[string]string{
+		"istio-system": nil,
+		"bookinfo":     {common.MemberOfKey: "istio-system", "istio.io/rev": "default"},
+		"legacy":       {common.MemberOfKey: "istio-system", injectionLabel: "enabled"},
+		"ignored":      {common.MemberOfKey: "istio-system", common.IgnoreNamespaceKey: "true"},
+		"other-mesh":   {common.MemberOfKey: "other-system"},
+		"former":       {},
+	}
+	for name, expected := range expectLabels {
+		ns := &corev1.Namespace{}
+		Must(t, cl.Get(ctx, client.ObjectKey{Name: name}, ns))
+		if len(expected) == 0 && len(ns.Labels) == 0 {
+			continue
+		}
+		if diff := cmp.Diff(expected, ns.Labels); diff != "" {
+			t.Errorf("unexpected labels on namespace %s; diff (-expected, +actual):\n%v", name, diff)
+		}
+	}
+}
+
+func TestReconcileMembersOfTwoIstios(t *testing.T) {
+	ctx := context.Background()
+	cl := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "bookinfo"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sleep"}},
+	).Build()
+	r := &IstioReconciler{Client: cl}
+
+	blue := &v1.Istio{ObjectMeta: metav1.ObjectMeta{Name: "blue", Namespace: "istio-system"}}
+	green := &v1.Istio{ObjectMeta: metav1.ObjectMeta{Name: "green", Namespace: "istio-system"}}
+	// each reconciliation only removes the namespaces of its own Istio resource
+	for i := 0; i < 2; i++ {
+		Must(t, r.reconcileMembers(ctx, blue, []string{"bookinfo"}, "blue"))
+		Must(t, r.reconcileMembers(ctx, green, []string{"sleep"}, "green"))
+	}
+	expected := map[string]string{"bookinfo": "blue", "sleep": "green"}
+	for name, istioName := range expected {
+		ns := &corev1.Namespace{}
+		Must(t, cl.Get(ctx, client.ObjectKey{Name: name}, ns))
+		if ns.Annotations[common.MemberOfNameKey] != istioName || ns.Labels["istio.io/rev"] != istioName {
+			t.Errorf("expected namespace %s to be a member of Istio %s, got labels %v and annotations %v",
+				name, istioName, ns.Labels, ns.Annotations)
+		}
+	}
+
+	// a namespace of one Istio resource can't be taken over by the other
+	if err := r.reconcileMembers(ctx, green, []string{"sleep", "bookinfo"}, "green"); !isConflict(err) {
+		t.Errorf("expected conflict for a member of the other Istio resource, got %v", err)
+	}
+
+	// removing the members of one Istio resource keeps those of the other
+	Must(t, r.reconcileMembers(ctx, blue, nil, "blue"))
+	ns := &corev1.Namespace{}
+	Must(t, cl.Get(ctx, client.ObjectKey{Name: "bookinfo"}, ns))
+	if _, found := ns.Labels[common.MemberOfKey]; found || ns.Annotations[common.MemberOfNameKey] != "" {
+		t.Errorf("expected bookinfo to be removed from the mesh, got labels %v and annotations %v", ns.Labels, ns.Annotations)
+	}
+	Must(t, cl.Get(ctx, client.ObjectKey{Name: "sleep"}, ns))
+	if ns.Labels[common.MemberOfKey] != "istio-system" {
+		t.Errorf("expected sleep to stay in the mesh, got labels %v", ns.Labels)
+	}
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/durationpb"
+	meshv1alpha1 "istio.io/api/mesh/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"maistra.io/istio-operator/api/v1alpha1"
+)
+
+type meshConfigError struct {
+	err error
+}
+
+func (e *meshConfigError) Error() string {
+	return fmt.Sprintf("invalid spec.meshConfig: %v", e.err)
+}
+
+// isInvalidMeshConfig returns true if the error is caused by an invalid spec.meshConfig
+func isInvalidMeshConfig(err error) bool {
+	var agg utilerrors.Aggregate
+	if errors.As(err, &agg) {
+		for _, e := range agg.Errors() {
+			if isInvalidMeshConfig(e) {
+				return true
+			}
+		}
+		return false
+	}
+	var meshConfigErr *meshConfigError
+	return errors.As(err, &meshConfigErr)
+}
+
+// validateMeshConfig checks that spec.meshConfig only contains fields of Istio's
+// MeshConfig API with values of the right type, and that the values that would
+// prevent istiod from loading the configuration are valid.
+func validateMeshConfig(istio *v1alpha1.Istio) error {
+	if len(istio.Spec.MeshConfig) == 0 {
+		return nil
+	}
+	meshConfig := &meshv1alpha1.MeshConfig{}
+	if err := protojson.Unmarshal(istio.Spec.MeshConfig, meshConfig); err != nil {
+		return &meshConfigError{err: err}
+	}
+
+	var errs []error
+	errs = append(errs, validatePort("proxyListenPort", meshConfig.ProxyListenPort))
+	errs = append(errs, validatePort("proxyHttpPort", meshConfig.ProxyHttpPort))
+	errs = append(errs, validatePositiveDuration("connectTimeout", meshConfig.ConnectTimeout))
+	if timeout := meshConfig.ProtocolDetectionTimeout; timeout != nil && timeout.AsDuration() < 0 {
+		errs = append(errs, fmt.Errorf("protocolDetectionTimeout must not be negative"))
+	}
+
+	if proxyConfig := meshConfig.DefaultConfig; proxyConfig != nil {
+		if address := proxyConfig.DiscoveryAddress; address != "" {
+			if _, port, err := net.SplitHostPort(address); err != nil {
+				errs = append(errs, fmt.Errorf("defaultConfig.discoveryAddress: %v", err))
+			} else if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+				errs = append(errs, fmt.Errorf("defaultConfig.discoveryAddress: invalid port %q", port))
+			}
+		}
+		errs = append(errs, validatePositiveDuration("defaultConfig.drainDuration", proxyConfig.DrainDuration))
+		if concurrency := proxyConfig.Concurrency; concurrency != nil && concurrency.Value < 0 {
+			errs = append(errs, fmt.Errorf("defaultConfig.concurrency must not be negative"))
+		}
+	}
+
+	providerNames := sets.New[string]()
+	for i, provider := range meshConfig.ExtensionProviders {
+		if provider.Name == "" {
+			errs = append(errs, fmt.Errorf("extensionProviders[%d]: name is required", i))
+		} else if providerNames.Has(provider.Name) {
+			errs = append(errs, fmt.Errorf("extensionProviders[%d]: duplicate name %q", i, provider.Name))
+		}
+		providerNames.Insert(provider.Name)
+		if provider.Provider == nil {
+			errs = append(errs, fmt.Errorf("extensionProviders[%d]: no provider configured", i))
+		}
+	}
+
+	for i, selector := range meshConfig.DiscoverySelectors {
+		if _, err := metav1.LabelSelectorAsSelector(selector); err != nil {
+			errs = append(errs, fmt.Errorf("discoverySelectors[%d]: %v", i, err))
+		}
+	}
+
+	if err := utilerrors.NewAggregate(errs); err != nil {
+		return &meshConfigError{err: err}
+	}
+	return nil
+}
+
+func validatePort(field string, port int32) error {
+	// zero means that the port isn't set
+	if port < 0 || port > 65535 {
+		return fmt.Errorf("%s: invalid port %d", field, port)
+	}
+	return nil
+}
+
+func validatePositiveDuration(field string, duration *durationpb.Duration) error {
+	if duration != nil && duration.AsDuration() <= 0 {
+		return fmt.Errorf("%s must be positive", field)
+	}
+	return nil
+}
+
+// applyMeshConfig merges spec.meshConfig into values.meshConfig. The fields of
+// spec.meshConfig take precedence; lists replace those in the values.
+func applyMeshConfig(istio *v1alpha1.Istio) error {
+	meshConfig := istio.Spec.GetMeshConfig()
+	if len(meshConfig) == 0 {
+		return nil
+	}
+
+	values := istio.Spec.GetValues()
+	if values == nil {
+		values = make(map[string]interface{})
+	}
+	valuesMeshConfig, ok := values["meshConfig"].(map[string]interface{})
+	if values["meshConfig"] != nil && !ok {
+		return fmt.Errorf("invalid values.meshConfig: expected an object")
+	}
+	values["meshConfig"] = mergeValues(meshConfig, valuesMeshConfig)
+	return istio.Spec.SetValues(values)
+}
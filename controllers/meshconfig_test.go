@@ -0,0 +1,163 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "maistra.io/istio-operator/api/v1alpha1"
+	"maistra.io/istio-operator/pkg/redact"
+)
+
+func TestValidateMeshConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		meshConfig string
+		expectErr  string
+	}{
+		{name: "not set"},
+		{
+			name: "valid",
+			meshConfig: `{"accessLogFile": "/dev/stdout", "connectTimeout": "5s",
+				"defaultConfig": {"discoveryAddress": "istiod.istio-system.svc:15012", "holdApplicationUntilProxyStarts": true},
+				"extensionProviders": [{"name": "otel", "opentelemetry": {"service": "otel.observability.svc", "port": 4317}}],
+				"discoverySelectors": [{"matchExpressions": [{"key": "mesh", "operator": "Exists"}]}]}`,
+		},
+		{name: "unknown field", meshConfig: `{"accessLogFiles": "/dev/stdout"}`, expectErr: `unknown field "accessLogFiles"`},
+		{name: "wrong type", meshConfig: `{"enableTracing": "yes"}`, expectErr: "invalid spec.meshConfig"},
+		{name: "invalid duration", meshConfig: `{"connectTimeout": "5 seconds"}`, expectErr: "invalid spec.meshConfig"},
+		{name: "zero connect timeout", meshConfig: `{"connectTimeout": "0s"}`, expectErr: "connectTimeout must be positive"},
+		{name: "invalid port", meshConfig: `{"proxyListenPort": 70000}`, expectErr: "proxyListenPort: invalid port 70000"},
+		{
+			name:       "discovery address without port",
+			meshConfig: `{"defaultConfig": {"discoveryAddress": "istiod.istio-system.svc"}}`,
+			expectErr:  "defaultConfig.discoveryAddress",
+		},
+		{
+			name:       "duplicate extension provider",
+			meshConfig: `{"extensionProviders": [{"name": "log", "envoyFileAccessLog": {}}, {"name": "log", "envoyFileAccessLog": {}}]}`,
+			expectErr:  `extensionProviders[1]: duplicate name "log"`,
+		},
+		{
+			name:       "extension provider without type",
+			meshConfig: `{"extensionProviders": [{"name": "log"}]}`,
+			expectErr:  "extensionProviders[0]: no provider configured",
+		},
+		{
+			name:       "invalid discovery selector",
+			meshConfig: `{"discoverySelectors": [{"matchExpressions": [{"key": "mesh", "operator": "Has"}]}]}`,
+			expectErr:  "discoverySelectors[0]",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			istio := &v1.Istio{Spec: v1.IstioSpec{MeshConfig: json.RawMessage(tc.meshConfig)}}
+			err := validateMeshConfig(istio)
+			if tc.expectErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.expectErr) {
+				t.Errorf("expected error containing %q, got %v", tc.expectErr, err)
+			}
+			if reason := determineReconciledCondition(err).Reason; reason != v1.ConditionReasonInvalidMeshConfig {
+				t.Errorf("expected reason %s, got %s", v1.ConditionReasonInvalidMeshConfig, reason)
+			}
+		})
+	}
+}
+
+func TestApplyMeshConfig(t *testing.T) {
+	istio := &v1.Istio{
+		Spec: v1.IstioSpec{
+			Values: []byte(`{"meshConfig":{"accessLogFile":"/dev/null","enableTracing":true,
+				"defaultConfig":{"holdApplicationUntilProxyStarts":false,"tracing":{"sampling":1}},
+				"discoverySelectors":[{"matchLabels":{"a":"b"}}]},"pilot":{"enabled":true}}`),
+			MeshConfig: []byte(`{"accessLogFile":"/dev/stdout","defaultConfig":{"holdApplicationUntilProxyStarts":true},
+				"discoverySelectors":[{"matchLabels":{"mesh":"basic"}}]}`),
+		},
+	}
+	Must(t, applyMeshConfig(istio))
+
+	expected := map[string]interface{}{
+		"meshConfig": map[string]interface{}{
+			"accessLogFile": "/dev/stdout",
+			"enableTracing": true,
+			"defaultConfig": map[string]interface{}{
+				"holdApplicationUntilProxyStarts": true,
+				"tracing":                         map[string]interface{}{"sampling": float64(1)},
+			},
+			"discoverySelectors": []interface{}{
+				map[string]interface{}{"matchLabels": map[string]interface{}{"mesh": "basic"}},
+			},
+		},
+		"pilot": map[string]interface{}{"enabled": true},
+	}
+	if diff := cmp.Diff(expected, istio.Spec.GetValues()); diff != "" {
+		t.Errorf("unexpected values (-expected +actual):\n%s", diff)
+	}
+
+	// without values
+	istio = &v1.Istio{Spec: v1.IstioSpec{MeshConfig: []byte(`{"accessLogFile":"/dev/stdout"}`)}}
+	Must(t, applyMeshConfig(istio))
+	expected = map[string]interface{}{"meshConfig": map[string]interface{}{"accessLogFile": "/dev/stdout"}}
+	if diff := cmp.Diff(expected, istio.Spec.GetValues()); diff != "" {
+		t.Errorf("unexpected values (-expected +actual):\n%s", diff)
+	}
+}
+
+func TestSetAppliedMeshConfig(t *testing.T) {
+	istio := &v1.Istio{}
+	values := map[string]interface{}{
+		"meshConfig": map[string]interface{}{
+			"accessLogFile": "/dev/stdout",
+			"defaultConfig": map[string]interface{}{
+				"proxyMetadata": map[string]interface{}{"ISTIO_META_TOKEN": "secret"},
+			},
+		},
+	}
+	Must(t, setAppliedMeshConfig(istio, values))
+	var applied map[string]interface{}
+	Must(t, json.Unmarshal(istio.Status.AppliedMeshConfig, &applied))
+	expected := map[string]interface{}{
+		"accessLogFile": "/dev/stdout",
+		"defaultConfig": map[string]interface{}{
+			"proxyMetadata": map[string]interface{}{"ISTIO_META_TOKEN": redact.Placeholder},
+		},
+	}
+	if diff := cmp.Diff(expected, applied); diff != "" {
+		t.Errorf("unexpected applied mesh config (-expected +actual):\n%s", diff)
+	}
+
+	Must(t, setAppliedMeshConfig(istio, map[string]interface{}{}))
+	if istio.Status.AppliedMeshConfig != nil {
+		t.Errorf("expected no applied mesh config, got %s", istio.Status.AppliedMeshConfig)
+	}
+}
+
+func TestIstioValidatorMeshConfig(t *testing.T) {
+	ctx := context.Background()
+	validator := &IstioValidator{}
+	newIstio := func(meshConfig string) *v1.Istio {
+		return &v1.Istio{Spec: v1.IstioSpec{Version: "v3.0", MeshConfig: json.RawMessage(meshConfig)}}
+	}
+	invalid := `{"accessLogFiles": "/dev/stdout"}`
+
+	if _, err := validator.ValidateCreate(ctx, newIstio(invalid)); err == nil {
+		t.Errorf("expected error for invalid mesh config")
+	}
+	if _, err := validator.ValidateUpdate(ctx, newIstio(`{}`), newIstio(invalid)); err == nil {
+		t.Errorf("expected error for invalid mesh config")
+	}
+	// resources created before the webhook was enabled can still be updated
+	old := newIstio(invalid)
+	updated := newIstio(invalid)
+	updated.Spec.Members = []string{"bookinfo"}
+	if _, err := validator.ValidateUpdate(ctx, old, updated); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sort"
+
+	"istio.io/api/label"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"maistra.io/istio-operator/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// istiodMonitoringPort is the port of the istiod Service that serves its metrics
+	istiodMonitoringPort = "http-monitoring"
+	// envoyMetricsPort is the container port of the proxies that serves the Envoy metrics
+	envoyMetricsPort = "http-envoy-prom"
+	envoyMetricsPath = "/stats/prometheus"
+
+	gatewaysPodMonitorName = "istio-gateways"
+	proxiesPodMonitorName  = "istio-proxies"
+)
+
+var (
+	serviceMonitorGVK = schema.GroupVersionKind{Group: "monitoring.coreos.com", Version: "v1", Kind: "ServiceMonitor"}
+	podMonitorGVK     = schema.GroupVersionKind{Group: "monitoring.coreos.com", Version: "v1", Kind: "PodMonitor"}
+)
+
+// reconcileMonitors creates the ServiceMonitor of istiod and the PodMonitors of the
+// gateways and sidecar proxies if monitoring is enabled, and deletes them otherwise.
+// If prometheus-operator isn't installed, the monitors are skipped.
+func (r *IstioReconciler) reconcileMonitors(ctx context.Context, istio *v1alpha1.Istio, values map[string]interface{}) error {
+	monitors := newMonitors(istio, values)
+	if istio.Spec.Monitoring == nil || !istio.Spec.Monitoring.Enabled {
+		for _, monitor := range monitors {
+			if err := r.deleteOwnedObject(ctx, istio, monitor.obj); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, monitor := range monitors {
+		monitor := monitor
+		err := r.createOrUpdateOwnedObject(ctx, istio, monitor.obj, func() {
+			monitor.obj.SetLabels(istio.Spec.Monitoring.Labels)
+			monitor.obj.Object["spec"] = monitor.spec
+		})
+		if meta.IsNoMatchError(err) {
+			log.FromContext(ctx).Info("monitoring.coreos.com CRDs not installed; skipping the creation of monitors")
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type monitor struct {
+	obj  *unstructured.Unstructured
+	spec map[string]interface{}
+}
+
+// newMonitors returns the monitors of the control plane. The proxies of the
+// gateways and the sidecars are told apart by the tlsMode label, which is only set
+// on the pods that have a sidecar injected.
+func newMonitors(istio *v1alpha1.Istio, values map[string]interface{}) []monitor {
+	revision := getRevision(values)
+	var interval string
+	if istio.Spec.Monitoring != nil {
+		interval = istio.Spec.Monitoring.Interval
+	}
+	endpoint := func(port, path string) map[string]interface{} {
+		endpoint := map[string]interface{}{"port": port}
+		if path != "" {
+			endpoint["path"] = path
+		}
+		if interval != "" {
+			endpoint["interval"] = interval
+		}
+		return endpoint
+	}
+	revisionSelector := func(operator string) map[string]interface{} {
+		return map[string]interface{}{
+			"matchLabels": map[string]interface{}{label.IoIstioRev.Name: revision},
+			"matchExpressions": []interface{}{
+				map[string]interface{}{"key": label.SecurityTlsMode.Name, "operator": operator},
+			},
+		}
+	}
+
+	// the sidecars are in the member namespaces, or in any namespace if the mesh has no members
+	proxiesNamespaceSelector := map[string]interface{}{"any": true}
+	if len(istio.Spec.Members) > 0 {
+		members := append([]string{}, istio.Spec.Members...)
+		sort.Strings(members)
+		matchNames := make([]interface{}, 0, len(members))
+		for _, member := range members {
+			matchNames = append(matchNames, member)
+		}
+		proxiesNamespaceSelector = map[string]interface{}{"matchNames": matchNames}
+	}
+
+	return []monitor{
+		{
+			obj: newMonitorObject(istio, serviceMonitorGVK, istiodDeploymentKey(istio).Name),
+			spec: map[string]interface{}{
+				"selector": map[string]interface{}{
+					"matchLabels": map[string]interface{}{"app": "istiod", label.IoIstioRev.Name: revision},
+				},
+				"namespaceSelector": map[string]interface{}{"matchNames": []interface{}{istio.Namespace}},
+				"endpoints":         []interface{}{endpoint(istiodMonitoringPort, "")},
+			},
+		},
+		{
+			obj: newMonitorObject(istio, podMonitorGVK, revisionedName(gatewaysPodMonitorName, values)),
+			spec: map[string]interface{}{
+				"selector":            revisionSelector("DoesNotExist"),
+				"namespaceSelector":   map[string]interface{}{"matchNames": []interface{}{istio.Namespace}},
+				"podMetricsEndpoints": []interface{}{endpoint(envoyMetricsPort, envoyMetricsPath)},
+			},
+		},
+		{
+			obj: newMonitorObject(istio, podMonitorGVK, revisionedName(proxiesPodMonitorName, values)),
+			spec: map[string]interface{}{
+				"selector":            revisionSelector("Exists"),
+				"namespaceSelector":   proxiesNamespaceSelector,
+				"podMetricsEndpoints": []interface{}{endpoint(envoyMetricsPort, envoyMetricsPath)},
+			},
+		},
+	}
+}
+
+func newMonitorObject(istio *v1alpha1.Istio, gvk schema.GroupVersionKind, name string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+	obj.SetName(name)
+	obj.SetNamespace(istio.Namespace)
+	return obj
+}
+
+// revisionedName appends the revision to the name, like the charts do for the
+// names of istiod's resources
+func revisionedName(name string, values map[string]interface{}) string {
+	if revision, _, _ := unstructured.NestedString(values, "revision"); revision != "" {
+		return name + "-" + revision
+	}
+	return name
+}
@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	v1 "maistra.io/istio-operator/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestReconcileMonitors(t *testing.T) {
+	ctx := context.Background()
+	s := runtime.NewScheme()
+	Must(t, clientgoscheme.AddToScheme(s))
+	for _, gvk := range []schema.GroupVersionKind{serviceMonitorGVK, podMonitorGVK} {
+		s.AddKnownTypeWithName(gvk, &unstructured.Unstructured{})
+		s.AddKnownTypeWithName(gvk.GroupVersion().WithKind(gvk.Kind+"List"), &unstructured.UnstructuredList{})
+	}
+
+	newIstio := func(monitoring *v1.MonitoringConfig, members ...string) *v1.Istio {
+		return &v1.Istio{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "istio-system", UID: "istio-uid"},
+			Spec: v1.IstioSpec{
+				Values:     []byte(`{"revision":"canary"}`),
+				Members:    members,
+				Monitoring: monitoring,
+			},
+		}
+	}
+	getMonitor := func(t *testing.T, cl client.Client, kind, name string) *unstructured.Unstructured {
+		t.Helper()
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(serviceMonitorGVK.GroupVersion().WithKind(kind))
+		if err := cl.Get(ctx, client.ObjectKey{Namespace: "istio-system", Name: name}, obj); err != nil {
+			if errors.IsNotFound(err) {
+				return nil
+			}
+			t.Fatal(err)
+		}
+		return obj
+	}
+
+	cl := fake.NewClientBuilder().WithScheme(s).Build()
+	r := &IstioReconciler{Client: cl}
+	istio := newIstio(&v1.MonitoringConfig{Enabled: true, Interval: "30s", Labels: map[string]string{"release": "prometheus"}}, "foo", "bar")
+	Must(t, r.reconcileMonitors(ctx, istio, istio.Spec.GetValues()))
+
+	istiodMonitor := getMonitor(t, cl, "ServiceMonitor", "istiod-canary")
+	if istiodMonitor == nil {
+		t.Fatal("expected ServiceMonitor istiod-canary")
+	}
+	if owner := metav1.GetControllerOf(istiodMonitor); owner == nil || owner.UID != istio.UID {
+		t.Errorf("expected ServiceMonitor to be owned by the Istio resource, got %v", owner)
+	}
+	if diff := cmp.Diff(map[string]string{"release": "prometheus"}, istiodMonitor.GetLabels()); diff != "" {
+		t.Errorf("unexpected labels (-expected +actual):\n%s", diff)
+	}
+	expectedIstiodSpec := map[string]interface{}{
+		"selector": map[string]interface{}{
+			"matchLabels": map[string]interface{}{"app": "istiod", "istio.io/rev": "canary"},
+		},
+		"namespaceSelector": map[string]interface{}{"matchNames": []interface{}{"istio-system"}},
+		"endpoints":         []interface{}{map[string]interface{}{"port": "http-monitoring", "interval": "30s"}},
+	}
+	if diff := cmp.Diff(expectedIstiodSpec, istiodMonitor.Object["spec"]); diff != "" {
+		t.Errorf("unexpected ServiceMonitor spec (-expected +actual):\n%s", diff)
+	}
+
+	if getMonitor(t, cl, "PodMonitor", "istio-gateways-canary") == nil {
+		t.Error("expected PodMonitor istio-gateways-canary")
+	}
+	proxiesMonitor := getMonitor(t, cl, "PodMonitor", "istio-proxies-canary")
+	if proxiesMonitor == nil {
+		t.Fatal("expected PodMonitor istio-proxies-canary")
+	}
+	expectedProxiesSpec := map[string]interface{}{
+		"selector": map[string]interface{}{
+			"matchLabels": map[string]interface{}{"istio.io/rev": "canary"},
+			"matchExpressions": []interface{}{
+				map[string]interface{}{"key": "security.istio.io/tlsMode", "operator": "Exists"},
+			},
+		},
+		"namespaceSelector": map[string]interface{}{"matchNames": []interface{}{"bar", "foo"}},
+		"podMetricsEndpoints": []interface{}{
+			map[string]interface{}{"port": "http-envoy-prom", "path": "/stats/prometheus", "interval": "30s"},
+		},
+	}
+	if diff := cmp.Diff(expectedProxiesSpec, proxiesMonitor.Object["spec"]); diff != "" {
+		t.Errorf("unexpected PodMonitor spec (-expected +actual):\n%s", diff)
+	}
+
+	// disabling monitoring deletes the monitors
+	istio.Spec.Monitoring.Enabled = false
+	Must(t, r.reconcileMonitors(ctx, istio, istio.Spec.GetValues()))
+	for kind, name := range map[string]string{
+		"ServiceMonitor": "istiod-canary", "PodMonitor": "istio-proxies-canary",
+	} {
+		if getMonitor(t, cl, kind, name) != nil {
+			t.Errorf("expected %s %s to be deleted", kind, name)
+		}
+	}
+
+	t.Run("CRDs not installed", func(t *testing.T) {
+		noMatch := func(obj client.Object) error {
+			if obj.GetObjectKind().GroupVersionKind().Group == serviceMonitorGVK.Group {
+				return &meta.NoKindMatchError{GroupKind: obj.GetObjectKind().GroupVersionKind().GroupKind()}
+			}
+			return nil
+		}
+		cl := fake.NewClientBuilder().WithScheme(s).WithInterceptorFuncs(interceptor.Funcs{
+			Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+				if err := noMatch(obj); err != nil {
+					return err
+				}
+				return c.Get(ctx, key, obj, opts...)
+			},
+			Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+				if err := noMatch(obj); err != nil {
+					return err
+				}
+				return c.Create(ctx, obj, opts...)
+			},
+		}).Build()
+		r := &IstioReconciler{Client: cl}
+		for _, enabled := range []bool{true, false} {
+			istio := newIstio(&v1.MonitoringConfig{Enabled: enabled})
+			if err := r.reconcileMonitors(ctx, istio, istio.Spec.GetValues()); err != nil {
+				t.Errorf("unexpected error with monitoring enabled=%v: %v", enabled, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,290 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"maistra.io/istio-operator/api/v1alpha1"
+	"maistra.io/istio-operator/pkg/common"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"istio.io/api/label"
+	networkingapi "istio.io/api/networking/v1alpha3"
+	networkingv1alpha3 "istio.io/client-go/pkg/apis/networking/v1alpha3"
+)
+
+const (
+	eastWestGatewayName  = "istio-eastwestgateway"
+	eastWestGatewayLabel = "eastwestgateway"
+
+	// crossNetworkGatewayName is the Gateway that exposes the services of the network
+	crossNetworkGatewayName = "cross-network-gateway"
+	// istiodGatewayName is the Gateway that exposes istiod to other networks
+	istiodGatewayName = "istiod-gateway"
+	// istiodVirtualServiceName routes the traffic of the istiod Gateway to istiod
+	istiodVirtualServiceName = "istiod-vs"
+
+	crossNetworkPort  = 15443
+	istiodXDSPort     = 15012
+	istiodWebhookPort = 15017
+)
+
+// applyMultiNetwork sets the network of the control plane and the networks of the
+// mesh that are reached through explicit gateway addresses
+func applyMultiNetwork(istio *v1alpha1.Istio) error {
+	multiNetwork := istio.Spec.MultiNetwork
+	if multiNetwork == nil {
+		return nil
+	}
+
+	global := map[string]interface{}{
+		"network": multiNetwork.Network,
+	}
+	if len(multiNetwork.MeshNetworks) > 0 {
+		networks := make(map[string]interface{}, len(multiNetwork.MeshNetworks))
+		for _, network := range multiNetwork.MeshNetworks {
+			endpoints := make([]interface{}, 0, len(network.Clusters))
+			for _, cluster := range network.Clusters {
+				endpoints = append(endpoints, map[string]interface{}{"fromRegistry": cluster})
+			}
+			networks[network.Name] = map[string]interface{}{
+				"endpoints": endpoints,
+				"gateways": []interface{}{
+					map[string]interface{}{
+						"address": network.GatewayAddress,
+						"port":    int64(network.GetGatewayPort()),
+					},
+				},
+			}
+		}
+		global["meshNetworks"] = networks
+	}
+	return istio.Spec.SetValues(mergeValues(map[string]interface{}{"global": global}, istio.Spec.GetValues()))
+}
+
+// hasEastWestGateway returns whether the east-west gateway is deployed for the Istio resource
+func hasEastWestGateway(istio *v1alpha1.Istio) bool {
+	return istio.Spec.MultiNetwork != nil && istio.Spec.MultiNetwork.EastWestGateway.IsEnabled()
+}
+
+// eastWestGatewayValues returns the values of the gateway chart that deploy the
+// east-west gateway of the control plane's network
+func eastWestGatewayValues(istio *v1alpha1.Istio, values map[string]interface{}) map[string]interface{} {
+	network := istio.Spec.MultiNetwork.Network
+	gatewayValues := map[string]interface{}{
+		"name":           eastWestGatewayName,
+		"networkGateway": network,
+		"labels": map[string]interface{}{
+			"app":                      eastWestGatewayName,
+			"istio":                    eastWestGatewayLabel,
+			label.TopologyNetwork.Name: network,
+		},
+		"service": map[string]interface{}{
+			"type": string(istio.Spec.MultiNetwork.EastWestGateway.GetServiceType()),
+		},
+	}
+	if revision, _, _ := unstructured.NestedString(values, "revision"); revision != "" {
+		gatewayValues["revision"] = revision
+	}
+	if pullSecrets, _, _ := unstructured.NestedStringSlice(values, "global", "imagePullSecrets"); len(pullSecrets) > 0 {
+		refs := make([]interface{}, 0, len(pullSecrets))
+		for _, name := range pullSecrets {
+			refs = append(refs, map[string]interface{}{"name": name})
+		}
+		gatewayValues["imagePullSecrets"] = refs
+	}
+	return gatewayValues
+}
+
+// getNetwork returns the network of the control plane with the given values
+func getNetwork(values map[string]interface{}) string {
+	network, _, _ := unstructured.NestedString(values, "global", "network")
+	return network
+}
+
+// reconcileNetworkLabels labels the istio namespace and the member namespaces with
+// the network of the control plane. The label of the previous network is removed
+// when the control plane no longer has a network.
+func (r *IstioReconciler) reconcileNetworkLabels(ctx context.Context, istio *v1alpha1.Istio, network, previousNetwork string) error {
+	namespaces := &corev1.NamespaceList{}
+	if err := r.Client.List(ctx, namespaces, client.MatchingLabels{common.MemberOfKey: istio.Namespace}); err != nil {
+		return fmt.Errorf("failed to list member namespaces: %v", err)
+	}
+	istioNamespace := &corev1.Namespace{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Name: istio.Namespace}, istioNamespace); err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to get namespace %s: %v", istio.Namespace, err)
+		}
+	} else {
+		namespaces.Items = append(namespaces.Items, *istioNamespace)
+	}
+
+	var errs []error
+	for i := range namespaces.Items {
+		ns := &namespaces.Items[i]
+		if ns.Name != istio.Namespace && !isMemberOf(ns, istio) {
+			continue
+		}
+		current, found := ns.Labels[label.TopologyNetwork.Name]
+		switch {
+		case network == "":
+			if !found || previousNetwork == "" || current != previousNetwork {
+				continue
+			}
+		case current == network:
+			continue
+		case found && current != previousNetwork:
+			errs = append(errs, newConflictError("namespace %s already belongs to network %s", ns.Name, current))
+			continue
+		}
+
+		if err := r.patchNamespaceLabels(ctx, ns, func(labels map[string]string) {
+			if network == "" {
+				delete(labels, label.TopologyNetwork.Name)
+			} else {
+				labels[label.TopologyNetwork.Name] = network
+			}
+		}); err != nil {
+			return err
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// reconcileNetworkGateways creates the Gateways that expose the services and istiod
+// through the east-west gateway, and deletes the ones that are no longer needed
+func (r *IstioReconciler) reconcileNetworkGateways(ctx context.Context, istio *v1alpha1.Istio) error {
+	exposeServices, exposeIstiod := false, false
+	if hasEastWestGateway(istio) && istio.Spec.ConfigCluster == nil {
+		exposeServices = istio.Spec.MultiNetwork.EastWestGateway.ShouldExposeServices()
+		exposeIstiod = istio.Spec.MultiNetwork.EastWestGateway.ExposeIstiod
+	}
+
+	crossNetworkGateway := &networkingv1alpha3.Gateway{ObjectMeta: metav1.ObjectMeta{Name: crossNetworkGatewayName, Namespace: istio.Namespace}}
+	istiodGateway := &networkingv1alpha3.Gateway{ObjectMeta: metav1.ObjectMeta{Name: istiodGatewayName, Namespace: istio.Namespace}}
+	istiodVirtualService := &networkingv1alpha3.VirtualService{ObjectMeta: metav1.ObjectMeta{Name: istiodVirtualServiceName, Namespace: istio.Namespace}}
+
+	if !exposeServices {
+		if err := r.deleteOwnedObject(ctx, istio, crossNetworkGateway); err != nil {
+			return err
+		}
+	} else if err := r.createOrUpdateOwnedObject(ctx, istio, crossNetworkGateway, func() {
+		crossNetworkGateway.Spec = networkingapi.Gateway{
+			Selector: map[string]string{"istio": eastWestGatewayLabel},
+			Servers: []*networkingapi.Server{
+				{
+					Port:  &networkingapi.Port{Number: crossNetworkPort, Name: "tls", Protocol: "TLS"},
+					Tls:   &networkingapi.ServerTLSSettings{Mode: networkingapi.ServerTLSSettings_AUTO_PASSTHROUGH},
+					Hosts: []string{"*.local"},
+				},
+			},
+		}
+	}); err != nil {
+		return err
+	}
+
+	if !exposeIstiod {
+		for _, obj := range []client.Object{istiodGateway, istiodVirtualService} {
+			if err := r.deleteOwnedObject(ctx, istio, obj); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := r.createOrUpdateOwnedObject(ctx, istio, istiodGateway, func() {
+		istiodGateway.Spec = networkingapi.Gateway{
+			Selector: map[string]string{"istio": eastWestGatewayLabel},
+			Servers: []*networkingapi.Server{
+				{
+					Port:  &networkingapi.Port{Number: istiodXDSPort, Name: "tls-istiod", Protocol: "TLS"},
+					Tls:   &networkingapi.ServerTLSSettings{Mode: networkingapi.ServerTLSSettings_PASSTHROUGH},
+					Hosts: []string{"*"},
+				},
+				{
+					Port:  &networkingapi.Port{Number: istiodWebhookPort, Name: "tls-istiodwebhook", Protocol: "TLS"},
+					Tls:   &networkingapi.ServerTLSSettings{Mode: networkingapi.ServerTLSSettings_PASSTHROUGH},
+					Hosts: []string{"*"},
+				},
+			},
+		}
+	}); err != nil {
+		return err
+	}
+
+	// the istiod Service is named like its service account
+	istiodHost := fmt.Sprintf("%s.%s.svc.cluster.local", istiodServiceAccount(istio), istio.Namespace)
+	route := func(port, targetPort uint32) *networkingapi.TLSRoute {
+		return &networkingapi.TLSRoute{
+			Match: []*networkingapi.TLSMatchAttributes{{Port: port, SniHosts: []string{"*"}}},
+			Route: []*networkingapi.RouteDestination{
+				{Destination: &networkingapi.Destination{Host: istiodHost, Port: &networkingapi.PortSelector{Number: targetPort}}},
+			},
+		}
+	}
+	return r.createOrUpdateOwnedObject(ctx, istio, istiodVirtualService, func() {
+		istiodVirtualService.Spec = networkingapi.VirtualService{
+			Hosts:    []string{"*"},
+			Gateways: []string{istiodGatewayName},
+			Tls:      []*networkingapi.TLSRoute{route(istiodXDSPort, istiodXDSPort), route(istiodWebhookPort, 443)},
+		}
+	})
+}
+
+// createOrUpdateOwnedObject creates or updates an object in the istio namespace
+// that is controlled by the Istio resource
+func (r *IstioReconciler) createOrUpdateOwnedObject(ctx context.Context, istio *v1alpha1.Istio, obj client.Object, mutate func()) error {
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, obj, func() error {
+		if owner := metav1.GetControllerOf(obj); owner != nil && owner.UID != istio.UID {
+			return newConflictError("%T %s is already managed by %s %s", obj, obj.GetName(), owner.Kind, owner.Name)
+		}
+		obj.SetOwnerReferences([]metav1.OwnerReference{newOwnerReference(istio)})
+		mutate()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reconcile %T %s: %w", obj, obj.GetName(), err)
+	}
+	return nil
+}
+
+// deleteOwnedObject deletes the object if it's controlled by the Istio resource
+func (r *IstioReconciler) deleteOwnedObject(ctx context.Context, istio *v1alpha1.Istio, obj client.Object) error {
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+		// the CRD of the object may not be installed, e.g. in an external control plane
+		if errors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get %T %s: %v", obj, obj.GetName(), err)
+	}
+	if owner := metav1.GetControllerOf(obj); owner == nil || owner.UID != istio.UID {
+		return nil
+	}
+	if err := r.Client.Delete(ctx, obj); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete %T %s: %v", obj, obj.GetName(), err)
+	}
+	return nil
+}
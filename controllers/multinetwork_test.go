@@ -0,0 +1,191 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	v1 "maistra.io/istio-operator/api/v1alpha1"
+	"maistra.io/istio-operator/pkg/common"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	networkingv1alpha3 "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	"istio.io/istio/pkg/ptr"
+)
+
+func TestApplyMultiNetwork(t *testing.T) {
+	istio := &v1.Istio{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "istio-system"},
+		Spec: v1.IstioSpec{
+			Values: []byte(`{"global":{"meshID":"mesh1"}}`),
+			MultiNetwork: &v1.MultiNetworkConfig{
+				Network: "network1",
+				MeshNetworks: []v1.MeshNetwork{
+					{Name: "network2", Clusters: []string{"cluster2"}, GatewayAddress: "10.0.0.2"},
+				},
+			},
+		},
+	}
+	Must(t, applyMultiNetwork(istio))
+
+	expected := map[string]interface{}{
+		"global": map[string]interface{}{
+			"meshID":  "mesh1",
+			"network": "network1",
+			"meshNetworks": map[string]interface{}{
+				"network2": map[string]interface{}{
+					"endpoints": []interface{}{map[string]interface{}{"fromRegistry": "cluster2"}},
+					"gateways":  []interface{}{map[string]interface{}{"address": "10.0.0.2", "port": float64(15443)}},
+				},
+			},
+		},
+	}
+	if diff := cmp.Diff(expected, istio.Spec.GetValues()); diff != "" {
+		t.Errorf("unexpected values; diff (-expected, +actual):\n%v", diff)
+	}
+}
+
+func TestEastWestGatewayComponent(t *testing.T) {
+	var eastWest component
+	for _, c := range components {
+		if c.name == "eastwest-gateway" {
+			eastWest = c
+		}
+	}
+
+	istio := &v1.Istio{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "istio-system"}}
+	values := map[string]interface{}{"revision": "canary"}
+	if eastWest.isInstalled(istio, values) {
+		t.Error("expected east-west gateway not to be installed without multiNetwork")
+	}
+
+	istio.Spec.MultiNetwork = &v1.MultiNetworkConfig{
+		Network:         "network1",
+		EastWestGateway: v1.EastWestGatewayConfig{ServiceType: corev1.ServiceTypeNodePort},
+	}
+	if !eastWest.isInstalled(istio, values) {
+		t.Error("expected east-west gateway to be installed")
+	}
+	expected := map[string]interface{}{
+		"name":           "istio-eastwestgateway",
+		"revision":       "canary",
+		"networkGateway": "network1",
+		"labels": map[string]interface{}{
+			"app":                       "istio-eastwestgateway",
+			"istio":                     "eastwestgateway",
+			"topology.istio.io/network": "network1",
+		},
+		"service": map[string]interface{}{"type": "NodePort"},
+	}
+	if diff := cmp.Diff(expected, eastWest.valuesFor(istio, values)); diff != "" {
+		t.Errorf("unexpected gateway values; diff (-expected, +actual):\n%v", diff)
+	}
+
+	istio.Spec.MultiNetwork.EastWestGateway.Enabled = ptr.Of(false)
+	if eastWest.isInstalled(istio, values) {
+		t.Error("expected disabled east-west gateway not to be installed")
+	}
+}
+
+func TestReconcileNetworkLabels(t *testing.T) {
+	ctx := context.Background()
+	newNamespace := func(name string, labels map[string]string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+
+	cl := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(
+		newNamespace("istio-system", nil),
+		newNamespace("bookinfo", map[string]string{common.MemberOfKey: "istio-system"}),
+		newNamespace("moved", map[string]string{common.MemberOfKey: "istio-system", "topology.istio.io/network": "old"}),
+		newNamespace("other-network", map[string]string{common.MemberOfKey: "istio-system", "topology.istio.io/network": "other"}),
+		newNamespace("non-member", nil),
+	).Build()
+	r := &IstioReconciler{Client: cl}
+	istio := &v1.Istio{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "istio-system"}}
+
+	err := r.reconcileNetworkLabels(ctx, istio, "network1", "old")
+	if !isConflict(err) {
+		t.Errorf("expected conflict for namespace in another network, got %v", err)
+	}
+
+	expectNetwork := func(expected map[string]string) {
+		t.Helper()
+		for name, network := range expected {
+			ns := &corev1.Namespace{}
+			Must(t, cl.Get(ctx, client.ObjectKey{Name: name}, ns))
+			if actual := ns.Labels["topology.istio.io/network"]; actual != network {
+				t.Errorf("expected namespace %s to be in network %q, got %q", name, network, actual)
+			}
+		}
+	}
+	expectNetwork(map[string]string{
+		"istio-system":  "network1",
+		"bookinfo":      "network1",
+		"moved":         "network1",
+		"other-network": "other",
+		"non-member":    "",
+	})
+
+	Must(t, r.reconcileNetworkLabels(ctx, istio, "", "network1"))
+	expectNetwork(map[string]string{
+		"istio-system":  "",
+		"bookinfo":      "",
+		"moved":         "",
+		"other-network": "other",
+	})
+}
+
+func TestReconcileNetworkGateways(t *testing.T) {
+	ctx := context.Background()
+	s := runtime.NewScheme()
+	Must(t, clientgoscheme.AddToScheme(s))
+	Must(t, networkingv1alpha3.AddToScheme(s))
+	cl := fake.NewClientBuilder().WithScheme(s).Build()
+	r := &IstioReconciler{Client: cl}
+
+	istio := &v1.Istio{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "istio-system", UID: "istio-uid"},
+		Spec: v1.IstioSpec{
+			Values: []byte(`{"revision":"canary"}`),
+			MultiNetwork: &v1.MultiNetworkConfig{
+				Network:         "network1",
+				EastWestGateway: v1.EastWestGatewayConfig{ExposeIstiod: true},
+			},
+		},
+	}
+	Must(t, r.reconcileNetworkGateways(ctx, istio))
+
+	crossNetworkGateway := &networkingv1alpha3.Gateway{}
+	Must(t, cl.Get(ctx, client.ObjectKey{Namespace: "istio-system", Name: "cross-network-gateway"}, crossNetworkGateway))
+	if hosts := crossNetworkGateway.Spec.Servers[0].Hosts; len(hosts) != 1 || hosts[0] != "*.local" {
+		t.Errorf("unexpected hosts of cross-network gateway: %v", hosts)
+	}
+	if owner := metav1.GetControllerOf(crossNetworkGateway); owner == nil || owner.UID != istio.UID {
+		t.Errorf("expected cross-network gateway to be controlled by the Istio resource, got %v", owner)
+	}
+	Must(t, cl.Get(ctx, client.ObjectKey{Namespace: "istio-system", Name: "istiod-gateway"}, &networkingv1alpha3.Gateway{}))
+	vs := &networkingv1alpha3.VirtualService{}
+	Must(t, cl.Get(ctx, client.ObjectKey{Namespace: "istio-system", Name: "istiod-vs"}, vs))
+	if host := vs.Spec.Tls[0].Route[0].Destination.Host; host != "istiod-canary.istio-system.svc.cluster.local" {
+		t.Errorf("unexpected istiod host %s", host)
+	}
+
+	istio.Spec.MultiNetwork.EastWestGateway.ExposeIstiod = false
+	istio.Spec.MultiNetwork.EastWestGateway.ExposeServices = ptr.Of(false)
+	Must(t, r.reconcileNetworkGateways(ctx, istio))
+	for name, obj := range map[string]client.Object{
+		"cross-network-gateway": &networkingv1alpha3.Gateway{},
+		"istiod-gateway":        &networkingv1alpha3.Gateway{},
+		"istiod-vs":             &networkingv1alpha3.VirtualService{},
+	} {
+		if err := cl.Get(ctx, client.ObjectKey{Namespace: "istio-system", Name: name}, obj); !errors.IsNotFound(err) {
+			t.Errorf("expected %s to be deleted, got %v", name, err)
+		}
+	}
+}
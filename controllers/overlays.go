@@ -0,0 +1,76 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"maistra.io/istio-operator/api/v1alpha1"
+	"sigs.k8s.io/yaml"
+)
+
+// validateOverlays checks that every overlay refers to a component that is
+// installed and that its patch can be parsed. Whether an overlay matches any object
+// is only known once the charts are rendered.
+func validateOverlays(istio *v1alpha1.Istio) error {
+	values := istio.Spec.GetValues()
+	var errs []error
+	for i, overlay := range istio.Spec.Overlays {
+		c, found := componentByName(overlay.Component)
+		if !found {
+			errs = append(errs, fmt.Errorf("spec.overlays[%d]: unknown component %q", i, overlay.Component))
+			continue
+		}
+		if !c.isInstalled(istio, values) {
+			errs = append(errs, fmt.Errorf("spec.overlays[%d]: component %q isn't installed", i, overlay.Component))
+			continue
+		}
+		patch, err := yaml.YAMLToJSON([]byte(overlay.Patch))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("spec.overlays[%d]: invalid patch: %v", i, err))
+			continue
+		}
+		if overlay.Type == v1alpha1.OverlayPatchTypeJSON {
+			if _, err := jsonpatch.DecodePatch(patch); err != nil {
+				errs = append(errs, fmt.Errorf("spec.overlays[%d]: invalid JSON patch: %v", i, err))
+			}
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// overlaysFor returns the overlays that patch the objects of the given component
+func overlaysFor(istio *v1alpha1.Istio, componentName string) []v1alpha1.Overlay {
+	var overlays []v1alpha1.Overlay
+	for _, overlay := range istio.Spec.Overlays {
+		if overlay.Component == componentName {
+			overlays = append(overlays, overlay)
+		}
+	}
+	return overlays
+}
+
+func componentByName(name string) (component, bool) {
+	for _, c := range components {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return component{}, false
+}
@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	v1 "maistra.io/istio-operator/api/v1alpha1"
+)
+
+func TestValidateOverlays(t *testing.T) {
+	testCases := []struct {
+		name      string
+		overlays  []v1.Overlay
+		values    string
+		external  bool
+		expectErr string
+	}{
+		{
+			name: "valid",
+			overlays: []v1.Overlay{
+				{Component: "istiod", Target: v1.OverlayTarget{Kind: "Deployment"}, Patch: "metadata:\n  labels:\n    foo: bar"},
+				{Component: "cni", Target: v1.OverlayTarget{Kind: "DaemonSet"}, Type: v1.OverlayPatchTypeJSON, Patch: `[{"op": "remove", "path": "/spec/x"}]`},
+			},
+		},
+		{
+			name:      "unknown component",
+			overlays:  []v1.Overlay{{Component: "pilot", Target: v1.OverlayTarget{Kind: "Deployment"}, Patch: "{}"}},
+			expectErr: `spec.overlays[0]: unknown component "pilot"`,
+		},
+		{
+			name:     "enabled gateway",
+			overlays: []v1.Overlay{{Component: "ingress-gateway", Target: v1.OverlayTarget{Kind: "Deployment"}, Patch: "{}"}},
+			values:   `{"gateways": {"istio-ingressgateway": {"enabled": true}}}`,
+		},
+		{
+			name:      "disabled gateway",
+			overlays:  []v1.Overlay{{Component: "ingress-gateway", Target: v1.OverlayTarget{Kind: "Deployment"}, Patch: "{}"}},
+			expectErr: `spec.overlays[0]: component "ingress-gateway" isn't installed`,
+		},
+		{
+			name:      "external control plane",
+			overlays:  []v1.Overlay{{Component: "cni", Target: v1.OverlayTarget{Kind: "DaemonSet"}, Patch: "{}"}},
+			external:  true,
+			expectErr: `spec.overlays[0]: component "cni" isn't installed`,
+		},
+		{
+			name:      "invalid YAML",
+			overlays:  []v1.Overlay{{Component: "istiod", Target: v1.OverlayTarget{Kind: "Deployment"}, Patch: "metadata: [foo"}},
+			expectErr: "spec.overlays[0]: invalid patch",
+		},
+		{
+			name:      "JSON patch that isn't a list",
+			overlays:  []v1.Overlay{{Component: "istiod", Target: v1.OverlayTarget{Kind: "Deployment"}, Type: v1.OverlayPatchTypeJSON, Patch: "{}"}},
+			expectErr: "spec.overlays[0]: invalid JSON patch",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			istio := &v1.Istio{Spec: v1.IstioSpec{Overlays: tc.overlays, Values: []byte(tc.values)}}
+			if tc.external {
+				istio.Spec.ConfigCluster = &v1.RemoteCluster{Name: "config"}
+			}
+			err := validateOverlays(istio)
+			if tc.expectErr == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if tc.expectErr != "" && (err == nil || !strings.Contains(err.Error(), tc.expectErr)) {
+				t.Errorf("expected error containing %q, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestOverlaysFor(t *testing.T) {
+	istio := &v1.Istio{Spec: v1.IstioSpec{Overlays: []v1.Overlay{
+		{Component: "istiod", Patch: "a"},
+		{Component: "cni", Patch: "b"},
+		{Component: "istiod", Patch: "c"},
+	}}}
+	overlays := overlaysFor(istio, "istiod")
+	if len(overlays) != 2 || overlays[0].Patch != "a" || overlays[1].Patch != "c" {
+		t.Errorf("unexpected overlays: %v", overlays)
+	}
+	if overlays := overlaysFor(istio, "base"); overlays != nil {
+		t.Errorf("expected no overlays, got %v", overlays)
+	}
+}
@@ -0,0 +1,270 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"maistra.io/istio-operator/api/v1alpha1"
+	"maistra.io/istio-operator/pkg/common"
+	"maistra.io/istio-operator/pkg/helm"
+	"maistra.io/istio-operator/pkg/kube"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// pullSecretLabel marks the copies of the image pull Secret
+const pullSecretLabel = "operator.istio.io/pull-secret"
+
+// registryMirrors returns the mirrors of the Istio resource followed by the mirrors
+// in the operator configuration
+func registryMirrors(istio *v1alpha1.Istio) ([]v1alpha1.RegistryMirror, error) {
+	var mirrors []v1alpha1.RegistryMirror
+	if istio.Spec.Registry != nil {
+		mirrors = append(mirrors, istio.Spec.Registry.Mirrors...)
+	}
+	for _, mirror := range common.Config.Registry.Mirrors {
+		source, target, found := strings.Cut(mirror, "=")
+		if !found || source == "" || target == "" {
+			return nil, fmt.Errorf("invalid registry mirror %q in operator configuration, expected <source>=<mirror>", mirror)
+		}
+		mirrors = append(mirrors, v1alpha1.RegistryMirror{Source: source, Mirror: target})
+	}
+	return mirrors, nil
+}
+
+// mirrorImage rewrites the image or hub to the first mirror whose source it starts
+// with. Images without a registry are on Docker Hub. Images that already refer
+// to a mirror are returned unchanged.
+func mirrorImage(image string, mirrors []v1alpha1.RegistryMirror) string {
+	for _, mirror := range mirrors {
+		if hasImagePrefix(image, mirror.Mirror) {
+			return image
+		}
+	}
+	qualified := image
+	if first, _, found := strings.Cut(image, "/"); !found || !(strings.ContainsAny(first, ".:") || first == "localhost") {
+		qualified = "docker.io/" + image
+	}
+	for _, mirror := range mirrors {
+		source := strings.TrimSuffix(mirror.Source, "/")
+		if hasImagePrefix(qualified, source) {
+			return strings.TrimSuffix(mirror.Mirror, "/") + strings.TrimPrefix(qualified, source)
+		}
+	}
+	return image
+}
+
+// hasImagePrefix returns whether the image starts with the registry or repository prefix
+func hasImagePrefix(image, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if !strings.HasPrefix(image, prefix) {
+		return false
+	}
+	rest := image[len(prefix):]
+	return rest == "" || strings.ContainsAny(rest[:1], "/:@")
+}
+
+// applyRegistry rewrites the hubs and images in the values to their mirrors and
+// adds the image pull Secret to global.imagePullSecrets
+func applyRegistry(istio *v1alpha1.Istio) error {
+	mirrors, err := registryMirrors(istio)
+	if err != nil {
+		return err
+	}
+	pullSecret, err := pullSecretReference(istio)
+	if err != nil {
+		return err
+	}
+	if len(mirrors) == 0 && pullSecret == nil {
+		return nil
+	}
+
+	values := istio.Spec.GetValues()
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	if len(mirrors) > 0 {
+		mirrorValues(values, mirrors)
+	}
+	if pullSecret != nil {
+		secrets, _, err := unstructured.NestedStringSlice(values, "global", "imagePullSecrets")
+		if err != nil {
+			return fmt.Errorf("invalid global.imagePullSecrets: %v", err)
+		}
+		if !sets.New(secrets...).Has(pullSecret.Name) {
+			if err := unstructured.SetNestedStringSlice(values, append(secrets, pullSecret.Name), "global", "imagePullSecrets"); err != nil {
+				return err
+			}
+		}
+	}
+	return istio.Spec.SetValues(values)
+}
+
+// mirrorValues rewrites the values of all hub keys and of all image keys that
+// contain a repository (as opposed to image names that are combined with a hub)
+func mirrorValues(obj interface{}, mirrors []v1alpha1.RegistryMirror) {
+	switch obj := obj.(type) {
+	case map[string]interface{}:
+		for key, value := range obj {
+			if s, ok := value.(string); ok {
+				if key == "hub" || (key == "image" && strings.Contains(s, "/")) {
+					obj[key] = mirrorImage(s, mirrors)
+				}
+				continue
+			}
+			mirrorValues(value, mirrors)
+		}
+	case []interface{}:
+		for _, value := range obj {
+			mirrorValues(value, mirrors)
+		}
+	}
+}
+
+// pullSecretReference returns the image pull Secret of the Istio resource or,
+// if it doesn't have one, the pull Secret in the operator configuration. The pull
+// Secret of the Istio resource must be in its namespace, so that the operator's
+// access to Secrets can't be used to copy Secrets from other namespaces.
+func pullSecretReference(istio *v1alpha1.Istio) (*v1alpha1.PullSecretReference, error) {
+	if istio.Spec.Registry != nil && istio.Spec.Registry.PullSecret != nil {
+		ref := *istio.Spec.Registry.PullSecret
+		if ref.Namespace == "" {
+			ref.Namespace = istio.Namespace
+		} else if ref.Namespace != istio.Namespace {
+			return nil, fmt.Errorf("image pull secret %s/%s must be in the namespace of the Istio resource (%s)",
+				ref.Namespace, ref.Name, istio.Namespace)
+		}
+		return &ref, nil
+	}
+	if configured := common.Config.Registry.PullSecret; configured != "" {
+		namespace, name, found := strings.Cut(configured, "/")
+		if !found {
+			namespace, name = kube.GetOperatorNamespace(), configured
+		}
+		if namespace == "" || name == "" {
+			return nil, fmt.Errorf("invalid pull secret %q in operator configuration, expected [<namespace>/]<name>", configured)
+		}
+		return &v1alpha1.PullSecretReference{Namespace: namespace, Name: name}, nil
+	}
+	return nil, nil
+}
+
+// reconcilePullSecret copies the image pull Secret to the istio and operator
+// namespaces and deletes the copies that are no longer needed. The copy in the
+// istio namespace is owned by the Istio resource; the copy in the operator
+// namespace carries its owner annotations.
+func (r *IstioReconciler) reconcilePullSecret(ctx context.Context, istio *v1alpha1.Istio) error {
+	ref, err := pullSecretReference(istio)
+	if err != nil {
+		return err
+	}
+
+	desired := sets.New[client.ObjectKey]()
+	if ref != nil {
+		source := &corev1.Secret{}
+		if err := r.Client.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, source); err != nil {
+			return fmt.Errorf("failed to get image pull secret %s/%s: %v", ref.Namespace, ref.Name, err)
+		}
+		if source.Type != corev1.SecretTypeDockerConfigJson && source.Type != corev1.SecretTypeDockercfg {
+			return fmt.Errorf("secret %s/%s is not an image pull secret: expected type %s or %s, got %q",
+				ref.Namespace, ref.Name, corev1.SecretTypeDockerConfigJson, corev1.SecretTypeDockercfg, source.Type)
+		}
+		for _, namespace := range sets.List(sets.New(istio.Namespace, kube.GetOperatorNamespace())) {
+			if namespace == ref.Namespace {
+				continue
+			}
+			copied := &corev1.Secret{}
+			copied.Namespace, copied.Name = namespace, ref.Name
+			desired.Insert(client.ObjectKeyFromObject(copied))
+			if err := r.copyPullSecret(ctx, istio, source, copied); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, namespace := range sets.List(sets.New(istio.Namespace, kube.GetOperatorNamespace())) {
+		copies := &corev1.SecretList{}
+		if err := r.Client.List(ctx, copies, client.InNamespace(namespace), client.HasLabels{pullSecretLabel}); err != nil {
+			return fmt.Errorf("failed to list image pull secrets: %v", err)
+		}
+		for i := range copies.Items {
+			secret := &copies.Items[i]
+			if desired.Has(client.ObjectKeyFromObject(secret)) || !isOwnedPullSecret(secret, istio) {
+				continue
+			}
+			if err := r.Client.Delete(ctx, secret); err != nil && !errors.IsNotFound(err) {
+				return fmt.Errorf("failed to delete image pull secret %s/%s: %v", secret.Namespace, secret.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// copyPullSecret creates or updates the copy of the image pull Secret. Secrets
+// that aren't copies of a pull Secret are never overwritten.
+func (r *IstioReconciler) copyPullSecret(ctx context.Context, istio *v1alpha1.Istio, source, copied *corev1.Secret) error {
+	existing := &corev1.Secret{}
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(copied), existing); err == nil {
+		if _, found := existing.Labels[pullSecretLabel]; !found {
+			return newConflictError("Secret %s/%s already exists and is not a copy of an image pull secret", copied.Namespace, copied.Name)
+		}
+	} else if !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to get image pull secret %s/%s: %v", copied.Namespace, copied.Name, err)
+	}
+
+	mutate := func() {
+		if copied.Labels == nil {
+			copied.Labels = map[string]string{}
+		}
+		copied.Labels[pullSecretLabel] = "true"
+		copied.Type = source.Type
+		copied.Data = source.Data
+	}
+	if copied.Namespace == istio.Namespace {
+		return r.createOrUpdateOwnedObject(ctx, istio, copied, mutate)
+	}
+
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, copied, func() error {
+		if copied.ResourceVersion != "" && !isOwnedByAnnotations(copied, istio) {
+			return newConflictError("Secret %s/%s is not managed by %s", copied.Namespace, copied.Name, istio.Name)
+		}
+		helm.SetOwnerAnnotations(copied, newOwnerReference(istio), istio.Namespace)
+		mutate()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy image pull secret to %s: %w", copied.Namespace, err)
+	}
+	return nil
+}
+
+// isOwnedPullSecret returns whether the copy of the image pull Secret belongs to the Istio resource
+func isOwnedPullSecret(secret *corev1.Secret, istio *v1alpha1.Istio) bool {
+	for _, owner := range secret.OwnerReferences {
+		if owner.UID == istio.UID {
+			return true
+		}
+	}
+	return isOwnedByAnnotations(secret, istio)
+}
@@ -0,0 +1,199 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	v1 "maistra.io/istio-operator/api/v1alpha1"
+	"maistra.io/istio-operator/pkg/common"
+	"maistra.io/istio-operator/pkg/kube"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestMirrorImage(t *testing.T) {
+	mirrors := []v1.RegistryMirror{
+		{Source: "quay.io/maistra-dev", Mirror: "registry.example.com/maistra"},
+		{Source: "quay.io", Mirror: "registry.example.com/quay"},
+		{Source: "docker.io/", Mirror: "registry.example.com/dockerhub/"},
+	}
+	testCases := map[string]string{
+		"quay.io/maistra-dev/pilot:3.0":             "registry.example.com/maistra/pilot:3.0",
+		"quay.io/maistra-dev":                       "registry.example.com/maistra",
+		"quay.io/maistra-devel/pilot:3.0":           "registry.example.com/quay/maistra-devel/pilot:3.0",
+		"quay.io/other/pilot@sha256:abc":            "registry.example.com/quay/other/pilot@sha256:abc",
+		"istio/proxyv2:1.20.0":                      "registry.example.com/dockerhub/istio/proxyv2:1.20.0",
+		"busybox":                                   "registry.example.com/dockerhub/busybox",
+		"gcr.io/istio-release/pilot:1.20.0":         "gcr.io/istio-release/pilot:1.20.0",
+		"registry.example.com/maistra/pilot:3.0":    "registry.example.com/maistra/pilot:3.0",
+		"registry.example.com/quay/foo/pilot:3.0":   "registry.example.com/quay/foo/pilot:3.0",
+		"quay.io.example.com/maistra-dev/pilot:3.0": "quay.io.example.com/maistra-dev/pilot:3.0",
+	}
+	for image, expected := range testCases {
+		if actual := mirrorImage(image, mirrors); actual != expected {
+			t.Errorf("mirrorImage(%s): expected %s, got %s", image, expected, actual)
+		}
+	}
+}
+
+func TestApplyRegistry(t *testing.T) {
+	originalConfig := common.Config
+	t.Cleanup(func() { common.Config = originalConfig })
+	common.Config = common.OperatorConfig{Registry: common.RegistryConfig{
+		Mirrors:    []string{"quay.io=registry.example.com/quay"},
+		PullSecret: "secrets/registry-credentials",
+	}}
+
+	istio := &v1.Istio{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "istio-system"},
+		Spec: v1.IstioSpec{
+			Values: []byte(`{"global": {"hub": "quay.io/maistra-dev", "imagePullSecrets": ["other"]},` +
+				`"pilot": {"image": "pilot"}, "cni": {"image": "quay.io/maistra-dev/install-cni:3.0"}}`),
+			Registry: &v1.RegistryConfig{
+				Mirrors: []v1.RegistryMirror{{Source: "quay.io/maistra-dev", Mirror: "registry.example.com/maistra"}},
+			},
+		},
+	}
+	Must(t, applyRegistry(istio))
+	values := istio.Spec.GetValues()
+	expected := map[string][]string{
+		"registry.example.com/maistra": {"global", "hub"},
+		"pilot":                        {"pilot", "image"},
+		"registry.example.com/maistra/install-cni:3.0": {"cni", "image"},
+	}
+	for value, path := range expected {
+		if actual, _, _ := unstructured.NestedString(values, path...); actual != value {
+			t.Errorf("expected %v to be %s, got %s", path, value, actual)
+		}
+	}
+	if secrets, _, _ := unstructured.NestedStringSlice(values, "global", "imagePullSecrets"); !reflect.DeepEqual(secrets, []string{"other", "registry-credentials"}) {
+		t.Errorf("expected pull secret to be added to global.imagePullSecrets, got %v", secrets)
+	}
+
+	// applying the registry again doesn't change the values
+	Must(t, applyRegistry(istio))
+	if !reflect.DeepEqual(istio.Spec.GetValues(), values) {
+		t.Errorf("expected values to be unchanged, got %v", istio.Spec.GetValues())
+	}
+
+	common.Config.Registry.Mirrors = []string{"quay.io"}
+	if err := applyRegistry(istio); err == nil {
+		t.Error("expected error for invalid mirror")
+	}
+}
+
+func TestReconcilePullSecret(t *testing.T) {
+	ctx := context.Background()
+	originalConfig := common.Config
+	t.Cleanup(func() { common.Config = originalConfig })
+	common.Config = common.OperatorConfig{}
+
+	operatorNamespace := kube.GetOperatorNamespace()
+	source := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "pull-secret", Namespace: "secrets"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths": {}}`)},
+	}
+	// the operator configuration may refer to a Secret in any namespace
+	common.Config.Registry.PullSecret = "secrets/pull-secret"
+	istio := &v1.Istio{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "istio-system", UID: "istio-uid"},
+	}
+	getCopy := func(cl client.Client, namespace string) (*corev1.Secret, error) {
+		secret := &corev1.Secret{}
+		return secret, cl.Get(ctx, client.ObjectKey{Namespace: namespace, Name: "pull-secret"}, secret)
+	}
+
+	cl := fake.NewClientBuilder().WithObjects(source).Build()
+	r := &IstioReconciler{Client: cl}
+	Must(t, r.reconcilePullSecret(ctx, istio))
+
+	copied, err := getCopy(cl, istio.Namespace)
+	Must(t, err)
+	if copied.Type != source.Type || !reflect.DeepEqual(copied.Data, source.Data) {
+		t.Errorf("expected copy of the pull secret, got %+v", copied)
+	}
+	if owner := metav1.GetControllerOf(copied); owner == nil || owner.UID != istio.UID {
+		t.Errorf("expected copy in istio namespace to be owned by the Istio resource, got %v", copied.OwnerReferences)
+	}
+	operatorCopy, err := getCopy(cl, operatorNamespace)
+	Must(t, err)
+	if !isOwnedByAnnotations(operatorCopy, istio) {
+		t.Errorf("expected owner annotations on copy in operator namespace, got %v", operatorCopy.Annotations)
+	}
+
+	// removing the pull secret deletes the copies
+	common.Config.Registry.PullSecret = ""
+	Must(t, r.reconcilePullSecret(ctx, istio))
+	for _, namespace := range []string{istio.Namespace, operatorNamespace} {
+		if _, err := getCopy(cl, namespace); !errors.IsNotFound(err) {
+			t.Errorf("expected copy in namespace %s to be deleted, got %v", namespace, err)
+		}
+	}
+
+	t.Run("existing secret", func(t *testing.T) {
+		existing := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "pull-secret", Namespace: "istio-system"}}
+		cl := fake.NewClientBuilder().WithObjects(source, existing).Build()
+		r := &IstioReconciler{Client: cl}
+		common.Config.Registry.PullSecret = "secrets/pull-secret"
+		if err := r.reconcilePullSecret(ctx, istio); !isConflict(err) {
+			t.Errorf("expected conflict for existing secret, got %v", err)
+		}
+	})
+
+	t.Run("secret in istio namespace", func(t *testing.T) {
+		common.Config.Registry.PullSecret = ""
+		inIstioNamespace := source.DeepCopy()
+		inIstioNamespace.Namespace = istio.Namespace
+		cl := fake.NewClientBuilder().WithObjects(inIstioNamespace).Build()
+		r := &IstioReconciler{Client: cl}
+		istio := istio.DeepCopy()
+		istio.Spec.Registry = &v1.RegistryConfig{PullSecret: &v1.PullSecretReference{Name: "pull-secret"}}
+		Must(t, r.reconcilePullSecret(ctx, istio))
+		if _, err := getCopy(cl, operatorNamespace); err != nil {
+			t.Errorf("expected copy in operator namespace, got %v", err)
+		}
+	})
+
+	t.Run("secret in other namespace", func(t *testing.T) {
+		common.Config.Registry.PullSecret = ""
+		cl := fake.NewClientBuilder().WithObjects(source).Build()
+		r := &IstioReconciler{Client: cl}
+		istio := istio.DeepCopy()
+		istio.Spec.Registry = &v1.RegistryConfig{PullSecret: &v1.PullSecretReference{Name: "pull-secret", Namespace: "secrets"}}
+		if err := r.reconcilePullSecret(ctx, istio); err == nil {
+			t.Error("expected error for pull secret outside the istio namespace")
+		}
+		for _, namespace := range []string{istio.Namespace, operatorNamespace} {
+			if _, err := getCopy(cl, namespace); !errors.IsNotFound(err) {
+				t.Errorf("expected no copy in namespace %s, got %v", namespace, err)
+			}
+		}
+	})
+
+	t.Run("secret of other type", func(t *testing.T) {
+		common.Config.Registry.PullSecret = "secrets/pull-secret"
+		opaque := source.DeepCopy()
+		opaque.Type = corev1.SecretTypeOpaque
+		cl := fake.NewClientBuilder().WithObjects(opaque).Build()
+		r := &IstioReconciler{Client: cl}
+		if err := r.reconcilePullSecret(ctx, istio); err == nil {
+			t.Error("expected error for secret that isn't an image pull secret")
+		}
+		if _, err := getCopy(cl, istio.Namespace); !errors.IsNotFound(err) {
+			t.Errorf("expected no copy, got %v", err)
+		}
+	})
+
+	t.Run("missing secret", func(t *testing.T) {
+		common.Config.Registry.PullSecret = "secrets/missing"
+		if err := r.reconcilePullSecret(ctx, istio); err == nil {
+			t.Error("expected error for missing pull secret")
+		}
+	})
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"helm.sh/helm/v3/pkg/release"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"maistra.io/istio-operator/api/v1alpha1"
+	"maistra.io/istio-operator/pkg/helm"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ReleaseMigration moves the helm releases of the components of the Istio resources
+// that are stored by a previously configured storage driver to the current one. It's
+// a leader-elected runnable, so only one replica of the operator migrates the
+// releases, and the reconciler waits for it to complete.
+type ReleaseMigration struct {
+	Client           client.Client
+	RestClientGetter genericclioptions.RESTClientGetter
+	Driver           string
+
+	done chan struct{}
+}
+
+func NewReleaseMigration(client client.Client, restClientGetter genericclioptions.RESTClientGetter, driver string) *ReleaseMigration {
+	return &ReleaseMigration{
+		Client:           client,
+		RestClientGetter: restClientGetter,
+		Driver:           driver,
+		done:             make(chan struct{}),
+	}
+}
+
+// Start migrates the releases
+func (m *ReleaseMigration) Start(ctx context.Context) error {
+	istios := &v1alpha1.IstioList{}
+	if err := m.Client.List(ctx, istios); err != nil {
+		return fmt.Errorf("failed to list Istio resources: %v", err)
+	}
+	err := helm.MigrateReleases(m.RestClientGetter, m.Driver, func(rel *release.Release) bool {
+		return isOperatorRelease(istios.Items, rel)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to migrate helm releases: %v", err)
+	}
+	close(m.done)
+	return nil
+}
+
+// Wait blocks until the releases have been migrated or the context is done
+func (m *ReleaseMigration) Wait(ctx context.Context) error {
+	select {
+	case <-m.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isOperatorRelease returns whether the helm release was installed by the operator for
+// one of the Istio resources, i.e. whether it has the release name and chart of one of
+// their components and is in the component's namespace
+func isOperatorRelease(istios []v1alpha1.Istio, rel *release.Release) bool {
+	if rel.Chart == nil || rel.Chart.Metadata == nil {
+		return false
+	}
+	for i := range istios {
+		istio := &istios[i]
+		for _, c := range components {
+			if rel.Name == c.releaseName(istio) && rel.Namespace == c.namespace(istio) && rel.Chart.Metadata.Name == c.chartName {
+				return true
+			}
+		}
+	}
+	return false
+}
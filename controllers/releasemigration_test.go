@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"path"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/release"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1 "maistra.io/istio-operator/api/v1alpha1"
+	"maistra.io/istio-operator/pkg/common"
+	"maistra.io/istio-operator/pkg/versions"
+)
+
+func TestIsOperatorRelease(t *testing.T) {
+	istios := []v1.Istio{{ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "istio-system"}}}
+	newRelease := func(name, namespace, chartName string) *release.Release {
+		return &release.Release{Name: name, Namespace: namespace, Chart: &chart.Chart{Metadata: &chart.Metadata{Name: chartName}}}
+	}
+
+	testCases := []struct {
+		name    string
+		release *release.Release
+		expect  bool
+	}{
+		{name: "component release", release: newRelease("default-istiod", "istio-system", "istiod"), expect: true},
+		{name: "gateway release", release: newRelease("default-ingress-gateway", "istio-system", "istio-ingress"), expect: true},
+		{name: "other namespace", release: newRelease("default-istiod", "apps", "istiod")},
+		{name: "other Istio resource", release: newRelease("foo-istiod", "istio-system", "istiod")},
+		{name: "other chart", release: newRelease("default-istiod", "istio-system", "my-app")},
+		{name: "no chart", release: &release.Release{Name: "default-istiod", Namespace: "istio-system"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := isOperatorRelease(istios, tc.release); actual != tc.expect {
+				t.Errorf("expected %v, got %v", tc.expect, actual)
+			}
+		})
+	}
+}
+
+func TestComponentChartNames(t *testing.T) {
+	resourceDir := path.Join(common.RepositoryRoot, "resources")
+	supported, err := versions.Discover(resourceDir)
+	Must(t, err)
+	for _, version := range supported {
+		for _, c := range components {
+			metadata, err := chartutil.LoadChartfile(path.Join(resourceDir, version.Name, "charts", c.chart, "Chart.yaml"))
+			Must(t, err)
+			if metadata.Name != c.chartName {
+				t.Errorf("expected chart name %s for component %s in version %s, got %s", c.chartName, c.name, version.Name, metadata.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,507 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"maistra.io/istio-operator/api/v1alpha1"
+	"maistra.io/istio-operator/pkg/helm"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"istio.io/istio/pkg/util/sets"
+)
+
+const (
+	remoteChart   = "istiod-remote"
+	remoteProfile = "remote"
+
+	// remoteClusterPollInterval is how often the operator checks a remote cluster whose installation is pending
+	remoteClusterPollInterval = 10 * time.Second
+
+	// readerServiceAccount is created by the istiod-remote chart. Istiod uses its token
+	// to watch the remote cluster.
+	readerServiceAccount = "istio-reader-service-account"
+
+	// multiClusterSecretLabel marks the secrets that istiod reads the kubeconfigs of remote clusters from
+	multiClusterSecretLabel = "istio/multiCluster"
+	// clusterAnnotation records the name of the remote cluster on its remote secret
+	clusterAnnotation = "networking.istio.io/cluster"
+	// remoteSecretOfLabel records the Istio resource that a remote secret was created for
+	remoteSecretOfLabel = "operator.istio.io/remote-secret-of"
+)
+
+// remoteClusterClient holds the clients for a remote cluster, created from the
+// kubeconfig in a Secret
+type remoteClusterClient struct {
+	resourceVersion  string
+	key              string
+	restClientGetter genericclioptions.RESTClientGetter
+	client           client.Client
+	// host is the address of the cluster's API server
+	host string
+}
+
+// remoteClusterClients caches the clients of remote clusters until their kubeconfig Secret changes
+type remoteClusterClients struct {
+	mu      sync.Mutex
+	clients map[types.NamespacedName]*remoteClusterClient
+}
+
+func (c *remoteClusterClients) get(secret *corev1.Secret, key string) (*remoteClusterClient, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	secretKey := client.ObjectKeyFromObject(secret)
+	if cached, found := c.clients[secretKey]; found && cached.resourceVersion == secret.ResourceVersion && cached.key == key {
+		return cached, nil
+	}
+
+	kubeconfig, found := secret.Data[key]
+	if !found {
+		return nil, fmt.Errorf("secret %s has no key %s", secretKey, key)
+	}
+	restConfig, err := restConfigFromKubeconfig(kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kubeconfig in secret %s: %v", secretKey, err)
+	}
+	cl, err := client.New(restConfig, client.Options{Scheme: scheme.Scheme})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client for kubeconfig in secret %s: %v", secretKey, err)
+	}
+
+	remote := &remoteClusterClient{
+		resourceVersion:  secret.ResourceVersion,
+		key:              key,
+		restClientGetter: helm.NewRESTClientGetter(restConfig),
+		client:           cl,
+		host:             restConfig.Host,
+	}
+	if c.clients == nil {
+		c.clients = make(map[types.NamespacedName]*remoteClusterClient)
+	}
+	c.clients[secretKey] = remote
+	return remote, nil
+}
+
+// restConfigFromKubeconfig returns the REST config for a kubeconfig provided by the
+// user. Since the operator would run them with its own privileges, exec and auth
+// provider plugins and references to files in the operator's filesystem (e.g. its
+// service account token) aren't allowed.
+func restConfigFromKubeconfig(kubeconfig []byte) (*rest.Config, error) {
+	config, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	for name, authInfo := range config.AuthInfos {
+		switch {
+		case authInfo.Exec != nil:
+			return nil, fmt.Errorf("user %s uses an exec plugin, which isn't supported", name)
+		case authInfo.AuthProvider != nil:
+			return nil, fmt.Errorf("user %s uses an auth provider, which isn't supported", name)
+		case authInfo.TokenFile != "" || authInfo.ClientCertificate != "" || authInfo.ClientKey != "":
+			return nil, fmt.Errorf("user %s references a file, which isn't supported; embed the credentials instead", name)
+		}
+	}
+	for name, cluster := range config.Clusters {
+		if cluster.CertificateAuthority != "" {
+			return nil, fmt.Errorf("cluster %s references a file, which isn't supported; embed the certificate authority instead", name)
+		}
+	}
+	return clientcmd.NewDefaultClientConfig(*config, &clientcmd.ConfigOverrides{}).ClientConfig()
+}
+
+// remoteClusterClient returns the clients for the remote cluster
+func (r *IstioReconciler) remoteClusterClient(ctx context.Context, istio *v1alpha1.Istio, cluster v1alpha1.RemoteCluster) (*remoteClusterClient, error) {
+	secret := &corev1.Secret{}
+	key := client.ObjectKey{Namespace: istio.Namespace, Name: cluster.KubeconfigSecret.Name}
+	if err := r.Client.Get(ctx, key, secret); err != nil {
+		return nil, fmt.Errorf("failed to get kubeconfig secret %s: %w", key, err)
+	}
+	return r.remoteClients.get(secret, cluster.KubeconfigSecret.GetKey())
+}
+
+// reconcileRemoteClusters installs the istiod-remote chart in the remote clusters and
+// creates their remote secrets. It records the state of each cluster in the status
+// and returns the duration after which pending clusters should be checked again.
+func (r *IstioReconciler) reconcileRemoteClusters(ctx context.Context, istio *v1alpha1.Istio, values map[string]interface{}) (time.Duration, error) {
+	previous := make(map[string]v1alpha1.RemoteClusterStatus, len(istio.Status.RemoteClusters))
+	for _, s := range istio.Status.RemoteClusters {
+		previous[s.Name] = s
+	}
+
+	var statuses []v1alpha1.RemoteClusterStatus
+	var errs []error
+	var requeueAfter time.Duration
+	// istiod-remote is uninstalled from the clusters that were removed from the spec;
+	// they stay in the status until that succeeds
+	for _, cluster := range removedRemoteClusters(istio) {
+		if err := r.uninstallRemoteCluster(ctx, istio, cluster); err != nil {
+			status := previous[cluster.Name]
+			status.State = v1alpha1.RemoteClusterStateError
+			status.Message = err.Error()
+			statuses = append(statuses, status)
+			errs = append(errs, err)
+		}
+	}
+	for _, cluster := range istio.Spec.RemoteClusters {
+		status, err := r.reconcileRemoteCluster(ctx, istio, values, cluster, previous[cluster.Name], false)
+		if err != nil {
+			status.State = v1alpha1.RemoteClusterStateError
+			status.Message = err.Error()
+			errs = append(errs, fmt.Errorf("failed to reconcile remote cluster %s: %w", cluster.Name, err))
+		} else if status.State == v1alpha1.RemoteClusterStatePending {
+			requeueAfter = remoteClusterPollInterval
+		}
+		statuses = append(statuses, status)
+	}
+	istio.Status.RemoteClusters = statuses
+
+	if err := r.deleteStaleRemoteSecrets(ctx, istio); err != nil {
+		errs = append(errs, err)
+	}
+	return requeueAfter, utilerrors.NewAggregate(errs)
+}
+
+// reconcileRemoteCluster installs istiod-remote in a remote cluster, or in the config
+// cluster of an external control plane, and creates the kubeconfig secret that istiod
+// uses to access it
+func (r *IstioReconciler) reconcileRemoteCluster(ctx context.Context, istio *v1alpha1.Istio, values map[string]interface{},
+	cluster v1alpha1.RemoteCluster, previous v1alpha1.RemoteClusterStatus, configCluster bool,
+) (v1alpha1.RemoteClusterStatus, error) {
+	status := v1alpha1.RemoteClusterStatus{
+		Name:             cluster.Name,
+		AppliedHash:      previous.AppliedHash,
+		KubeconfigSecret: cluster.KubeconfigSecret.DeepCopy(),
+	}
+	remote, err := r.remoteClusterClient(ctx, istio, cluster)
+	if err != nil {
+		return status, err
+	}
+
+	remoteValues, err := remoteClusterValues(r.ResourceDirectory, istio, values, cluster, configCluster)
+	if err != nil {
+		return status, err
+	}
+	hash, err := computeRemoteHash(ctx, istio, remoteValues)
+	if err != nil {
+		return status, err
+	}
+
+	releaseName := remoteReleaseName(istio, cluster)
+	drifted := true
+	if hash == previous.AppliedHash {
+		if drifted, err = helm.HasDrifted(remote.restClientGetter, istio.Namespace, releaseName); err != nil {
+			return status, err
+		}
+	}
+	if drifted {
+		log.FromContext(ctx).Info("Installing istiod-remote", "cluster", cluster.Name)
+		if err := ensureNamespace(ctx, remote.client, istio.Namespace); err != nil {
+			return status, err
+		}
+		if err := helm.UpgradeOrInstallRemoteChart(ctx, remote.restClientGetter, remoteChart, remoteValues,
+			istio.Spec.Version, releaseName, istio.Namespace, newOwnerReference(istio), istio.Namespace); err != nil {
+			status.AppliedHash = ""
+			return status, err
+		}
+		status.AppliedHash = hash
+	}
+
+	var pending string
+	if configCluster {
+		pending, err = r.reconcileIstioKubeconfig(ctx, istio, cluster.Name, remote.client, remote.host)
+	} else {
+		pending, err = r.reconcileRemoteSecret(ctx, istio, cluster.Name, remote.client, remote.host)
+	}
+	if err != nil {
+		return status, err
+	}
+	if pending != "" {
+		status.State = v1alpha1.RemoteClusterStatePending
+		status.Message = pending
+		return status, nil
+	}
+	status.State = v1alpha1.RemoteClusterStateInstalled
+	return status, nil
+}
+
+// reconcileRemoteSecret creates the remote secret, which contains the kubeconfig
+// that istiod uses to discover the endpoints in the remote cluster. The kubeconfig
+// uses the token of the reader service account in the remote cluster, so istiod
+// only gets the permissions it needs. It returns a message describing what it's
+// waiting for, or an empty string when the remote secret is up to date.
+func (r *IstioReconciler) reconcileRemoteSecret(ctx context.Context, istio *v1alpha1.Istio, clusterName string,
+	remote client.Client, host string,
+) (string, error) {
+	kubeconfig, pending, err := serviceAccountKubeconfig(ctx, remote, istio.Namespace, readerServiceAccount, clusterName, host)
+	if err != nil || pending != "" {
+		return pending, err
+	}
+
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: remoteSecretName(clusterName), Namespace: istio.Namespace}}
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		if owner, found := secret.Labels[remoteSecretOfLabel]; found && owner != istio.Name {
+			return newConflictError("remote secret %s is already managed by Istio %s", secret.Name, owner)
+		}
+		if secret.Labels == nil {
+			secret.Labels = map[string]string{}
+		}
+		secret.Labels[multiClusterSecretLabel] = "true"
+		secret.Labels[remoteSecretOfLabel] = istio.Name
+		if secret.Annotations == nil {
+			secret.Annotations = map[string]string{}
+		}
+		secret.Annotations[clusterAnnotation] = clusterName
+		secret.OwnerReferences = []metav1.OwnerReference{newOwnerReference(istio)}
+		secret.Data = map[string][]byte{clusterName: kubeconfig}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to reconcile remote secret for cluster %s: %w", clusterName, err)
+	}
+	return "", nil
+}
+
+// serviceAccountKubeconfig returns a kubeconfig for the remote cluster that
+// authenticates with the token of the given service account. If the token hasn't
+// been issued yet, it returns a message describing what it's waiting for.
+func serviceAccountKubeconfig(ctx context.Context, remote client.Client, namespace, serviceAccount, clusterName, host string) ([]byte, string, error) {
+	token := &corev1.Secret{}
+	tokenKey := client.ObjectKey{Namespace: namespace, Name: tokenSecretName(serviceAccount)}
+	if err := remote.Get(ctx, tokenKey, token); errors.IsNotFound(err) {
+		token = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        tokenKey.Name,
+				Namespace:   namespace,
+				Annotations: map[string]string{corev1.ServiceAccountNameKey: serviceAccount},
+			},
+			Type: corev1.SecretTypeServiceAccountToken,
+		}
+		if err := remote.Create(ctx, token); err != nil {
+			return nil, "", fmt.Errorf("failed to create token secret for %s: %v", serviceAccount, err)
+		}
+	} else if err != nil {
+		return nil, "", fmt.Errorf("failed to get token secret for %s: %v", serviceAccount, err)
+	}
+	if len(token.Data[corev1.ServiceAccountTokenKey]) == 0 {
+		return nil, fmt.Sprintf("waiting for the token of %s to be issued", serviceAccount), nil
+	}
+
+	kubeconfig, err := buildRemoteKubeconfig(clusterName, host, token.Data[corev1.ServiceAccountRootCAKey], token.Data[corev1.ServiceAccountTokenKey])
+	return kubeconfig, "", err
+}
+
+// deleteStaleRemoteSecrets deletes the remote secrets of clusters that were removed from the spec
+func (r *IstioReconciler) deleteStaleRemoteSecrets(ctx context.Context, istio *v1alpha1.Istio) error {
+	clusters := sets.New[string]()
+	for _, cluster := range istio.Spec.RemoteClusters {
+		clusters.Insert(cluster.Name)
+	}
+
+	secrets := &corev1.SecretList{}
+	if err := r.Client.List(ctx, secrets, client.InNamespace(istio.Namespace), client.MatchingLabels{remoteSecretOfLabel: istio.Name}); err != nil {
+		return fmt.Errorf("failed to list remote secrets: %v", err)
+	}
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		// the kubeconfig of the config cluster was labeled as a remote secret by older versions
+		if secret.Name == istioKubeconfigSecret || clusters.Contains(secret.Annotations[clusterAnnotation]) {
+			continue
+		}
+		log.FromContext(ctx).Info("Deleting remote secret of removed cluster", "secret", secret.Name)
+		if err := r.Client.Delete(ctx, secret); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete remote secret %s: %v", secret.Name, err)
+		}
+	}
+	return nil
+}
+
+// uninstallRemoteClusters uninstalls istiod-remote from the remote clusters and the
+// config cluster, including the remote clusters that were removed from the spec but
+// not uninstalled yet
+func (r *IstioReconciler) uninstallRemoteClusters(ctx context.Context, istio *v1alpha1.Istio) error {
+	clusters := append([]v1alpha1.RemoteCluster{}, istio.Spec.RemoteClusters...)
+	if istio.Spec.ConfigCluster != nil {
+		clusters = append([]v1alpha1.RemoteCluster{*istio.Spec.ConfigCluster}, clusters...)
+	}
+	clusters = append(clusters, removedRemoteClusters(istio)...)
+	for _, cluster := range clusters {
+		if err := r.uninstallRemoteCluster(ctx, istio, cluster); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removedRemoteClusters returns the remote clusters in the status that are no longer
+// in the spec. Their kubeconfig Secret is the one they were last reconciled with.
+func removedRemoteClusters(istio *v1alpha1.Istio) []v1alpha1.RemoteCluster {
+	specified := sets.New[string]()
+	for _, cluster := range istio.Spec.RemoteClusters {
+		specified.Insert(cluster.Name)
+	}
+	var removed []v1alpha1.RemoteCluster
+	for _, status := range istio.Status.RemoteClusters {
+		if !specified.Contains(status.Name) && status.KubeconfigSecret != nil {
+			removed = append(removed, v1alpha1.RemoteCluster{Name: status.Name, KubeconfigSecret: *status.KubeconfigSecret})
+		}
+	}
+	return removed
+}
+
+// uninstallRemoteCluster uninstalls istiod-remote from the cluster and deletes the
+// token secrets. It's skipped if the kubeconfig Secret of the cluster no longer exists.
+func (r *IstioReconciler) uninstallRemoteCluster(ctx context.Context, istio *v1alpha1.Istio, cluster v1alpha1.RemoteCluster) error {
+	remote, err := r.remoteClusterClient(ctx, istio, cluster)
+	if errors.IsNotFound(err) {
+		log.FromContext(ctx).Info("Kubeconfig secret not found; skipping uninstallation in remote cluster", "cluster", cluster.Name)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	log.FromContext(ctx).Info("Uninstalling istiod-remote", "cluster", cluster.Name)
+	if err := helm.UninstallChart(remote.restClientGetter, remoteReleaseName(istio, cluster), istio.Namespace); err != nil {
+		return fmt.Errorf("failed to uninstall istiod-remote from cluster %s: %w", cluster.Name, err)
+	}
+	for _, serviceAccount := range []string{readerServiceAccount, istiodServiceAccount(istio)} {
+		token := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: tokenSecretName(serviceAccount), Namespace: istio.Namespace}}
+		if err := remote.client.Delete(ctx, token); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete token secret in cluster %s: %v", cluster.Name, err)
+		}
+	}
+	return nil
+}
+
+// remoteClusterValues returns the values for the istiod-remote chart in the cluster.
+// They are the values of the control plane with the remote profile applied on top.
+// The config cluster of an external control plane additionally gets the CRDs,
+// validation webhook and mesh config.
+func remoteClusterValues(resourceDir string, istio *v1alpha1.Istio, values map[string]interface{},
+	cluster v1alpha1.RemoteCluster, configCluster bool,
+) (map[string]interface{}, error) {
+	profileValues, err := loadProfileValues(resourceDir, istio.Spec.Version, remoteProfile)
+	if err != nil {
+		return nil, err
+	}
+	remoteValues, err := copyValues(profileValues)
+	if err != nil {
+		return nil, err
+	}
+	controlPlaneValues, err := copyValues(values)
+	if err != nil {
+		return nil, err
+	}
+	if configCluster {
+		remoteValues = mergeValues(configClusterValues(), remoteValues)
+	}
+	remoteValues = mergeValues(remoteValues, controlPlaneValues)
+
+	if err := unstructured.SetNestedField(remoteValues, cluster.Name, "global", "multiCluster", "clusterName"); err != nil {
+		return nil, err
+	}
+	if err := unstructured.SetNestedField(remoteValues, cluster.DiscoveryAddress, "global", "remotePilotAddress"); err != nil {
+		return nil, err
+	}
+	return remoteValues, nil
+}
+
+// copyValues returns a deep copy of the values, normalized to the types produced by encoding/json
+func copyValues(values map[string]interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	if result == nil {
+		result = make(map[string]interface{})
+	}
+	return result, nil
+}
+
+// computeRemoteHash returns a hash of everything that determines the resources
+// installed in a remote cluster
+func computeRemoteHash(ctx context.Context, istio *v1alpha1.Istio, values map[string]interface{}) (string, error) {
+	h := sha256.New()
+	valuesJSON, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	h.Write(valuesJSON)
+	digest, err := helm.ChartDigest(ctx, istio.Spec.Version, remoteChart)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(h, "\x00%s\x00%s", istio.Spec.Version, digest)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// buildRemoteKubeconfig returns a kubeconfig that authenticates with the given token
+func buildRemoteKubeconfig(clusterName, host string, caData, token []byte) ([]byte, error) {
+	config := clientcmdapi.NewConfig()
+	config.Clusters[clusterName] = &clientcmdapi.Cluster{
+		Server:                   host,
+		CertificateAuthorityData: caData,
+	}
+	config.AuthInfos[clusterName] = &clientcmdapi.AuthInfo{Token: string(token)}
+	config.Contexts[clusterName] = &clientcmdapi.Context{Cluster: clusterName, AuthInfo: clusterName}
+	config.CurrentContext = clusterName
+	return clientcmd.Write(*config)
+}
+
+func ensureNamespace(ctx context.Context, cl client.Client, name string) error {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	if err := cl.Create(ctx, ns); err != nil && !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create namespace %s: %v", name, err)
+	}
+	return nil
+}
+
+func remoteReleaseName(istio *v1alpha1.Istio, cluster v1alpha1.RemoteCluster) string {
+	return istio.Name + "-remote-" + cluster.Name
+}
+
+func tokenSecretName(serviceAccount string) string {
+	return serviceAccount + "-istio-remote-secret-token"
+}
+
+func remoteSecretName(clusterName string) string {
+	return "istio-remote-secret-" + clusterName
+}
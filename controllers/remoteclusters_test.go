@@ -0,0 +1,245 @@
+package controllers
+
+import (
+	"context"
+	"path"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	v1 "maistra.io/istio-operator/api/v1alpha1"
+	"maistra.io/istio-operator/pkg/common"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRemoteClusterValues(t *testing.T) {
+	istio := &v1.Istio{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "istio-system"},
+		Spec:       v1.IstioSpec{Version: "v3.0"},
+	}
+	values := map[string]interface{}{
+		"global": map[string]interface{}{
+			"meshID":         "mesh1",
+			"externalIstiod": false,
+		},
+		"pilot": map[string]interface{}{"configMap": true},
+	}
+	cluster := v1.RemoteCluster{Name: "cluster2", DiscoveryAddress: "10.0.0.1"}
+
+	actual, err := remoteClusterValues(path.Join(common.RepositoryRoot, "resources"), istio, values, cluster, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		path   []string
+		expect interface{}
+	}{
+		{path: []string{"global", "meshID"}, expect: "mesh1"},
+		{path: []string{"global", "externalIstiod"}, expect: true},
+		{path: []string{"global", "configCluster"}, expect: false},
+		{path: []string{"pilot", "configMap"}, expect: false},
+		{path: []string{"global", "multiCluster", "clusterName"}, expect: "cluster2"},
+		{path: []string{"global", "remotePilotAddress"}, expect: "10.0.0.1"},
+	} {
+		value, _, _ := unstructured.NestedFieldNoCopy(actual, tc.path...)
+		if value != tc.expect {
+			t.Errorf("expected %v to be %v, got %v", tc.path, tc.expect, value)
+		}
+	}
+
+	if externalIstiod, _, _ := unstructured.NestedBool(values, "global", "externalIstiod"); externalIstiod {
+		t.Errorf("expected the control plane values to remain unchanged")
+	}
+}
+
+func TestReconcileRemoteSecret(t *testing.T) {
+	ctx := context.Background()
+	istio := &v1.Istio{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "istio-system", UID: "uid"}}
+
+	primary := fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+	remote := fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+	r := &IstioReconciler{Client: primary}
+
+	pending, err := r.reconcileRemoteSecret(ctx, istio, "cluster2", remote, "https://cluster2:6443")
+	Must(t, err)
+	if pending == "" {
+		t.Fatal("expected to wait for the token")
+	}
+	token := &corev1.Secret{}
+	Must(t, remote.Get(ctx, client.ObjectKey{Namespace: "istio-system", Name: tokenSecretName(readerServiceAccount)}, token))
+	if token.Type != corev1.SecretTypeServiceAccountToken || token.Annotations[corev1.ServiceAccountNameKey] != readerServiceAccount {
+		t.Errorf("unexpected token secret: %+v", token)
+	}
+
+	// simulate the token controller
+	token.Data = map[string][]byte{corev1.ServiceAccountTokenKey: []byte("token"), corev1.ServiceAccountRootCAKey: []byte("ca")}
+	Must(t, remote.Update(ctx, token))
+
+	pending, err = r.reconcileRemoteSecret(ctx, istio, "cluster2", remote, "https://cluster2:6443")
+	Must(t, err)
+	if pending != "" {
+		t.Fatalf("expected the remote secret to be created, got %q", pending)
+	}
+
+	secret := &corev1.Secret{}
+	Must(t, primary.Get(ctx, client.ObjectKey{Namespace: "istio-system", Name: "istio-remote-secret-cluster2"}, secret))
+	if secret.Labels[multiClusterSecretLabel] != "true" || secret.Annotations[clusterAnnotation] != "cluster2" {
+		t.Errorf("unexpected labels/annotations on remote secret: %v %v", secret.Labels, secret.Annotations)
+	}
+	kubeconfig, err := clientcmd.Load(secret.Data["cluster2"])
+	Must(t, err)
+	if kubeconfig.Clusters["cluster2"].Server != "https://cluster2:6443" || kubeconfig.AuthInfos["cluster2"].Token != "token" {
+		t.Errorf("unexpected kubeconfig in remote secret: %+v", kubeconfig)
+	}
+
+	// remove the cluster from the spec
+	Must(t, r.deleteStaleRemoteSecrets(ctx, istio))
+	if err := primary.Get(ctx, client.ObjectKeyFromObject(secret), secret); err == nil {
+		t.Errorf("expected remote secret of removed cluster to be deleted")
+	}
+}
+
+func TestRemoteClusterClients(t *testing.T) {
+	kubeconfig, err := buildRemoteKubeconfig("cluster2", "https://cluster2:6443", nil, []byte("token"))
+	Must(t, err)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "kubeconfig", Namespace: "istio-system", ResourceVersion: "1"},
+		Data:       map[string][]byte{"kubeconfig": kubeconfig},
+	}
+
+	var clients remoteClusterClients
+	first, err := clients.get(secret, "kubeconfig")
+	Must(t, err)
+	if first.host != "https://cluster2:6443" {
+		t.Errorf("unexpected host %s", first.host)
+	}
+	if cached, _ := clients.get(secret, "kubeconfig"); cached != first {
+		t.Errorf("expected cached client to be returned")
+	}
+	secret.ResourceVersion = "2"
+	if updated, _ := clients.get(secret, "kubeconfig"); updated == first {
+		t.Errorf("expected new client after the secret changed")
+	}
+	if _, err := clients.get(secret, "missing"); err == nil {
+		t.Errorf("expected error for missing key")
+	}
+}
+
+func TestRestConfigFromKubeconfig(t *testing.T) {
+	newKubeconfig := func(mutate func(cluster *clientcmdapi.Cluster, authInfo *clientcmdapi.AuthInfo)) []byte {
+		config := clientcmdapi.NewConfig()
+		config.Clusters["cluster2"] = &clientcmdapi.Cluster{Server: "https://cluster2:6443"}
+		config.AuthInfos["cluster2"] = &clientcmdapi.AuthInfo{Token: "token"}
+		config.Contexts["cluster2"] = &clientcmdapi.Context{Cluster: "cluster2", AuthInfo: "cluster2"}
+		config.CurrentContext = "cluster2"
+		mutate(config.Clusters["cluster2"], config.AuthInfos["cluster2"])
+		kubeconfig, err := clientcmd.Write(*config)
+		Must(t, err)
+		return kubeconfig
+	}
+
+	testCases := []struct {
+		name      string
+		mutate    func(cluster *clientcmdapi.Cluster, authInfo *clientcmdapi.AuthInfo)
+		expectErr bool
+	}{
+		{
+			name:   "token",
+			mutate: func(cluster *clientcmdapi.Cluster, authInfo *clientcmdapi.AuthInfo) {},
+		},
+		{
+			name: "exec plugin",
+			mutate: func(cluster *clientcmdapi.Cluster, authInfo *clientcmdapi.AuthInfo) {
+				authInfo.Exec = &clientcmdapi.ExecConfig{Command: "/bin/sh", APIVersion: "client.authentication.k8s.io/v1"}
+			},
+			expectErr: true,
+		},
+		{
+			name: "auth provider",
+			mutate: func(cluster *clientcmdapi.Cluster, authInfo *clientcmdapi.AuthInfo) {
+				authInfo.AuthProvider = &clientcmdapi.AuthProviderConfig{Name: "oidc"}
+			},
+			expectErr: true,
+		},
+		{
+			name: "token file",
+			mutate: func(cluster *clientcmdapi.Cluster, authInfo *clientcmdapi.AuthInfo) {
+				authInfo.TokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+			},
+			expectErr: true,
+		},
+		{
+			name: "certificate authority file",
+			mutate: func(cluster *clientcmdapi.Cluster, authInfo *clientcmdapi.AuthInfo) {
+				cluster.CertificateAuthority = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+			},
+			expectErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config, err := restConfigFromKubeconfig(newKubeconfig(tc.mutate))
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("expected error")
+				}
+				return
+			}
+			Must(t, err)
+			if config.Host != "https://cluster2:6443" || config.BearerToken != "token" {
+				t.Errorf("unexpected config: %+v", config)
+			}
+		})
+	}
+}
+
+func TestReconcileRemovedRemoteClusters(t *testing.T) {
+	ctx := context.Background()
+	execKubeconfig := clientcmdapi.NewConfig()
+	execKubeconfig.Clusters["cluster3"] = &clientcmdapi.Cluster{Server: "https://cluster3:6443"}
+	execKubeconfig.AuthInfos["cluster3"] = &clientcmdapi.AuthInfo{Exec: &clientcmdapi.ExecConfig{Command: "/bin/sh"}}
+	execKubeconfig.Contexts["cluster3"] = &clientcmdapi.Context{Cluster: "cluster3", AuthInfo: "cluster3"}
+	execKubeconfig.CurrentContext = "cluster3"
+	kubeconfig, err := clientcmd.Write(*execKubeconfig)
+	Must(t, err)
+
+	istio := &v1.Istio{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "istio-system", UID: "uid"},
+		Status: v1.IstioStatus{
+			RemoteClusters: []v1.RemoteClusterStatus{
+				{Name: "cluster2", State: v1.RemoteClusterStateInstalled, KubeconfigSecret: &v1.KubeconfigSecretReference{Name: "cluster2"}},
+				{Name: "cluster3", State: v1.RemoteClusterStateInstalled, KubeconfigSecret: &v1.KubeconfigSecretReference{Name: "cluster3"}},
+			},
+		},
+	}
+	cl := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster3", Namespace: "istio-system"},
+		Data:       map[string][]byte{"kubeconfig": kubeconfig},
+	}).Build()
+	r := &IstioReconciler{Client: cl}
+
+	// the kubeconfig of cluster2 no longer exists, so there's nothing left to uninstall;
+	// the uninstallation in cluster3 fails, so it's kept in the status
+	_, err = r.reconcileRemoteClusters(ctx, istio, nil)
+	if err == nil {
+		t.Fatal("expected error for cluster3")
+	}
+	if len(istio.Status.RemoteClusters) != 1 || istio.Status.RemoteClusters[0].Name != "cluster3" ||
+		istio.Status.RemoteClusters[0].State != v1.RemoteClusterStateError {
+		t.Errorf("expected only cluster3 in the status with state Error, got %+v", istio.Status.RemoteClusters)
+	}
+
+	// once the kubeconfig is deleted, the cluster is removed from the status
+	Must(t, cl.Delete(ctx, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "cluster3", Namespace: "istio-system"}}))
+	_, err = r.reconcileRemoteClusters(ctx, istio, nil)
+	Must(t, err)
+	if len(istio.Status.RemoteClusters) != 0 {
+		t.Errorf("expected no remote clusters in the status, got %+v", istio.Status.RemoteClusters)
+	}
+}
@@ -0,0 +1,268 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"maistra.io/istio-operator/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"istio.io/istio/pkg/util/sets"
+)
+
+const (
+	// rolloutPollInterval is how often the operator checks the progress of a rollout
+	rolloutPollInterval = 10 * time.Second
+
+	// rolloutFailureTimeout is how long a restarted StatefulSet may take to become
+	// available before it's considered failed. Deployments report this themselves
+	// through their progress deadline.
+	rolloutFailureTimeout = 10 * time.Minute
+
+	// AnnotationRolloutTarget is set on the pod template of restarted workloads. It
+	// records the rollout that restarted the workload.
+	AnnotationRolloutTarget = "operator.istio.io/rollout-target"
+
+	// AnnotationRestartedAt is set on the pod template of restarted workloads
+	AnnotationRestartedAt = "operator.istio.io/restarted-at"
+)
+
+// workload is a Deployment or StatefulSet that can be restarted by a rollout
+type workload struct {
+	object    client.Object
+	template  *corev1.PodTemplateSpec
+	selector  *metav1.LabelSelector
+	available bool
+	// failed is true if the workload didn't become available after its restart
+	failed bool
+}
+
+func (w workload) String() string {
+	return fmt.Sprintf("%T %s/%s", w.object, w.object.GetNamespace(), w.object.GetName())
+}
+
+// reconcileRollout restarts the workloads with outdated proxies in batches, as
+// configured in the RolloutPolicy of the Istio resource, and records the progress in
+// istio.Status.Rollout. It returns the duration after which the rollout should be
+// checked again, or zero if there's nothing to do.
+func (r *IstioReconciler) reconcileRollout(ctx context.Context, istio *v1alpha1.Istio, values map[string]interface{}) (time.Duration, error) {
+	policy := istio.Spec.RolloutPolicy
+	if policy == nil || !policy.Enabled {
+		istio.Status.Rollout = nil
+		return 0, nil
+	}
+
+	status := istio.Status.Rollout
+	if status == nil || status.Target != istio.Status.AppliedHash {
+		status = &v1alpha1.RolloutStatus{Target: istio.Status.AppliedHash}
+	}
+	istio.Status.Rollout = status
+
+	if status.Target == "" {
+		status.State = v1alpha1.RolloutStateWaiting
+		status.Message = "waiting for the control plane to be installed"
+		return rolloutPollInterval, nil
+	}
+	if ready := r.determineReadyCondition(ctx, istio); ready.Status != metav1.ConditionTrue {
+		status.State = v1alpha1.RolloutStateWaiting
+		status.Message = "waiting for the control plane to become ready: " + ready.Message
+		return rolloutPollInterval, nil
+	}
+
+	workloads, outdatedPods, err := r.listRolloutWorkloads(ctx, istio, values)
+	if err != nil {
+		return 0, err
+	}
+
+	var pending, inFlight, failed []workload
+	status.Restarted = 0
+	for _, w := range workloads {
+		if w.template.Annotations[AnnotationRolloutTarget] == status.Target {
+			status.Restarted++
+			if w.failed {
+				failed = append(failed, w)
+			} else if !w.available {
+				inFlight = append(inFlight, w)
+			}
+		} else if hasOutdatedPods(w, outdatedPods) {
+			pending = append(pending, w)
+		}
+	}
+	status.Pending = int32(len(pending))
+
+	if len(failed) > 0 && policy.ShouldPauseOnFailure() {
+		status.State = v1alpha1.RolloutStatePaused
+		status.Message = fmt.Sprintf("restarted workloads failed to become available: %s", joinWorkloads(failed))
+		return rolloutPollInterval, nil
+	}
+	if len(inFlight) > 0 {
+		status.State = v1alpha1.RolloutStateInProgress
+		status.Message = fmt.Sprintf("waiting for restarted workloads to become available: %s", joinWorkloads(inFlight))
+		return rolloutPollInterval, nil
+	}
+	if len(pending) == 0 {
+		status.State = v1alpha1.RolloutStateComplete
+		status.Message = ""
+		return 0, nil
+	}
+
+	batch := pending
+	if len(batch) > int(policy.GetBatchSize()) {
+		batch = batch[:policy.GetBatchSize()]
+	}
+	for _, w := range batch {
+		log.FromContext(ctx).Info("Restarting workload with outdated proxies", "workload", w.String())
+		if err := r.restartWorkload(ctx, w, status.Target); err != nil {
+			return 0, err
+		}
+		status.Restarted++
+		status.Pending--
+	}
+	status.State = v1alpha1.RolloutStateInProgress
+	status.Message = fmt.Sprintf("restarted %s", joinWorkloads(batch))
+	return rolloutPollInterval, nil
+}
+
+// listRolloutWorkloads returns the Deployments and StatefulSets in the namespaces that
+// contain pods injected by the control plane (limited to the members of the mesh, if
+// specified), and the injected pods that don't run the control plane's proxy image
+func (r *IstioReconciler) listRolloutWorkloads(ctx context.Context, istio *v1alpha1.Istio, values map[string]interface{}) ([]workload, []corev1.Pod, error) {
+	pods, err := r.listInjectedPods(ctx, istio, getRevision(values))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	members := sets.New(istio.Spec.Members...)
+	namespaces := sets.New[string]()
+	expectedImage := expectedProxyImage(values)
+	var outdated []corev1.Pod
+	for _, pod := range pods {
+		if members.Len() > 0 && !members.Contains(pod.Namespace) {
+			continue
+		}
+		namespaces.Insert(pod.Namespace)
+		if proxyImage(&pod) != expectedImage {
+			outdated = append(outdated, pod)
+		}
+	}
+
+	var workloads []workload
+	for _, ns := range sets.SortedList(namespaces) {
+		deployments := &appsv1.DeploymentList{}
+		if err := r.Client.List(ctx, deployments, client.InNamespace(ns)); err != nil {
+			return nil, nil, fmt.Errorf("failed to list Deployments: %v", err)
+		}
+		for i := range deployments.Items {
+			workloads = append(workloads, newDeploymentWorkload(&deployments.Items[i]))
+		}
+
+		statefulSets := &appsv1.StatefulSetList{}
+		if err := r.Client.List(ctx, statefulSets, client.InNamespace(ns)); err != nil {
+			return nil, nil, fmt.Errorf("failed to list StatefulSets: %v", err)
+		}
+		for i := range statefulSets.Items {
+			workloads = append(workloads, newStatefulSetWorkload(&statefulSets.Items[i]))
+		}
+	}
+	return workloads, outdated, nil
+}
+
+func newDeploymentWorkload(d *appsv1.Deployment) workload {
+	replicas := int32(1)
+	if d.Spec.Replicas != nil {
+		replicas = *d.Spec.Replicas
+	}
+	w := workload{
+		object:   d,
+		template: &d.Spec.Template,
+		selector: d.Spec.Selector,
+		available: d.Status.ObservedGeneration >= d.Generation && d.Status.UpdatedReplicas == replicas &&
+			d.Status.Replicas == replicas && d.Status.AvailableReplicas == replicas,
+	}
+	for _, c := range d.Status.Conditions {
+		if c.Type == appsv1.DeploymentProgressing && c.Status == corev1.ConditionFalse && c.Reason == "ProgressDeadlineExceeded" {
+			w.failed = !w.available
+		}
+	}
+	return w
+}
+
+func newStatefulSetWorkload(s *appsv1.StatefulSet) workload {
+	replicas := int32(1)
+	if s.Spec.Replicas != nil {
+		replicas = *s.Spec.Replicas
+	}
+	w := workload{
+		object:   s,
+		template: &s.Spec.Template,
+		selector: s.Spec.Selector,
+		available: s.Status.ObservedGeneration >= s.Generation && s.Status.UpdatedReplicas == replicas &&
+			s.Status.ReadyReplicas == replicas && s.Status.CurrentRevision == s.Status.UpdateRevision,
+	}
+	if restartedAt, err := time.Parse(time.RFC3339, s.Spec.Template.Annotations[AnnotationRestartedAt]); err == nil {
+		w.failed = !w.available && time.Since(restartedAt) > rolloutFailureTimeout
+	}
+	return w
+}
+
+// hasOutdatedPods returns whether any of the outdated pods belongs to the workload
+func hasOutdatedPods(w workload, outdatedPods []corev1.Pod) bool {
+	selector, err := metav1.LabelSelectorAsSelector(w.selector)
+	if err != nil || selector.Empty() {
+		return false
+	}
+	for _, pod := range outdatedPods {
+		if pod.Namespace == w.object.GetNamespace() && selector.Matches(labels.Set(pod.Labels)) {
+			return true
+		}
+	}
+	return false
+}
+
+// restartWorkload triggers a rolling restart of the workload by annotating its pod
+// template, like `kubectl rollout restart`
+func (r *IstioReconciler) restartWorkload(ctx context.Context, w workload, target string) error {
+	patch := client.MergeFrom(w.object.DeepCopyObject().(client.Object))
+	if w.template.Annotations == nil {
+		w.template.Annotations = map[string]string{}
+	}
+	w.template.Annotations[AnnotationRolloutTarget] = target
+	w.template.Annotations[AnnotationRestartedAt] = time.Now().Format(time.RFC3339)
+	if err := r.Client.Patch(ctx, w.object, patch); err != nil {
+		return fmt.Errorf("failed to restart %s: %v", w, err)
+	}
+	return nil
+}
+
+func joinWorkloads(workloads []workload) string {
+	names := make([]string, 0, len(workloads))
+	for _, w := range workloads {
+		names = append(names, w.object.GetNamespace()+"/"+w.object.GetName())
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	v1 "maistra.io/istio-operator/api/v1alpha1"
+	"maistra.io/istio-operator/pkg/kube"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"istio.io/istio/pkg/ptr"
+)
+
+const (
+	currentProxyImage = "quay.io/maistra-dev/proxyv2:3.0-latest"
+	oldProxyImage     = "quay.io/maistra-dev/proxyv2:2.4"
+)
+
+func TestReconcileRollout(t *testing.T) {
+	ctx := context.Background()
+	values := map[string]interface{}{
+		"global": map[string]interface{}{
+			"proxy": map[string]interface{}{"image": currentProxyImage},
+		},
+	}
+
+	newIstio := func(policy *v1.RolloutPolicy) *v1.Istio {
+		return &v1.Istio{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "istio-system"},
+			Spec:       v1.IstioSpec{Version: "v3.0", RolloutPolicy: policy},
+			Status:     v1.IstioStatus{AppliedHash: "hash"},
+		}
+	}
+	controlPlane := func() []client.Object {
+		return []client.Object{
+			&appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: "istiod", Namespace: "istio-system"},
+				Status:     appsv1.DeploymentStatus{Replicas: 1, ReadyReplicas: 1},
+			},
+			&appsv1.DaemonSet{
+				ObjectMeta: metav1.ObjectMeta{Name: "istio-cni-node", Namespace: kube.GetOperatorNamespace()},
+				Status:     appsv1.DaemonSetStatus{CurrentNumberScheduled: 1, NumberReady: 1},
+			},
+		}
+	}
+	newDeployment := func(name string) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "app"},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: ptr.Of(int32(1)),
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": name}},
+			},
+			Status: appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1},
+		}
+	}
+	newPod := func(app, image string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        app + "-pod",
+				Namespace:   "app",
+				Labels:      map[string]string{"app": app, "istio.io/rev": "default"},
+				Annotations: map[string]string{"sidecar.istio.io/status": `{"revision":"default"}`},
+			},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "istio-proxy", Image: image}}},
+		}
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		istio := newIstio(nil)
+		istio.Status.Rollout = &v1.RolloutStatus{Target: "old"}
+		r := &IstioReconciler{Client: fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()}
+		requeue, err := r.reconcileRollout(ctx, istio, values)
+		Must(t, err)
+		if requeue != 0 || istio.Status.Rollout != nil {
+			t.Errorf("expected no rollout, got requeue %v and status %+v", requeue, istio.Status.Rollout)
+		}
+	})
+
+	t.Run("control plane not ready", func(t *testing.T) {
+		istio := newIstio(&v1.RolloutPolicy{Enabled: true})
+		r := &IstioReconciler{Client: fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()}
+		_, err := r.reconcileRollout(ctx, istio, values)
+		Must(t, err)
+		if istio.Status.Rollout.State != v1.RolloutStateWaiting {
+			t.Errorf("expected state %s, got %s", v1.RolloutStateWaiting, istio.Status.Rollout.State)
+		}
+	})
+
+	t.Run("batches", func(t *testing.T) {
+		objects := append(controlPlane(),
+			newDeployment("a"), newPod("a", oldProxyImage),
+			newDeployment("b"), newPod("b", oldProxyImage),
+			newDeployment("c"), newPod("c", currentProxyImage),
+		)
+		cl := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(objects...).Build()
+		r := &IstioReconciler{Client: cl}
+		istio := newIstio(&v1.RolloutPolicy{Enabled: true, BatchSize: 1})
+
+		for i, expected := range []v1.RolloutStatus{
+			{Target: "hash", State: v1.RolloutStateInProgress, Restarted: 1, Pending: 1, Message: "restarted app/a"},
+			{Target: "hash", State: v1.RolloutStateInProgress, Restarted: 2, Pending: 0, Message: "restarted app/b"},
+			{Target: "hash", State: v1.RolloutStateComplete, Restarted: 2, Pending: 0},
+		} {
+			_, err := r.reconcileRollout(ctx, istio, values)
+			Must(t, err)
+			if *istio.Status.Rollout != expected {
+				t.Errorf("step %d: expected status %+v, got %+v", i, expected, *istio.Status.Rollout)
+			}
+		}
+
+		for name, expectRestart := range map[string]bool{"a": true, "b": true, "c": false} {
+			d := &appsv1.Deployment{}
+			Must(t, cl.Get(ctx, client.ObjectKey{Namespace: "app", Name: name}, d))
+			if restarted := d.Spec.Template.Annotations[AnnotationRolloutTarget] == "hash"; restarted != expectRestart {
+				t.Errorf("expected Deployment %s restarted=%v", name, expectRestart)
+			}
+		}
+	})
+
+	t.Run("pause on failure", func(t *testing.T) {
+		failed := newDeployment("a")
+		failed.Spec.Template.Annotations = map[string]string{AnnotationRolloutTarget: "hash"}
+		failed.Status = appsv1.DeploymentStatus{
+			Replicas: 2, UpdatedReplicas: 1, AvailableReplicas: 1,
+			Conditions: []appsv1.DeploymentCondition{
+				{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionFalse, Reason: "ProgressDeadlineExceeded"},
+			},
+		}
+		objects := append(controlPlane(), failed, newPod("a", oldProxyImage), newDeployment("b"), newPod("b", oldProxyImage))
+
+		for _, tc := range []struct {
+			pause  *bool
+			expect v1.RolloutState
+		}{
+			{pause: nil, expect: v1.RolloutStatePaused},
+			{pause: ptr.Of(false), expect: v1.RolloutStateInProgress},
+		} {
+			r := &IstioReconciler{Client: fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(objects...).Build()}
+			istio := newIstio(&v1.RolloutPolicy{Enabled: true, PauseOnFailure: tc.pause})
+			_, err := r.reconcileRollout(ctx, istio, values)
+			Must(t, err)
+			if istio.Status.Rollout.State != tc.expect {
+				t.Errorf("expected state %s, got %s (%s)", tc.expect, istio.Status.Rollout.State, istio.Status.Rollout.Message)
+			}
+		}
+	})
+}
@@ -0,0 +1,237 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"maistra.io/istio-operator/api/v1alpha1"
+	"maistra.io/istio-operator/pkg/helm"
+	"maistra.io/istio-operator/pkg/kube"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// uninstallPollInterval is how often the operator checks whether the workloads of
+// uninstalled components have terminated
+const uninstallPollInterval = 5 * time.Second
+
+// types of the objects that the operator tracks through the primary-resource
+// annotations instead of OwnerReferences. Namespaced types are only swept in the
+// operator namespace.
+var annotatedTypes = []struct {
+	list       client.ObjectList
+	namespaced bool
+}{
+	{list: &rbacv1.ClusterRoleList{}},
+	{list: &rbacv1.ClusterRoleBindingList{}},
+	{list: &admissionv1.MutatingWebhookConfigurationList{}},
+	{list: &admissionv1.ValidatingWebhookConfigurationList{}},
+	{list: &appsv1.DaemonSetList{}, namespaced: true},
+	{list: &corev1.ConfigMapList{}, namespaced: true},
+	{list: &corev1.SecretList{}, namespaced: true},
+	{list: &corev1.ServiceAccountList{}, namespaced: true},
+	{list: &rbacv1.RoleList{}, namespaced: true},
+	{list: &rbacv1.RoleBindingList{}, namespaced: true},
+}
+
+// uninstall uninstalls the components of the Istio resource in reverse dependency
+// order, after uninstalling istiod-remote from the remote clusters. A component is
+// only uninstalled after the pods of the components that depend on it have
+// terminated. Once all components are gone, any remaining objects carrying the
+// primary-resource annotations of the Istio resource are deleted. uninstall returns
+// a message describing what it's waiting for, or an empty string if the
+// uninstallation is complete.
+func (r *IstioReconciler) uninstall(ctx context.Context, istio *v1alpha1.Istio) (string, error) {
+	sorted, err := sortComponents(components)
+	if err != nil {
+		return "", err
+	}
+
+	if err := r.uninstallRemoteClusters(ctx, istio); err != nil {
+		return "", err
+	}
+
+	revision := getRevision(istio.Status.GetAppliedValues())
+	for i := len(sorted) - 1; i >= 0; i-- {
+		c := sorted[i]
+		if err := helm.UninstallChart(r.RestClientGetter, c.releaseName(istio), c.namespace(istio)); err != nil {
+			return "", fmt.Errorf("failed to uninstall component %s: %w", c.name, err)
+		}
+
+		pods, err := r.terminatingPods(ctx, istio, c, revision)
+		if err != nil {
+			return "", err
+		}
+		if pods > 0 {
+			return fmt.Sprintf("waiting for %d pod(s) of component %s to terminate", pods, c.name), nil
+		}
+	}
+
+	return "", r.deleteAnnotatedObjects(ctx, istio)
+}
+
+// deletionBlocker returns a message describing why the deletion of the Istio
+// resource is blocked by its DeletionPolicy, or an empty string if the components
+// may be uninstalled. Once the uninstallation has started, it's never blocked again.
+func (r *IstioReconciler) deletionBlocker(ctx context.Context, istio *v1alpha1.Istio) (string, error) {
+	if istio.Spec.DeletionPolicy == v1alpha1.DeletionPolicyAlways || istio.Annotations[v1alpha1.AnnotationForceDeletion] == "true" {
+		return "", nil
+	}
+	if condition := istio.Status.GetCondition(v1alpha1.ConditionTypeTerminating); condition.Status == metav1.ConditionTrue &&
+		condition.Reason != v1alpha1.ConditionReasonInUse {
+		return "", nil
+	}
+
+	revision := getRevision(istio.Status.GetAppliedValues())
+	pods, err := r.listInjectedPods(ctx, istio, revision)
+	if err != nil {
+		return "", err
+	}
+
+	injected := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		injected = append(injected, pod.Namespace+"/"+pod.Name)
+	}
+	if len(injected) == 0 {
+		return "", nil
+	}
+	sort.Strings(injected)
+	return fmt.Sprintf("deletion blocked by deletionPolicy %s: %d pod(s) injected by revision %s still exist (e.g. %s); "+
+		"delete them or annotate the Istio resource with %s=true", v1alpha1.DeletionPolicyBlockIfInUse, len(injected), revision,
+		injected[0], v1alpha1.AnnotationForceDeletion), nil
+}
+
+// terminatingPods returns the number of pods of the component that still exist.
+// Pods of shared components that are managed by a workload of another control
+// plane are ignored.
+func (r *IstioReconciler) terminatingPods(ctx context.Context, istio *v1alpha1.Istio, c component, revision string) (int, error) {
+	if len(c.podLabels) == 0 {
+		return 0, nil
+	}
+	pods := &corev1.PodList{}
+	if err := r.Client.List(ctx, pods, client.InNamespace(c.namespace(istio)), client.MatchingLabels(c.podSelector(revision))); err != nil {
+		return 0, fmt.Errorf("failed to list pods of component %s: %v", c.name, err)
+	}
+
+	count := 0
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if c.shared {
+			active, err := r.hasActiveDaemonSet(ctx, pod)
+			if err != nil {
+				return 0, err
+			} else if active {
+				continue
+			}
+		}
+		count++
+	}
+	return count, nil
+}
+
+// hasActiveDaemonSet returns whether the pod is controlled by a DaemonSet that exists and isn't being deleted
+func (r *IstioReconciler) hasActiveDaemonSet(ctx context.Context, pod *corev1.Pod) (bool, error) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil || owner.Kind != "DaemonSet" {
+		return false, nil
+	}
+	ds := &appsv1.DaemonSet{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: pod.Namespace, Name: owner.Name}, ds); err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return ds.UID == owner.UID && ds.DeletionTimestamp == nil, nil
+}
+
+// deleteAnnotatedObjects deletes the objects that belong to the Istio resource
+// according to their primary-resource annotations, but weren't removed with the
+// helm releases (e.g. because a release record was missing)
+func (r *IstioReconciler) deleteAnnotatedObjects(ctx context.Context, istio *v1alpha1.Istio) error {
+	logger := log.FromContext(ctx)
+	for _, t := range annotatedTypes {
+		list := t.list.DeepCopyObject().(client.ObjectList)
+		var opts []client.ListOption
+		if t.namespaced {
+			opts = append(opts, client.InNamespace(kube.GetOperatorNamespace()))
+		}
+		if err := r.Client.List(ctx, list, opts...); err != nil {
+			return fmt.Errorf("failed to list objects to clean up: %v", err)
+		}
+
+		objects, err := meta.ExtractList(list)
+		if err != nil {
+			return err
+		}
+		for _, o := range objects {
+			obj, ok := o.(client.Object)
+			if !ok || !isOwnedByAnnotations(obj, istio) {
+				continue
+			}
+			logger.Info("Deleting leftover object", "kind", fmt.Sprintf("%T", obj), "namespace", obj.GetNamespace(), "name", obj.GetName())
+			if err := r.Client.Delete(ctx, obj); err != nil && !errors.IsNotFound(err) {
+				return fmt.Errorf("failed to delete %s/%s: %v", obj.GetNamespace(), obj.GetName(), err)
+			}
+		}
+	}
+	return nil
+}
+
+func isOwnedByAnnotations(obj client.Object, istio *v1alpha1.Istio) bool {
+	namespacedName, kind, apiGroup := helm.GetOwnerFromAnnotations(obj.GetAnnotations())
+	return namespacedName != nil && *namespacedName == client.ObjectKeyFromObject(istio) &&
+		kind == v1alpha1.IstioKind && apiGroup == v1alpha1.GroupVersion.Group
+}
+
+// updateTerminatingStatus reports the progress of the uninstallation in the Terminating condition
+func (r *IstioReconciler) updateTerminatingStatus(ctx context.Context, istio *v1alpha1.Istio, reason v1alpha1.IstioConditionReason,
+	message string, err error,
+) error {
+	condition := v1alpha1.IstioCondition{
+		Type:    v1alpha1.ConditionTypeTerminating,
+		Status:  metav1.ConditionTrue,
+		Reason:  reason,
+		Message: message,
+	}
+	if err != nil {
+		// the reason is kept, because it records whether the uninstallation has started
+		condition.Message = fmt.Sprintf("error uninstalling components: %v", err)
+	}
+
+	status := istio.Status.DeepCopy()
+	status.SetCondition(condition)
+	status.State = condition.Reason
+	if statusErr := r.Client.Status().Patch(ctx, istio, kube.NewStatusPatch(*status)); statusErr != nil && !errors.IsNotFound(statusErr) {
+		log.FromContext(ctx).Error(statusErr, "failed to patch status")
+		if err == nil {
+			return statusErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,235 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	v1 "maistra.io/istio-operator/api/v1alpha1"
+	"maistra.io/istio-operator/pkg/helm"
+	"maistra.io/istio-operator/pkg/kube"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"istio.io/istio/pkg/ptr"
+)
+
+func TestTerminatingPods(t *testing.T) {
+	ctx := context.Background()
+	istio := &v1.Istio{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "istio-system"}}
+	operatorNamespace := kube.GetOperatorNamespace()
+
+	newPod := func(name, namespace string, labels map[string]string, owner *metav1.OwnerReference) *corev1.Pod {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels}}
+		if owner != nil {
+			pod.OwnerReferences = []metav1.OwnerReference{*owner}
+		}
+		return pod
+	}
+	activeDaemonSet := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "istio-cni-node", Namespace: operatorNamespace, UID: "ds-uid"}}
+	daemonSetOwner := &metav1.OwnerReference{Kind: "DaemonSet", Name: "istio-cni-node", UID: "ds-uid", Controller: ptr.Of(true)}
+	deletedDaemonSetOwner := &metav1.OwnerReference{Kind: "DaemonSet", Name: "istio-cni-node", UID: "old-uid", Controller: ptr.Of(true)}
+
+	testCases := []struct {
+		name      string
+		objects   []client.Object
+		component string
+		revision  string
+		expect    int
+	}{
+		{
+			name:      "istiod pods of this revision",
+			component: "istiod",
+			revision:  "default",
+			objects: []client.Object{
+				newPod("istiod-1", "istio-system", map[string]string{"operator.istio.io/component": "Pilot", "istio.io/rev": "default"}, nil),
+				newPod("istiod-canary-1", "istio-system", map[string]string{"operator.istio.io/component": "Pilot", "istio.io/rev": "canary"}, nil),
+			},
+			expect: 1,
+		},
+		{
+			name:      "no pods",
+			component: "istiod",
+			revision:  "default",
+			expect:    0,
+		},
+		{
+			name:      "cni pods of a deleted DaemonSet",
+			component: "cni",
+			objects: []client.Object{
+				newPod("cni-1", operatorNamespace, map[string]string{"k8s-app": "istio-cni-node"}, deletedDaemonSetOwner),
+			},
+			expect: 1,
+		},
+		{
+			name:      "cni pods of another control plane",
+			component: "cni",
+			objects: []client.Object{
+				activeDaemonSet,
+				newPod("cni-1", operatorNamespace, map[string]string{"k8s-app": "istio-cni-node"}, daemonSetOwner),
+			},
+			expect: 0,
+		},
+		{
+			name:      "component without pods",
+			component: "base",
+			expect:    0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &IstioReconciler{Client: fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(tc.objects...).Build()}
+			var c component
+			for _, comp := range components {
+				if comp.name == tc.component {
+					c = comp
+				}
+			}
+			actual, err := r.terminatingPods(ctx, istio, c, tc.revision)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual != tc.expect {
+				t.Errorf("expected %d terminating pods, got %d", tc.expect, actual)
+			}
+		})
+	}
+}
+
+func TestDeleteAnnotatedObjects(t *testing.T) {
+	ctx := context.Background()
+	istio := &v1.Istio{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "istio-system"}}
+	annotations := func(owner string) map[string]string {
+		return map[string]string{
+			helm.AnnotationPrimaryResource:     owner,
+			helm.AnnotationPrimaryResourceType: v1.IstioKind + "." + v1.GroupVersion.Group,
+		}
+	}
+
+	ownClusterRole := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "own", Annotations: annotations("istio-system/test")}}
+	otherClusterRole := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "other", Annotations: annotations("other/test")}}
+	unrelatedClusterRole := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "unrelated"}}
+	ownWebhook := &admissionv1.MutatingWebhookConfiguration{ObjectMeta: metav1.ObjectMeta{Name: "own", Annotations: annotations("istio-system/test")}}
+	ownDaemonSet := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{
+		Name: "istio-cni-node", Namespace: kube.GetOperatorNamespace(), Annotations: annotations("istio-system/test"),
+	}}
+
+	cl := fake.NewClientBuilder().WithScheme(scheme.Scheme).
+		WithObjects(ownClusterRole, otherClusterRole, unrelatedClusterRole, ownWebhook, ownDaemonSet).
+		Build()
+	r := &IstioReconciler{Client: cl}
+	Must(t, r.deleteAnnotatedObjects(ctx, istio))
+
+	for _, obj := range []client.Object{ownClusterRole, ownWebhook, ownDaemonSet} {
+		if err := cl.Get(ctx, client.ObjectKeyFromObject(obj), obj); !errors.IsNotFound(err) {
+			t.Errorf("expected %T %s to be deleted, got %v", obj, obj.GetName(), err)
+		}
+	}
+	for _, obj := range []client.Object{otherClusterRole, unrelatedClusterRole} {
+		if err := cl.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+			t.Errorf("expected %T %s to be kept, got %v", obj, obj.GetName(), err)
+		}
+	}
+}
+
+func TestDeletionBlocker(t *testing.T) {
+	ctx := context.Background()
+	injectedPod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:        "app-1",
+		Namespace:   "bookinfo",
+		Labels:      map[string]string{"istio.io/rev": "default"},
+		Annotations: map[string]string{"sidecar.istio.io/status": "{}"},
+	}}
+	otherRevisionPod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:        "app-1",
+		Namespace:   "other",
+		Labels:      map[string]string{"istio.io/rev": "canary"},
+		Annotations: map[string]string{"sidecar.istio.io/status": "{}"},
+	}}
+	uninjectedPod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:      "istiod-1",
+		Namespace: "istio-system",
+		Labels:    map[string]string{"istio.io/rev": "default"},
+	}}
+	eastWestGatewayPod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:        "istio-eastwestgateway-1",
+		Namespace:   "istio-system",
+		Labels:      map[string]string{"istio.io/rev": "default", "istio": eastWestGatewayLabel},
+		Annotations: map[string]string{"sidecar.istio.io/status": "{}"},
+	}}
+
+	testCases := []struct {
+		name        string
+		policy      v1.DeletionPolicy
+		annotations map[string]string
+		condition   *v1.IstioCondition
+		objects     []client.Object
+		expectBlock bool
+	}{
+		{
+			name:        "injected pods",
+			objects:     []client.Object{injectedPod, otherRevisionPod, uninjectedPod},
+			expectBlock: true,
+		},
+		{
+			name:    "only pods of other revisions or without sidecar",
+			objects: []client.Object{otherRevisionPod, uninjectedPod},
+		},
+		{
+			name:    "only pods of the control plane's own gateway",
+			objects: []client.Object{eastWestGatewayPod},
+		},
+		{
+			name:    "policy Always",
+			policy:  v1.DeletionPolicyAlways,
+			objects: []client.Object{injectedPod},
+		},
+		{
+			name:        "force annotation",
+			annotations: map[string]string{v1.AnnotationForceDeletion: "true"},
+			objects:     []client.Object{injectedPod},
+		},
+		{
+			name: "uninstallation already started",
+			condition: &v1.IstioCondition{
+				Type: v1.ConditionTypeTerminating, Status: metav1.ConditionTrue, Reason: v1.ConditionReasonUninstalling,
+			},
+			objects: []client.Object{injectedPod},
+		},
+		{
+			name: "previously blocked",
+			condition: &v1.IstioCondition{
+				Type: v1.ConditionTypeTerminating, Status: metav1.ConditionTrue, Reason: v1.ConditionReasonInUse,
+			},
+			objects:     []client.Object{injectedPod},
+			expectBlock: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			istio := &v1.Istio{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "istio-system", Annotations: tc.annotations},
+				Spec:       v1.IstioSpec{DeletionPolicy: tc.policy},
+			}
+			if tc.condition != nil {
+				istio.Status.SetCondition(*tc.condition)
+			}
+			r := &IstioReconciler{Client: fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(tc.objects...).Build()}
+			blocker, err := r.deletionBlocker(ctx, istio)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (blocker != "") != tc.expectBlock {
+				t.Errorf("expected blocked=%v, got %q", tc.expectBlock, blocker)
+			}
+		})
+	}
+}
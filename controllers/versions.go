@@ -0,0 +1,192 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"maistra.io/istio-operator/api/v1alpha1"
+	"maistra.io/istio-operator/pkg/version"
+	"maistra.io/istio-operator/pkg/versions"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// versionPublishInterval is the interval at which publishing the versions is retried
+const versionPublishInterval = 10 * time.Second
+
+type versionNotSupportedError struct {
+	version   string
+	supported []string
+}
+
+func (e *versionNotSupportedError) Error() string {
+	return fmt.Sprintf("version %s is not supported by this operator; supported versions: %s",
+		e.version, strings.Join(e.supported, ", "))
+}
+
+// isVersionNotSupported returns true if the error is caused by a version that the
+// operator doesn't support
+func isVersionNotSupported(err error) bool {
+	var agg utilerrors.Aggregate
+	if errors.As(err, &agg) {
+		for _, e := range agg.Errors() {
+			if isVersionNotSupported(e) {
+				return true
+			}
+		}
+		return false
+	}
+	var versionErr *versionNotSupportedError
+	return errors.As(err, &versionErr)
+}
+
+// checkVersionSupported returns an error if the version isn't in the resource directory,
+// e.g. because a newer operator dropped the version of an existing Istio resource
+func checkVersionSupported(resourceDir, version string) error {
+	supported, err := versions.Discover(resourceDir)
+	if err != nil {
+		return fmt.Errorf("failed to discover supported versions: %v", err)
+	}
+	if _, found := versions.Find(supported, version); !found {
+		return &versionNotSupportedError{version: version, supported: versions.Names(supported)}
+	}
+	return nil
+}
+
+type versionChangeError struct {
+	err error
+}
+
+func (e *versionChangeError) Error() string {
+	return fmt.Sprintf("%v; annotate the resource with %s=true to change the version regardless",
+		e.err, v1alpha1.AnnotationForceVersionChange)
+}
+
+// isVersionChangeNotAllowed returns true if the error is caused by a change of the
+// version that the upgrade path rules forbid
+func isVersionChangeNotAllowed(err error) bool {
+	var agg utilerrors.Aggregate
+	if errors.As(err, &agg) {
+		for _, e := range agg.Errors() {
+			if isVersionChangeNotAllowed(e) {
+				return true
+			}
+		}
+		return false
+	}
+	var changeErr *versionChangeError
+	return errors.As(err, &changeErr)
+}
+
+// validateVersionChange checks that spec.version is allowed given the version that was
+// last installed and the minimum version supported by the operator, unless the Istio
+// resource has the force-version-change annotation. It compares with the installed
+// version rather than the previous spec.version, so that a change is also rejected if
+// the previous change was never installed.
+func validateVersionChange(from string, istio *v1alpha1.Istio) error {
+	if istio.Annotations[v1alpha1.AnnotationForceVersionChange] == "true" {
+		return nil
+	}
+	if err := versions.ValidateTransition(from, istio.Spec.Version, version.Info.MinimumSupportedVersion); err != nil {
+		return &versionChangeError{err: err}
+	}
+	return nil
+}
+
+// VersionPublisher publishes an IstioVersion for every version in the resource
+// directory when the operator starts, and deletes the IstioVersions of versions that
+// are no longer supported. Failures are retried until publishing succeeds.
+type VersionPublisher struct {
+	Client            client.Client
+	ResourceDirectory string
+}
+
+// +kubebuilder:rbac:groups=operator.istio.io,resources=istioversions,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups=operator.istio.io,resources=istioversions/status,verbs=get;update;patch
+
+// Start publishes the versions
+func (p *VersionPublisher) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("versions")
+	return wait.PollUntilContextCancel(ctx, versionPublishInterval, true, func(ctx context.Context) (bool, error) {
+		if err := p.publish(ctx); err != nil {
+			logger.Error(err, "failed to publish supported versions")
+			return false, nil
+		}
+		return true, nil
+	})
+}
+
+func (p *VersionPublisher) publish(ctx context.Context) error {
+	supported, err := versions.Discover(p.ResourceDirectory)
+	if err != nil {
+		return err
+	}
+
+	for i, version := range supported {
+		status := versionStatus(version, i == len(supported)-1)
+		istioVersion := &v1alpha1.IstioVersion{}
+		err := p.Client.Get(ctx, client.ObjectKey{Name: version.Name}, istioVersion)
+		if apierrors.IsNotFound(err) {
+			istioVersion.Name = version.Name
+			err = p.Client.Create(ctx, istioVersion)
+		}
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(istioVersion.Status, status) {
+			istioVersion.Status = status
+			if err := p.Client.Status().Update(ctx, istioVersion); err != nil {
+				return err
+			}
+		}
+	}
+
+	list := &v1alpha1.IstioVersionList{}
+	if err := p.Client.List(ctx, list); err != nil {
+		return err
+	}
+	for i := range list.Items {
+		if _, found := versions.Find(supported, list.Items[i].Name); !found {
+			if err := p.Client.Delete(ctx, &list.Items[i]); client.IgnoreNotFound(err) != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func versionStatus(version versions.Version, latest bool) v1alpha1.IstioVersionStatus {
+	status := v1alpha1.IstioVersionStatus{Latest: latest, Profiles: version.Profiles}
+	for _, chart := range version.Charts {
+		status.Charts = append(status.Charts, v1alpha1.IstioVersionChart{
+			Name:       chart.Name,
+			Path:       chart.Path,
+			Version:    chart.Version,
+			AppVersion: chart.AppVersion,
+		})
+	}
+	return status
+}
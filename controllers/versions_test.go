@@ -0,0 +1,186 @@
+package controllers
+
+import (
+	"context"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	v1 "maistra.io/istio-operator/api/v1alpha1"
+	"maistra.io/istio-operator/pkg/common"
+	"maistra.io/istio-operator/pkg/version"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCheckVersionSupported(t *testing.T) {
+	resourceDir := path.Join(common.RepositoryRoot, "resources")
+	if err := checkVersionSupported(resourceDir, "v3.0"); err != nil {
+		t.Errorf("expected v3.0 to be supported, got %v", err)
+	}
+
+	err := checkVersionSupported(resourceDir, "v2.4")
+	if !isVersionNotSupported(err) || !isVersionNotSupported(utilerrors.NewAggregate([]error{err})) {
+		t.Fatalf("expected version not supported error, got %v", err)
+	}
+	expected := "version v2.4 is not supported by this operator; supported versions: v3.0"
+	if err.Error() != expected {
+		t.Errorf("expected %q, got %q", expected, err.Error())
+	}
+	if reason := determineReconciledCondition(err).Reason; reason != v1.ConditionReasonVersionNotSupported {
+		t.Errorf("expected reason %s, got %s", v1.ConditionReasonVersionNotSupported, reason)
+	}
+}
+
+func TestVersionPublisher(t *testing.T) {
+	ctx := context.Background()
+	resourceDir := t.TempDir()
+	writeChart := func(version string) {
+		t.Helper()
+		chartDir := path.Join(resourceDir, version, "charts", "base")
+		Must(t, os.MkdirAll(chartDir, 0o755))
+		Must(t, os.WriteFile(path.Join(chartDir, "Chart.yaml"), []byte("apiVersion: v2\nname: base\nversion: 1.0.0\n"), 0o644))
+		Must(t, os.MkdirAll(path.Join(resourceDir, version, "profiles"), 0o755))
+		Must(t, os.WriteFile(path.Join(resourceDir, version, "profiles", "default.yaml"), nil, 0o644))
+	}
+	writeChart("v3.0")
+	writeChart("v3.1")
+
+	s := runtime.NewScheme()
+	Must(t, v1.AddToScheme(s))
+	stale := &v1.IstioVersion{}
+	stale.Name = "v2.9"
+	cl := fake.NewClientBuilder().WithScheme(s).WithStatusSubresource(&v1.IstioVersion{}).WithObjects(stale).Build()
+	publisher := &VersionPublisher{Client: cl, ResourceDirectory: resourceDir}
+
+	expectVersions := func(expected map[string]bool) {
+		t.Helper()
+		list := &v1.IstioVersionList{}
+		Must(t, cl.List(ctx, list))
+		actual := map[string]bool{}
+		for _, version := range list.Items {
+			actual[version.Name] = version.Status.Latest
+			expectedStatus := v1.IstioVersionStatus{
+				Latest:   version.Status.Latest,
+				Profiles: []string{"default"},
+				Charts:   []v1.IstioVersionChart{{Name: "base", Path: "base", Version: "1.0.0"}},
+			}
+			if !reflect.DeepEqual(version.Status, expectedStatus) {
+				t.Errorf("unexpected status of %s: %+v", version.Name, version.Status)
+			}
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("expected versions %v, got %v", expected, actual)
+		}
+	}
+
+	Must(t, publisher.publish(ctx))
+	expectVersions(map[string]bool{"v3.0": false, "v3.1": true})
+
+	// a newer operator adds v3.2 and drops v3.0
+	Must(t, os.RemoveAll(path.Join(resourceDir, "v3.0")))
+	writeChart("v3.2")
+	Must(t, publisher.publish(ctx))
+	expectVersions(map[string]bool{"v3.1": false, "v3.2": true})
+
+	version := &v1.IstioVersion{}
+	Must(t, cl.Get(ctx, client.ObjectKey{Name: "v3.2"}, version))
+	resourceVersion := version.ResourceVersion
+	Must(t, publisher.publish(ctx))
+	Must(t, cl.Get(ctx, client.ObjectKey{Name: "v3.2"}, version))
+	if version.ResourceVersion != resourceVersion {
+		t.Errorf("expected unchanged IstioVersion not to be updated")
+	}
+}
+
+func TestValidateVersionChange(t *testing.T) {
+	originalInfo := version.Info
+	t.Cleanup(func() { version.Info = originalInfo })
+	version.Info.MinimumSupportedVersion = "v3.0"
+
+	newIstio := func(specVersion, installedVersion string, annotations map[string]string) *v1.Istio {
+		istio := &v1.Istio{Spec: v1.IstioSpec{Version: specVersion}, Status: v1.IstioStatus{Version: installedVersion}}
+		istio.Annotations = annotations
+		return istio
+	}
+	force := map[string]string{v1.AnnotationForceVersionChange: "true"}
+
+	testCases := []struct {
+		name      string
+		istio     *v1.Istio
+		expectErr string
+	}{
+		{name: "new control plane", istio: newIstio("v3.0", "", nil)},
+		{name: "unchanged", istio: newIstio("v3.1", "v3.1", nil)},
+		{name: "upgrade", istio: newIstio("v3.1", "v3.0", nil)},
+		{name: "below minimum", istio: newIstio("v2.4", "", nil), expectErr: "lower than the minimum supported version v3.0"},
+		{name: "downgrade", istio: newIstio("v3.0", "v3.1", nil), expectErr: "downgrading from v3.1 to v3.0 is not supported"},
+		{name: "skipped minor", istio: newIstio("v3.2", "v3.0", nil), expectErr: "upgrade to v3.1 first"},
+		{name: "forced downgrade", istio: newIstio("v3.0", "v3.1", force)},
+		{name: "forced below minimum", istio: newIstio("v2.4", "", force)},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateVersionChange(tc.istio.Status.Version, tc.istio)
+			if tc.expectErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.expectErr) || !strings.Contains(err.Error(), v1.AnnotationForceVersionChange) {
+				t.Fatalf("expected error containing %q and the override annotation, got %v", tc.expectErr, err)
+			}
+			if reason := determineReconciledCondition(err).Reason; reason != v1.ConditionReasonVersionChangeNotAllowed {
+				t.Errorf("expected reason %s, got %s", v1.ConditionReasonVersionChangeNotAllowed, reason)
+			}
+		})
+	}
+}
+
+func TestIstioValidator(t *testing.T) {
+	ctx := context.Background()
+	originalInfo := version.Info
+	t.Cleanup(func() { version.Info = originalInfo })
+	version.Info.MinimumSupportedVersion = "v3.0"
+
+	newIstio := func(specVersion, installedVersion string) *v1.Istio {
+		return &v1.Istio{Spec: v1.IstioSpec{Version: specVersion}, Status: v1.IstioStatus{Version: installedVersion}}
+	}
+	validator := &IstioValidator{}
+
+	if _, err := validator.ValidateCreate(ctx, newIstio("v3.0", "")); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := validator.ValidateCreate(ctx, newIstio("v2.4", "")); err == nil {
+		t.Errorf("expected error for version below the minimum")
+	}
+
+	testCases := []struct {
+		name        string
+		old, new    *v1.Istio
+		expectError bool
+	}{
+		{name: "version unchanged", old: newIstio("v3.0", "v3.0"), new: newIstio("v3.0", "v3.0")},
+		{name: "upgrade", old: newIstio("v3.0", "v3.0"), new: newIstio("v3.1", "v3.0")},
+		{name: "upgrade before installation", old: newIstio("v3.0", ""), new: newIstio("v3.1", "")},
+		{name: "skipped minor", old: newIstio("v3.0", ""), new: newIstio("v3.2", ""), expectError: true},
+		// the installed version counts, not the previous spec.version
+		{name: "pending upgrade", old: newIstio("v3.1", "v3.0"), new: newIstio("v3.2", "v3.0"), expectError: true},
+		{name: "downgrade", old: newIstio("v3.1", "v3.1"), new: newIstio("v3.0", "v3.1"), expectError: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := validator.ValidateUpdate(ctx, tc.old, tc.new)
+			if tc.expectError && err == nil {
+				t.Errorf("expected error")
+			} else if !tc.expectError && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
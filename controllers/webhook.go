@@ -0,0 +1,84 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"maistra.io/istio-operator/api/v1alpha1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// +kubebuilder:webhook:path=/validate-operator-istio-io-v1alpha1-istio,mutating=false,failurePolicy=fail,sideEffects=None,groups=operator.istio.io,resources=istios,verbs=create;update,versions=v1alpha1,name=vistio.operator.istio.io,admissionReviewVersions=v1
+
+// IstioValidator rejects Istio resources that the reconciler would refuse to
+// install, so that users learn about the problem when they apply the resource
+type IstioValidator struct{}
+
+var _ webhook.CustomValidator = &IstioValidator{}
+
+// SetupWebhookWithManager registers the validating webhook with the manager
+func (v *IstioValidator) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).For(&v1alpha1.Istio{}).WithValidator(v).Complete()
+}
+
+// ValidateCreate validates a new Istio resource
+func (v *IstioValidator) ValidateCreate(_ context.Context, obj runtime.Object) (admission.Warnings, error) {
+	istio, ok := obj.(*v1alpha1.Istio)
+	if !ok {
+		return nil, fmt.Errorf("expected an Istio resource, got %T", obj)
+	}
+	return nil, utilerrors.NewAggregate([]error{validateVersionChange("", istio), validateMeshConfig(istio)})
+}
+
+// ValidateUpdate validates a change of an Istio resource. The version is compared
+// with the installed version, or with the previous spec.version if the control plane
+// hasn't been installed yet. Only the fields that changed are validated, so that
+// resources created before the webhook was enabled can still be updated.
+func (v *IstioValidator) ValidateUpdate(_ context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	oldIstio, ok := oldObj.(*v1alpha1.Istio)
+	if !ok {
+		return nil, fmt.Errorf("expected an Istio resource, got %T", oldObj)
+	}
+	istio, ok := newObj.(*v1alpha1.Istio)
+	if !ok {
+		return nil, fmt.Errorf("expected an Istio resource, got %T", newObj)
+	}
+	var errs []error
+	if istio.Spec.Version != oldIstio.Spec.Version {
+		from := oldIstio.Status.Version
+		if from == "" {
+			from = oldIstio.Spec.Version
+		}
+		errs = append(errs, validateVersionChange(from, istio))
+	}
+	if !bytes.Equal(istio.Spec.MeshConfig, oldIstio.Spec.MeshConfig) {
+		errs = append(errs, validateMeshConfig(istio))
+	}
+	return nil, utilerrors.NewAggregate(errs)
+}
+
+// ValidateDelete allows all deletions
+func (v *IstioValidator) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
@@ -0,0 +1,43 @@
+// Code generated by gen-rbac. DO NOT EDIT.
+
+package controllers
+
+// Permissions needed to install the charts in resources
+// +kubebuilder:rbac:groups=admissionregistration.k8s.io,resources=mutatingwebhookconfigurations;validatingwebhookconfigurations,verbs=create;delete;get;list;patch;update;watch
+// +kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get;list;watch
+// +kubebuilder:rbac:groups=apps,resources=daemonsets;replicasets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=create;delete;get;list;patch;update;watch
+// +kubebuilder:rbac:groups=authentication.istio.io,resources="*",verbs=get;list;watch
+// +kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
+// +kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+// +kubebuilder:rbac:groups=autoscaling,resources=horizontalpodautoscalers,verbs=create;delete;get;list;patch;update;watch
+// +kubebuilder:rbac:groups=config.istio.io,resources="*",verbs=get;list;watch
+// +kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=create;get;patch;update
+// +kubebuilder:rbac:groups=discovery.k8s.io,resources=endpointslices,verbs=get;list;watch
+// +kubebuilder:rbac:groups=extensions.istio.io,resources="*",verbs=get;list;watch
+// +kubebuilder:rbac:groups=gateway.networking.k8s.io,resources="*",verbs=get;list;patch;update;watch
+// +kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=gatewayclasses,verbs=create;delete;patch;update
+// +kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=gateways,verbs=get;list;watch
+// +kubebuilder:rbac:groups=k8s.cni.cncf.io,resources=network-attachment-definitions,verbs=get;list;watch
+// +kubebuilder:rbac:groups=multicluster.x-k8s.io,resources=serviceexports,verbs=create;delete;get;list;watch
+// +kubebuilder:rbac:groups=multicluster.x-k8s.io,resources=serviceimports,verbs=get;list;watch
+// +kubebuilder:rbac:groups=networking.istio.io,resources="*",verbs=get;list;watch
+// +kubebuilder:rbac:groups=networking.istio.io,resources=envoyfilters;workloadentries;"workloadentries/status",verbs=create;delete;get;list;patch;update;watch
+// +kubebuilder:rbac:groups=networking.istio.io,resources=gateways,verbs=create
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=ingressclasses;ingresses,verbs=get;list;watch
+// +kubebuilder:rbac:groups=networking.k8s.io,resources="ingresses/status",verbs="*"
+// +kubebuilder:rbac:groups=networking.x-k8s.io,resources="*",verbs=get;list;patch;update;watch
+// +kubebuilder:rbac:groups=networking.x-k8s.io,resources=gateways,verbs=get;list;watch
+// +kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=create;delete;get;list;patch;update;watch
+// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterrolebindings;clusterroles;rolebindings;roles,verbs=create;delete;get;list;patch;update;watch
+// +kubebuilder:rbac:groups=rbac.istio.io,resources="*",verbs=get;list;watch
+// +kubebuilder:rbac:groups=security.istio.io,resources="*",verbs=get;list;watch
+// +kubebuilder:rbac:groups=security.openshift.io,resources=securitycontextconstraints,resourceNames=privileged,verbs=use
+// +kubebuilder:rbac:groups=telemetry.istio.io,resources="*",verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=configmaps;endpoints;events;serviceaccounts;services,verbs=create;delete;get;list;patch;update;watch
+// +kubebuilder:rbac:groups="",resources=namespaces;nodes;replicationcontrollers;resourcequotas,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=delete;get;list;patch;update;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=create;delete;get;list;update;watch
+// +kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=create;delete;patch;update,namespace=system
+// +kubebuilder:rbac:groups=k8s.cni.cncf.io,resources=network-attachment-definitions,verbs=create;delete;patch;update,namespace=system
+// +kubebuilder:rbac:groups="",resources=resourcequotas,verbs=create;delete;patch;update,namespace=system
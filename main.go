@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"path/filepath"
 
 	multusv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -31,6 +32,7 @@ import (
 	"maistra.io/istio-operator/controllers"
 	"maistra.io/istio-operator/pkg/common"
 	"maistra.io/istio-operator/pkg/helm"
+	"maistra.io/istio-operator/pkg/images"
 	"maistra.io/istio-operator/pkg/kube"
 	"maistra.io/istio-operator/pkg/version"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -61,11 +63,13 @@ func main() {
 	var configFile string
 	var resourceDirectory string
 	var logAPIRequests bool
+	var enableWebhooks bool
 	var printVersion bool
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.StringVar(&configFile, "config-file", "/etc/istio-operator/config.properties", "Location of the config file, propagated by k8s downward APIs")
 	flag.StringVar(&resourceDirectory, "resource-directory", "/var/lib/istio-operator/resources", "Where to find resources (e.g. charts)")
+	flag.BoolVar(&enableWebhooks, "enable-webhooks", true, "Whether to serve the admission webhooks, which requires a serving certificate")
 	flag.BoolVar(&logAPIRequests, "log-api-requests", false, "Whether to log each request sent to the Kubernetes API server")
 	flag.BoolVar(&printVersion, "version", printVersion, "Prints version information and exits")
 
@@ -97,6 +101,11 @@ func main() {
 		})
 	}
 
+	if err := helm.ValidateDriver(common.Config.Helm.Driver); err != nil {
+		setupLog.Error(err, "invalid helm configuration")
+		os.Exit(1)
+	}
+
 	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
 		Scheme:                  scheme,
 		Metrics:                 metricsserver.Options{BindAddress: metricsAddr},
@@ -122,12 +131,38 @@ func main() {
 	}
 
 	helm.ResourceDirectory = resourceDirectory
+	chartCacheDirectory := common.Config.Charts.CacheDirectory
+	if chartCacheDirectory == "" {
+		chartCacheDirectory = filepath.Join(os.TempDir(), "istio-operator", "charts")
+	}
+	helm.Sources, err = helm.NewChartSources(common.Config.Charts.Sources, chartCacheDirectory,
+		&images.Client{InsecureRegistries: common.Config.Images.InsecureRegistries}, nil)
+	if err != nil {
+		setupLog.Error(err, "invalid chart sources")
+		os.Exit(1)
+	}
 	controller := controllers.NewIstioReconciler(mgr.GetClient(), mgr.GetScheme(), mgr.GetConfig(), resourceDirectory)
+	// move releases stored by a previously configured driver to the current one
+	controller.ReleaseMigration = controllers.NewReleaseMigration(mgr.GetClient(), controller.RestClientGetter, common.Config.Helm.Driver)
+	if err := mgr.Add(controller.ReleaseMigration); err != nil {
+		setupLog.Error(err, "unable to set up helm release migration")
+		os.Exit(1)
+	}
 	err = controller.SetupWithManager(mgr)
 	if err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Istio")
 		os.Exit(1)
 	}
+	if enableWebhooks {
+		if err := (&controllers.IstioValidator{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Istio")
+			os.Exit(1)
+		}
+	}
+	if err := mgr.Add(&controllers.VersionPublisher{Client: mgr.GetClient(), ResourceDirectory: resourceDirectory}); err != nil {
+		setupLog.Error(err, "unable to set up version publisher")
+		os.Exit(1)
+	}
 	// +kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -18,13 +18,59 @@ var (
 )
 
 type OperatorConfig struct {
-	Images3_0 ImageConfig3_0 `properties:"images3_0"`
+	Images3_0 ImageConfig3_0  `properties:"images3_0"`
+	Images    ImagesConfig    `properties:"images"`
+	Registry  RegistryConfig  `properties:"registry"`
+	Helm      HelmConfig      `properties:"helm"`
+	Charts    ChartsConfig    `properties:"charts"`
+	Redaction RedactionConfig `properties:"redaction"`
 }
 
+// ImageConfig3_0 overrides the images in the image manifest of version 3.0
 type ImageConfig3_0 struct {
-	Istiod string `properties:"istiod"`
-	Proxy  string `properties:"proxy"`
-	CNI    string `properties:"cni"`
+	Istiod string `properties:"istiod,default="`
+	Proxy  string `properties:"proxy,default="`
+	CNI    string `properties:"cni,default="`
+}
+
+type ImagesConfig struct {
+	// PinDigests enables resolving the tags of the images to digests
+	PinDigests bool `properties:"pinDigests,default=false"`
+	// VerificationKey is the file containing the public key that the cosign
+	// signatures of the images are verified with. If empty, signatures aren't verified.
+	VerificationKey string `properties:"verificationKey,default="`
+	// InsecureRegistries, separated by semicolons, are accessed over plain HTTP
+	InsecureRegistries []string `properties:"insecureRegistries,default="`
+}
+
+type HelmConfig struct {
+	// Driver is the storage driver for helm releases (secret or configmap)
+	Driver string `properties:"driver,default=secret"`
+}
+
+type ChartsConfig struct {
+	// Sources, separated by semicolons, provide the charts of a version from a chart
+	// repository instead of the resource directory. Each source has the form
+	// <version>=<repository URL>@<chart version>.
+	Sources []string `properties:"sources,default="`
+	// CacheDirectory is where the charts fetched from the sources are stored. If
+	// empty, a directory in the system's temporary directory is used.
+	CacheDirectory string `properties:"cacheDirectory,default="`
+}
+
+type RegistryConfig struct {
+	// Mirrors, separated by semicolons, rewrite images and hubs that start with a
+	// source to its mirror. Each mirror has the form <source>=<mirror>.
+	Mirrors []string `properties:"mirrors,default="`
+	// PullSecret is the image pull Secret (<namespace>/<name>, or <name> in the
+	// operator namespace) that is copied to the istio and operator namespaces
+	PullSecret string `properties:"pullSecret,default="`
+}
+
+type RedactionConfig struct {
+	// Paths of sensitive values, separated by semicolons, that are redacted in
+	// addition to the default paths before values are logged or written to the status
+	Paths []string `properties:"paths,default="`
 }
 
 func ReadConfig(configFile string) error {
@@ -16,6 +16,10 @@ const (
 	// MemberOfKey represents the mesh (namespace) to which the resource relates
 	MemberOfKey = MetadataNamespace + "/member-of"
 
+	// MemberOfNameKey is used in annotations to record the name of the Istio resource
+	// whose mesh (see MemberOfKey) the namespace is a member of
+	MemberOfNameKey = MetadataNamespace + "/member-of-name"
+
 	// IgnoreNamespaceKey indicates that sidecar injection should be disabled for the namespace
 	IgnoreNamespaceKey = MetadataNamespace + "/ignore-namespace"
 
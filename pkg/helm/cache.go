@@ -0,0 +1,256 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	chartLoader "helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	chartCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "istio_operator_chart_cache_requests_total",
+		Help: "Number of chart lookups in the chart cache, partitioned by result (hit or miss)",
+	}, []string{"result"})
+
+	releaseCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "istio_operator_release_cache_requests_total",
+		Help: "Number of helm release lookups in the release cache, partitioned by result (hit or miss)",
+	}, []string{"result"})
+
+	charts   = newChartCache()
+	releases = newReleaseCache()
+)
+
+func init() {
+	metrics.Registry.MustRegister(chartCacheRequests, releaseCacheRequests)
+}
+
+// chartCache keeps loaded charts in memory. A cached chart is reloaded when
+// any of its files is modified, and the whole cache is dropped when the
+// ResourceDirectory changes. Charts loaded from archives in the chart source
+// cache are kept by path, since the path contains the digest of the archive.
+type chartCache struct {
+	mu          sync.Mutex
+	resourceDir string
+	charts      map[string]cachedChart
+	archives    map[string]cachedChart
+}
+
+type cachedChart struct {
+	chart   *chart.Chart
+	modTime time.Time
+	digest  string
+}
+
+func newChartCache() *chartCache {
+	return &chartCache{charts: make(map[string]cachedChart), archives: make(map[string]cachedChart)}
+}
+
+// Load returns the chart in the given directory, relative to the resourceDir
+func (c *chartCache) Load(resourceDir, chartDir string) (*chart.Chart, error) {
+	cached, err := c.get(resourceDir, chartDir)
+	if err != nil {
+		return nil, err
+	}
+	return copyChart(cached.chart), nil
+}
+
+// Digest returns a digest of all files of the chart in the given directory
+func (c *chartCache) Digest(resourceDir, chartDir string) (string, error) {
+	cached, err := c.get(resourceDir, chartDir)
+	if err != nil {
+		return "", err
+	}
+	return cached.digest, nil
+}
+
+func (c *chartCache) get(resourceDir, chartDir string) (cachedChart, error) {
+	chartPath := filepath.Join(resourceDir, chartDir)
+	modTime, err := latestModTime(chartPath)
+	if err != nil {
+		return cachedChart{}, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.resourceDir != resourceDir {
+		c.resourceDir = resourceDir
+		c.charts = make(map[string]cachedChart)
+	}
+
+	if cached, found := c.charts[chartDir]; found && cached.modTime.Equal(modTime) {
+		chartCacheRequests.WithLabelValues("hit").Inc()
+		return cached, nil
+	}
+	chartCacheRequests.WithLabelValues("miss").Inc()
+
+	loaded, err := chartLoader.Load(chartPath)
+	if err != nil {
+		return cachedChart{}, err
+	}
+	cached := cachedChart{chart: loaded, modTime: modTime, digest: digest(loaded)}
+	c.charts[chartDir] = cached
+	return cached, nil
+}
+
+func (c *chartCache) getArchive(archivePath string) (cachedChart, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cached, found := c.archives[archivePath]; found {
+		chartCacheRequests.WithLabelValues("hit").Inc()
+		return cached, nil
+	}
+	chartCacheRequests.WithLabelValues("miss").Inc()
+
+	loaded, err := chartLoader.LoadFile(archivePath)
+	if err != nil {
+		return cachedChart{}, err
+	}
+	cached := cachedChart{chart: loaded, digest: digest(loaded)}
+	c.archives[archivePath] = cached
+	return cached, nil
+}
+
+// digest returns the sha256 digest of the names and contents of all chart files
+func digest(c *chart.Chart) string {
+	files := make([]*chart.File, len(c.Raw))
+	copy(files, c.Raw)
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	h := sha256.New()
+	for _, f := range files {
+		h.Write([]byte(f.Name))
+		h.Write([]byte{0})
+		h.Write(f.Data)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// copyChart returns a shallow copy of the chart, so that helm actions that modify
+// the chart's top-level fields (e.g. its dependencies) don't modify the cached chart
+func copyChart(c *chart.Chart) *chart.Chart {
+	chartCopy := *c
+	return &chartCopy
+}
+
+// latestModTime returns the latest modification time of all files in the directory
+func latestModTime(dir string) (time.Time, error) {
+	var latest time.Time
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+		return nil
+	})
+	return latest, err
+}
+
+// releaseCache remembers which releases are installed, so that the releases
+// don't need to be read from the storage on every reconciliation. The operator
+// is the only one that installs and uninstalls these releases; any failed helm
+// action or drift check invalidates the cached entry. Since releases are installed
+// in several clusters, the entries are keyed by the cluster's API server address.
+type releaseCache struct {
+	mu        sync.Mutex
+	installed map[releaseKey]bool
+}
+
+type releaseKey struct {
+	cluster   string
+	namespace string
+	name      string
+}
+
+func newReleaseCache() *releaseCache {
+	return &releaseCache{installed: make(map[releaseKey]bool)}
+}
+
+// newReleaseKey returns the key of the release in the cluster that the action config
+// connects to
+func newReleaseKey(cfg *action.Configuration, namespace, releaseName string) releaseKey {
+	key := releaseKey{namespace: namespace, name: releaseName}
+	if cfg.RESTClientGetter != nil {
+		if restConfig, err := cfg.RESTClientGetter.ToRESTConfig(); err == nil {
+			key.cluster = restConfig.Host
+		}
+	}
+	return key
+}
+
+// IsInstalled returns whether a deployed (or failed) release with the given name exists
+func (c *releaseCache) IsInstalled(cfg *action.Configuration, namespace, releaseName string) (bool, error) {
+	key := newReleaseKey(cfg, namespace, releaseName)
+	c.mu.Lock()
+	installed, found := c.installed[key]
+	c.mu.Unlock()
+	if found {
+		releaseCacheRequests.WithLabelValues("hit").Inc()
+		return installed, nil
+	}
+	releaseCacheRequests.WithLabelValues("miss").Inc()
+
+	rel, err := cfg.Releases.Last(releaseName)
+	if errors.Is(err, driver.ErrReleaseNotFound) {
+		installed = false
+	} else if err != nil {
+		return false, err
+	} else {
+		installed = rel.Info.Status == release.StatusDeployed || rel.Info.Status == release.StatusFailed
+	}
+
+	c.Set(cfg, namespace, releaseName, installed)
+	return installed, nil
+}
+
+// Set records whether the release is installed
+func (c *releaseCache) Set(cfg *action.Configuration, namespace, releaseName string, installed bool) {
+	key := newReleaseKey(cfg, namespace, releaseName)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.installed[key] = installed
+}
+
+// Invalidate removes the release from the cache
+func (c *releaseCache) Invalidate(cfg *action.Configuration, namespace, releaseName string) {
+	key := newReleaseKey(cfg, namespace, releaseName)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.installed, key)
+}
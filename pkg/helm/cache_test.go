@@ -0,0 +1,116 @@
+package helm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	"k8s.io/client-go/rest"
+)
+
+func TestChartCache(t *testing.T) {
+	resourceDir := t.TempDir()
+	chartDir := filepath.Join("v3.0", "charts", "test")
+	chartFile := filepath.Join(resourceDir, chartDir, "Chart.yaml")
+	writeChart := func(version string, modTime time.Time) {
+		if err := os.MkdirAll(filepath.Dir(chartFile), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(chartFile, []byte("apiVersion: v2\nname: test\nversion: "+version+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(chartFile, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cache := newChartCache()
+	hits := chartCacheRequests.WithLabelValues("hit")
+	misses := chartCacheRequests.WithLabelValues("miss")
+	load := func(expectVersion string, expectHits, expectMisses float64) {
+		t.Helper()
+		initialHits, initialMisses := testutil.ToFloat64(hits), testutil.ToFloat64(misses)
+		chart, err := cache.Load(resourceDir, chartDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if chart.Metadata.Version != expectVersion {
+			t.Errorf("expected chart version %s, got %s", expectVersion, chart.Metadata.Version)
+		}
+		if actual := testutil.ToFloat64(hits) - initialHits; actual != expectHits {
+			t.Errorf("expected %v cache hits, got %v", expectHits, actual)
+		}
+		if actual := testutil.ToFloat64(misses) - initialMisses; actual != expectMisses {
+			t.Errorf("expected %v cache misses, got %v", expectMisses, actual)
+		}
+	}
+
+	now := time.Now()
+	writeChart("1.0.0", now.Add(-time.Hour))
+	load("1.0.0", 0, 1)
+	load("1.0.0", 1, 0)
+
+	// modified charts are reloaded
+	writeChart("1.1.0", now.Add(time.Hour))
+	load("1.1.0", 0, 1)
+	load("1.1.0", 1, 0)
+
+	// the cache is dropped when the resource directory changes
+	cache.charts[chartDir] = cachedChart{}
+	cache.resourceDir = "/some/other/dir"
+	load("1.1.0", 0, 1)
+}
+
+func TestReleaseCache(t *testing.T) {
+	cfg := &action.Configuration{Releases: storage.Init(driver.NewMemory())}
+	cache := newReleaseCache()
+
+	installed, err := cache.IsInstalled(cfg, "istio-system", "test-istiod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if installed {
+		t.Error("expected release to not be installed")
+	}
+
+	// the cached result is returned until the entry is updated
+	if err := cfg.Releases.Create(&release.Release{
+		Name:      "test-istiod",
+		Namespace: "istio-system",
+		Version:   1,
+		Info:      &release.Info{Status: release.StatusDeployed},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if installed, _ := cache.IsInstalled(cfg, "istio-system", "test-istiod"); installed {
+		t.Error("expected cached result")
+	}
+
+	cache.Invalidate(cfg, "istio-system", "test-istiod")
+	if installed, _ := cache.IsInstalled(cfg, "istio-system", "test-istiod"); !installed {
+		t.Error("expected release to be installed after the cache entry was invalidated")
+	}
+
+	cache.Set(cfg, "istio-system", "test-istiod", false)
+	if installed, _ := cache.IsInstalled(cfg, "istio-system", "test-istiod"); installed {
+		t.Error("expected release to not be installed after the cache entry was set")
+	}
+
+	// the entries of releases in other clusters are separate
+	remote := &action.Configuration{
+		Releases:         cfg.Releases,
+		RESTClientGetter: NewRESTClientGetter(&rest.Config{Host: "https://cluster2:6443"}),
+	}
+	if installed, _ := cache.IsInstalled(remote, "istio-system", "test-istiod"); !installed {
+		t.Error("expected release in other cluster to be read from the storage")
+	}
+	if installed, _ := cache.IsInstalled(cfg, "istio-system", "test-istiod"); installed {
+		t.Error("expected cached result of the release in the first cluster to be unchanged")
+	}
+}
@@ -19,15 +19,16 @@ package helm
 import (
 	"context"
 	"fmt"
-	"os"
 	"path"
 	"path/filepath"
 
 	"helm.sh/helm/v3/pkg/action"
-	chartLoader "helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/postrender"
 	"helm.sh/helm/v3/pkg/release"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/cli-runtime/pkg/resource"
+	"maistra.io/istio-operator/pkg/common"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
@@ -36,118 +37,176 @@ var (
 	ResourceDirectory, _ = filepath.Abs("resources")
 )
 
-func UninstallCharts(restClientGetter genericclioptions.RESTClientGetter, charts map[string]string, releaseNameBase, ns string) error {
+// UninstallChart uninstalls the release with the given name, if it exists
+func UninstallChart(restClientGetter genericclioptions.RESTClientGetter, releaseName, ns string) error {
 	actionConfig, err := newActionConfig(restClientGetter, ns)
 	if err != nil {
 		return err
 	}
-	for _, suffix := range charts {
-		_, err = uninstallChart(actionConfig, ns, releaseNameBase+suffix)
-		if err != nil {
-			return err
-		}
+	_, err = uninstallChart(actionConfig, ns, releaseName)
+	return err
+}
+
+// UpgradeOrInstallChart upgrades the release with the given name to the chart, or
+// installs the chart if the release doesn't exist yet. If postRenderer isn't nil,
+// it modifies the rendered manifests before they are applied.
+func UpgradeOrInstallChart(
+	ctx context.Context, restClientGetter genericclioptions.RESTClientGetter,
+	chartName string, values map[string]interface{},
+	chartVersion, releaseName, ns string, ownerReference metav1.OwnerReference, istioNamespace string,
+	postRenderer postrender.PostRenderer,
+) error {
+	actionConfig, err := newActionConfig(restClientGetter, ns)
+	if err != nil {
+		return err
 	}
-	return nil
+	visitor := newResourceVisitor(ownerReference, istioNamespace)
+	_, err = upgradeOrInstallChart(ctx, actionConfig, chartName, chartVersion, ns, releaseName, visitor, postRenderer, values)
+	return err
 }
 
-func UpgradeOrInstallCharts(
+// UpgradeOrInstallRemoteChart is like UpgradeOrInstallChart, but installs the chart
+// in a cluster other than the one of the primary resource. Since OwnerReferences
+// can't point to another cluster, all resources are marked with the primary-resource
+// annotations instead.
+func UpgradeOrInstallRemoteChart(
 	ctx context.Context, restClientGetter genericclioptions.RESTClientGetter,
-	charts map[string]string, values map[string]interface{},
-	chartVersion, releaseNameBase, ns string, ownerReference metav1.OwnerReference, istioNamespace string,
+	chartName string, values map[string]interface{},
+	chartVersion, releaseName, ns string, ownerReference metav1.OwnerReference, istioNamespace string,
 ) error {
 	actionConfig, err := newActionConfig(restClientGetter, ns)
 	if err != nil {
 		return err
 	}
-	for chartName, suffix := range charts {
-		_, err = upgradeOrInstallChart(ctx, actionConfig, chartName, chartVersion, ns, releaseNameBase+suffix, ownerReference, istioNamespace, values)
-		if err != nil {
-			return err
-		}
+	visitor := newRemoteResourceVisitor(ownerReference, istioNamespace)
+	_, err = upgradeOrInstallChart(ctx, actionConfig, chartName, chartVersion, ns, releaseName, visitor, nil, values)
+	return err
+}
+
+// ChartDigest returns a digest of all files of the given chart, which changes
+// whenever the chart is modified
+func ChartDigest(ctx context.Context, chartVersion, chartName string) (string, error) {
+	cached, err := loadChart(ctx, chartVersion, chartName)
+	if err != nil {
+		return "", err
 	}
-	return nil
+	return cached.digest, nil
 }
 
-// newActionConfig Create a new Helm action config from in-cluster service account
-func newActionConfig(restClientGetter genericclioptions.RESTClientGetter, namespace string) (*action.Configuration, error) {
-	actionConfig := new(action.Configuration)
-	if err := actionConfig.Init(restClientGetter, namespace, os.Getenv("HELM_DRIVER"), logger.V(2).Info); err != nil {
-		return nil, err
+// loadChart returns the chart in the ResourceDirectory, or the chart with the same
+// name from the chart source of the version, if it has one
+func loadChart(ctx context.Context, chartVersion, chartName string) (cachedChart, error) {
+	local, err := charts.get(ResourceDirectory, path.Join(chartVersion, "charts", chartName))
+	if err != nil {
+		return cachedChart{}, err
+	}
+	archivePath, found, err := Sources.Archive(ctx, chartVersion, local.chart.Name())
+	if err != nil || !found {
+		return local, err
+	}
+	remote, err := charts.getArchive(archivePath)
+	if err != nil {
+		return cachedChart{}, err
+	}
+	if remote.chart.Name() != local.chart.Name() {
+		return cachedChart{}, fmt.Errorf("chart source of version %s provides chart %s instead of %s",
+			chartVersion, remote.chart.Name(), local.chart.Name())
 	}
-	return actionConfig, nil
+	return remote, nil
+}
+
+// newActionConfig Create a new Helm action config from in-cluster service account,
+// using the storage driver from the operator config
+func newActionConfig(restClientGetter genericclioptions.RESTClientGetter, namespace string) (*action.Configuration, error) {
+	return newActionConfigForDriver(restClientGetter, namespace, common.Config.Helm.Driver)
 }
 
 // upgradeOrInstallChart upgrades a chart in cluster or installs it new if it does not already exist
 func upgradeOrInstallChart(ctx context.Context, cfg *action.Configuration,
-	chartName, chartVersion, namespace, releaseName string, ownerReference metav1.OwnerReference, istioNamespace string,
-	values map[string]interface{},
+	chartName, chartVersion, namespace, releaseName string, visitor resource.VisitorFunc,
+	postRenderer postrender.PostRenderer, values map[string]interface{},
 ) (*release.Release, error) {
-	// Helm List Action
-	listAction := action.NewList(cfg)
-	releases, err := listAction.Run()
+	toUpgrade, err := releases.IsInstalled(cfg, namespace, releaseName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list installed helm releases: %v", err)
+		return nil, fmt.Errorf("failed to get installed helm release %s: %v", releaseName, err)
 	}
 
-	toUpgrade := false
-	for _, release := range releases {
-		if release.Name == releaseName && release.Namespace == namespace {
-			toUpgrade = true
-		}
-	}
-
-	chart, err := chartLoader.Load(path.Join(ResourceDirectory, chartVersion, "charts", chartName))
+	loaded, err := loadChart(ctx, chartVersion, chartName)
 	if err != nil {
 		return nil, err
 	}
+	chart := copyChart(loaded.chart)
 	var rel *release.Release
 	if toUpgrade {
 		logger.V(2).Info("Performing helm upgrade", "chartName", chart.Name())
 		updateAction := action.NewUpgrade(cfg)
-		updateAction.ResourceVisitor = addOwnerReferenceVisitor(ownerReference, istioNamespace)
+		updateAction.ResourceVisitor = visitor
+		updateAction.PostRenderer = postRenderer
 		updateAction.MaxHistory = 1
 		updateAction.SkipCRDs = true
 		rel, err = updateAction.RunWithContext(ctx, releaseName, chart, values)
 		if err != nil {
-			return nil, fmt.Errorf("failed to update helm chart %s: %v", chart.Name(), err)
+			releases.Invalidate(cfg, namespace, releaseName)
+			return nil, fmt.Errorf("failed to update helm chart %s: %w", chart.Name(), err)
 		}
 
 	} else {
 		logger.V(2).Info("Performing helm install", "chartName", chart.Name())
 		installAction := action.NewInstall(cfg)
-		installAction.ResourceVisitor = addOwnerReferenceVisitor(ownerReference, istioNamespace)
+		installAction.ResourceVisitor = visitor
+		installAction.PostRenderer = postRenderer
 		installAction.Namespace = namespace
 		installAction.ReleaseName = releaseName
 		installAction.SkipCRDs = true
 		rel, err = installAction.RunWithContext(ctx, chart, values)
 		if err != nil {
-			return nil, fmt.Errorf("failed to install helm chart %s: %v", chart.Name(), err)
+			releases.Invalidate(cfg, namespace, releaseName)
+			return nil, fmt.Errorf("failed to install helm chart %s: %w", chart.Name(), err)
 		}
 	}
+	releases.Set(cfg, namespace, releaseName, true)
 	return rel, nil
 }
 
+// newResourceVisitor returns a visitor function that refuses to take over resources
+// belonging to another primary resource and marks all others as owned by ownerReference
+func newResourceVisitor(ownerReference metav1.OwnerReference, istioNamespace string) resource.VisitorFunc {
+	checkConflict := checkConflictVisitor(ownerReference, istioNamespace)
+	addOwnerReference := addOwnerReferenceVisitor(ownerReference, istioNamespace)
+	return func(info *resource.Info, err error) error {
+		if err := checkConflict(info, err); err != nil {
+			return err
+		}
+		return addOwnerReference(info, nil)
+	}
+}
+
+// newRemoteResourceVisitor returns a visitor function like newResourceVisitor, but
+// marks all resources with the primary-resource annotations
+func newRemoteResourceVisitor(ownerReference metav1.OwnerReference, istioNamespace string) resource.VisitorFunc {
+	checkConflict := checkConflictVisitor(ownerReference, istioNamespace)
+	addOwnerAnnotations := addOwnerAnnotationsVisitor(ownerReference, istioNamespace)
+	return func(info *resource.Info, err error) error {
+		if err := checkConflict(info, err); err != nil {
+			return err
+		}
+		return addOwnerAnnotations(info, nil)
+	}
+}
+
 // uninstallChart removes a chart from the cluster
 func uninstallChart(cfg *action.Configuration, namespace, releaseName string) (*release.UninstallReleaseResponse, error) {
-	// Helm List Action
-	listAction := action.NewList(cfg)
-	releases, err := listAction.Run()
+	found, err := releases.IsInstalled(cfg, namespace, releaseName)
 	if err != nil {
 		return nil, err
 	}
-
-	found := false
-	for _, release := range releases {
-		if release.Name == releaseName && release.Namespace == namespace {
-			found = true
-		}
-	}
 	if !found {
 		return nil, nil
 	}
 
 	uninstallAction := action.NewUninstall(cfg)
 	response, err := uninstallAction.Run(releaseName)
+	releases.Invalidate(cfg, namespace, releaseName)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,92 @@
+package helm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cli-runtime/pkg/resource"
+)
+
+// ConflictError indicates that a resource rendered for one primary resource
+// already exists in the cluster and belongs to another primary resource
+type ConflictError struct {
+	Kind      string
+	Name      string
+	Namespace string
+	Owner     types.NamespacedName
+}
+
+func (e *ConflictError) Error() string {
+	name := e.Name
+	if e.Namespace != "" {
+		name = e.Namespace + "/" + e.Name
+	}
+	return fmt.Sprintf("%s %s is already managed by %s", e.Kind, name, e.Owner)
+}
+
+// IsConflict returns true if the error (or one of the errors it wraps) is a ConflictError
+func IsConflict(err error) bool {
+	var conflictErr *ConflictError
+	return errors.As(err, &conflictErr)
+}
+
+// checkConflictVisitor returns a visitor function that fails if a resource
+// already exists in the cluster and belongs to a primary resource other than
+// the owner of the release (as recorded in its OwnerReferences or the
+// primary-resource annotations).
+func checkConflictVisitor(ownerReference metav1.OwnerReference, istioNamespace string) resource.VisitorFunc {
+	owner := types.NamespacedName{Namespace: istioNamespace, Name: ownerReference.Name}
+	return func(info *resource.Info, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Client == nil || info.Mapping == nil {
+			return nil
+		}
+
+		existing, err := resource.NewHelper(info.Client, info.Mapping).Get(info.Namespace, info.Name)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil
+			}
+			return err
+		}
+		objMeta, err := meta.Accessor(existing)
+		if err != nil {
+			return err
+		}
+
+		if existingOwner := getPrimaryResource(objMeta, ownerReference); existingOwner != nil && *existingOwner != owner {
+			return &ConflictError{
+				Kind:      info.Mapping.GroupVersionKind.Kind,
+				Name:      info.Name,
+				Namespace: info.Namespace,
+				Owner:     *existingOwner,
+			}
+		}
+		return nil
+	}
+}
+
+// getPrimaryResource returns the primary resource of the given kind that the
+// object belongs to, or nil if it doesn't belong to one
+func getPrimaryResource(obj metav1.Object, ownerReference metav1.OwnerReference) *types.NamespacedName {
+	ownerAPIGroup, _, _ := strings.Cut(ownerReference.APIVersion, "/")
+	for _, ref := range obj.GetOwnerReferences() {
+		refAPIGroup, _, _ := strings.Cut(ref.APIVersion, "/")
+		if ref.Controller != nil && *ref.Controller && ref.Kind == ownerReference.Kind && refAPIGroup == ownerAPIGroup {
+			return &types.NamespacedName{Namespace: obj.GetNamespace(), Name: ref.Name}
+		}
+	}
+
+	namespacedName, kind, apiGroup := GetOwnerFromAnnotations(obj.GetAnnotations())
+	if namespacedName != nil && kind == ownerReference.Kind && apiGroup == ownerAPIGroup {
+		return namespacedName
+	}
+	return nil
+}
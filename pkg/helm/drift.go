@@ -0,0 +1,216 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	apiresource "k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/cli-runtime/pkg/resource"
+)
+
+// kinds whose contents are modified at runtime (e.g. istiod patches the caBundle and
+// failurePolicy of its webhooks), so the drift check only verifies that they exist
+var presenceOnlyKinds = map[string]bool{
+	"MutatingWebhookConfiguration":   true,
+	"ValidatingWebhookConfiguration": true,
+}
+
+// HasDrifted returns whether the resources of a deployed release no longer match
+// the release manifest, i.e. whether a resource is missing or any of the fields set
+// in the manifest has a different value in the cluster. Fields that aren't set in
+// the manifest (e.g. defaults and the status) are ignored. If the release has
+// drifted or the check fails, the cached state of the release is invalidated, since
+// the release may have been modified (e.g. deleted or rolled back) out of band.
+func HasDrifted(restClientGetter genericclioptions.RESTClientGetter, namespace, releaseName string) (bool, error) {
+	cfg, err := newActionConfig(restClientGetter, namespace)
+	if err != nil {
+		return false, err
+	}
+	drifted, err := hasDrifted(cfg, releaseName)
+	if drifted || err != nil {
+		releases.Invalidate(cfg, namespace, releaseName)
+	}
+	return drifted, err
+}
+
+func hasDrifted(cfg *action.Configuration, releaseName string) (bool, error) {
+	rel, err := cfg.Releases.Deployed(releaseName)
+	if errors.Is(err, driver.ErrNoDeployedReleases) || errors.Is(err, driver.ErrReleaseNotFound) {
+		logger.V(2).Info("Release not deployed", "release", releaseName)
+		return true, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to get release %s: %v", releaseName, err)
+	}
+
+	resources, err := cfg.KubeClient.Build(bytes.NewBufferString(rel.Manifest), false)
+	if err != nil {
+		return false, fmt.Errorf("failed to build resources of release %s: %v", releaseName, err)
+	}
+
+	drifted := false
+	err = resources.Visit(func(info *resource.Info, err error) error {
+		if err != nil || drifted {
+			return err
+		}
+		ns := info.Namespace
+		if ns == "" && info.Mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			ns = rel.Namespace
+		}
+		live, err := resource.NewHelper(info.Client, info.Mapping).Get(ns, info.Name)
+		if apierrors.IsNotFound(err) {
+			logger.V(2).Info("Resource of release is missing", "release", releaseName, "kind", info.Mapping.GroupVersionKind.Kind, "name", info.Name)
+			drifted = true
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if presenceOnlyKinds[info.Mapping.GroupVersionKind.Kind] {
+			return nil
+		}
+		if !matchesManifest(info.Object, live) {
+			logger.V(2).Info("Resource of release was modified", "release", releaseName, "kind", info.Mapping.GroupVersionKind.Kind, "name", info.Name)
+			drifted = true
+		}
+		return nil
+	})
+	return drifted, err
+}
+
+// matchesManifest returns whether the live object contains all fields of the
+// object in the manifest (ignoring metadata other than labels and annotations)
+func matchesManifest(expected, live runtime.Object) bool {
+	expectedMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(expected)
+	if err != nil {
+		return false
+	}
+	liveMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(live)
+	if err != nil {
+		return false
+	}
+
+	for key, value := range expectedMap {
+		switch key {
+		case "metadata":
+			expectedMeta, _ := value.(map[string]interface{})
+			liveMeta, _ := liveMap[key].(map[string]interface{})
+			for _, field := range []string{"labels", "annotations"} {
+				if !isSubset(expectedMeta[field], liveMeta[field]) {
+					return false
+				}
+			}
+		case "stringData":
+			// the API server converts stringData to data
+			continue
+		default:
+			if !isSubset(value, liveMap[key]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isSubset returns whether all fields set in expected have the same value in actual
+func isSubset(expected, actual interface{}) bool {
+	switch e := expected.(type) {
+	case nil:
+		return true
+	case map[string]interface{}:
+		a, ok := actual.(map[string]interface{})
+		if !ok {
+			return len(e) == 0 && actual == nil
+		}
+		for key, value := range e {
+			if !isSubset(value, a[key]) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		a, ok := actual.([]interface{})
+		if !ok {
+			return len(e) == 0 && actual == nil
+		}
+		if len(e) != len(a) {
+			return false
+		}
+		for i := range e {
+			if !isSubset(e[i], a[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		if expectedNumber, ok := toFloat(expected); ok {
+			if actualNumber, ok := toFloat(actual); ok {
+				return expectedNumber == actualNumber
+			}
+		}
+		if reflect.DeepEqual(expected, actual) {
+			return true
+		}
+		// the API server canonicalizes quantities, e.g. 2048Mi becomes 2Gi and 2000m becomes 2
+		expectedQuantity, ok := toQuantity(expected)
+		if !ok {
+			return false
+		}
+		actualQuantity, ok := toQuantity(actual)
+		return ok && expectedQuantity.Cmp(actualQuantity) == 0
+	}
+}
+
+// toQuantity parses a string, or a number that the manifest sets for a quantity
+// field, as a resource quantity
+func toQuantity(value interface{}) (apiresource.Quantity, bool) {
+	if number, ok := toFloat(value); ok {
+		value = strconv.FormatFloat(number, 'f', -1, 64)
+	}
+	s, ok := value.(string)
+	if !ok {
+		return apiresource.Quantity{}, false
+	}
+	quantity, err := apiresource.ParseQuantity(s)
+	return quantity, err == nil
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
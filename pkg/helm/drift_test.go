@@ -0,0 +1,184 @@
+package helm
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestMatchesManifest(t *testing.T) {
+	manifest := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata": map[string]interface{}{
+			"name":      "istiod",
+			"namespace": "istio-system",
+			"labels":    map[string]interface{}{"app": "istiod"},
+		},
+		"spec": map[string]interface{}{
+			"ports": []interface{}{
+				map[string]interface{}{"name": "grpc-xds", "port": int64(15010)},
+			},
+			"selector": map[string]interface{}{"app": "istiod"},
+		},
+	}
+
+	testCases := []struct {
+		name   string
+		live   map[string]interface{}
+		expect bool
+	}{
+		{
+			name: "defaults and metadata added by the cluster",
+			live: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Service",
+				"metadata": map[string]interface{}{
+					"name":            "istiod",
+					"namespace":       "istio-system",
+					"resourceVersion": "123",
+					"labels":          map[string]interface{}{"app": "istiod", "extra": "label"},
+					"annotations":     map[string]interface{}{"operator-sdk/primary-resource": "istio-system/default"},
+				},
+				"spec": map[string]interface{}{
+					"clusterIP": "10.0.0.1",
+					"ports": []interface{}{
+						map[string]interface{}{"name": "grpc-xds", "port": float64(15010), "protocol": "TCP"},
+					},
+					"selector": map[string]interface{}{"app": "istiod"},
+				},
+				"status": map[string]interface{}{},
+			},
+			expect: true,
+		},
+		{
+			name: "modified field",
+			live: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Service",
+				"metadata": map[string]interface{}{
+					"name":      "istiod",
+					"namespace": "istio-system",
+					"labels":    map[string]interface{}{"app": "istiod"},
+				},
+				"spec": map[string]interface{}{
+					"ports": []interface{}{
+						map[string]interface{}{"name": "grpc-xds", "port": int64(15011)},
+					},
+					"selector": map[string]interface{}{"app": "istiod"},
+				},
+			},
+			expect: false,
+		},
+		{
+			name: "removed label",
+			live: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Service",
+				"metadata": map[string]interface{}{
+					"name":      "istiod",
+					"namespace": "istio-system",
+				},
+				"spec": map[string]interface{}{
+					"ports": []interface{}{
+						map[string]interface{}{"name": "grpc-xds", "port": int64(15010)},
+					},
+					"selector": map[string]interface{}{"app": "istiod"},
+				},
+			},
+			expect: false,
+		},
+		{
+			name: "added list element",
+			live: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Service",
+				"metadata": map[string]interface{}{
+					"name":      "istiod",
+					"namespace": "istio-system",
+					"labels":    map[string]interface{}{"app": "istiod"},
+				},
+				"spec": map[string]interface{}{
+					"ports": []interface{}{
+						map[string]interface{}{"name": "grpc-xds", "port": int64(15010)},
+						map[string]interface{}{"name": "https-dns", "port": int64(15012)},
+					},
+					"selector": map[string]interface{}{"app": "istiod"},
+				},
+			},
+			expect: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			expected := &unstructured.Unstructured{Object: manifest}
+			live := &unstructured.Unstructured{Object: tc.live}
+			if actual := matchesManifest(expected, live); actual != tc.expect {
+				t.Errorf("matchesManifest() = %v, expected %v", actual, tc.expect)
+			}
+		})
+	}
+}
+
+func TestMatchesManifestQuantities(t *testing.T) {
+	deployment := func(resources map[string]interface{}) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata":   map[string]interface{}{"name": "istiod", "namespace": "istio-system"},
+			"spec": map[string]interface{}{
+				"template": map[string]interface{}{
+					"spec": map[string]interface{}{
+						"containers": []interface{}{
+							map[string]interface{}{"name": "discovery", "resources": resources},
+						},
+					},
+				},
+			},
+		}}
+	}
+	manifest := deployment(map[string]interface{}{
+		"requests": map[string]interface{}{"cpu": "500m", "memory": "2048Mi"},
+		"limits":   map[string]interface{}{"cpu": "2000m", "memory": int64(4294967296)},
+	})
+
+	testCases := []struct {
+		name      string
+		resources map[string]interface{}
+		expect    bool
+	}{
+		{
+			name: "canonicalized by the API server",
+			resources: map[string]interface{}{
+				"requests": map[string]interface{}{"cpu": "500m", "memory": "2Gi"},
+				"limits":   map[string]interface{}{"cpu": "2", "memory": "4Gi"},
+			},
+			expect: true,
+		},
+		{
+			name: "modified request",
+			resources: map[string]interface{}{
+				"requests": map[string]interface{}{"cpu": "500m", "memory": "1Gi"},
+				"limits":   map[string]interface{}{"cpu": "2", "memory": "4Gi"},
+			},
+			expect: false,
+		},
+		{
+			name: "modified limit",
+			resources: map[string]interface{}{
+				"requests": map[string]interface{}{"cpu": "500m", "memory": "2Gi"},
+				"limits":   map[string]interface{}{"cpu": "1", "memory": "4Gi"},
+			},
+			expect: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := matchesManifest(manifest, deployment(tc.resources)); actual != tc.expect {
+				t.Errorf("matchesManifest() = %v, expected %v", actual, tc.expect)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helm
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"helm.sh/helm/v3/pkg/postrender"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/kubernetes/scheme"
+	"maistra.io/istio-operator/api/v1alpha1"
+	"sigs.k8s.io/yaml"
+)
+
+// overlayPostRenderer applies overlays to the manifests rendered by Helm
+type overlayPostRenderer struct {
+	overlays []v1alpha1.Overlay
+}
+
+var _ postrender.PostRenderer = &overlayPostRenderer{}
+
+// NewOverlayPostRenderer returns a PostRenderer that patches the rendered objects
+// matching the targets of the given overlays. Rendering fails if an overlay doesn't
+// match any object. If there are no overlays, it returns nil.
+func NewOverlayPostRenderer(overlays []v1alpha1.Overlay) postrender.PostRenderer {
+	if len(overlays) == 0 {
+		return nil
+	}
+	return &overlayPostRenderer{overlays: overlays}
+}
+
+func (r *overlayPostRenderer) Run(renderedManifests *bytes.Buffer) (*bytes.Buffer, error) {
+	manifests := releaseutil.SplitManifests(renderedManifests.String())
+	keys := make([]string, 0, len(manifests))
+	for key := range manifests {
+		keys = append(keys, key)
+	}
+	sort.Sort(releaseutil.BySplitManifestsOrder(keys))
+
+	matched := make([]bool, len(r.overlays))
+	out := &bytes.Buffer{}
+	for _, key := range keys {
+		manifest, err := r.patchManifest(manifests[key], matched)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(out, "---\n%s\n", manifest)
+	}
+
+	for i, overlay := range r.overlays {
+		if !matched[i] {
+			return nil, fmt.Errorf("overlay for %s didn't match any object", describeTarget(overlay.Target))
+		}
+	}
+	return out, nil
+}
+
+// patchManifest applies all overlays that match the object in the manifest and
+// records which of them matched
+func (r *overlayPostRenderer) patchManifest(manifest string, matched []bool) (string, error) {
+	obj := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal([]byte(manifest), &obj.Object); err != nil {
+		return "", fmt.Errorf("failed to parse rendered manifest: %v", err)
+	}
+
+	var patchedJSON []byte
+	for i, overlay := range r.overlays {
+		if !matchesTarget(obj, overlay.Target) {
+			continue
+		}
+		matched[i] = true
+		var err error
+		if patchedJSON == nil {
+			if patchedJSON, err = obj.MarshalJSON(); err != nil {
+				return "", err
+			}
+		}
+		if patchedJSON, err = applyOverlay(obj, patchedJSON, overlay); err != nil {
+			return "", fmt.Errorf("failed to apply overlay to %s %s: %v", obj.GetKind(), obj.GetName(), err)
+		}
+	}
+	if patchedJSON == nil {
+		return manifest, nil
+	}
+
+	patched, err := yaml.JSONToYAML(patchedJSON)
+	if err != nil {
+		return "", err
+	}
+	return string(patched), nil
+}
+
+// applyOverlay applies the patch of the overlay to the JSON document of obj
+func applyOverlay(obj *unstructured.Unstructured, doc []byte, overlay v1alpha1.Overlay) ([]byte, error) {
+	patch, err := yaml.YAMLToJSON([]byte(overlay.Patch))
+	if err != nil {
+		return nil, fmt.Errorf("invalid patch: %v", err)
+	}
+
+	switch overlay.Type {
+	case v1alpha1.OverlayPatchTypeJSON:
+		jsonPatch, err := jsonpatch.DecodePatch(patch)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON patch: %v", err)
+		}
+		return jsonPatch.Apply(doc)
+	case v1alpha1.OverlayPatchTypeStrategicMerge, "":
+		// strategic merge patches need the Go type of the object; all other
+		// objects (e.g. Istio's custom resources) get a JSON merge patch
+		typed, err := scheme.Scheme.New(obj.GroupVersionKind())
+		if err != nil {
+			return jsonpatch.MergePatch(doc, patch)
+		}
+		return strategicpatch.StrategicMergePatch(doc, patch, typed)
+	default:
+		return nil, fmt.Errorf("unknown patch type %q", overlay.Type)
+	}
+}
+
+func matchesTarget(obj *unstructured.Unstructured, target v1alpha1.OverlayTarget) bool {
+	gvk := obj.GroupVersionKind()
+	return gvk.Kind == target.Kind &&
+		(target.Group == "" || gvk.Group == target.Group) &&
+		(target.Name == "" || obj.GetName() == target.Name)
+}
+
+func describeTarget(target v1alpha1.OverlayTarget) string {
+	kind := target.Kind
+	if target.Group != "" {
+		kind += "." + target.Group
+	}
+	if target.Name == "" {
+		return kind
+	}
+	return kind + " " + target.Name
+}
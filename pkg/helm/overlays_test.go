@@ -0,0 +1,166 @@
+package helm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"maistra.io/istio-operator/api/v1alpha1"
+	"sigs.k8s.io/yaml"
+)
+
+const renderedManifests = `---
+# Source: istiod/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: istiod
+spec:
+  template:
+    spec:
+      containers:
+      - name: discovery
+        image: pilot
+        env:
+        - name: A
+          value: a
+---
+# Source: istiod/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: istiod
+---
+# Source: istiod/templates/envoyfilter.yaml
+apiVersion: networking.istio.io/v1alpha3
+kind: EnvoyFilter
+metadata:
+  name: stats
+spec:
+  priority: 1
+`
+
+func TestOverlayPostRenderer(t *testing.T) {
+	testCases := []struct {
+		name      string
+		overlays  []v1alpha1.Overlay
+		expectErr string
+		check     func(t *testing.T, objs map[string]*unstructured.Unstructured)
+	}{
+		{
+			name: "strategic merge patch merges lists by key",
+			overlays: []v1alpha1.Overlay{{
+				Target: v1alpha1.OverlayTarget{Group: "apps", Kind: "Deployment", Name: "istiod"},
+				Patch: `
+spec:
+  template:
+    spec:
+      containers:
+      - name: discovery
+        env:
+        - name: B
+          value: b
+      tolerations:
+      - key: dedicated
+        operator: Exists`,
+			}},
+			check: func(t *testing.T, objs map[string]*unstructured.Unstructured) {
+				containers, _, _ := unstructured.NestedSlice(objs["Deployment"].Object, "spec", "template", "spec", "containers")
+				if len(containers) != 1 {
+					t.Fatalf("expected 1 container, got %v", containers)
+				}
+				container := containers[0].(map[string]interface{})
+				if container["image"] != "pilot" || len(container["env"].([]interface{})) != 2 {
+					t.Errorf("expected merged container, got %v", container)
+				}
+				tolerations, _, _ := unstructured.NestedSlice(objs["Deployment"].Object, "spec", "template", "spec", "tolerations")
+				if len(tolerations) != 1 {
+					t.Errorf("expected toleration, got %v", tolerations)
+				}
+			},
+		},
+		{
+			name: "JSON patch",
+			overlays: []v1alpha1.Overlay{{
+				Target: v1alpha1.OverlayTarget{Kind: "Service"},
+				Type:   v1alpha1.OverlayPatchTypeJSON,
+				Patch:  `[{"op": "add", "path": "/metadata/annotations", "value": {"foo": "bar"}}]`,
+			}},
+			check: func(t *testing.T, objs map[string]*unstructured.Unstructured) {
+				if objs["Service"].GetAnnotations()["foo"] != "bar" {
+					t.Errorf("expected annotation, got %v", objs["Service"].GetAnnotations())
+				}
+			},
+		},
+		{
+			name: "merge patch for custom resources",
+			overlays: []v1alpha1.Overlay{{
+				Target: v1alpha1.OverlayTarget{Group: "networking.istio.io", Kind: "EnvoyFilter", Name: "stats"},
+				Patch:  `{"spec": {"priority": 2}}`,
+			}},
+			check: func(t *testing.T, objs map[string]*unstructured.Unstructured) {
+				priority, _, _ := unstructured.NestedFloat64(objs["EnvoyFilter"].Object, "spec", "priority")
+				if priority != 2 {
+					t.Errorf("expected priority 2, got %v", priority)
+				}
+			},
+		},
+		{
+			name: "overlay matching nothing",
+			overlays: []v1alpha1.Overlay{{
+				Target: v1alpha1.OverlayTarget{Group: "apps", Kind: "Deployment", Name: "istiod-canary"},
+				Patch:  `{"metadata": {"labels": {"foo": "bar"}}}`,
+			}},
+			expectErr: "Deployment.apps istiod-canary didn't match any object",
+		},
+		{
+			name: "group mismatch",
+			overlays: []v1alpha1.Overlay{{
+				Target: v1alpha1.OverlayTarget{Group: "extensions", Kind: "Deployment"},
+				Patch:  `{"metadata": {"labels": {"foo": "bar"}}}`,
+			}},
+			expectErr: "didn't match any object",
+		},
+		{
+			name: "failing JSON patch",
+			overlays: []v1alpha1.Overlay{{
+				Target: v1alpha1.OverlayTarget{Kind: "Service"},
+				Type:   v1alpha1.OverlayPatchTypeJSON,
+				Patch:  `[{"op": "replace", "path": "/spec/missing", "value": 1}]`,
+			}},
+			expectErr: "failed to apply overlay to Service istiod",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := NewOverlayPostRenderer(tc.overlays).Run(bytes.NewBufferString(renderedManifests))
+			if tc.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectErr) {
+					t.Fatalf("expected error containing %q, got %v", tc.expectErr, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			objs := map[string]*unstructured.Unstructured{}
+			for _, manifest := range releaseutil.SplitManifests(out.String()) {
+				obj := &unstructured.Unstructured{}
+				if err := yaml.Unmarshal([]byte(manifest), &obj.Object); err != nil {
+					t.Fatalf("failed to parse output: %v", err)
+				}
+				objs[obj.GetKind()] = obj
+			}
+			if len(objs) != 3 {
+				t.Fatalf("expected 3 objects, got %d", len(objs))
+			}
+			tc.check(t, objs)
+		})
+	}
+
+	if NewOverlayPostRenderer(nil) != nil {
+		t.Errorf("expected no post renderer without overlays")
+	}
+}
@@ -30,19 +30,43 @@ func addOwnerReferenceVisitor(ownerReference metav1.OwnerReference, istioNamespa
 		if objMeta.GetNamespace() == istioNamespace {
 			objMeta.SetOwnerReferences([]metav1.OwnerReference{ownerReference})
 		} else {
-			annotations := objMeta.GetAnnotations()
-			if annotations == nil {
-				annotations = make(map[string]string)
-			}
-			ownerAPIGroup, _, _ := strings.Cut(ownerReference.APIVersion, "/")
-			annotations[AnnotationPrimaryResource] = istioNamespace + "/" + ownerReference.Name
-			annotations[AnnotationPrimaryResourceType] = ownerReference.Kind + "." + ownerAPIGroup
-			objMeta.SetAnnotations(annotations)
+			SetOwnerAnnotations(objMeta, ownerReference, istioNamespace)
 		}
 		return nil
 	}
 }
 
+// addOwnerAnnotationsVisitor returns a visitor function that adds the primary-resource
+// annotations pointing to the owner to each resource it visits, regardless of its namespace
+func addOwnerAnnotationsVisitor(ownerReference metav1.OwnerReference, istioNamespace string) resource.VisitorFunc {
+	return func(info *resource.Info, err error) error {
+		if err != nil {
+			return err
+		}
+
+		objMeta, err := meta.Accessor(info.Object)
+		if err != nil {
+			return err
+		}
+		SetOwnerAnnotations(objMeta, ownerReference, istioNamespace)
+		return nil
+	}
+}
+
+// SetOwnerAnnotations marks the object as belonging to the owner, for objects
+// that can't have an owner reference, e.g. because they are cluster-scoped or in
+// another namespace
+func SetOwnerAnnotations(objMeta metav1.Object, ownerReference metav1.OwnerReference, istioNamespace string) {
+	annotations := objMeta.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	ownerAPIGroup, _, _ := strings.Cut(ownerReference.APIVersion, "/")
+	annotations[AnnotationPrimaryResource] = istioNamespace + "/" + ownerReference.Name
+	annotations[AnnotationPrimaryResourceType] = ownerReference.Kind + "." + ownerAPIGroup
+	objMeta.SetAnnotations(annotations)
+}
+
 func GetOwnerFromAnnotations(annotations map[string]string) (*types.NamespacedName, string, string) {
 	if annotations == nil {
 		return nil, "", ""
@@ -1,6 +1,8 @@
 package helm
 
 import (
+	"sync"
+
 	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/discovery"
@@ -10,8 +12,10 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 )
 
-// restClientGetter is required by helm to instantiate ActionConfig
+// restClientGetter is required by helm to instantiate ActionConfig. It is safe
+// for concurrent use, because charts are installed concurrently.
 type restClientGetter struct {
+	mu              sync.Mutex
 	config          *rest.Config
 	discoveryClient discovery.CachedDiscoveryInterface
 	restMapper      meta.RESTMapper
@@ -28,6 +32,12 @@ func (c *restClientGetter) ToRESTConfig() (*rest.Config, error) {
 }
 
 func (c *restClientGetter) ToDiscoveryClient() (discovery.CachedDiscoveryInterface, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.toDiscoveryClient(), nil
+}
+
+func (c *restClientGetter) toDiscoveryClient() discovery.CachedDiscoveryInterface {
 	if c.discoveryClient == nil {
 		oldBurst := c.config.Burst
 		// use the default (high) burst for discovery
@@ -38,13 +48,14 @@ func (c *restClientGetter) ToDiscoveryClient() (discovery.CachedDiscoveryInterfa
 		discoveryClient, _ := discovery.NewDiscoveryClientForConfig(c.config)
 		c.discoveryClient = memory.NewMemCacheClient(discoveryClient)
 	}
-	return c.discoveryClient, nil
+	return c.discoveryClient
 }
 
 func (c *restClientGetter) ToRESTMapper() (meta.RESTMapper, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if c.restMapper == nil {
-		// we know err is always nil
-		discoveryClient, _ := c.ToDiscoveryClient()
+		discoveryClient := c.toDiscoveryClient()
 
 		mapper := restmapper.NewDeferredDiscoveryRESTMapper(discoveryClient)
 		c.restMapper = restmapper.NewShortcutExpander(mapper, discoveryClient)
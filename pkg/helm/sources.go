@@ -0,0 +1,333 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helm
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"helm.sh/helm/v3/pkg/repo"
+	"maistra.io/istio-operator/pkg/images"
+	"sigs.k8s.io/yaml"
+)
+
+// ChartMediaType is the media type of the layer that holds the chart archive of a
+// Helm chart stored in an OCI registry
+const ChartMediaType = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+
+// maxArchiveSize limits the size of the chart archives and repository indexes that are read
+const maxArchiveSize = 20 << 20
+
+// fetchTimeout limits the time it takes to fetch a chart from its source
+const fetchTimeout = time.Minute
+
+// Sources are the chart sources that replace the charts in the ResourceDirectory
+var Sources = &ChartSources{}
+
+// ChartSource provides the charts of a version from a chart repository instead of
+// the ResourceDirectory
+type ChartSource struct {
+	// Version whose charts are replaced, as in spec.version
+	Version string
+	// URL of the repository: oci://<registry>/<repository> for an OCI registry,
+	// http(s)://... for a Helm HTTP repository or file:///... for a directory laid
+	// out like a Helm HTTP repository
+	URL string
+	// ChartVersion is the version of the charts in the repository
+	ChartVersion string
+}
+
+// ParseChartSource parses a source of the form <version>=<url>@<chart version>
+func ParseChartSource(s string) (ChartSource, error) {
+	version, rest, found := strings.Cut(s, "=")
+	i := strings.LastIndex(rest, "@")
+	if !found || version == "" || i <= 0 || i == len(rest)-1 {
+		return ChartSource{}, fmt.Errorf("invalid chart source %q: expected <version>=<url>@<chart version>", s)
+	}
+	source := ChartSource{Version: version, URL: strings.TrimSuffix(rest[:i], "/"), ChartVersion: rest[i+1:]}
+	u, err := url.Parse(source.URL)
+	if err != nil {
+		return ChartSource{}, fmt.Errorf("invalid chart source %q: %v", s, err)
+	}
+	switch u.Scheme {
+	case "oci", "http", "https", "file":
+	default:
+		return ChartSource{}, fmt.Errorf("invalid chart source %q: unsupported scheme %q", s, u.Scheme)
+	}
+	return source, nil
+}
+
+// ChartSources fetches charts from their sources. The chart archives are verified
+// against the digests in the repository index or OCI manifest and cached on disk,
+// and the digest of each chart is only resolved once, so that the sources are only
+// accessed until all charts of a version have been fetched. Each chart is fetched
+// under its own lock, so a slow source only delays the callers that need its charts.
+type ChartSources struct {
+	sources      map[string]ChartSource
+	cacheDir     string
+	registry     *images.Client
+	httpClient   *http.Client
+	fetchTimeout time.Duration
+
+	mu sync.Mutex
+	// resolved are the digests of the chart archives by version and chart name
+	resolved map[string]string
+	// fetching holds the lock of each chart by version and chart name
+	fetching map[string]*sync.Mutex
+}
+
+// NewChartSources returns the chart sources parsed from the given strings (see
+// ParseChartSource). The archives are cached in cacheDir; OCI registries are
+// accessed with the registry client and HTTP repositories with the HTTP client
+// (http.DefaultClient if nil).
+func NewChartSources(sources []string, cacheDir string, registry *images.Client, httpClient *http.Client) (*ChartSources, error) {
+	s := &ChartSources{
+		sources:      map[string]ChartSource{},
+		cacheDir:     cacheDir,
+		registry:     registry,
+		httpClient:   httpClient,
+		fetchTimeout: fetchTimeout,
+		resolved:     map[string]string{},
+		fetching:     map[string]*sync.Mutex{},
+	}
+	for _, str := range sources {
+		source, err := ParseChartSource(str)
+		if err != nil {
+			return nil, err
+		}
+		if _, found := s.sources[source.Version]; found {
+			return nil, fmt.Errorf("duplicate chart source for version %s", source.Version)
+		}
+		s.sources[source.Version] = source
+	}
+	if len(s.sources) > 0 {
+		if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create chart cache directory: %v", err)
+		}
+	}
+	return s, nil
+}
+
+// Archive returns the path of the verified archive of the chart of the version.
+// If the version has no source, found is false.
+func (s *ChartSources) Archive(ctx context.Context, version, chartName string) (archivePath string, found bool, err error) {
+	source, found := s.sources[version]
+	if !found {
+		return "", false, nil
+	}
+
+	key := version + "/" + chartName
+	if archivePath, resolved := s.resolvedArchive(key); resolved {
+		return archivePath, true, nil
+	}
+
+	lock := s.lock(key)
+	lock.Lock()
+	defer lock.Unlock()
+	// the chart may have been fetched while waiting for the lock
+	if archivePath, resolved := s.resolvedArchive(key); resolved {
+		return archivePath, true, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.fetchTimeout)
+	defer cancel()
+	var digest string
+	if strings.HasPrefix(source.URL, "oci://") {
+		digest, err = s.fetchFromRegistry(ctx, source, chartName)
+	} else {
+		digest, err = s.fetchFromRepository(ctx, source, chartName)
+	}
+	if err != nil {
+		return "", true, fmt.Errorf("failed to fetch chart %s %s from %s: %v", chartName, source.ChartVersion, source.URL, err)
+	}
+	logger.V(2).Info("Fetched chart", "chart", chartName, "version", source.ChartVersion, "source", source.URL, "digest", digest)
+	s.mu.Lock()
+	s.resolved[key] = digest
+	s.mu.Unlock()
+	return s.archivePath(digest), true, nil
+}
+
+// resolvedArchive returns the path of the archive of the chart if its digest has
+// been resolved and the archive is still in the cache
+func (s *ChartSources) resolvedArchive(key string) (string, bool) {
+	s.mu.Lock()
+	digest, resolved := s.resolved[key]
+	s.mu.Unlock()
+	if !resolved {
+		return "", false
+	}
+	if _, err := os.Stat(s.archivePath(digest)); err != nil {
+		return "", false
+	}
+	return s.archivePath(digest), true
+}
+
+// lock returns the lock that is held while fetching the chart
+func (s *ChartSources) lock(key string) *sync.Mutex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lock, found := s.fetching[key]
+	if !found {
+		lock = &sync.Mutex{}
+		s.fetching[key] = lock
+	}
+	return lock
+}
+
+// fetchFromRegistry stores the archive of the chart in the OCI registry in the
+// cache and returns its digest
+func (s *ChartSources) fetchFromRegistry(ctx context.Context, source ChartSource, chartName string) (string, error) {
+	ref, err := images.ParseReference(strings.TrimPrefix(source.URL, "oci://") + "/" + chartName)
+	if err != nil {
+		return "", err
+	}
+	// OCI tags can't contain "+", so Helm replaces it
+	ref.Tag = strings.ReplaceAll(source.ChartVersion, "+", "_")
+	digest, err := s.registry.LayerDigest(ctx, ref, ChartMediaType)
+	if err != nil {
+		return "", err
+	}
+	if s.isCached(digest) {
+		return digest, nil
+	}
+	data, err := s.registry.Blob(ctx, ref, digest, maxArchiveSize)
+	if err != nil {
+		return "", err
+	}
+	return digest, s.store(digest, data)
+}
+
+// fetchFromRepository stores the archive of the chart in the Helm repository in
+// the cache and returns its digest
+func (s *ChartSources) fetchFromRepository(ctx context.Context, source ChartSource, chartName string) (string, error) {
+	base, err := url.Parse(source.URL + "/")
+	if err != nil {
+		return "", err
+	}
+	data, err := s.get(ctx, base.JoinPath("index.yaml"))
+	if err != nil {
+		return "", err
+	}
+	index := &repo.IndexFile{}
+	if err := yaml.Unmarshal(data, index); err != nil {
+		return "", fmt.Errorf("invalid repository index: %v", err)
+	}
+	chartVersion, err := index.Get(chartName, source.ChartVersion)
+	if err != nil {
+		return "", err
+	}
+	if chartVersion.Digest == "" || len(chartVersion.URLs) == 0 {
+		return "", fmt.Errorf("repository index has no digest or URL for the chart")
+	}
+	digest := "sha256:" + chartVersion.Digest
+	if s.isCached(digest) {
+		return digest, nil
+	}
+
+	archiveURL, err := base.Parse(chartVersion.URLs[0])
+	if err != nil {
+		return "", err
+	}
+	if data, err = s.get(ctx, archiveURL); err != nil {
+		return "", err
+	}
+	if sha256Digest(data) != digest {
+		return "", fmt.Errorf("archive %s doesn't match the digest in the repository index", archiveURL)
+	}
+	return digest, s.store(digest, data)
+}
+
+// get returns the contents of the file or HTTP URL
+func (s *ChartSources) get(ctx context.Context, u *url.URL) ([]byte, error) {
+	var body io.ReadCloser
+	if u.Scheme == "file" {
+		f, err := os.Open(u.Path)
+		if err != nil {
+			return nil, err
+		}
+		body = f
+	} else {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		httpClient := s.httpClient
+		if httpClient == nil {
+			httpClient = http.DefaultClient
+		}
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s returned %d %s", u, resp.StatusCode, http.StatusText(resp.StatusCode))
+		}
+		body = resp.Body
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(body, maxArchiveSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxArchiveSize {
+		return nil, fmt.Errorf("%s exceeds %d bytes", u, maxArchiveSize)
+	}
+	return data, nil
+}
+
+// isCached returns whether the cache has an archive that matches the digest
+func (s *ChartSources) isCached(digest string) bool {
+	data, err := os.ReadFile(s.archivePath(digest))
+	return err == nil && sha256Digest(data) == digest
+}
+
+// store writes the archive to the cache. The archive is renamed into place, so
+// that a partially written archive is never used.
+func (s *ChartSources) store(digest string, data []byte) error {
+	f, err := os.CreateTemp(s.cacheDir, "chart-*.tgz")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.archivePath(digest))
+}
+
+func (s *ChartSources) archivePath(digest string) string {
+	return filepath.Join(s.cacheDir, strings.Replace(digest, ":", "-", 1)+".tgz")
+}
+
+func sha256Digest(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
@@ -0,0 +1,254 @@
+package helm
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/repo"
+	"maistra.io/istio-operator/pkg/images/imagestest"
+)
+
+func TestParseChartSource(t *testing.T) {
+	testCases := map[string]*ChartSource{
+		"v3.0=oci://registry.example.com/charts@1.0.1":     {Version: "v3.0", URL: "oci://registry.example.com/charts", ChartVersion: "1.0.1"},
+		"v3.0=https://charts.example.com/istio/@1.0.1+fix": {Version: "v3.0", URL: "https://charts.example.com/istio", ChartVersion: "1.0.1+fix"},
+		"v3.0=file:///srv/charts@1.0.1":                    {Version: "v3.0", URL: "file:///srv/charts", ChartVersion: "1.0.1"},
+		"v3.0=oci://registry.example.com/charts":           nil,
+		"v3.0=oci://registry.example.com/charts@":          nil,
+		"=oci://registry.example.com/charts@1.0.1":         nil,
+		"v3.0=ftp://charts.example.com@1.0.1":              nil,
+	}
+	for s, expected := range testCases {
+		source, err := ParseChartSource(s)
+		if expected == nil {
+			if err == nil {
+				t.Errorf("expected error for %q", s)
+			}
+		} else if err != nil || source != *expected {
+			t.Errorf("ParseChartSource(%q): expected %+v, got %+v (error: %v)", s, *expected, source, err)
+		}
+	}
+}
+
+// writeChartArchive packages a chart with a single template and returns the path
+// of the archive
+func writeChartArchive(t *testing.T, dir, name, version, template string) string {
+	t.Helper()
+	c := &chart.Chart{
+		Metadata:  &chart.Metadata{APIVersion: chart.APIVersionV2, Name: name, Version: version},
+		Templates: []*chart.File{{Name: "templates/configmap.yaml", Data: []byte(template)}},
+	}
+	archivePath, err := chartutil.Save(c, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return archivePath
+}
+
+// writeRepository creates a Helm repository with the archives in dir
+func writeRepository(t *testing.T, dir string, archivePaths ...string) {
+	t.Helper()
+	index := repo.NewIndexFile()
+	for _, archivePath := range archivePaths {
+		data, err := os.ReadFile(archivePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name, version, _ := strings.Cut(strings.TrimSuffix(filepath.Base(archivePath), ".tgz"), "-")
+		md := &chart.Metadata{APIVersion: chart.APIVersionV2, Name: name, Version: version}
+		if err := index.MustAdd(md, filepath.Base(archivePath), "", fmt.Sprintf("%x", sha256.Sum256(data))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := index.WriteFile(filepath.Join(dir, "index.yaml"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChartSources(t *testing.T) {
+	repoDir := t.TempDir()
+	archivePath := writeChartArchive(t, repoDir, "istiod", "1.0.1", "kind: ConfigMap")
+	writeRepository(t, repoDir, archivePath)
+	archive, err := os.ReadFile(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var httpRequests atomic.Int32
+	fileServer := http.FileServer(http.Dir(repoDir))
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		httpRequests.Add(1)
+		fileServer.ServeHTTP(w, req)
+	}))
+	defer httpServer.Close()
+
+	registry := imagestest.NewRegistry(t, true)
+	registry.PushArtifact("charts/istiod", "1.0.1_fix", ChartMediaType, archive)
+
+	testCases := []struct {
+		name   string
+		source string
+	}{
+		{name: "file", source: "v3.0=file://" + repoDir + "@1.0.1"},
+		{name: "http", source: "v3.0=" + httpServer.URL + "@1.0.1"},
+		{name: "oci", source: "v3.0=oci://" + registry.Host + "/charts@1.0.1+fix"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cacheDir := t.TempDir()
+			sources, err := NewChartSources([]string{tc.source}, cacheDir, registry.Client(), httpServer.Client())
+			if err != nil {
+				t.Fatal(err)
+			}
+			path, found, err := sources.Archive(context.Background(), "v3.0", "istiod")
+			if err != nil || !found {
+				t.Fatalf("expected archive, got found=%v, error: %v", found, err)
+			}
+			if data, err := os.ReadFile(path); err != nil || string(data) != string(archive) {
+				t.Fatalf("cached archive doesn't match the chart (error: %v)", err)
+			}
+
+			// the resolved digest is reused without accessing the source
+			requests := httpRequests.Load()
+			if path2, _, err := sources.Archive(context.Background(), "v3.0", "istiod"); err != nil || path2 != path {
+				t.Errorf("expected cached archive %s, got %s (error: %v)", path, path2, err)
+			}
+			if httpRequests.Load() != requests {
+				t.Errorf("expected no requests for a resolved chart")
+			}
+
+			if _, found, err := sources.Archive(context.Background(), "v3.1", "istiod"); found || err != nil {
+				t.Errorf("expected no source for v3.1, got found=%v, error: %v", found, err)
+			}
+		})
+	}
+
+	t.Run("digest mismatch", func(t *testing.T) {
+		tamperedDir := t.TempDir()
+		writeRepository(t, tamperedDir, archivePath)
+		tampered := writeChartArchive(t, tamperedDir, "istiod", "1.0.1", "kind: Secret")
+		if filepath.Base(tampered) != filepath.Base(archivePath) {
+			t.Fatalf("unexpected archive name %s", tampered)
+		}
+		sources, err := NewChartSources([]string{"v3.0=file://" + tamperedDir + "@1.0.1"}, t.TempDir(), nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := sources.Archive(context.Background(), "v3.0", "istiod"); err == nil || !strings.Contains(err.Error(), "doesn't match the digest") {
+			t.Errorf("expected digest mismatch, got %v", err)
+		}
+	})
+
+	t.Run("large oci chart", func(t *testing.T) {
+		// chart blobs are limited like archives in repositories, not like manifests
+		large := make([]byte, 5<<20)
+		registry.PushArtifact("charts/istiod", "1.0.2", ChartMediaType, large)
+		sources, err := NewChartSources([]string{"v3.0=oci://" + registry.Host + "/charts@1.0.2"}, t.TempDir(), registry.Client(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path, found, err := sources.Archive(context.Background(), "v3.0", "istiod")
+		if err != nil || !found {
+			t.Fatalf("expected archive, got found=%v, error: %v", found, err)
+		}
+		if info, err := os.Stat(path); err != nil || info.Size() != int64(len(large)) {
+			t.Errorf("cached archive doesn't match the chart (error: %v)", err)
+		}
+	})
+
+	t.Run("missing chart version", func(t *testing.T) {
+		sources, err := NewChartSources([]string{"v3.0=file://" + repoDir + "@2.0.0"}, t.TempDir(), nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := sources.Archive(context.Background(), "v3.0", "istiod"); err == nil {
+			t.Errorf("expected error for missing chart version")
+		}
+	})
+
+	t.Run("slow source", func(t *testing.T) {
+		requested := make(chan struct{})
+		slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			close(requested)
+			<-req.Context().Done()
+		}))
+		defer slowServer.Close()
+		sources, err := NewChartSources([]string{"v3.0=" + slowServer.URL + "@1.0.1", "v3.1=file://" + repoDir + "@1.0.1"},
+			t.TempDir(), nil, slowServer.Client())
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources.fetchTimeout = time.Second
+
+		slowErr := make(chan error)
+		go func() {
+			_, _, err := sources.Archive(context.Background(), "v3.0", "istiod")
+			slowErr <- err
+		}()
+		<-requested
+		// other sources aren't blocked by the slow one
+		if _, _, err := sources.Archive(context.Background(), "v3.1", "istiod"); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if err := <-slowErr; err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+			t.Errorf("expected timeout, got %v", err)
+		}
+	})
+
+	t.Run("duplicate version", func(t *testing.T) {
+		source := "v3.0=file://" + repoDir + "@1.0.1"
+		if _, err := NewChartSources([]string{source, source}, t.TempDir(), nil, nil); err == nil {
+			t.Errorf("expected error for duplicate sources")
+		}
+	})
+}
+
+func TestLoadChartFromSource(t *testing.T) {
+	originalResourceDirectory, originalSources := ResourceDirectory, Sources
+	t.Cleanup(func() { ResourceDirectory, Sources = originalResourceDirectory, originalSources })
+
+	ResourceDirectory = t.TempDir()
+	chartDir := filepath.Join(ResourceDirectory, "v3.0", "charts", "istio-control", "istio-discovery")
+	if err := os.MkdirAll(chartDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(chartDir, "Chart.yaml"), []byte("apiVersion: v2\nname: istiod\nversion: 1.0.0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	repoDir := t.TempDir()
+	writeRepository(t, repoDir,
+		writeChartArchive(t, repoDir, "istiod", "1.0.1", "kind: ConfigMap"),
+		writeChartArchive(t, repoDir, "base", "1.0.1", "kind: ConfigMap"))
+
+	Sources = &ChartSources{}
+	local, err := loadChart(context.Background(), "v3.0", "istio-control/istio-discovery")
+	if err != nil || local.chart.Metadata.Version != "1.0.0" {
+		t.Fatalf("expected local chart, got %v (error: %v)", local.chart, err)
+	}
+
+	Sources, err = NewChartSources([]string{"v3.0=file://" + repoDir + "@1.0.1"}, t.TempDir(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := loadChart(context.Background(), "v3.0", "istio-control/istio-discovery")
+	if err != nil || remote.chart.Metadata.Version != "1.0.1" {
+		t.Fatalf("expected chart from source, got %v (error: %v)", remote.chart, err)
+	}
+	if remote.digest == local.digest {
+		t.Errorf("expected the digest to change with the chart source")
+	}
+	if digest, err := ChartDigest(context.Background(), "v3.0", "istio-control/istio-discovery"); err != nil || digest != remote.digest {
+		t.Errorf("expected digest %s, got %s (error: %v)", remote.digest, digest, err)
+	}
+}
@@ -0,0 +1,216 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helm
+
+import (
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+const (
+	DriverSecret    = "secret"
+	DriverConfigMap = "configmap"
+)
+
+// SupportedDrivers are the storage drivers that can be used to store helm releases.
+// There is no driver that stores releases in a custom resource of the operator: it
+// would need a CRD of its own, and its objects would be subject to the same size
+// limit as Secrets and ConfigMaps, so it wouldn't allow larger releases.
+var SupportedDrivers = []string{DriverSecret, DriverConfigMap}
+
+// CompactedLabel is set on the Secret or ConfigMap of a release that was stored
+// without its chart templates and files
+const CompactedLabel = "operator.istio.io/compacted"
+
+// maxReleaseSize is the maximum size of an encoded release. Each release is stored
+// in a single Secret or ConfigMap, which must not exceed 1MiB. The remaining space
+// is left for the object's metadata.
+const maxReleaseSize = 1000 * 1024
+
+// ValidateDriver returns an error if the driver isn't supported
+func ValidateDriver(name string) error {
+	for _, d := range SupportedDrivers {
+		if name == d {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported helm storage driver %q; must be one of %v", name, SupportedDrivers)
+}
+
+// sizeLimitingDriver wraps a storage driver and removes the chart templates and files
+// from releases that would otherwise exceed maxReleaseSize when stored. Both drivers
+// already store the release gzipped, so this only happens if the compressed release
+// is still too large. The operator always installs charts from its resource
+// directory and never rolls back releases, so it only needs the manifest, hooks and
+// values of a stored release. Such a release can't be rolled back with the helm CLI,
+// however, so it's labeled with CompactedLabel and a message is logged.
+type sizeLimitingDriver struct {
+	driver.Driver
+}
+
+func (d sizeLimitingDriver) Create(key string, rls *release.Release) error {
+	rls, err := compactRelease(rls)
+	if err != nil {
+		return err
+	}
+	return d.Driver.Create(key, rls)
+}
+
+func (d sizeLimitingDriver) Update(key string, rls *release.Release) error {
+	rls, err := compactRelease(rls)
+	if err != nil {
+		return err
+	}
+	return d.Driver.Update(key, rls)
+}
+
+// compactRelease returns the release unchanged if it fits into maxReleaseSize, or
+// a copy without the chart templates and files, labeled with CompactedLabel, otherwise
+func compactRelease(rls *release.Release) (*release.Release, error) {
+	size, err := encodedSize(rls)
+	if err != nil {
+		return nil, err
+	} else if size <= maxReleaseSize || rls.Chart == nil {
+		return rls, nil
+	}
+
+	compacted := *rls
+	chart := *rls.Chart
+	chart.Templates = nil
+	chart.Files = nil
+	compacted.Chart = &chart
+	compacted.Labels = map[string]string{CompactedLabel: "true"}
+	for k, v := range rls.Labels {
+		compacted.Labels[k] = v
+	}
+
+	compactedSize, err := encodedSize(&compacted)
+	if err != nil {
+		return nil, err
+	} else if compactedSize > maxReleaseSize {
+		return nil, fmt.Errorf("release %s is too large to be stored: %d bytes (max %d bytes)", rls.Name, compactedSize, maxReleaseSize)
+	}
+	logger.Info("Release exceeds the size limit; storing it without chart templates and files, so it can't be rolled back",
+		"release", rls.Name, "namespace", rls.Namespace, "size", size, "compactedSize", compactedSize, "maxSize", maxReleaseSize)
+	return &compacted, nil
+}
+
+// encodedSize returns the size of the release when encoded by the secret and
+// configmap storage drivers (gzipped and base64-encoded JSON)
+func encodedSize(rls *release.Release) (int, error) {
+	b, err := json.Marshal(rls)
+	if err != nil {
+		return 0, err
+	}
+	counter := &countingWriter{}
+	w, err := gzip.NewWriterLevel(counter, gzip.BestCompression)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := w.Write(b); err != nil {
+		return 0, err
+	}
+	if err := w.Close(); err != nil {
+		return 0, err
+	}
+	return base64.StdEncoding.EncodedLen(counter.n), nil
+}
+
+type countingWriter struct {
+	n int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += len(p)
+	return len(p), nil
+}
+
+var _ io.Writer = &countingWriter{}
+
+// MigrateReleases moves the releases accepted by the filter from all supported
+// storage drivers other than the given one to the given driver. Releases that
+// already exist in the target driver are left there and removed from the source.
+func MigrateReleases(restClientGetter genericclioptions.RESTClientGetter, to string, filter func(*release.Release) bool) error {
+	for _, from := range SupportedDrivers {
+		if from == to {
+			continue
+		}
+
+		// an empty namespace means all namespaces
+		source, err := newActionConfigForDriver(restClientGetter, "", from)
+		if err != nil {
+			return err
+		}
+		releases, err := source.Releases.ListReleases()
+		if err != nil {
+			return fmt.Errorf("failed to list releases stored by driver %s: %v", from, err)
+		}
+
+		for _, rls := range releases {
+			if !filter(rls) {
+				continue
+			}
+			if err := migrateRelease(restClientGetter, rls, from, to); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func migrateRelease(restClientGetter genericclioptions.RESTClientGetter, rls *release.Release, from, to string) error {
+	logger.Info("Migrating helm release", "release", rls.Name, "namespace", rls.Namespace, "version", rls.Version, "from", from, "to", to)
+	source, err := newActionConfigForDriver(restClientGetter, rls.Namespace, from)
+	if err != nil {
+		return err
+	}
+	target, err := newActionConfigForDriver(restClientGetter, rls.Namespace, to)
+	if err != nil {
+		return err
+	}
+
+	if _, err := target.Releases.Get(rls.Name, rls.Version); errors.Is(err, driver.ErrReleaseNotFound) {
+		if err := target.Releases.Create(rls); err != nil {
+			return fmt.Errorf("failed to migrate release %s/%s: %v", rls.Namespace, rls.Name, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to get release %s/%s: %v", rls.Namespace, rls.Name, err)
+	}
+
+	if _, err := source.Releases.Delete(rls.Name, rls.Version); err != nil && !errors.Is(err, driver.ErrReleaseNotFound) {
+		return fmt.Errorf("failed to remove migrated release %s/%s: %v", rls.Namespace, rls.Name, err)
+	}
+	return nil
+}
+
+func newActionConfigForDriver(restClientGetter genericclioptions.RESTClientGetter, namespace, driverName string) (*action.Configuration, error) {
+	actionConfig := new(action.Configuration)
+	if err := actionConfig.Init(restClientGetter, namespace, driverName, logger.V(2).Info); err != nil {
+		return nil, err
+	}
+	actionConfig.Releases.Driver = sizeLimitingDriver{actionConfig.Releases.Driver}
+	return actionConfig, nil
+}
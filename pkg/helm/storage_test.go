@@ -0,0 +1,91 @@
+package helm
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/release"
+)
+
+func TestCompactRelease(t *testing.T) {
+	newRelease := func(templateSize, manifestSize int) *release.Release {
+		return &release.Release{
+			Name: "test-istiod",
+			Chart: &chart.Chart{
+				Metadata:  &chart.Metadata{Name: "istiod", Version: "1.0.0"},
+				Templates: []*chart.File{{Name: "templates/large.yaml", Data: randomBytes(t, templateSize)}},
+			},
+			Manifest: string(randomBytes(t, manifestSize)),
+		}
+	}
+
+	testCases := []struct {
+		name            string
+		release         *release.Release
+		expectCompacted bool
+		expectErr       bool
+	}{
+		{
+			name:    "small release",
+			release: newRelease(1024, 1024),
+		},
+		{
+			name:            "large templates",
+			release:         newRelease(maxReleaseSize, 1024),
+			expectCompacted: true,
+		},
+		{
+			name:      "large manifest",
+			release:   newRelease(1024, maxReleaseSize),
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := compactRelease(tc.release)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("compactRelease() error = %v, expectErr %v", err, tc.expectErr)
+			}
+			if err != nil {
+				return
+			}
+			if compacted := len(actual.Chart.Templates) == 0; compacted != tc.expectCompacted {
+				t.Errorf("expected compacted = %v, got %v", tc.expectCompacted, compacted)
+			}
+			if labeled := actual.Labels[CompactedLabel] == "true"; labeled != tc.expectCompacted {
+				t.Errorf("expected %s label = %v, got %v", CompactedLabel, tc.expectCompacted, labeled)
+			}
+			if len(tc.release.Chart.Templates) == 0 {
+				t.Error("compactRelease() modified the original release")
+			}
+			if actual.Chart.Metadata == nil || actual.Manifest != tc.release.Manifest {
+				t.Error("compactRelease() removed the chart metadata or the manifest")
+			}
+		})
+	}
+}
+
+func TestValidateDriver(t *testing.T) {
+	for _, name := range []string{DriverSecret, DriverConfigMap} {
+		if err := ValidateDriver(name); err != nil {
+			t.Errorf("expected driver %s to be valid, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "memory", "sql"} {
+		if err := ValidateDriver(name); err == nil {
+			t.Errorf("expected driver %q to be invalid", name)
+		}
+	}
+}
+
+// randomBytes returns incompressible data of the given size
+func randomBytes(t *testing.T, size int) []byte {
+	t.Helper()
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package images
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// SignatureAnnotation is the annotation of a signature layer that contains the
+	// base64-encoded signature of the layer's payload
+	SignatureAnnotation = "dev.cosignproject.cosign/signature"
+	// SignatureMediaType is the media type of a signature payload
+	SignatureMediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+)
+
+// ErrNoSignature is returned when an image has no signature that can be verified
+// with the public key
+var ErrNoSignature = errors.New("no valid signature found")
+
+// SignatureTag returns the tag under which cosign stores the signatures of the
+// manifest with the given digest
+func SignatureTag(digest string) string {
+	return strings.Replace(digest, ":", "-", 1) + ".sig"
+}
+
+// Payload is the simple signing payload that cosign signs
+type Payload struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+// NewPayload returns the payload that cosign signs for the image
+func NewPayload(ref Reference, digest string) Payload {
+	payload := Payload{}
+	payload.Critical.Identity.DockerReference = ref.Registry + "/" + ref.Repository
+	payload.Critical.Image.DockerManifestDigest = digest
+	payload.Critical.Type = "cosign container image signature"
+	return payload
+}
+
+type ociManifest struct {
+	Layers []struct {
+		MediaType   string            `json:"mediaType"`
+		Digest      string            `json:"digest"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"layers"`
+}
+
+// VerifySignature verifies that the manifest with the digest of the image has a
+// cosign signature that was created with the private key of the public key. Only
+// signatures stored in the image's repository are considered, and neither
+// transparency logs nor certificates are checked.
+func (c *Client) VerifySignature(ctx context.Context, ref Reference, digest string, publicKey crypto.PublicKey) error {
+	sigRef := ref
+	sigRef.Tag, sigRef.Digest = SignatureTag(digest), ""
+	body, err := c.fetch(ctx, sigRef, "manifests/"+sigRef.Tag, []string{"application/vnd.oci.image.manifest.v1+json"}, maxManifestSize)
+	if IsNotFound(err) {
+		return ErrNoSignature
+	} else if err != nil {
+		return fmt.Errorf("failed to get signatures: %v", err)
+	}
+	manifest := &ociManifest{}
+	if err := json.Unmarshal(body, manifest); err != nil {
+		return fmt.Errorf("invalid signature manifest: %v", err)
+	}
+
+	for _, layer := range manifest.Layers {
+		signature, found := layer.Annotations[SignatureAnnotation]
+		if !found || !digestPattern.MatchString(layer.Digest) {
+			continue
+		}
+		payload, err := c.fetch(ctx, sigRef, "blobs/"+layer.Digest, nil, maxManifestSize)
+		if err != nil {
+			return fmt.Errorf("failed to get signature payload: %v", err)
+		}
+		if sha256Digest(payload) != layer.Digest {
+			continue
+		}
+		if verifyPayload(payload, signature, digest, publicKey) {
+			return nil
+		}
+	}
+	return ErrNoSignature
+}
+
+// verifyPayload returns whether the signature of the payload is valid and the
+// payload refers to the digest
+func verifyPayload(payload []byte, signature, digest string, publicKey crypto.PublicKey) bool {
+	var p Payload
+	if err := json.Unmarshal(payload, &p); err != nil || p.Critical.Image.DockerManifestDigest != digest {
+		return false
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	hash := sha256.Sum256(payload)
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(key, hash[:], sig)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig) == nil ||
+			rsa.VerifyPSS(key, crypto.SHA256, hash[:], sig, nil) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, payload, sig)
+	}
+	return false
+}
+
+// ParsePublicKey parses a PEM-encoded public key as generated by cosign
+func ParsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported public key type %T", key)
+}
+
+// KeyFingerprint returns the SHA-256 digest of the DER encoding of the public key
+func KeyFingerprint(publicKey crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+	return sha256Digest(der), nil
+}
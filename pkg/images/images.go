@@ -0,0 +1,130 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package images resolves the tags of the images that the operator installs to
+// digests and verifies their cosign signatures
+package images
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// ManifestFile is the name of the file listing the images of a version
+const ManifestFile = "images.yaml"
+
+// Manifest lists the images of a version
+type Manifest struct {
+	Istiod string `json:"istiod"`
+	Proxy  string `json:"proxy"`
+	CNI    string `json:"cni"`
+}
+
+// LoadManifest returns the images of the version in resourceDir
+func LoadManifest(resourceDir, version string) (*Manifest, error) {
+	file := path.Join(resourceDir, version, ManifestFile)
+	// prevent path traversal attacks
+	if path.Dir(path.Dir(file)) != path.Clean(resourceDir) {
+		return nil, fmt.Errorf("invalid version %s", version)
+	}
+	contents, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{}
+	if err := yaml.UnmarshalStrict(contents, manifest); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s: %v", file, err)
+	}
+	return manifest, nil
+}
+
+var digestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// Reference is a parsed image reference
+type Reference struct {
+	// Registry is the host (and port) of the registry
+	Registry string
+	// Repository is the name of the repository within the registry
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseReference parses an image reference like quay.io/maistra/pilot:3.0 or
+// quay.io/maistra/pilot@sha256:<hex>. References without a registry refer to
+// Docker Hub; references without a tag or digest refer to the latest tag.
+func ParseReference(image string) (Reference, error) {
+	ref := Reference{}
+	name := image
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, ref.Digest = name[:i], name[i+1:]
+		if !digestPattern.MatchString(ref.Digest) {
+			return Reference{}, fmt.Errorf("invalid digest in image %s", image)
+		}
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref.Tag = name[:i], name[i+1:]
+	}
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = "latest"
+	}
+
+	first, rest, found := strings.Cut(name, "/")
+	if found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		ref.Registry, ref.Repository = first, rest
+	} else {
+		ref.Registry, ref.Repository = "docker.io", name
+		if !found {
+			ref.Repository = "library/" + name
+		}
+	}
+	if ref.Repository == "" || ref.Repository != strings.ToLower(ref.Repository) {
+		return Reference{}, fmt.Errorf("invalid repository in image %s", image)
+	}
+	return ref, nil
+}
+
+// String returns the reference in the form registry/repository[:tag][@digest]
+func (r Reference) String() string {
+	s := r.Registry + "/" + r.Repository
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// WithDigest returns the reference pinned to the digest. The tag is kept for
+// information only.
+func (r Reference) WithDigest(digest string) Reference {
+	r.Digest = digest
+	return r
+}
+
+// reference returns the tag or digest by which the manifest is retrieved
+func (r Reference) reference() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
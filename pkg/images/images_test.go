@@ -0,0 +1,164 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package images_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"path"
+	"strings"
+	"testing"
+
+	"maistra.io/istio-operator/pkg/common"
+	"maistra.io/istio-operator/pkg/images"
+	"maistra.io/istio-operator/pkg/images/imagestest"
+)
+
+const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestParseReference(t *testing.T) {
+	testCases := []struct {
+		image    string
+		expected images.Reference
+		str      string
+	}{
+		{
+			image:    "quay.io/maistra-dev/pilot:3.0-latest",
+			expected: images.Reference{Registry: "quay.io", Repository: "maistra-dev/pilot", Tag: "3.0-latest"},
+			str:      "quay.io/maistra-dev/pilot:3.0-latest",
+		},
+		{
+			image:    "localhost:5000/pilot@" + digest,
+			expected: images.Reference{Registry: "localhost:5000", Repository: "pilot", Digest: digest},
+			str:      "localhost:5000/pilot@" + digest,
+		},
+		{
+			image:    "quay.io/maistra/pilot:3.0@" + digest,
+			expected: images.Reference{Registry: "quay.io", Repository: "maistra/pilot", Tag: "3.0", Digest: digest},
+			str:      "quay.io/maistra/pilot:3.0@" + digest,
+		},
+		{
+			image:    "busybox",
+			expected: images.Reference{Registry: "docker.io", Repository: "library/busybox", Tag: "latest"},
+			str:      "docker.io/library/busybox:latest",
+		},
+		{
+			image:    "istio/pilot:1.20.0",
+			expected: images.Reference{Registry: "docker.io", Repository: "istio/pilot", Tag: "1.20.0"},
+			str:      "docker.io/istio/pilot:1.20.0",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.image, func(t *testing.T) {
+			ref, err := images.ParseReference(tc.image)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ref != tc.expected {
+				t.Errorf("expected %+v, got %+v", tc.expected, ref)
+			}
+			if ref.String() != tc.str {
+				t.Errorf("expected %s, got %s", tc.str, ref.String())
+			}
+		})
+	}
+
+	for _, image := range []string{"quay.io/maistra/pilot@sha256:abc", "quay.io/Maistra/pilot:3.0"} {
+		if _, err := images.ParseReference(image); err == nil {
+			t.Errorf("expected error for %s", image)
+		}
+	}
+}
+
+func TestLoadManifest(t *testing.T) {
+	resourceDir := path.Join(common.RepositoryRoot, "resources")
+	manifest, err := images.LoadManifest(resourceDir, "v3.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, image := range []string{manifest.Istiod, manifest.Proxy, manifest.CNI} {
+		if _, err := images.ParseReference(image); err != nil {
+			t.Errorf("invalid image in manifest: %v", err)
+		}
+	}
+
+	if _, err := images.LoadManifest(resourceDir, "v3.0/profiles"); err == nil || !strings.Contains(err.Error(), "invalid version") {
+		t.Errorf("expected invalid version error, got %v", err)
+	}
+}
+
+func TestResolveAndVerify(t *testing.T) {
+	ctx := context.Background()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKeyDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := images.ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyDER}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, requireToken := range []bool{false, true} {
+		registry := imagestest.NewRegistry(t, requireToken)
+		client := registry.Client()
+		signedDigest := registry.PushImage("maistra/pilot", "3.0")
+		registry.Sign("maistra/pilot", signedDigest, key)
+		unsignedDigest := registry.PushImage("maistra/proxyv2", "3.0")
+		otherDigest := registry.PushImage("maistra/install-cni", "3.0")
+		registry.Sign("maistra/install-cni", otherDigest, otherKey)
+
+		ref, err := images.ParseReference(registry.Host + "/maistra/pilot:3.0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resolved, err := client.Resolve(ctx, ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resolved != signedDigest {
+			t.Errorf("expected digest %s, got %s", signedDigest, resolved)
+		}
+		if err := client.VerifySignature(ctx, ref, resolved, publicKey); err != nil {
+			t.Errorf("expected valid signature, got %v", err)
+		}
+
+		missing, _ := images.ParseReference(registry.Host + "/maistra/pilot:missing")
+		if _, err := client.Resolve(ctx, missing); !images.IsNotFound(err) {
+			t.Errorf("expected not found error, got %v", err)
+		}
+
+		for repository, digest := range map[string]string{"maistra/proxyv2": unsignedDigest, "maistra/install-cni": otherDigest} {
+			ref, _ := images.ParseReference(registry.Host + "/" + repository + ":3.0")
+			if err := client.VerifySignature(ctx, ref, digest, publicKey); !errors.Is(err, images.ErrNoSignature) {
+				t.Errorf("expected no valid signature for %s, got %v", repository, err)
+			}
+		}
+	}
+}
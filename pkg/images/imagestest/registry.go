@@ -0,0 +1,195 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package imagestest provides an in-memory registry that stands in for a real
+// registry in tests
+package imagestest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"maistra.io/istio-operator/pkg/images"
+)
+
+const (
+	ociManifestType = "application/vnd.oci.image.manifest.v1+json"
+	testToken       = "anonymous-token"
+)
+
+// Registry is an in-memory registry that serves the manifests and blobs of the
+// Docker Registry HTTP API V2 over plain HTTP
+type Registry struct {
+	// Host is the host and port of the registry, to be used in image references
+	Host string
+
+	server *httptest.Server
+	mu     sync.Mutex
+	// manifests by repository and tag or digest
+	manifests map[string]map[string][]byte
+	blobs     map[string][]byte
+	// requireToken makes the registry require an anonymous bearer token
+	requireToken bool
+	// pushes counts the pushed images, so that each push creates a new digest
+	pushes int
+}
+
+// NewRegistry starts a registry that is stopped at the end of the test. If
+// requireToken is set, clients must obtain an anonymous bearer token first.
+func NewRegistry(t *testing.T, requireToken bool) *Registry {
+	r := &Registry{
+		manifests:    map[string]map[string][]byte{},
+		blobs:        map[string][]byte{},
+		requireToken: requireToken,
+	}
+	r.server = httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+	t.Cleanup(r.server.Close)
+	r.Host = strings.TrimPrefix(r.server.URL, "http://")
+	return r
+}
+
+// Client returns a client that accesses the registry
+func (r *Registry) Client() *images.Client {
+	return &images.Client{HTTPClient: r.server.Client(), InsecureRegistries: []string{r.Host}}
+}
+
+// PushImage stores a new image manifest with a single layer under the tag and
+// returns the digest of the manifest
+func (r *Registry) PushImage(repository, tag string) string {
+	r.mu.Lock()
+	r.pushes++
+	content := fmt.Sprintf("%s:%s#%d", repository, tag, r.pushes)
+	r.mu.Unlock()
+	layer := r.pushBlob([]byte(content))
+	manifest := map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     ociManifestType,
+		"config":        map[string]interface{}{"mediaType": "application/vnd.oci.image.config.v1+json", "digest": r.pushBlob([]byte("{}")), "size": 2},
+		"layers":        []interface{}{map[string]interface{}{"mediaType": "application/vnd.oci.image.layer.v1.tar+gzip", "digest": layer}},
+	}
+	return r.pushManifest(repository, tag, manifest)
+}
+
+// PushArtifact stores a manifest with the content as its only layer under the tag
+// and returns the digest of the manifest
+func (r *Registry) PushArtifact(repository, tag, mediaType string, content []byte) string {
+	manifest := map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     ociManifestType,
+		"config":        map[string]interface{}{"mediaType": "application/vnd.oci.image.config.v1+json", "digest": r.pushBlob([]byte("{}")), "size": 2},
+		"layers":        []interface{}{map[string]interface{}{"mediaType": mediaType, "digest": r.pushBlob(content), "size": len(content)}},
+	}
+	return r.pushManifest(repository, tag, manifest)
+}
+
+// Sign stores a cosign signature of the manifest with the digest, created with
+// the signer
+func (r *Registry) Sign(repository, digest string, signer crypto.Signer) {
+	ref := images.Reference{Registry: r.Host, Repository: repository}
+	payload, err := json.Marshal(images.NewPayload(ref, digest))
+	if err != nil {
+		panic(err)
+	}
+	hash := sha256.Sum256(payload)
+	signature, err := signer.Sign(rand.Reader, hash[:], crypto.SHA256)
+	if err != nil {
+		panic(err)
+	}
+	manifest := map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     ociManifestType,
+		"config":        map[string]interface{}{"mediaType": "application/vnd.oci.image.config.v1+json", "digest": r.pushBlob([]byte("{}")), "size": 2},
+		"layers": []interface{}{map[string]interface{}{
+			"mediaType":   images.SignatureMediaType,
+			"digest":      r.pushBlob(payload),
+			"size":        len(payload),
+			"annotations": map[string]string{images.SignatureAnnotation: base64.StdEncoding.EncodeToString(signature)},
+		}},
+	}
+	r.pushManifest(repository, images.SignatureTag(digest), manifest)
+}
+
+func (r *Registry) pushBlob(data []byte) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	r.blobs[digest] = data
+	return digest
+}
+
+func (r *Registry) pushManifest(repository, tag string, manifest map[string]interface{}) string {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		panic(err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	if r.manifests[repository] == nil {
+		r.manifests[repository] = map[string][]byte{}
+	}
+	r.manifests[repository][tag] = data
+	r.manifests[repository][digest] = data
+	return digest
+}
+
+func (r *Registry) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		_ = json.NewEncoder(w).Encode(map[string]string{"token": testToken})
+		return
+	}
+	if r.requireToken && req.Header.Get("Authorization") != "Bearer "+testToken {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="%s"`, r.server.URL, r.Host))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i := strings.LastIndex(path, "/manifests/"); i >= 0 {
+		data, found := r.manifests[path[:i]][path[i+len("/manifests/"):]]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", ociManifestType)
+		w.Header().Set("Docker-Content-Digest", fmt.Sprintf("sha256:%x", sha256.Sum256(data)))
+		if req.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+		return
+	}
+	if i := strings.LastIndex(path, "/blobs/"); i >= 0 {
+		data, found := r.blobs[path[i+len("/blobs/"):]]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(data)
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
@@ -0,0 +1,256 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package images
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// manifestMediaTypes are the media types of the manifests that Resolve accepts
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// maxManifestSize limits the size of the manifests and signature payloads that are read
+const maxManifestSize = 4 << 20
+
+// Client accesses registries with the Docker Registry HTTP API V2. It only supports
+// anonymous access.
+type Client struct {
+	// HTTPClient sends the requests. If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+	// InsecureRegistries are accessed over plain HTTP
+	InsecureRegistries []string
+}
+
+// Resolve returns the digest of the manifest that the tag of the image refers to.
+// If the image is referenced by digest, the digest is returned as is.
+func (c *Client) Resolve(ctx context.Context, ref Reference) (string, error) {
+	if ref.Digest != "" {
+		return ref.Digest, nil
+	}
+	resp, err := c.get(ctx, http.MethodHead, ref, "manifests/"+ref.Tag, manifestMediaTypes)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if digest := resp.Header.Get("Docker-Content-Digest"); digestPattern.MatchString(digest) {
+		return digest, nil
+	}
+
+	// the registry doesn't report the digest, so it's computed from the manifest
+	body, err := c.fetch(ctx, ref, "manifests/"+ref.Tag, manifestMediaTypes, maxManifestSize)
+	if err != nil {
+		return "", err
+	}
+	return sha256Digest(body), nil
+}
+
+// LayerDigest returns the digest of the first layer with the media type in the
+// manifest of the artifact, e.g. the chart archive of a Helm chart stored in an OCI
+// registry
+func (c *Client) LayerDigest(ctx context.Context, ref Reference, mediaType string) (string, error) {
+	reference := ref.Tag
+	if ref.Digest != "" {
+		reference = ref.Digest
+	}
+	body, err := c.fetch(ctx, ref, "manifests/"+reference, []string{"application/vnd.oci.image.manifest.v1+json"}, maxManifestSize)
+	if err != nil {
+		return "", err
+	}
+	if ref.Digest != "" && sha256Digest(body) != ref.Digest {
+		return "", fmt.Errorf("manifest of %s doesn't match its digest", ref)
+	}
+	manifest := &ociManifest{}
+	if err := json.Unmarshal(body, manifest); err != nil {
+		return "", fmt.Errorf("invalid manifest of %s: %v", ref, err)
+	}
+	for _, layer := range manifest.Layers {
+		if layer.MediaType == mediaType && digestPattern.MatchString(layer.Digest) {
+			return layer.Digest, nil
+		}
+	}
+	return "", fmt.Errorf("%s has no layer of type %s", ref, mediaType)
+}
+
+// Blob returns the contents of the blob with the digest in the repository of the
+// reference, after verifying that they match the digest. Blobs larger than maxSize
+// bytes are rejected.
+func (c *Client) Blob(ctx context.Context, ref Reference, digest string, maxSize int) ([]byte, error) {
+	if !digestPattern.MatchString(digest) {
+		return nil, fmt.Errorf("invalid digest %q", digest)
+	}
+	body, err := c.fetch(ctx, ref, "blobs/"+digest, nil, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	if sha256Digest(body) != digest {
+		return nil, fmt.Errorf("blob %s of %s doesn't match its digest", digest, ref.Repository)
+	}
+	return body, nil
+}
+
+// fetch returns the contents of the manifest or blob at the path of the repository,
+// which must not exceed maxSize bytes
+func (c *Client) fetch(ctx context.Context, ref Reference, path string, accept []string, maxSize int) ([]byte, error) {
+	resp, err := c.get(ctx, http.MethodGet, ref, path, accept)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxSize {
+		return nil, fmt.Errorf("%s of %s exceeds %d bytes", path, ref.Repository, maxSize)
+	}
+	return body, nil
+}
+
+// get sends a request for the path of the repository and authenticates with an
+// anonymous token if the registry requires it
+func (c *Client) get(ctx context.Context, method string, ref Reference, path string, accept []string) (*http.Response, error) {
+	u := c.baseURL(ref.Registry) + "/v2/" + ref.Repository + "/" + path
+	resp, err := c.do(ctx, method, u, accept, "")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		token, err := c.token(ctx, challenge)
+		if err != nil {
+			return nil, fmt.Errorf("failed to authenticate to %s: %v", ref.Registry, err)
+		}
+		if resp, err = c.do(ctx, method, u, accept, token); err != nil {
+			return nil, err
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &registryError{url: u, status: resp.StatusCode}
+	}
+	return resp, nil
+}
+
+func (c *Client) do(ctx context.Context, method, u string, accept []string, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(accept) > 0 {
+		req.Header.Set("Accept", strings.Join(accept, ", "))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return c.httpClient().Do(req)
+}
+
+// token requests an anonymous bearer token as described in the challenge
+func (c *Client) token(ctx context.Context, challenge string) (string, error) {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", fmt.Errorf("unsupported authentication scheme %q", scheme)
+	}
+	query := url.Values{}
+	var realm string
+	for _, param := range strings.Split(params, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		value = strings.Trim(value, `"`)
+		switch key {
+		case "realm":
+			realm = value
+		case "service", "scope":
+			query.Set(key, value)
+		}
+	}
+	if realm == "" {
+		return "", fmt.Errorf("no realm in challenge %q", challenge)
+	}
+
+	resp, err := c.do(ctx, http.MethodGet, realm+"?"+query.Encode(), nil, "")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", &registryError{url: realm, status: resp.StatusCode}
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxManifestSize)).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	return token.AccessToken, nil
+}
+
+func (c *Client) baseURL(registry string) string {
+	for _, insecure := range c.InsecureRegistries {
+		if insecure == registry {
+			return "http://" + registry
+		}
+	}
+	if registry == "docker.io" {
+		registry = "registry-1.docker.io"
+	}
+	return "https://" + registry
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func sha256Digest(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+// registryError is returned when the registry responds with an unexpected status
+type registryError struct {
+	url    string
+	status int
+}
+
+func (e *registryError) Error() string {
+	return fmt.Sprintf("%s returned %d %s", e.url, e.status, http.StatusText(e.status))
+}
+
+// IsNotFound returns whether the error is caused by a manifest or blob that doesn't exist
+func IsNotFound(err error) bool {
+	var regErr *registryError
+	return errors.As(err, &regErr) && regErr.status == http.StatusNotFound
+}
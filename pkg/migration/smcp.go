@@ -0,0 +1,328 @@
+package migration
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"maistra.io/istio-operator/api/v1alpha1"
+)
+
+// TargetVersion is the version of Istio that migrated control planes are moved to
+const TargetVersion = "v3.0"
+
+// TargetProfile is the profile used for migrated control planes. ServiceMeshControlPlanes
+// only ever ran on OpenShift, so the openshift profile is always the right choice.
+const TargetProfile = "openshift"
+
+var (
+	SMCPGroupVersionKind = schema.GroupVersionKind{Group: "maistra.io", Version: "v2", Kind: "ServiceMeshControlPlane"}
+	SMMRGroupVersionKind = schema.GroupVersionKind{Group: "maistra.io", Version: "v2", Kind: "ServiceMeshMemberRoll"}
+)
+
+// errUnsupported is returned by a fieldMapping when the field is known, but its
+// value can't be expressed in an Istio resource
+var errUnsupported = errors.New("unsupported value")
+
+// Result holds the outcome of a ServiceMeshControlPlane migration
+type Result struct {
+	// Istio is the Istio resource equivalent to the migrated ServiceMeshControlPlane
+	Istio *v1alpha1.Istio
+
+	// DiscoverySelectors are the meshConfig.discoverySelectors derived from the
+	// ServiceMeshMemberRoll that can't be expressed as Istio members (e.g. the
+	// memberSelectors). They are already included in the Istio values.
+	DiscoverySelectors []metav1.LabelSelector
+
+	// Unsupported lists the paths of all fields that could not be migrated
+	Unsupported []string
+}
+
+type fieldMapping struct {
+	path    string
+	convert func(value interface{}, values map[string]interface{}) error
+}
+
+// mappings defines how fields in the ServiceMeshControlPlane spec translate to Helm values.
+// Any spec field not covered by a mapping is reported as unsupported.
+var mappings = []fieldMapping{
+	{"version", ignore},
+	{"mode", ignore}, // handled in membership()
+	{"profiles", func(value interface{}, _ map[string]interface{}) error {
+		profiles, ok := value.([]interface{})
+		if !ok || len(profiles) > 1 || (len(profiles) == 1 && profiles[0] != "default") {
+			return errUnsupported
+		}
+		return nil
+	}},
+	{"general.logging.componentLevels", func(value interface{}, values map[string]interface{}) error {
+		levels, ok := value.(map[string]interface{})
+		if !ok {
+			return errUnsupported
+		}
+		return setValue(values, "global.logging.level", joinComponentLevels(levels))
+	}},
+	{"general.logging.logAsJSON", setValueFunc("global.logAsJson")},
+	{"proxy.logging.level", setValueFunc("global.proxy.logLevel")},
+	{"proxy.logging.componentLevels", func(value interface{}, values map[string]interface{}) error {
+		levels, ok := value.(map[string]interface{})
+		if !ok {
+			return errUnsupported
+		}
+		return setValue(values, "global.proxy.componentLogLevel", joinComponentLevels(levels))
+	}},
+	{"proxy.accessLogging.file.name", setValueFunc("meshConfig.accessLogFile")},
+	{"proxy.accessLogging.file.format", setValueFunc("meshConfig.accessLogFormat")},
+	{"proxy.accessLogging.file.encoding", setValueFunc("meshConfig.accessLogEncoding")},
+	{"proxy.networking.trafficControl.inbound.excludedPorts", joinListFunc("global.proxy.excludeInboundPorts")},
+	{"proxy.networking.trafficControl.outbound.excludedPorts", joinListFunc("global.proxy.excludeOutboundPorts")},
+	{"proxy.networking.trafficControl.outbound.includedIPRanges", joinListFunc("global.proxy.includeIPRanges")},
+	{"proxy.networking.trafficControl.outbound.excludedIPRanges", joinListFunc("global.proxy.excludeIPRanges")},
+	{"proxy.runtime.container.resources", setValueFunc("global.proxy.resources")},
+	{"security.trust.domain", setValueFunc("meshConfig.trustDomain")},
+	{"security.trust.additionalDomains", setValueFunc("meshConfig.trustDomainAliases")},
+	{"security.dataPlane.automtls", setValueFunc("meshConfig.enableAutoMtls")},
+	{"tracing.type", func(value interface{}, _ map[string]interface{}) error {
+		// Jaeger and other tracing integrations must be configured through meshConfig.extensionProviders
+		if value != "None" {
+			return errUnsupported
+		}
+		return nil
+	}},
+	{"tracing.sampling", func(value interface{}, values map[string]interface{}) error {
+		// SMCP expresses sampling in units of 0.01%, Istio in percent
+		switch sampling := value.(type) {
+		case int64:
+			return setValue(values, "pilot.traceSampling", float64(sampling)/100)
+		case float64:
+			return setValue(values, "pilot.traceSampling", sampling/100)
+		}
+		return errUnsupported
+	}},
+	{"runtime.components.pilot.deployment.replicas", setValueFunc("pilot.replicaCount")},
+	{"runtime.components.pilot.deployment.autoScaling.enabled", setValueFunc("pilot.autoscaleEnabled")},
+	{"runtime.components.pilot.deployment.autoScaling.minReplicas", setValueFunc("pilot.autoscaleMin")},
+	{"runtime.components.pilot.deployment.autoScaling.maxReplicas", setValueFunc("pilot.autoscaleMax")},
+	{"runtime.components.pilot.deployment.autoScaling.targetCPUUtilizationPercentage", setValueFunc("pilot.cpu.targetAverageUtilization")},
+	{"runtime.components.pilot.container.resources", setValueFunc("pilot.resources")},
+	{"runtime.components.pilot.container.env", setValueFunc("pilot.env")},
+	{"runtime.components.pilot.pod.nodeSelector", setValueFunc("pilot.nodeSelector")},
+	{"runtime.components.pilot.pod.tolerations", setValueFunc("pilot.tolerations")},
+	{"runtime.components.pilot.pod.affinity", setValueFunc("pilot.affinity")},
+	{"cluster.name", setValueFunc("global.multiCluster.clusterName")},
+	{"cluster.network", setValueFunc("global.network")},
+	{"meshConfig.extensionProviders", setValueFunc("meshConfig.extensionProviders")},
+	{"meshConfig.discoverySelectors", ignore}, // handled in membership()
+}
+
+// ConvertSMCP converts a maistra.io/v2 ServiceMeshControlPlane and its (optional)
+// ServiceMeshMemberRoll into an equivalent Istio resource. The members of the
+// ServiceMeshMemberRoll become the members of the Istio resource. Fields that can't be
+// migrated don't cause an error, but are listed in Result.Unsupported.
+func ConvertSMCP(smcp, smmr *unstructured.Unstructured) (*Result, error) {
+	if smcp == nil {
+		return nil, fmt.Errorf("no ServiceMeshControlPlane specified")
+	}
+	if smcp.GroupVersionKind() != SMCPGroupVersionKind {
+		return nil, fmt.Errorf("unexpected kind %s, expected %s", smcp.GroupVersionKind(), SMCPGroupVersionKind)
+	}
+	if smmr != nil && smmr.GroupVersionKind() != SMMRGroupVersionKind {
+		return nil, fmt.Errorf("unexpected kind %s, expected %s", smmr.GroupVersionKind(), SMMRGroupVersionKind)
+	}
+
+	spec, _, err := unstructured.NestedMap(smcp.Object, "spec")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ServiceMeshControlPlane spec: %v", err)
+	}
+
+	if version, _, _ := unstructured.NestedString(spec, "version"); version != "" && !strings.HasPrefix(version, "v2.") {
+		return nil, fmt.Errorf("unsupported ServiceMeshControlPlane version %s; only v2.x can be migrated", version)
+	}
+
+	result := &Result{}
+	values := map[string]interface{}{}
+	handled := map[string]bool{}
+	for _, m := range mappings {
+		value, found, err := unstructured.NestedFieldNoCopy(spec, strings.Split(m.path, ".")...)
+		if err != nil || !found {
+			continue
+		}
+		if err := m.convert(value, values); err != nil {
+			if errors.Is(err, errUnsupported) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to convert field spec.%s: %v", m.path, err)
+		}
+		handled[m.path] = true
+	}
+
+	members, selectors, err := membership(smcp, spec, smmr)
+	if err != nil {
+		return nil, err
+	}
+	if len(selectors) > 0 {
+		rawSelectors := make([]interface{}, 0, len(selectors))
+		for i := range selectors {
+			rawSelector, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&selectors[i])
+			if err != nil {
+				return nil, err
+			}
+			rawSelectors = append(rawSelectors, rawSelector)
+		}
+		if err := unstructured.SetNestedSlice(values, rawSelectors, "meshConfig", "discoverySelectors"); err != nil {
+			return nil, err
+		}
+	}
+	result.DiscoverySelectors = selectors
+
+	result.Unsupported = unsupportedFields(spec, "", handled)
+	sort.Strings(result.Unsupported)
+
+	istio := &v1alpha1.Istio{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1alpha1.GroupVersion.String(),
+			Kind:       v1alpha1.IstioKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      smcp.GetName(),
+			Namespace: smcp.GetNamespace(),
+		},
+		Spec: v1alpha1.IstioSpec{
+			Version: TargetVersion,
+			Profile: TargetProfile,
+			Members: members,
+		},
+	}
+	if len(values) > 0 {
+		if err := istio.Spec.SetValues(values); err != nil {
+			return nil, err
+		}
+	}
+	result.Istio = istio
+	return result, nil
+}
+
+// membership returns the member namespaces and the discoverySelectors that restrict
+// the control plane to the namespaces that were members of the ServiceMeshControlPlane
+func membership(smcp *unstructured.Unstructured, spec map[string]interface{}, smmr *unstructured.Unstructured) ([]string, []metav1.LabelSelector, error) {
+	var smmrMembers []string
+	var memberSelectors []interface{}
+	if smmr != nil {
+		var err error
+		if smmrMembers, _, err = unstructured.NestedStringSlice(smmr.Object, "spec", "members"); err != nil {
+			return nil, nil, fmt.Errorf("failed to read ServiceMeshMemberRoll members: %v", err)
+		}
+		if memberSelectors, _, err = unstructured.NestedSlice(smmr.Object, "spec", "memberSelectors"); err != nil {
+			return nil, nil, fmt.Errorf("failed to read ServiceMeshMemberRoll memberSelectors: %v", err)
+		}
+	}
+	meshSelectors, _, err := unstructured.NestedSlice(spec, "meshConfig", "discoverySelectors")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read spec.meshConfig.discoverySelectors: %v", err)
+	}
+
+	var members []string
+	for _, member := range smmrMembers {
+		if member != "*" && member != smcp.GetNamespace() {
+			members = append(members, member)
+		}
+	}
+
+	var selectors []metav1.LabelSelector
+	for _, raw := range append(memberSelectors, meshSelectors...) {
+		rawMap, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil, nil, fmt.Errorf("invalid label selector: %v", raw)
+		}
+		var selector metav1.LabelSelector
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(rawMap, &selector); err != nil {
+			return nil, nil, fmt.Errorf("invalid label selector: %v", err)
+		}
+		selectors = append(selectors, selector)
+	}
+
+	// When the Istio resource lists members, the operator adds the selector for the
+	// control plane namespace. Otherwise, it must be added here, unless the control
+	// plane watched the whole cluster.
+	mode, _, _ := unstructured.NestedString(spec, "mode")
+	clusterWide := mode == "ClusterWide" && len(selectors) == 0
+	if len(members) == 0 && !clusterWide {
+		selectors = append([]metav1.LabelSelector{namespaceNameSelector(smcp.GetNamespace())}, selectors...)
+	}
+	return members, selectors, nil
+}
+
+func namespaceNameSelector(namespace string) metav1.LabelSelector {
+	return metav1.LabelSelector{
+		MatchLabels: map[string]string{
+			"kubernetes.io/metadata.name": namespace,
+		},
+	}
+}
+
+// unsupportedFields returns the paths of all leaf fields under obj that
+// weren't handled by a mapping
+func unsupportedFields(obj map[string]interface{}, prefix string, handled map[string]bool) []string {
+	var fields []string
+	for key, value := range obj {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		if handled[path] {
+			continue
+		}
+		if nested, ok := value.(map[string]interface{}); ok && len(nested) > 0 {
+			fields = append(fields, unsupportedFields(nested, path, handled)...)
+			continue
+		}
+		// a disabled component (e.g. addons.grafana.enabled: false) has nothing to migrate
+		if key == "enabled" && value == false {
+			continue
+		}
+		fields = append(fields, "spec."+path)
+	}
+	return fields
+}
+
+func ignore(interface{}, map[string]interface{}) error {
+	return nil
+}
+
+func setValueFunc(key string) func(interface{}, map[string]interface{}) error {
+	return func(value interface{}, values map[string]interface{}) error {
+		return setValue(values, key, value)
+	}
+}
+
+func joinListFunc(key string) func(interface{}, map[string]interface{}) error {
+	return func(value interface{}, values map[string]interface{}) error {
+		list, ok := value.([]interface{})
+		if !ok {
+			return errUnsupported
+		}
+		items := make([]string, 0, len(list))
+		for _, item := range list {
+			items = append(items, fmt.Sprint(item))
+		}
+		return setValue(values, key, strings.Join(items, ","))
+	}
+}
+
+func setValue(values map[string]interface{}, key string, value interface{}) error {
+	return unstructured.SetNestedField(values, runtime.DeepCopyJSONValue(value), strings.Split(key, ".")...)
+}
+
+func joinComponentLevels(levels map[string]interface{}) string {
+	components := make([]string, 0, len(levels))
+	for component, level := range levels {
+		components = append(components, fmt.Sprintf("%s:%v", component, level))
+	}
+	sort.Strings(components)
+	return strings.Join(components, ",")
+}
@@ -0,0 +1,260 @@
+package migration
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+func TestConvertSMCP(t *testing.T) {
+	testCases := []struct {
+		name                string
+		smcp                string
+		smmr                string
+		expectValues        map[string]interface{}
+		expectSelectors     []metav1.LabelSelector
+		expectMembers       []string
+		expectUnsupported   []string
+		expectErr           bool
+		expectNoIstioValues bool
+	}{
+		{
+			name: "minimal",
+			smcp: `
+apiVersion: maistra.io/v2
+kind: ServiceMeshControlPlane
+metadata:
+  name: basic
+  namespace: istio-system
+spec:
+  version: v2.4
+`,
+			expectValues: map[string]interface{}{
+				"meshConfig": map[string]interface{}{
+					"discoverySelectors": []interface{}{
+						map[string]interface{}{"matchLabels": map[string]interface{}{"kubernetes.io/metadata.name": "istio-system"}},
+					},
+				},
+			},
+			expectSelectors: []metav1.LabelSelector{
+				{MatchLabels: map[string]string{"kubernetes.io/metadata.name": "istio-system"}},
+			},
+		},
+		{
+			name: "mapped fields",
+			smcp: `
+apiVersion: maistra.io/v2
+kind: ServiceMeshControlPlane
+metadata:
+  name: basic
+  namespace: istio-system
+spec:
+  version: v2.4
+  mode: ClusterWide
+  profiles: [default]
+  general:
+    logging:
+      componentLevels:
+        default: info
+        ads: debug
+  proxy:
+    accessLogging:
+      file:
+        name: /dev/stdout
+    networking:
+      trafficControl:
+        inbound:
+          excludedPorts: [8080, 9090]
+  security:
+    trust:
+      domain: example.com
+  tracing:
+    type: None
+    sampling: 10000
+  runtime:
+    components:
+      pilot:
+        deployment:
+          replicas: 2
+`,
+			expectValues: map[string]interface{}{
+				"global": map[string]interface{}{
+					"logging": map[string]interface{}{"level": "ads:debug,default:info"},
+					"proxy":   map[string]interface{}{"excludeInboundPorts": "8080,9090"},
+				},
+				"meshConfig": map[string]interface{}{
+					"accessLogFile": "/dev/stdout",
+					"trustDomain":   "example.com",
+				},
+				"pilot": map[string]interface{}{
+					"replicaCount":  float64(2),
+					"traceSampling": float64(100),
+				},
+			},
+		},
+		{
+			name: "unsupported fields",
+			smcp: `
+apiVersion: maistra.io/v2
+kind: ServiceMeshControlPlane
+metadata:
+  name: basic
+  namespace: istio-system
+spec:
+  version: v2.4
+  mode: ClusterWide
+  profiles: [small]
+  tracing:
+    type: Jaeger
+  addons:
+    grafana:
+      enabled: false
+    kiali:
+      enabled: true
+      name: kiali
+`,
+			expectNoIstioValues: true,
+			expectUnsupported: []string{
+				"spec.addons.kiali.enabled",
+				"spec.addons.kiali.name",
+				"spec.profiles",
+				"spec.tracing.type",
+			},
+		},
+		{
+			name: "member roll",
+			smcp: `
+apiVersion: maistra.io/v2
+kind: ServiceMeshControlPlane
+metadata:
+  name: basic
+  namespace: istio-system
+spec:
+  version: v2.4
+`,
+			smmr: `
+apiVersion: maistra.io/v2
+kind: ServiceMeshMemberRoll
+metadata:
+  name: default
+  namespace: istio-system
+spec:
+  members: [bookinfo]
+  memberSelectors:
+  - matchLabels:
+      mesh: basic
+`,
+			expectValues: map[string]interface{}{
+				"meshConfig": map[string]interface{}{
+					"discoverySelectors": []interface{}{
+						map[string]interface{}{"matchLabels": map[string]interface{}{"mesh": "basic"}},
+					},
+				},
+			},
+			expectSelectors: []metav1.LabelSelector{
+				{MatchLabels: map[string]string{"mesh": "basic"}},
+			},
+			expectMembers: []string{"bookinfo"},
+		},
+		{
+			name: "cluster-wide",
+			smcp: `
+apiVersion: maistra.io/v2
+kind: ServiceMeshControlPlane
+metadata:
+  name: basic
+  namespace: istio-system
+spec:
+  version: v2.4
+  mode: ClusterWide
+`,
+			smmr: `
+apiVersion: maistra.io/v2
+kind: ServiceMeshMemberRoll
+metadata:
+  name: default
+  namespace: istio-system
+spec:
+  members: ["*"]
+`,
+			expectNoIstioValues: true,
+		},
+		{
+			name: "v1 control plane",
+			smcp: `
+apiVersion: maistra.io/v2
+kind: ServiceMeshControlPlane
+metadata:
+  name: basic
+  namespace: istio-system
+spec:
+  version: v1.1
+`,
+			expectErr: true,
+		},
+		{
+			name: "wrong kind",
+			smcp: `
+apiVersion: maistra.io/v2
+kind: ServiceMeshMemberRoll
+metadata:
+  name: default
+  namespace: istio-system
+`,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			smcp := mustParse(t, tc.smcp)
+			var smmr *unstructured.Unstructured
+			if tc.smmr != "" {
+				smmr = mustParse(t, tc.smmr)
+			}
+
+			result, err := ConvertSMCP(smcp, smmr)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("ConvertSMCP() error = %v, expectErr %v", err, tc.expectErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if result.Istio.Name != smcp.GetName() || result.Istio.Namespace != smcp.GetNamespace() {
+				t.Errorf("expected Istio %s/%s, got %s/%s", smcp.GetNamespace(), smcp.GetName(), result.Istio.Namespace, result.Istio.Name)
+			}
+			if result.Istio.Spec.Version != TargetVersion {
+				t.Errorf("expected version %s, got %s", TargetVersion, result.Istio.Spec.Version)
+			}
+			if tc.expectNoIstioValues {
+				if len(result.Istio.Spec.Values) != 0 {
+					t.Errorf("expected no values, got %s", string(result.Istio.Spec.Values))
+				}
+			} else if diff := cmp.Diff(tc.expectValues, result.Istio.Spec.GetValues()); diff != "" {
+				t.Errorf("unexpected values; diff (-expected, +actual):\n%v", diff)
+			}
+			if diff := cmp.Diff(tc.expectMembers, result.Istio.Spec.Members); diff != "" {
+				t.Errorf("unexpected members; diff (-expected, +actual):\n%v", diff)
+			}
+			if diff := cmp.Diff(tc.expectSelectors, result.DiscoverySelectors); diff != "" {
+				t.Errorf("unexpected discoverySelectors; diff (-expected, +actual):\n%v", diff)
+			}
+			if diff := cmp.Diff(tc.expectUnsupported, result.Unsupported); diff != "" {
+				t.Errorf("unexpected unsupported fields; diff (-expected, +actual):\n%v", diff)
+			}
+		})
+	}
+}
+
+func mustParse(t *testing.T, manifest string) *unstructured.Unstructured {
+	t.Helper()
+	obj := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal([]byte(manifest), &obj.Object); err != nil {
+		t.Fatal(err)
+	}
+	return obj
+}
@@ -0,0 +1,436 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package rbac derives the permissions that the operator needs to install the
+// bundled charts from the objects that the charts render
+package rbac
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/yaml"
+)
+
+// Chart is a chart that the operator installs
+type Chart struct {
+	// Path of the chart, relative to the charts directory of a version
+	Path string
+	// System charts are installed in the operator namespace instead of the istio namespace
+	System bool
+	// Values of the chart. If nil, the chart is rendered with the values of each profile.
+	Values map[string]interface{}
+}
+
+// Permissions are the rules that the operator needs to install the charts
+type Permissions struct {
+	// Cluster rules are needed in all namespaces
+	Cluster []rbacv1.PolicyRule
+	// Operator rules are only needed in the operator namespace
+	Operator []rbacv1.PolicyRule
+}
+
+var (
+	// manageVerbs are needed to install, upgrade and uninstall objects
+	manageVerbs = []string{"create", "delete", "get", "list", "patch", "update", "watch"}
+	// readVerbs are needed to watch the objects installed in the operator namespace
+	readVerbs = []string{"get", "list", "watch"}
+)
+
+// ChartPermissions renders the charts of all versions in resourceDir with the values
+// of each profile and returns the rules that allow the operator to manage the
+// rendered objects. Objects in templates that no profile renders are found by
+// scanning the templates for their kind. Since Kubernetes prevents privilege
+// escalation, the rules also include the rules of the rendered Roles and
+// ClusterRoles. Rules for namespaced objects that are only installed by system
+// charts are limited to the operator namespace, except for the verbs needed to
+// watch them.
+func ChartPermissions(resourceDir string, charts []Chart) (*Permissions, error) {
+	cluster, operator := newRuleSet(), newRuleSet()
+	operatorOnly := map[schema.GroupResource]bool{}
+	record := func(chart Chart, gvk schema.GroupVersionKind, namespaced bool) {
+		resource := Resource(gvk)
+		if previous, found := operatorOnly[resource]; !found || previous {
+			operatorOnly[resource] = chart.System && namespaced
+		}
+	}
+
+	rendered := map[schema.GroupKind]bool{}
+	err := renderAll(resourceDir, charts, func(chart Chart, obj *unstructured.Unstructured) error {
+		gvk := obj.GroupVersionKind()
+		record(chart, gvk, obj.GetNamespace() != "")
+		rendered[gvk.GroupKind()] = true
+
+		switch gvk.GroupKind() {
+		case rbacv1.SchemeGroupVersion.WithKind("ClusterRole").GroupKind():
+			role := &rbacv1.ClusterRole{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, role); err != nil {
+				return fmt.Errorf("invalid ClusterRole %s: %v", obj.GetName(), err)
+			}
+			cluster.add(role.Rules...)
+		case rbacv1.SchemeGroupVersion.WithKind("Role").GroupKind():
+			role := &rbacv1.Role{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, role); err != nil {
+				return fmt.Errorf("invalid Role %s: %v", obj.GetName(), err)
+			}
+			if chart.System {
+				operator.add(role.Rules...)
+			} else {
+				cluster.add(role.Rules...)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// objects that depend on values that no profile sets are assumed to be namespaced
+	for _, chart := range charts {
+		kinds, err := TemplateKinds(resourceDir, chart.Path)
+		if err != nil {
+			return nil, err
+		}
+		for _, gvk := range kinds {
+			if !rendered[gvk.GroupKind()] {
+				record(chart, gvk, true)
+			}
+		}
+	}
+
+	for resource, onlyOperator := range operatorOnly {
+		if onlyOperator {
+			cluster.add(rbacv1.PolicyRule{APIGroups: []string{resource.Group}, Resources: []string{resource.Resource}, Verbs: readVerbs})
+			operator.add(rbacv1.PolicyRule{APIGroups: []string{resource.Group}, Resources: []string{resource.Resource}, Verbs: manageVerbs})
+		} else {
+			cluster.add(rbacv1.PolicyRule{APIGroups: []string{resource.Group}, Resources: []string{resource.Resource}, Verbs: manageVerbs})
+		}
+	}
+
+	return &Permissions{
+		Cluster:  cluster.rules(),
+		Operator: operator.subtract(cluster).rules(),
+	}, nil
+}
+
+// irregularResources are the resources whose name can't be guessed from their kind
+var irregularResources = map[schema.GroupKind]string{
+	{Group: "k8s.cni.cncf.io", Kind: "NetworkAttachmentDefinition"}: "network-attachment-definitions",
+}
+
+// Resource returns the resource of the kind
+func Resource(gvk schema.GroupVersionKind) schema.GroupResource {
+	if resource, found := irregularResources[gvk.GroupKind()]; found {
+		return schema.GroupResource{Group: gvk.Group, Resource: resource}
+	}
+	resource, _ := meta.UnsafeGuessKindToResource(gvk)
+	return resource.GroupResource()
+}
+
+var (
+	apiVersionLine = regexp.MustCompile(`(?m)^apiVersion:\s*"?([a-zA-Z0-9./-]+)"?\s*$`)
+	kindLine       = regexp.MustCompile(`(?m)^kind:\s*"?([a-zA-Z]+)"?\s*$`)
+	documentSep    = regexp.MustCompile(`(?m)^---`)
+)
+
+// TemplateKinds returns the kinds of the objects in the templates of a chart in all
+// versions in resourceDir, as far as the templates declare them literally
+func TemplateKinds(resourceDir, chartPath string) ([]schema.GroupVersionKind, error) {
+	templates, err := filepath.Glob(path.Join(resourceDir, "*", "charts", chartPath, "templates", "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	kinds := sets.New[schema.GroupVersionKind]()
+	for _, template := range templates {
+		contents, err := os.ReadFile(template)
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range documentSep.Split(string(contents), -1) {
+			apiVersion, kind := apiVersionLine.FindStringSubmatch(doc), kindLine.FindStringSubmatch(doc)
+			if apiVersion == nil || kind == nil {
+				continue
+			}
+			kinds.Insert(schema.FromAPIVersionAndKind(apiVersion[1], kind[1]))
+		}
+	}
+	result := kinds.UnsortedList()
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].String() < result[j].String()
+	})
+	return result, nil
+}
+
+// renderAll renders the charts of all versions with the values of each profile and
+// calls fn for each rendered object
+func renderAll(resourceDir string, charts []Chart, fn func(chart Chart, obj *unstructured.Unstructured) error) error {
+	versions, err := os.ReadDir(resourceDir)
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		if !version.IsDir() {
+			continue
+		}
+		profiles, err := loadProfiles(path.Join(resourceDir, version.Name(), "profiles"))
+		if err != nil {
+			return err
+		}
+		for _, chart := range charts {
+			for _, profile := range sets.List(sets.KeySet(profiles)) {
+				values := profiles[profile]
+				if chart.Values != nil {
+					values = chart.Values
+				}
+				objects, err := renderChart(path.Join(resourceDir, version.Name(), "charts", chart.Path), values)
+				if err != nil {
+					return fmt.Errorf("failed to render chart %s of version %s with profile %s: %v", chart.Path, version.Name(), profile, err)
+				}
+				for _, obj := range objects {
+					if err := fn(chart, obj); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// loadProfiles returns the values of all profiles in the directory by their name
+func loadProfiles(dir string) (map[string]map[string]interface{}, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	profiles := make(map[string]map[string]interface{}, len(files))
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".yaml") {
+			continue
+		}
+		contents, err := os.ReadFile(path.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var profile struct {
+			Spec struct {
+				Values map[string]interface{} `json:"values"`
+			} `json:"spec"`
+		}
+		if err := yaml.Unmarshal(contents, &profile); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal profile %s: %v", file.Name(), err)
+		}
+		profiles[strings.TrimSuffix(file.Name(), ".yaml")] = profile.Spec.Values
+	}
+	return profiles, nil
+}
+
+// renderChart renders the templates of the chart like a helm install without CRDs
+func renderChart(chartDir string, values map[string]interface{}) ([]*unstructured.Unstructured, error) {
+	chart, err := loader.Load(chartDir)
+	if err != nil {
+		return nil, err
+	}
+	options := chartutil.ReleaseOptions{Name: "rbac", Namespace: "istio-system", IsInstall: true}
+	renderValues, err := chartutil.ToRenderValues(chart, values, options, chartutil.DefaultCapabilities)
+	if err != nil {
+		return nil, err
+	}
+	manifests, err := engine.Render(chart, renderValues)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0, len(manifests))
+	for file := range manifests {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	var objects []*unstructured.Unstructured
+	for _, file := range files {
+		if !strings.HasSuffix(file, ".yaml") {
+			continue
+		}
+		for _, manifest := range releaseutil.SplitManifests(manifests[file]) {
+			obj := &unstructured.Unstructured{}
+			if err := yaml.Unmarshal([]byte(manifest), &obj.Object); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal manifest in %s: %v", file, err)
+			}
+			if obj.Object == nil || obj.GetKind() == "" {
+				continue
+			}
+			objects = append(objects, obj)
+		}
+	}
+	return objects, nil
+}
+
+// ruleSet is a set of rules, broken down to single verbs
+type ruleSet map[ruleKey]sets.Set[string]
+
+// ruleKey identifies the rules with the same verbs. Either URL or group and
+// resource are set.
+type ruleKey struct {
+	group, resource, resourceNames, url string
+}
+
+func newRuleSet() ruleSet {
+	return ruleSet{}
+}
+
+func (s ruleSet) add(rules ...rbacv1.PolicyRule) {
+	for _, rule := range rules {
+		for _, url := range rule.NonResourceURLs {
+			s.addVerbs(ruleKey{url: url}, rule.Verbs)
+		}
+		resourceNames := strings.Join(sets.List(sets.New(rule.ResourceNames...)), ",")
+		for _, group := range rule.APIGroups {
+			for _, resource := range rule.Resources {
+				s.addVerbs(ruleKey{group: group, resource: resource, resourceNames: resourceNames}, rule.Verbs)
+			}
+		}
+	}
+}
+
+func (s ruleSet) addVerbs(key ruleKey, verbs []string) {
+	if s[key] == nil {
+		s[key] = sets.New[string]()
+	}
+	s[key].Insert(verbs...)
+}
+
+// subtract returns the rules of s that aren't in other
+func (s ruleSet) subtract(other ruleSet) ruleSet {
+	result := newRuleSet()
+	for key, verbs := range s {
+		if remaining := verbs.Difference(other[key]); remaining.Len() > 0 {
+			result[key] = remaining
+		}
+	}
+	return result
+}
+
+// rules returns the rules in the set, merging the resources of rules with the same
+// group, resource names and verbs
+func (s ruleSet) rules() []rbacv1.PolicyRule {
+	type mergeKey struct {
+		group, resourceNames, url, verbs string
+	}
+	merged := map[mergeKey]sets.Set[string]{}
+	for key, verbs := range s {
+		verbList := sets.List(verbs)
+		if verbs.Has("*") {
+			verbList = []string{"*"}
+		}
+		mk := mergeKey{group: key.group, resourceNames: key.resourceNames, url: key.url, verbs: strings.Join(verbList, ",")}
+		if merged[mk] == nil {
+			merged[mk] = sets.New[string]()
+		}
+		if key.url == "" {
+			merged[mk].Insert(key.resource)
+		}
+	}
+
+	rules := make([]rbacv1.PolicyRule, 0, len(merged))
+	for mk, resources := range merged {
+		rule := rbacv1.PolicyRule{Verbs: strings.Split(mk.verbs, ",")}
+		if mk.url != "" {
+			rule.NonResourceURLs = []string{mk.url}
+		} else {
+			rule.APIGroups = []string{mk.group}
+			rule.Resources = sets.List(resources)
+			if mk.resourceNames != "" {
+				rule.ResourceNames = strings.Split(mk.resourceNames, ",")
+			}
+		}
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return ruleSortKey(rules[i]) < ruleSortKey(rules[j])
+	})
+	return rules
+}
+
+func ruleSortKey(rule rbacv1.PolicyRule) string {
+	return strings.Join([]string{
+		strings.Join(rule.NonResourceURLs, ","),
+		strings.Join(rule.APIGroups, ","),
+		strings.Join(rule.Resources, ","),
+		strings.Join(rule.ResourceNames, ","),
+		strings.Join(rule.Verbs, ","),
+	}, "|")
+}
+
+// plainValue matches the marker values that don't need to be quoted
+var plainValue = regexp.MustCompile(`^[a-z0-9.-]+$`)
+
+// Markers returns the kubebuilder RBAC markers for the permissions. The rules in
+// Operator are limited to the given namespace.
+func Markers(permissions *Permissions, namespace string) []string {
+	var markers []string
+	for _, rule := range permissions.Cluster {
+		markers = append(markers, marker(rule, ""))
+	}
+	for _, rule := range permissions.Operator {
+		markers = append(markers, marker(rule, namespace))
+	}
+	return markers
+}
+
+func marker(rule rbacv1.PolicyRule, namespace string) string {
+	var fields []string
+	if len(rule.NonResourceURLs) > 0 {
+		fields = append(fields, "urls="+markerValue(rule.NonResourceURLs))
+	} else {
+		fields = append(fields, "groups="+markerValue(rule.APIGroups), "resources="+markerValue(rule.Resources))
+		if len(rule.ResourceNames) > 0 {
+			fields = append(fields, "resourceNames="+markerValue(rule.ResourceNames))
+		}
+	}
+	fields = append(fields, "verbs="+markerValue(rule.Verbs))
+	if namespace != "" {
+		fields = append(fields, "namespace="+namespace)
+	}
+	return "// +kubebuilder:rbac:" + strings.Join(fields, ",")
+}
+
+func markerValue(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		if plainValue.MatchString(value) {
+			quoted[i] = value
+		} else {
+			quoted[i] = fmt.Sprintf("%q", value)
+		}
+	}
+	return strings.Join(quoted, ";")
+}
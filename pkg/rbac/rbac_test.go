@@ -0,0 +1,185 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbac_test
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/releaseutil"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"maistra.io/istio-operator/controllers"
+	"maistra.io/istio-operator/pkg/common"
+	"maistra.io/istio-operator/pkg/rbac"
+	"sigs.k8s.io/yaml"
+)
+
+var resourceDir = path.Join(common.RepositoryRoot, "resources")
+
+// TestRoleCoversCharts fails when the operator's roles lack a permission that is
+// needed to install the charts. Run `make gen-rbac gen-manifests` to fix it.
+func TestRoleCoversCharts(t *testing.T) {
+	permissions, err := rbac.ChartPermissions(resourceDir, controllers.ComponentCharts())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := os.ReadFile(path.Join(common.RepositoryRoot, "config", "rbac", "role.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var clusterRules, operatorRules []rbacv1.PolicyRule
+	for _, manifest := range releaseutil.SplitManifests(string(contents)) {
+		role := &rbacv1.Role{}
+		if err := yaml.Unmarshal([]byte(manifest), role); err != nil {
+			t.Fatal(err)
+		}
+		switch role.Kind {
+		case "ClusterRole":
+			clusterRules = append(clusterRules, role.Rules...)
+		case "Role":
+			operatorRules = append(operatorRules, role.Rules...)
+		}
+	}
+
+	for _, rule := range permissions.Cluster {
+		if missing := missingPermissions(rule, clusterRules); len(missing) > 0 {
+			t.Errorf("ClusterRole is missing %v", missing)
+		}
+	}
+	for _, rule := range permissions.Operator {
+		if missing := missingPermissions(rule, append(clusterRules, operatorRules...)); len(missing) > 0 {
+			t.Errorf("Role is missing %v", missing)
+		}
+	}
+}
+
+func TestGeneratedMarkersUpToDate(t *testing.T) {
+	permissions, err := rbac.ChartPermissions(resourceDir, controllers.ComponentCharts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := os.ReadFile(path.Join(common.RepositoryRoot, "controllers", "zz_generated.rbac.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var generated []string
+	for _, line := range strings.Split(string(contents), "\n") {
+		if strings.HasPrefix(line, "// +kubebuilder:rbac:") {
+			generated = append(generated, line)
+		}
+	}
+	expected := rbac.Markers(permissions, "system")
+	if strings.Join(generated, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("zz_generated.rbac.go is outdated, run `make gen-rbac`. Expected markers:\n%s", strings.Join(expected, "\n"))
+	}
+}
+
+func TestTemplateKinds(t *testing.T) {
+	kinds, err := rbac.TemplateKinds(resourceDir, "istio-cni")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]bool{}
+	for _, gvk := range kinds {
+		found[rbac.Resource(gvk).String()] = true
+	}
+	for _, resource := range []string{"daemonsets.apps", "network-attachment-definitions.k8s.cni.cncf.io", "resourcequotas", "clusterroles.rbac.authorization.k8s.io"} {
+		if !found[resource] {
+			t.Errorf("expected %s in the kinds of the istio-cni chart, got %v", resource, kinds)
+		}
+	}
+}
+
+func TestMarkers(t *testing.T) {
+	permissions := &rbac.Permissions{
+		Cluster: []rbacv1.PolicyRule{
+			{APIGroups: []string{""}, Resources: []string{"pods", "pods/log"}, Verbs: []string{"get"}},
+			{APIGroups: []string{"security.openshift.io"}, Resources: []string{"securitycontextconstraints"}, ResourceNames: []string{"privileged"}, Verbs: []string{"use"}},
+			{NonResourceURLs: []string{"/metrics"}, Verbs: []string{"*"}},
+		},
+		Operator: []rbacv1.PolicyRule{
+			{APIGroups: []string{"apps"}, Resources: []string{"daemonsets"}, Verbs: []string{"create", "delete"}},
+		},
+	}
+	expected := []string{
+		`// +kubebuilder:rbac:groups="",resources=pods;"pods/log",verbs=get`,
+		`// +kubebuilder:rbac:groups=security.openshift.io,resources=securitycontextconstraints,resourceNames=privileged,verbs=use`,
+		`// +kubebuilder:rbac:urls="/metrics",verbs="*"`,
+		`// +kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=create;delete,namespace=istio-operator`,
+	}
+	if actual := rbac.Markers(permissions, "istio-operator"); strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected markers:\n%s", strings.Join(actual, "\n"))
+	}
+}
+
+// missingPermissions returns the permissions of the rule that none of the rules grant
+func missingPermissions(rule rbacv1.PolicyRule, rules []rbacv1.PolicyRule) []string {
+	var missing []string
+	for _, verb := range rule.Verbs {
+		for _, url := range rule.NonResourceURLs {
+			if !grants(rules, func(r rbacv1.PolicyRule) bool {
+				return contains(r.Verbs, verb) && contains(r.NonResourceURLs, url)
+			}) {
+				missing = append(missing, verb+" "+url)
+			}
+		}
+		for _, group := range rule.APIGroups {
+			for _, resource := range rule.Resources {
+				if !grants(rules, func(r rbacv1.PolicyRule) bool {
+					return contains(r.Verbs, verb) && contains(r.APIGroups, group) && contains(r.Resources, resource) &&
+						(len(r.ResourceNames) == 0 || containsAll(r.ResourceNames, rule.ResourceNames))
+				}) {
+					missing = append(missing, verb+" "+resource+"."+group)
+				}
+			}
+		}
+	}
+	return missing
+}
+
+func grants(rules []rbacv1.PolicyRule, matches func(rbacv1.PolicyRule) bool) bool {
+	for _, rule := range rules {
+		if matches(rule) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value || v == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAll(values, required []string) bool {
+	if len(required) == 0 {
+		return false
+	}
+	for _, value := range required {
+		if !contains(values, value) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package redact removes sensitive values from helm values before they are logged
+// or published in the status of a resource
+package redact
+
+import (
+	"encoding/json"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Placeholder replaces redacted values
+const Placeholder = "<redacted>"
+
+// DefaultPaths are the value paths that are always redacted
+var DefaultPaths = []string{
+	"global.imagePullSecrets",
+	"pilot.env.*TOKEN*",
+	"pilot.env.*SECRET*",
+	"pilot.env.*PASSWORD*",
+	"meshConfig.defaultConfig.proxyMetadata.*TOKEN*",
+	"meshConfig.defaultConfig.proxyMetadata.*SECRET*",
+	"meshConfig.defaultConfig.proxyMetadata.*PASSWORD*",
+	"meshConfig.extensionProviders.*.*.headers",
+}
+
+// Values returns a copy of the values in which the values at the given paths are
+// replaced with Placeholder. A path consists of dot-separated segments, each of
+// which is a map key or list index. Segments may contain the wildcards of
+// path.Match, e.g. `*` to match all keys of a map or all elements of a list.
+// Values that can't be copied are redacted entirely.
+func Values(values map[string]interface{}, paths []string) map[string]interface{} {
+	if values == nil {
+		return nil
+	}
+	// the values may contain types that runtime.DeepCopyJSON doesn't support
+	var result map[string]interface{}
+	if data, err := json.Marshal(values); err != nil || json.Unmarshal(data, &result) != nil {
+		return map[string]interface{}{"values": Placeholder}
+	}
+	for _, p := range paths {
+		if p = strings.TrimSpace(p); p != "" {
+			redact(result, strings.Split(p, "."))
+		}
+	}
+	return result
+}
+
+// redact replaces the values at the path within the map or list obj
+func redact(obj interface{}, segments []string) {
+	last := len(segments) == 1
+	switch obj := obj.(type) {
+	case map[string]interface{}:
+		for key, value := range obj {
+			if !matches(segments[0], key) {
+				continue
+			}
+			if last {
+				obj[key] = Placeholder
+			} else {
+				redact(value, segments[1:])
+			}
+		}
+	case []interface{}:
+		for i, value := range obj {
+			if !matches(segments[0], strconv.Itoa(i)) {
+				continue
+			}
+			if last {
+				obj[i] = Placeholder
+			} else {
+				redact(value, segments[1:])
+			}
+		}
+	}
+}
+
+func matches(pattern, name string) bool {
+	matched, err := path.Match(pattern, name)
+	return err == nil && matched
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package redact
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValues(t *testing.T) {
+	values := map[string]interface{}{
+		"global": map[string]interface{}{
+			"imagePullSecrets": []interface{}{"registry-credentials"},
+			"hub":              "quay.io/maistra",
+		},
+		"pilot": map[string]interface{}{
+			"env": map[string]interface{}{
+				"EXTERNAL_TOKEN": "s3cr3t",
+				"PILOT_TRACE":    "true",
+			},
+		},
+		"meshConfig": map[string]interface{}{
+			"extensionProviders": []interface{}{
+				map[string]interface{}{
+					"name": "authz",
+					"envoyExtAuthzHttp": map[string]interface{}{
+						"service": "authz.foo.svc.cluster.local",
+						"headers": map[string]interface{}{"Authorization": "Bearer s3cr3t"},
+					},
+				},
+			},
+		},
+		"custom": map[string]interface{}{
+			"list": []interface{}{"a", "b"},
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		paths    []string
+		expected map[string]interface{}
+	}{
+		{
+			name:  "default paths",
+			paths: DefaultPaths,
+			expected: map[string]interface{}{
+				"global": map[string]interface{}{
+					"imagePullSecrets": Placeholder,
+					"hub":              "quay.io/maistra",
+				},
+				"pilot": map[string]interface{}{
+					"env": map[string]interface{}{
+						"EXTERNAL_TOKEN": Placeholder,
+						"PILOT_TRACE":    "true",
+					},
+				},
+				"meshConfig": map[string]interface{}{
+					"extensionProviders": []interface{}{
+						map[string]interface{}{
+							"name": "authz",
+							"envoyExtAuthzHttp": map[string]interface{}{
+								"service": "authz.foo.svc.cluster.local",
+								"headers": Placeholder,
+							},
+						},
+					},
+				},
+				"custom": map[string]interface{}{
+					"list": []interface{}{"a", "b"},
+				},
+			},
+		},
+		{
+			name:  "list index and missing path",
+			paths: []string{"custom.list.1", "does.not.exist", " "},
+			expected: map[string]interface{}{
+				"global": map[string]interface{}{
+					"imagePullSecrets": []interface{}{"registry-credentials"},
+					"hub":              "quay.io/maistra",
+				},
+				"pilot": map[string]interface{}{
+					"env": map[string]interface{}{
+						"EXTERNAL_TOKEN": "s3cr3t",
+						"PILOT_TRACE":    "true",
+					},
+				},
+				"meshConfig": values["meshConfig"],
+				"custom": map[string]interface{}{
+					"list": []interface{}{"a", Placeholder},
+				},
+			},
+		},
+		{
+			name:     "whole subtree",
+			paths:    []string{"*"},
+			expected: map[string]interface{}{"global": Placeholder, "pilot": Placeholder, "meshConfig": Placeholder, "custom": Placeholder},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := Values(values, tc.paths); !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("unexpected result:\nexpected %v\ngot      %v", tc.expected, actual)
+			}
+		})
+	}
+
+	if values["pilot"].(map[string]interface{})["env"].(map[string]interface{})["EXTERNAL_TOKEN"] != "s3cr3t" {
+		t.Error("expected the original values to be unchanged")
+	}
+	if Values(nil, DefaultPaths) != nil {
+		t.Error("expected nil for nil values")
+	}
+}
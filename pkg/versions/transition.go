@@ -0,0 +1,65 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package versions
+
+import (
+	"fmt"
+)
+
+// AnyVersion is the minimum version that allows all versions
+const AnyVersion = "any"
+
+// ValidateTransition returns an error if a control plane can't be changed from
+// version from (empty for a new control plane) to version to:
+//   - to must not be lower than the minimum version (unless minimum is empty or "any")
+//   - to must not be a lower minor version than from; patch downgrades are allowed
+//   - to must not skip a minor version, i.e. it must be at most the next minor version
+//     of from, or the first minor version of the next major version
+//
+// Versions that can't be parsed aren't validated.
+func ValidateTransition(from, to, minimum string) error {
+	target, err := Parse(to)
+	if err != nil {
+		return nil
+	}
+	if minimum != "" && minimum != AnyVersion {
+		if minVersion, err := Parse(minimum); err == nil && target.Compare(minVersion) < 0 {
+			return fmt.Errorf("version %s is lower than the minimum supported version %s", to, minimum)
+		}
+	}
+
+	if from == "" || from == to {
+		return nil
+	}
+	current, err := Parse(from)
+	if err != nil {
+		return nil
+	}
+	currentMinor := Parsed{Major: current.Major, Minor: current.Minor}
+	targetMinor := Parsed{Major: target.Major, Minor: target.Minor}
+	switch {
+	case targetMinor.Compare(currentMinor) < 0:
+		return fmt.Errorf("downgrading from %s to %s is not supported", from, to)
+	case target.Major == current.Major && target.Minor > current.Minor+1:
+		return fmt.Errorf("upgrading from %s to %s skips a minor version; upgrade to v%d.%d first",
+			from, to, current.Major, current.Minor+1)
+	case target.Major > current.Major && (target.Major > current.Major+1 || target.Minor > 0):
+		return fmt.Errorf("upgrading from %s to %s skips a minor version; upgrade to v%d.0 first",
+			from, to, current.Major+1)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package versions
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateTransition(t *testing.T) {
+	testCases := []struct {
+		from, to, minimum string
+		expectErr         string
+	}{
+		{from: "", to: "v3.0", minimum: "any"},
+		{from: "", to: "v3.0", minimum: ""},
+		{from: "", to: "v3.0", minimum: "v3.0"},
+		{from: "", to: "v2.4", minimum: "v3.0", expectErr: "lower than the minimum supported version v3.0"},
+		{from: "v3.0", to: "v3.0", minimum: "any"},
+		{from: "v3.0", to: "v3.1", minimum: "any"},
+		{from: "v3.0.1", to: "v3.0.0", minimum: "any"},
+		{from: "v3.0", to: "v3.1.2", minimum: "v3.0"},
+		{from: "v3.1", to: "v3.0", minimum: "any", expectErr: "downgrading from v3.1 to v3.0 is not supported"},
+		{from: "v4.0", to: "v3.9", minimum: "any", expectErr: "downgrading"},
+		{from: "v3.0", to: "v3.2", minimum: "any", expectErr: "skips a minor version; upgrade to v3.1 first"},
+		{from: "v3.9", to: "v4.0", minimum: "any"},
+		{from: "v3.9", to: "v4.1", minimum: "any", expectErr: "upgrade to v4.0 first"},
+		{from: "v3.9", to: "v5.0", minimum: "any", expectErr: "upgrade to v4.0 first"},
+		{from: "v3.0", to: "v3.1", minimum: "v3.2", expectErr: "minimum supported version"},
+		{from: "latest", to: "v3.5", minimum: "any"},
+		{from: "v3.0", to: "latest", minimum: "v3.2"},
+	}
+	for _, tc := range testCases {
+		err := ValidateTransition(tc.from, tc.to, tc.minimum)
+		if tc.expectErr == "" && err != nil {
+			t.Errorf("%s -> %s (minimum %s): unexpected error: %v", tc.from, tc.to, tc.minimum, err)
+		} else if tc.expectErr != "" && (err == nil || !strings.Contains(err.Error(), tc.expectErr)) {
+			t.Errorf("%s -> %s (minimum %s): expected error containing %q, got %v", tc.from, tc.to, tc.minimum, tc.expectErr, err)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package versions discovers the Istio versions that the operator supports, i.e.
+// the versions in its resource directory
+package versions
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// Version describes the resources of a version in the resource directory
+type Version struct {
+	// Name of the version, as used in spec.version
+	Name string
+	// Profiles are the names of the profiles of the version
+	Profiles []string
+	// Charts are the charts of the version
+	Charts []Chart
+}
+
+// Chart describes a chart of a version
+type Chart struct {
+	// Path of the chart directory, relative to the charts directory of the version
+	Path       string
+	Name       string
+	Version    string
+	AppVersion string
+}
+
+// Discover returns the versions in the resource directory, ordered from the oldest
+// to the latest. Every directory that contains a charts directory is a version.
+func Discover(resourceDir string) ([]Version, error) {
+	entries, err := os.ReadDir(resourceDir)
+	if err != nil {
+		return nil, err
+	}
+	var versions []Version
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		versionDir := filepath.Join(resourceDir, entry.Name())
+		if info, err := os.Stat(filepath.Join(versionDir, "charts")); err != nil || !info.IsDir() {
+			continue
+		}
+		version := Version{Name: entry.Name()}
+		if version.Profiles, err = discoverProfiles(filepath.Join(versionDir, "profiles")); err != nil {
+			return nil, err
+		}
+		if version.Charts, err = discoverCharts(filepath.Join(versionDir, "charts")); err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return Less(versions[i].Name, versions[j].Name) })
+	return versions, nil
+}
+
+// Names returns the names of the versions
+func Names(versions []Version) []string {
+	names := make([]string, 0, len(versions))
+	for _, v := range versions {
+		names = append(names, v.Name)
+	}
+	return names
+}
+
+// Find returns the version with the given name
+func Find(versions []Version, name string) (Version, bool) {
+	for _, v := range versions {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return Version{}, false
+}
+
+func discoverProfiles(profilesDir string) ([]string, error) {
+	entries, err := os.ReadDir(profilesDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var profiles []string
+	for _, entry := range entries {
+		if name, isProfile := strings.CutSuffix(entry.Name(), ".yaml"); isProfile && !entry.IsDir() {
+			profiles = append(profiles, name)
+		}
+	}
+	return profiles, nil
+}
+
+// discoverCharts returns the charts in the charts directory, i.e. all directories
+// with a Chart.yaml file that aren't subcharts of another chart
+func discoverCharts(chartsDir string) ([]Chart, error) {
+	var charts []Chart
+	err := filepath.WalkDir(chartsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		metadata, err := chartutil.LoadChartfile(filepath.Join(path, "Chart.yaml"))
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(chartsDir, path)
+		if err != nil {
+			return err
+		}
+		charts = append(charts, Chart{
+			Path:       filepath.ToSlash(relPath),
+			Name:       metadata.Name,
+			Version:    metadata.Version,
+			AppVersion: metadata.AppVersion,
+		})
+		return filepath.SkipDir
+	})
+	return charts, err
+}
+
+// Parsed is a version of the form v<major>.<minor>[.<patch>]
+type Parsed struct {
+	Major, Minor, Patch int
+}
+
+func (p Parsed) String() string {
+	return fmt.Sprintf("v%d.%d.%d", p.Major, p.Minor, p.Patch)
+}
+
+// Parse parses a version of the form v<major>.<minor>[.<patch>]; the "v" is optional
+func Parse(version string) (Parsed, error) {
+	parts := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return Parsed{}, fmt.Errorf("invalid version %q: expected v<major>.<minor>[.<patch>]", version)
+	}
+	numbers := make([]int, 3)
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return Parsed{}, fmt.Errorf("invalid version %q: expected v<major>.<minor>[.<patch>]", version)
+		}
+		numbers[i] = n
+	}
+	return Parsed{Major: numbers[0], Minor: numbers[1], Patch: numbers[2]}, nil
+}
+
+// Compare returns -1, 0 or 1 if p is lower than, equal to or greater than other
+func (p Parsed) Compare(other Parsed) int {
+	for _, d := range []int{p.Major - other.Major, p.Minor - other.Minor, p.Patch - other.Patch} {
+		if d < 0 {
+			return -1
+		} else if d > 0 {
+			return 1
+		}
+	}
+	return 0
+}
+
+// Less orders versions numerically; versions that can't be parsed are ordered
+// before all others, by name
+func Less(a, b string) bool {
+	pa, errA := Parse(a)
+	pb, errB := Parse(b)
+	switch {
+	case errA != nil && errB != nil:
+		return a < b
+	case errA != nil || errB != nil:
+		return errA != nil
+	default:
+		return pa.Compare(pb) < 0
+	}
+}
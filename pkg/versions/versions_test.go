@@ -0,0 +1,98 @@
+package versions
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDiscover(t *testing.T) {
+	resourceDir := t.TempDir()
+	writeFile := func(path, content string) {
+		t.Helper()
+		path = filepath.Join(resourceDir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("v3.10/charts/base/Chart.yaml", "apiVersion: v2\nname: base\nversion: 1.10.0\nappVersion: 1.22.0\n")
+	writeFile("v3.10/charts/istio-control/istio-discovery/Chart.yaml", "apiVersion: v2\nname: istiod\nversion: 1.10.0\n")
+	// subcharts aren't listed
+	writeFile("v3.10/charts/istio-control/istio-discovery/charts/sub/Chart.yaml", "apiVersion: v2\nname: sub\nversion: 1.0.0\n")
+	writeFile("v3.10/profiles/default.yaml", "")
+	writeFile("v3.10/profiles/demo.yaml", "")
+	writeFile("v3.10/profiles/README.md", "")
+	writeFile("v3.2/charts/base/Chart.yaml", "apiVersion: v2\nname: base\nversion: 1.2.0\n")
+	writeFile("notaversion/README.md", "")
+	writeFile("README.md", "")
+
+	versions, err := Discover(resourceDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Version{
+		{Name: "v3.2", Charts: []Chart{{Path: "base", Name: "base", Version: "1.2.0"}}},
+		{
+			Name:     "v3.10",
+			Profiles: []string{"default", "demo"},
+			Charts: []Chart{
+				{Path: "base", Name: "base", Version: "1.10.0", AppVersion: "1.22.0"},
+				{Path: "istio-control/istio-discovery", Name: "istiod", Version: "1.10.0"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(versions, expected) {
+		t.Errorf("expected %+v, got %+v", expected, versions)
+	}
+	if names := Names(versions); !reflect.DeepEqual(names, []string{"v3.2", "v3.10"}) {
+		t.Errorf("unexpected names %v", names)
+	}
+	if _, found := Find(versions, "v3.10"); !found {
+		t.Errorf("expected to find v3.10")
+	}
+	if _, found := Find(versions, "v3.1"); found {
+		t.Errorf("expected not to find v3.1")
+	}
+
+	if _, err := Discover(filepath.Join(resourceDir, "missing")); err == nil {
+		t.Errorf("expected error for missing resource directory")
+	}
+}
+
+func TestParse(t *testing.T) {
+	testCases := map[string]*Parsed{
+		"v3.0":     {Major: 3, Minor: 0},
+		"3.1":      {Major: 3, Minor: 1},
+		"v3.1.2":   {Major: 3, Minor: 1, Patch: 2},
+		"v3":       nil,
+		"v3.x":     nil,
+		"v3.1.2.3": nil,
+		"latest":   nil,
+		"v3.-1":    nil,
+	}
+	for version, expected := range testCases {
+		parsed, err := Parse(version)
+		if expected == nil {
+			if err == nil {
+				t.Errorf("expected error for %q", version)
+			}
+		} else if err != nil || parsed != *expected {
+			t.Errorf("Parse(%q): expected %v, got %v (error: %v)", version, *expected, parsed, err)
+		}
+	}
+}
+
+func TestLess(t *testing.T) {
+	ordered := []string{"dev", "latest", "v2.9", "v3.0", "v3.0.1", "v3.2", "v3.10", "v4.0"}
+	for i := range ordered {
+		for j := range ordered {
+			if actual := Less(ordered[i], ordered[j]); actual != (i < j) {
+				t.Errorf("Less(%s, %s): expected %v, got %v", ordered[i], ordered[j], i < j, actual)
+			}
+		}
+	}
+}
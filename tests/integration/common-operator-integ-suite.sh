@@ -66,6 +66,7 @@ else
 fi
 
 TIMEOUT="3m"
+CERT_MANAGER_VERSION="${CERT_MANAGER_VERSION:-v1.13.2}"
 
 check_ready() {
     local NS=$1
@@ -83,6 +84,11 @@ check_ready() {
 # Build and push docker image
 make docker-build docker-push
 
+# The serving certificate of the operator's webhook is issued by cert-manager
+echo "Deploying cert-manager"
+$COMMAND apply -f "https://github.com/cert-manager/cert-manager/releases/download/${CERT_MANAGER_VERSION}/cert-manager.yaml"
+$COMMAND wait deployment --all -n cert-manager --for condition=Available=True --timeout=${TIMEOUT}
+
 # Deploy Operator
 echo "Deploying Operator"
 make -s --no-print-directory deploy